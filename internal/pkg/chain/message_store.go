@@ -130,8 +130,8 @@ func (ms *MessageStore) LoadReceipts(ctx context.Context, c cid.Cid) ([]*types.M
 			return nil, errors.Wrapf(err, "failed to get receipt %s", c)
 		}
 
-		receipt := &types.MessageReceipt{}
-		if err := cbor.DecodeInto(receiptBlock.RawData(), receipt); err != nil {
+		receipt, err := types.DecodeReceipt(receiptBlock.RawData())
+		if err != nil {
 			return nil, errors.Wrapf(err, "could not decode receipt %s", c)
 		}
 		receipts[i] = receipt
@@ -198,12 +198,19 @@ func (ms *MessageStore) storeSignedMessages(messages []*types.SignedMessage) ([]
 
 func (ms *MessageStore) storeMessageReceipts(receipts []*types.MessageReceipt) ([]cid.Cid, error) {
 	cids := make([]cid.Cid, len(receipts))
-	var err error
-	for i, msg := range receipts {
-		cids[i], err = ms.storeBlock(msg)
+	for i, receipt := range receipts {
+		data, err := types.EncodeReceipt(receipt)
 		if err != nil {
 			return nil, err
 		}
+		sblk, err := makeBlockFromData(data)
+		if err != nil {
+			return nil, err
+		}
+		if err := ms.bs.Put(sblk); err != nil {
+			return nil, err
+		}
+		cids[i] = sblk.Cid()
 	}
 	return cids, nil
 }
@@ -226,7 +233,10 @@ func makeBlock(obj interface{}) (blocks.Block, error) {
 	if err != nil {
 		return nil, err
 	}
+	return makeBlockFromData(data)
+}
 
+func makeBlockFromData(data []byte) (blocks.Block, error) {
 	pre := cid.NewPrefixV1(cid.DagCBOR, multihash.BLAKE2B_MIN+31)
 	c, err := pre.Sum(data)
 	if err != nil {
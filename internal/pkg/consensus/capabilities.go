@@ -0,0 +1,32 @@
+package consensus
+
+import (
+	"fmt"
+
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/address"
+)
+
+// MissingCapabilityError is the cause of the rejection of a message whose execution called a
+// method requiring a capability its caller lacks.
+type MissingCapabilityError struct {
+	// Caller is the ID address of the caller lacking the capability.
+	Caller address.Address
+	// Target is the ID address of the actor the capability is over.
+	Target     address.Address
+	Capability vm.Capability
+}
+
+func (e *MissingCapabilityError) Error() string {
+	return fmt.Sprintf("caller %s lacks the %s capability over actor %s", e.Caller, e.Capability, e.Target)
+}
+
+// ShouldRevert implements the reverterror interface, as a rejected message changes no state.
+func (e *MissingCapabilityError) ShouldRevert() bool {
+	return true
+}
+
+func isMissingCapabilityError(err error) bool {
+	_, ok := err.(*MissingCapabilityError)
+	return ok
+}
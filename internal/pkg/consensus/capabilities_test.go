@@ -0,0 +1,70 @@
+package consensus_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	. "github.com/filecoin-project/go-filecoin/internal/pkg/consensus"
+	th "github.com/filecoin-project/go-filecoin/internal/pkg/testhelpers"
+	tf "github.com/filecoin-project/go-filecoin/internal/pkg/testhelpers/testflags"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/types"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/actor"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/actor/builtin"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/address"
+	vmerrors "github.com/filecoin-project/go-filecoin/internal/pkg/vm/errors"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/state"
+)
+
+func TestCallerCapabilities(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+	fakeActorCodeCid := types.NewCidForTestGetter()()
+	actors := builtin.NewBuilder().
+		AddAll(builtin.DefaultActors).
+		Add(fakeActorCodeCid, 0, &actor.FakeActor{}).
+		Build()
+
+	vms := th.VMStorage()
+	addresses, st := setupActorsForGasTest(t, vms, fakeActorCodeCid, 1000)
+	stranger := address.NewForTestGetter()()
+	th.RequireInitAccountActor(ctx, t, st, vms, stranger, types.NewAttoFILFromFIL(1000))
+
+	// The first sender owns the fake actor that receives the messages.
+	owner, found, err := ResolveAddress(ctx, addresses[0], state.NewCachedTree(st), vms, nil)
+	require.NoError(t, err)
+	require.True(t, found)
+	processor := NewConfiguredProcessor(NewDefaultMessageValidator(), &FakeBlockRewarder{}, actors, WithCallerCapabilities(
+		func(target, caller address.Address, capability vm.Capability) bool {
+			return capability == vm.CapabilityOwner && target == addresses[1] && caller == owner
+		}))
+
+	apply := func(from address.Address) (*ApplicationResult, error) {
+		msg := types.NewMeteredMessage(from, addresses[1], 0, types.ZeroAttoFIL, actor.RequiresOwnerID, nil, types.NewGasPrice(1), types.NewGasUnits(1000))
+		return processor.ApplyMessage(ctx, st, vms, msg, addresses[3], types.NewBlockHeight(0), vm.NewLegacyGasTracker(), nil)
+	}
+
+	t.Run("owner may call", func(t *testing.T) {
+		result, err := apply(addresses[0])
+		require.NoError(t, err)
+		assert.NoError(t, result.ExecutionError)
+		assert.Equal(t, uint8(0), result.Receipt.ExitCode)
+	})
+
+	t.Run("stranger is rejected", func(t *testing.T) {
+		_, err := apply(stranger)
+		require.Error(t, err)
+		assert.True(t, vmerrors.IsApplyErrorPermanent(err))
+		assert.Equal(t, FailureMissingCapability, FailureReasonOf(err))
+
+		capErr, ok := errors.Cause(err).(*MissingCapabilityError)
+		require.True(t, ok)
+		assert.Equal(t, addresses[1], capErr.Target)
+		assert.Equal(t, vm.CapabilityOwner, capErr.Capability)
+	})
+}
@@ -0,0 +1,98 @@
+package consensus
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+	"github.com/pkg/errors"
+
+	"github.com/filecoin-project/go-filecoin/internal/pkg/types"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/address"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/state"
+)
+
+// ReceiptMismatchError reports how the receipt produced by applying a message differs from the
+// receipt it was expected to produce.
+type ReceiptMismatchError struct {
+	Expected *types.MessageReceipt
+	Actual   *types.MessageReceipt
+	// Differences describes each mismatching field.
+	Differences []string
+}
+
+func (e *ReceiptMismatchError) Error() string {
+	return fmt.Sprintf("receipt mismatch: %s", strings.Join(e.Differences, "; "))
+}
+
+// ApplyAndAssert applies msg to st, paying gas to minerOwnerAddr, and returns a
+// *ReceiptMismatchError if the receipt produced differs from expected in exit code, gas
+// charged or return values. An error applying the message is returned as is.
+func (p *DefaultProcessor) ApplyAndAssert(ctx context.Context, st state.Tree, vms vm.StorageMap, msg *types.UnsignedMessage, minerOwnerAddr address.Address, bh *types.BlockHeight, expected *types.MessageReceipt) error {
+	result, err := p.ApplyMessage(ctx, st, vms, msg, minerOwnerAddr, bh, p.newBlockGasTracker(), nil)
+	if err != nil {
+		return err
+	}
+
+	if differences := receiptDifferences(expected, result.Receipt); len(differences) > 0 {
+		return &ReceiptMismatchError{Expected: expected, Actual: result.Receipt, Differences: differences}
+	}
+	return nil
+}
+
+// RecipientHeadMismatchError reports that the state head of a message's recipient after the
+// message was applied differs from the head it was expected to have.
+type RecipientHeadMismatchError struct {
+	// Addr is the ID address of the recipient.
+	Addr     address.Address
+	Expected cid.Cid
+	Actual   cid.Cid
+}
+
+func (e *RecipientHeadMismatchError) Error() string {
+	return fmt.Sprintf("recipient %s head mismatch: expected %s, got %s", e.Addr, e.Expected, e.Actual)
+}
+
+// ApplyAndAssertRecipientHead applies msg to st, paying gas to minerOwnerAddr, and returns a
+// *RecipientHeadMismatchError if the recipient's state head is not expectedHead afterwards.
+// The message's changes are kept either way. An error applying the message is returned as is.
+func (p *DefaultProcessor) ApplyAndAssertRecipientHead(ctx context.Context, st state.Tree, vms vm.StorageMap, msg *types.UnsignedMessage, minerOwnerAddr address.Address, bh *types.BlockHeight, expectedHead cid.Cid) error {
+	result, err := p.ApplyMessage(ctx, st, vms, msg, minerOwnerAddr, bh, p.newBlockGasTracker(), nil)
+	if err != nil {
+		return err
+	}
+
+	toAddr := result.Extended.ToAddr
+	to, err := st.GetActor(ctx, toAddr)
+	if err != nil {
+		return errors.Wrapf(err, "could not load recipient %s", toAddr)
+	}
+	if !to.Head.Equals(expectedHead) {
+		return &RecipientHeadMismatchError{Addr: toAddr, Expected: expectedHead, Actual: to.Head}
+	}
+	return nil
+}
+
+// receiptDifferences describes each field of actual that differs from expected.
+func receiptDifferences(expected, actual *types.MessageReceipt) []string {
+	var differences []string
+	if expected.ExitCode != actual.ExitCode {
+		differences = append(differences, fmt.Sprintf("exit code: expected %d, got %d", expected.ExitCode, actual.ExitCode))
+	}
+	if !expected.GasAttoFIL.Equal(actual.GasAttoFIL) {
+		differences = append(differences, fmt.Sprintf("gas: expected %s, got %s", expected.GasAttoFIL, actual.GasAttoFIL))
+	}
+	if len(expected.Return) != len(actual.Return) {
+		differences = append(differences, fmt.Sprintf("return: expected %d values, got %d", len(expected.Return), len(actual.Return)))
+		return differences
+	}
+	for i := range expected.Return {
+		if !bytes.Equal(expected.Return[i], actual.Return[i]) {
+			differences = append(differences, fmt.Sprintf("return value %d: expected %x, got %x", i, expected.Return[i], actual.Return[i]))
+		}
+	}
+	return differences
+}
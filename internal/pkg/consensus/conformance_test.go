@@ -0,0 +1,103 @@
+package consensus_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	. "github.com/filecoin-project/go-filecoin/internal/pkg/consensus"
+	th "github.com/filecoin-project/go-filecoin/internal/pkg/testhelpers"
+	tf "github.com/filecoin-project/go-filecoin/internal/pkg/testhelpers/testflags"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/types"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/actor"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/actor/builtin"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/address"
+)
+
+func TestApplyAndAssert(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+	fakeActorCodeCid := types.NewCidForTestGetter()()
+	actors := builtin.NewBuilder().
+		AddAll(builtin.DefaultActors).
+		Add(fakeActorCodeCid, 0, &actor.FakeActor{}).
+		Build()
+	processor := NewConfiguredProcessor(NewDefaultMessageValidator(), NewDefaultBlockRewarder(), actors)
+
+	bh := types.NewBlockHeight(0)
+	newMessage := func(addresses []address.Address) *types.UnsignedMessage {
+		return types.NewMeteredMessage(addresses[0], addresses[1], 0, types.ZeroAttoFIL, actor.HasReturnValueID, nil, types.NewGasPrice(1), types.NewGasUnits(300))
+	}
+
+	// Produce the reference receipt on an identical state.
+	vms := th.VMStorage()
+	addresses, st := setupActorsForGasTest(t, vms, fakeActorCodeCid, 1000)
+	reference, err := processor.ApplyMessage(ctx, st, vms, newMessage(addresses), addresses[3], bh, vm.NewLegacyGasTracker(), nil)
+	require.NoError(t, err)
+
+	t.Run("matching receipt", func(t *testing.T) {
+		vms := th.VMStorage()
+		addresses, st := setupActorsForGasTest(t, vms, fakeActorCodeCid, 1000)
+		assert.NoError(t, processor.ApplyAndAssert(ctx, st, vms, newMessage(addresses), addresses[3], bh, reference.Receipt))
+	})
+
+	t.Run("mismatching receipt", func(t *testing.T) {
+		vms := th.VMStorage()
+		addresses, st := setupActorsForGasTest(t, vms, fakeActorCodeCid, 1000)
+		expected := &types.MessageReceipt{ExitCode: 1, Return: reference.Receipt.Return, GasAttoFIL: types.ZeroAttoFIL}
+
+		err := processor.ApplyAndAssert(ctx, st, vms, newMessage(addresses), addresses[3], bh, expected)
+		require.Error(t, err)
+		mismatch, ok := err.(*ReceiptMismatchError)
+		require.True(t, ok)
+		assert.Len(t, mismatch.Differences, 2)
+		assert.Contains(t, err.Error(), "exit code: expected 1, got 0")
+	})
+}
+
+func TestApplyAndAssertRecipientHead(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+	fakeActorCodeCid := types.NewCidForTestGetter()()
+	actors := builtin.NewBuilder().
+		AddAll(builtin.DefaultActors).
+		Add(fakeActorCodeCid, 0, &actor.FakeActor{}).
+		Build()
+	processor := NewConfiguredProcessor(NewDefaultMessageValidator(), NewDefaultBlockRewarder(), actors)
+
+	bh := types.NewBlockHeight(0)
+	newMessage := func(addresses []address.Address) *types.UnsignedMessage {
+		return types.NewMeteredMessage(addresses[0], addresses[1], 0, types.ZeroAttoFIL, actor.HasReturnValueID, nil, types.NewGasPrice(1), types.NewGasUnits(300))
+	}
+
+	// HasReturnValue leaves the recipient's state unchanged.
+	t.Run("matching head", func(t *testing.T) {
+		vms := th.VMStorage()
+		addresses, st := setupActorsForGasTest(t, vms, fakeActorCodeCid, 1000)
+		to, err := st.GetActor(ctx, addresses[1])
+		require.NoError(t, err)
+
+		assert.NoError(t, processor.ApplyAndAssertRecipientHead(ctx, st, vms, newMessage(addresses), addresses[3], bh, to.Head))
+	})
+
+	t.Run("mismatching head", func(t *testing.T) {
+		vms := th.VMStorage()
+		addresses, st := setupActorsForGasTest(t, vms, fakeActorCodeCid, 1000)
+		to, err := st.GetActor(ctx, addresses[1])
+		require.NoError(t, err)
+		wrongHead := types.NewCidForTestGetter()()
+
+		err = processor.ApplyAndAssertRecipientHead(ctx, st, vms, newMessage(addresses), addresses[3], bh, wrongHead)
+		require.Error(t, err)
+		mismatch, ok := err.(*RecipientHeadMismatchError)
+		require.True(t, ok, "unexpected error: %v", err)
+		assert.Equal(t, addresses[1], mismatch.Addr)
+		assert.Equal(t, wrongHead, mismatch.Expected)
+		assert.Equal(t, to.Head, mismatch.Actual)
+	})
+}
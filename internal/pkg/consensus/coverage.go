@@ -0,0 +1,67 @@
+package consensus
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/ipfs/go-cid"
+
+	"github.com/filecoin-project/go-filecoin/internal/pkg/types"
+)
+
+// MethodCoverage records the actor methods dispatched by the messages a processor applies,
+// including those invoked by nested sends. One coverage may be shared by processors applying
+// messages concurrently.
+type MethodCoverage struct {
+	lk     sync.Mutex
+	counts map[MethodKey]uint64
+}
+
+// MethodKey identifies an actor method by the code of the actor implementing it.
+type MethodKey struct {
+	Code   cid.Cid
+	Method types.MethodID
+}
+
+// MethodInvocations is the number of times a method was dispatched.
+type MethodInvocations struct {
+	MethodKey
+	Count uint64
+}
+
+// NewMethodCoverage creates an empty coverage.
+func NewMethodCoverage() *MethodCoverage {
+	return &MethodCoverage{counts: map[MethodKey]uint64{}}
+}
+
+// Trace records a dispatch of method on an actor with the given code. It is a vm.DispatchTracer.
+func (c *MethodCoverage) Trace(code cid.Cid, method types.MethodID) {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	c.counts[MethodKey{Code: code, Method: method}]++
+}
+
+// Covered reports whether method has been dispatched on an actor with the given code.
+func (c *MethodCoverage) Covered(code cid.Cid, method types.MethodID) bool {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	return c.counts[MethodKey{Code: code, Method: method}] > 0
+}
+
+// Report returns each method dispatched so far with its count, ordered by code then method.
+func (c *MethodCoverage) Report() []MethodInvocations {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	report := make([]MethodInvocations, 0, len(c.counts))
+	for key, count := range c.counts {
+		report = append(report, MethodInvocations{MethodKey: key, Count: count})
+	}
+	sort.Slice(report, func(i, j int) bool {
+		if !report[i].Code.Equals(report[j].Code) {
+			return report[i].Code.KeyString() < report[j].Code.KeyString()
+		}
+		return report[i].Method < report[j].Method
+	})
+	return report
+}
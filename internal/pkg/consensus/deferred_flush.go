@@ -0,0 +1,54 @@
+package consensus
+
+import (
+	"context"
+
+	"github.com/ipfs/go-cid"
+
+	"github.com/filecoin-project/go-filecoin/internal/pkg/block"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/types"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/errors"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/state"
+)
+
+// PendingFlush holds the changes made by ApplyWithDeferredFlush until they are flushed to the
+// backing store.
+type PendingFlush struct {
+	st   *state.BufferedTree
+	vms  vm.StorageMap
+	root cid.Cid
+	done bool
+}
+
+// Flush writes the pending actors to the state tree, then the pending actor storage and state
+// tree to the backing store, and returns the new state root. It writes once: later calls
+// return the same root.
+func (f *PendingFlush) Flush(ctx context.Context) (cid.Cid, error) {
+	if f.done {
+		return f.root, nil
+	}
+	if err := f.vms.Flush(); err != nil {
+		return cid.Undef, errors.FaultErrorWrap(err, "could not flush actor storage")
+	}
+	root, err := f.st.Flush(ctx)
+	if err != nil {
+		return cid.Undef, errors.FaultErrorWrap(err, "could not flush state tree")
+	}
+	f.root, f.done = root, true
+	return root, nil
+}
+
+// ApplyWithDeferredFlush processes a tipset as ProcessTipSet does, but leaves st untouched:
+// the actors each message commits are buffered in memory, and written to st and the backing
+// store only when the returned handle is flushed. A tipset thus costs one write of each actor
+// it changes, rather than one per message that changes it.
+func (p *DefaultProcessor) ApplyWithDeferredFlush(ctx context.Context, st state.Tree, vms vm.StorageMap,
+	ts block.TipSet, tsMessages [][]*types.UnsignedMessage, ancestors []block.TipSet) ([]*ApplyMessageResult, *PendingFlush, error) {
+	buffered := state.NewBufferedTree(st)
+	results, err := p.ProcessTipSet(ctx, buffered, vms, ts, tsMessages, ancestors)
+	if err != nil {
+		return nil, nil, err
+	}
+	return results, &PendingFlush{st: buffered, vms: vms}, nil
+}
@@ -0,0 +1,168 @@
+package consensus_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-hamt-ipld"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-filecoin/internal/pkg/block"
+	. "github.com/filecoin-project/go-filecoin/internal/pkg/consensus"
+	th "github.com/filecoin-project/go-filecoin/internal/pkg/testhelpers"
+	tf "github.com/filecoin-project/go-filecoin/internal/pkg/testhelpers/testflags"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/types"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/actor/builtin"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/actor/builtin/account"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/actor/builtin/miner"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/address"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/state"
+)
+
+// setupTransfers creates two accounts, a miner owned by the second, and a single block tipset
+// of count messages transferring funds between the accounts.
+func setupTransfers(ctx context.Context, count int) (state.Tree, vm.StorageMap, block.TipSet, []*types.UnsignedMessage, error) {
+	vms := th.VMStorage()
+	st := state.NewTree(hamt.NewCborStore())
+
+	from, err := address.NewIDAddress(100)
+	if err != nil {
+		return nil, nil, block.UndefTipSet, nil, err
+	}
+	to, err := address.NewIDAddress(101)
+	if err != nil {
+		return nil, nil, block.UndefTipSet, nil, err
+	}
+	for _, addr := range []address.Address{from, to} {
+		act, err := account.NewActor(types.NewAttoFILFromFIL(1000))
+		if err != nil {
+			return nil, nil, block.UndefTipSet, nil, err
+		}
+		if err := st.SetActor(ctx, addr, act); err != nil {
+			return nil, nil, block.UndefTipSet, nil, err
+		}
+	}
+
+	minerAddr, err := address.NewIDAddress(102)
+	if err != nil {
+		return nil, nil, block.UndefTipSet, nil, err
+	}
+	pid, err := th.RandPeerID()
+	if err != nil {
+		return nil, nil, block.UndefTipSet, nil, err
+	}
+	minerAct := miner.NewActor()
+	storage := vms.NewStorage(minerAddr, minerAct)
+	head, err := storage.Put(miner.NewState(to, to, pid, types.OneKiBSectorSize))
+	if err != nil {
+		return nil, nil, block.UndefTipSet, nil, err
+	}
+	if err := storage.LegacyCommit(head, cid.Undef); err != nil {
+		return nil, nil, block.UndefTipSet, nil, err
+	}
+	if err := st.SetActor(ctx, minerAddr, minerAct); err != nil {
+		return nil, nil, block.UndefTipSet, nil, err
+	}
+	if err := vms.Flush(); err != nil {
+		return nil, nil, block.UndefTipSet, nil, err
+	}
+	ts, err := block.NewTipSet(&block.Block{Height: 1, Miner: minerAddr})
+	if err != nil {
+		return nil, nil, block.UndefTipSet, nil, err
+	}
+
+	msgs := make([]*types.UnsignedMessage, count)
+	for i := range msgs {
+		msgs[i] = types.NewMeteredMessage(from, to, uint64(i), types.NewAttoFILFromFIL(1), types.SendMethodID, nil, types.NewGasPrice(1), types.NewGasUnits(100))
+	}
+	return st, vms, ts, msgs, nil
+}
+
+func TestApplyWithDeferredFlush(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+	processor := NewConfiguredProcessor(NewDefaultMessageValidator(), &FakeBlockRewarder{}, builtin.DefaultActors)
+
+	// Processing the tipset directly and deferring its writes reach the same state.
+	st, vms, ts, msgs, err := setupTransfers(ctx, 10)
+	require.NoError(t, err)
+	_, err = processor.ProcessTipSet(ctx, st, vms, ts, [][]*types.UnsignedMessage{msgs}, nil)
+	require.NoError(t, err)
+	require.NoError(t, vms.Flush())
+	expected, err := st.Flush(ctx)
+	require.NoError(t, err)
+
+	st, vms, ts, msgs, err = setupTransfers(ctx, 10)
+	require.NoError(t, err)
+	before, err := st.Flush(ctx)
+	require.NoError(t, err)
+	results, pending, err := processor.ApplyWithDeferredFlush(ctx, st, vms, ts, [][]*types.UnsignedMessage{msgs}, nil)
+	require.NoError(t, err)
+	require.Len(t, results, len(msgs))
+	for _, r := range results {
+		require.NoError(t, r.Failure)
+	}
+
+	// nothing reaches the state tree until the handle is flushed
+	unflushed, err := st.Flush(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, before, unflushed)
+
+	root, err := pending.Flush(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, expected, root)
+
+	again, err := pending.Flush(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, root, again)
+}
+
+func BenchmarkTipSetFlush(b *testing.B) {
+	ctx := context.Background()
+	processor := NewConfiguredProcessor(NewDefaultMessageValidator(), &FakeBlockRewarder{}, builtin.DefaultActors)
+	const tipSetMessages = 100
+
+	b.Run("committed per message", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			b.StopTimer()
+			st, vms, ts, msgs, err := setupTransfers(ctx, tipSetMessages)
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.StartTimer()
+
+			if _, err := processor.ProcessTipSet(ctx, st, vms, ts, [][]*types.UnsignedMessage{msgs}, nil); err != nil {
+				b.Fatal(err)
+			}
+			if err := vms.Flush(); err != nil {
+				b.Fatal(err)
+			}
+			if _, err := st.Flush(ctx); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("deferred", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			b.StopTimer()
+			st, vms, ts, msgs, err := setupTransfers(ctx, tipSetMessages)
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.StartTimer()
+
+			_, pending, err := processor.ApplyWithDeferredFlush(ctx, st, vms, ts, [][]*types.UnsignedMessage{msgs}, nil)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if _, err := pending.Flush(ctx); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
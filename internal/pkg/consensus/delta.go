@@ -0,0 +1,209 @@
+package consensus
+
+import (
+	"context"
+	"sort"
+
+	"github.com/filecoin-project/go-leb128"
+
+	"github.com/filecoin-project/go-filecoin/internal/pkg/block"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/encoding"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/types"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/actor"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/actor/builtin/initactor"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/address"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/errors"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/state"
+)
+
+func init() {
+	encoding.RegisterIpldCborType(ActorEntry{})
+	encoding.RegisterIpldCborType(StateDelta{})
+}
+
+// DeltaKind classifies the change a delta makes to an actor's entry.
+type DeltaKind int
+
+const (
+	// DeltaUpdated means the actor existed before and was changed. It is the zero value so
+	// that entries encoded before kinds were recorded are applied as plain writes.
+	DeltaUpdated DeltaKind = iota
+	// DeltaCreated means the actor did not exist before.
+	DeltaCreated
+	// DeltaDeleted means the actor was removed. Its entry has no actor.
+	DeltaDeleted
+)
+
+// ActorEntry is the state tree entry for one actor.
+type ActorEntry struct {
+	Address address.Address
+	Actor   *actor.Actor
+	Kind    DeltaKind
+	// KeyAddress is the key address the actor at Address was created for, if the init actor
+	// registers one for it, so that the entry can be traced back to the key that funded it.
+	KeyAddress address.Address
+}
+
+// StateDelta holds the state tree entries changed by applying a message, ordered by address.
+// Entries refer to actor state by cid only; a peer applying the delta must obtain the
+// referenced state blocks separately.
+type StateDelta struct {
+	Entries []ActorEntry
+}
+
+// Encode encodes the delta for transmission.
+func (d *StateDelta) Encode() ([]byte, error) {
+	return encoding.Encode(d)
+}
+
+// DecodeStateDelta decodes a delta encoded with StateDelta.Encode.
+func DecodeStateDelta(raw []byte) (*StateDelta, error) {
+	var d StateDelta
+	if err := encoding.Decode(raw, &d); err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// ApplyStateDelta writes the entries of a delta into a state tree.
+func ApplyStateDelta(ctx context.Context, st state.Tree, delta *StateDelta) error {
+	for _, entry := range delta.Entries {
+		if entry.Kind == DeltaDeleted {
+			if err := st.DeleteActor(ctx, entry.Address); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := st.SetActor(ctx, entry.Address, entry.Actor); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ApplyMessageWithDelta applies a message as ApplyMessage does and additionally returns the
+// state tree entries that the application changed.
+func (p *DefaultProcessor) ApplyMessageWithDelta(ctx context.Context, st state.Tree, vms vm.StorageMap, msg *types.UnsignedMessage, minerOwnerAddr address.Address, bh *types.BlockHeight, gasTracker vm.GasTracker, ancestors []block.TipSet) (*ApplicationResult, *StateDelta, error) {
+	recorder := newRecordingTree(st)
+	result, err := p.ApplyMessage(ctx, recorder, vms, msg, minerOwnerAddr, bh, gasTracker, ancestors)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	delta, err := recorder.delta(ctx, vms)
+	if err != nil {
+		return nil, nil, errors.FaultErrorWrap(err, "could not collect state delta")
+	}
+	return result, delta, nil
+}
+
+// recordingTree is a state tree that records the addresses of the actors written or deleted
+// through it, whether each existed before it was first written, and if so its entry then.
+type recordingTree struct {
+	state.Tree
+	existed map[address.Address]bool
+	before  map[address.Address]*actor.Actor
+}
+
+func newRecordingTree(st state.Tree) *recordingTree {
+	return &recordingTree{Tree: st, existed: map[address.Address]bool{}, before: map[address.Address]*actor.Actor{}}
+}
+
+func (t *recordingTree) SetActor(ctx context.Context, a address.Address, act *actor.Actor) error {
+	if err := t.record(ctx, a); err != nil {
+		return err
+	}
+	return t.Tree.SetActor(ctx, a, act)
+}
+
+func (t *recordingTree) DeleteActor(ctx context.Context, a address.Address) error {
+	if err := t.record(ctx, a); err != nil {
+		return err
+	}
+	return t.Tree.DeleteActor(ctx, a)
+}
+
+func (t *recordingTree) record(ctx context.Context, a address.Address) error {
+	if _, seen := t.existed[a]; seen {
+		return nil
+	}
+	act, err := t.Tree.GetActor(ctx, a)
+	if err != nil && !state.IsActorNotFoundError(err) {
+		return err
+	}
+	t.existed[a] = err == nil
+	if err == nil {
+		t.before[a] = act
+	}
+	return nil
+}
+
+// addresses returns the addresses of the written actors, sorted.
+func (t *recordingTree) addresses() []address.Address {
+	addrs := make([]address.Address, 0, len(t.existed))
+	for a := range t.existed {
+		addrs = append(addrs, a)
+	}
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i].String() < addrs[j].String() })
+	return addrs
+}
+
+// delta collects the current entries of the written actors.
+func (t *recordingTree) delta(ctx context.Context, vms vm.StorageMap) (*StateDelta, error) {
+	addrs := t.addresses()
+	keys, err := keyAddresses(ctx, t.Tree, vms, addrs)
+	if err != nil {
+		return nil, err
+	}
+
+	delta := &StateDelta{Entries: make([]ActorEntry, 0, len(addrs))}
+	for _, a := range addrs {
+		act, err := t.Tree.GetActor(ctx, a)
+		if state.IsActorNotFoundError(err) {
+			// an actor created and deleted by the same message leaves no trace
+			if t.existed[a] {
+				delta.Entries = append(delta.Entries, ActorEntry{Address: a, Kind: DeltaDeleted, KeyAddress: keys[a]})
+			}
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		kind := DeltaUpdated
+		if !t.existed[a] {
+			kind = DeltaCreated
+		}
+		entry := *act
+		delta.Entries = append(delta.Entries, ActorEntry{Address: a, Actor: &entry, Kind: kind, KeyAddress: keys[a]})
+	}
+	return delta, nil
+}
+
+// keyAddresses maps each of the given id addresses of actors registered with the init actor
+// in st to the key address the actor was created for. Only the given actors are looked up.
+// The map is empty if st has no init actor.
+func keyAddresses(ctx context.Context, st state.Tree, vms vm.StorageMap, ids []address.Address) (map[address.Address]address.Address, error) {
+	cached := state.NewCachedTree(st)
+	params, err := initActorContextParams(ctx, cached, vms)
+	if state.IsActorNotFoundError(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	vmCtx := vm.NewVMContext(params)
+
+	keys := make(map[address.Address]address.Address, len(ids))
+	for _, id := range ids {
+		if id.Protocol() != address.ID {
+			continue
+		}
+		key, found, err := initactor.LookupAddress(vmCtx, leb128.ToUInt64(id.Payload()))
+		if err != nil {
+			return nil, err
+		}
+		if found && key.Protocol() != address.ID {
+			keys[id] = key
+		}
+	}
+	return keys, nil
+}
@@ -0,0 +1,137 @@
+package consensus_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	. "github.com/filecoin-project/go-filecoin/internal/pkg/consensus"
+	th "github.com/filecoin-project/go-filecoin/internal/pkg/testhelpers"
+	tf "github.com/filecoin-project/go-filecoin/internal/pkg/testhelpers/testflags"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/types"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/actor"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/actor/builtin"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/address"
+)
+
+func TestApplyMessageWithDelta(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+	fakeActorCodeCid := types.NewCidForTestGetter()()
+	actors := builtin.NewBuilder().
+		AddAll(builtin.DefaultActors).
+		Add(fakeActorCodeCid, 0, &actor.FakeActor{}).
+		Build()
+	processor := NewConfiguredProcessor(NewDefaultMessageValidator(), NewDefaultBlockRewarder(), actors)
+
+	// Two nodes start from the same state. The message creates a new account actor for its recipient.
+	executingVms := th.VMStorage()
+	addresses, executed := setupActorsForGasTest(t, executingVms, fakeActorCodeCid, 1000)
+	_, synced := setupActorsForGasTest(t, th.VMStorage(), fakeActorCodeCid, 1000)
+
+	newAddr := address.NewForTestGetter()()
+	msg := types.NewMeteredMessage(addresses[0], newAddr, 0, types.NewAttoFILFromFIL(10), types.SendMethodID, nil, types.NewGasPrice(1), types.NewGasUnits(300))
+
+	result, delta, err := processor.ApplyMessageWithDelta(ctx, executed, executingVms, msg, addresses[3], types.NewBlockHeight(0), vm.NewLegacyGasTracker(), nil)
+	require.NoError(t, err)
+	require.NoError(t, result.ExecutionError)
+
+	// sender, recipient and init actor
+	assert.Len(t, delta.Entries, 3)
+
+	raw, err := delta.Encode()
+	require.NoError(t, err)
+	decoded, err := DecodeStateDelta(raw)
+	require.NoError(t, err)
+	require.NoError(t, ApplyStateDelta(ctx, synced, decoded))
+
+	executedRoot, err := executed.Flush(ctx)
+	require.NoError(t, err)
+	syncedRoot, err := synced.Flush(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, executedRoot, syncedRoot)
+}
+
+func TestStateDeltaKinds(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+	fakeActorCodeCid := types.NewCidForTestGetter()()
+	actors := builtin.NewBuilder().
+		AddAll(builtin.DefaultActors).
+		Add(fakeActorCodeCid, 0, &actor.FakeActor{}).
+		Build()
+	processor := NewConfiguredProcessor(NewDefaultMessageValidator(), NewDefaultBlockRewarder(), actors)
+
+	vms := th.VMStorage()
+	addresses, st := setupActorsForGasTest(t, vms, fakeActorCodeCid, 1000)
+
+	// Sending to a fresh address creates an actor for it and updates the sender and init actor.
+	msg := types.NewMeteredMessage(addresses[0], address.NewForTestGetter()(), 0, types.NewAttoFILFromFIL(10), types.SendMethodID, nil, types.NewGasPrice(1), types.NewGasUnits(300))
+	result, delta, err := processor.ApplyMessageWithDelta(ctx, st, vms, msg, addresses[3], types.NewBlockHeight(0), vm.NewLegacyGasTracker(), nil)
+	require.NoError(t, err)
+	require.NoError(t, result.ExecutionError)
+	require.True(t, result.Extended.ActorCreated)
+
+	kinds := map[address.Address]DeltaKind{}
+	for _, entry := range delta.Entries {
+		kinds[entry.Address] = entry.Kind
+	}
+	assert.Equal(t, map[address.Address]DeltaKind{
+		result.Extended.CreatedActorAddr: DeltaCreated,
+		result.Extended.FromAddr:         DeltaUpdated,
+		address.InitAddress:              DeltaUpdated,
+	}, kinds)
+
+	raw, err := delta.Encode()
+	require.NoError(t, err)
+	decoded, err := DecodeStateDelta(raw)
+	require.NoError(t, err)
+	assert.Equal(t, delta.Entries[0].Kind, decoded.Entries[0].Kind)
+}
+
+func TestStateDeltaKeyAddresses(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+	fakeActorCodeCid := types.NewCidForTestGetter()()
+	actors := builtin.NewBuilder().
+		AddAll(builtin.DefaultActors).
+		Add(fakeActorCodeCid, 0, &actor.FakeActor{}).
+		Build()
+	processor := NewConfiguredProcessor(NewDefaultMessageValidator(), NewDefaultBlockRewarder(), actors)
+
+	vms := th.VMStorage()
+	addresses, st := setupActorsForGasTest(t, vms, fakeActorCodeCid, 1000)
+
+	addrGetter := address.NewForTestGetter()
+	keyAddr := addrGetter()
+	_, unchangedID := th.RequireInitAccountActor(ctx, t, st, vms, addrGetter(), types.NewAttoFILFromFIL(1))
+	msg := types.NewMeteredMessage(addresses[0], keyAddr, 0, types.NewAttoFILFromFIL(10), types.SendMethodID, nil, types.NewGasPrice(1), types.NewGasUnits(300))
+	result, delta, err := processor.ApplyMessageWithDelta(ctx, st, vms, msg, addresses[3], types.NewBlockHeight(0), vm.NewLegacyGasTracker(), nil)
+	require.NoError(t, err)
+	require.True(t, result.Extended.ActorCreated)
+
+	keys := map[address.Address]address.Address{}
+	for _, entry := range delta.Entries {
+		keys[entry.Address] = entry.KeyAddress
+	}
+	assert.Equal(t, map[address.Address]address.Address{
+		result.Extended.CreatedActorAddr: keyAddr,
+		result.Extended.FromAddr:         addresses[0],
+		address.InitAddress:              address.Undef,
+	}, keys)
+	assert.NotContains(t, keys, unchangedID)
+
+	raw, err := delta.Encode()
+	require.NoError(t, err)
+	decoded, err := DecodeStateDelta(raw)
+	require.NoError(t, err)
+	for i, entry := range delta.Entries {
+		assert.Equal(t, entry.KeyAddress, decoded.Entries[i].KeyAddress)
+	}
+}
@@ -0,0 +1,89 @@
+package consensus
+
+import (
+	"sync"
+
+	"github.com/ipfs/go-cid"
+
+	"github.com/filecoin-project/go-filecoin/internal/pkg/types"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/actor"
+)
+
+// estimateGranularity is the multiple of gas units cached estimates are rounded up to, so
+// that structurally identical calls using slightly more gas are not under-estimated.
+const estimateGranularity = types.GasUnits(100)
+
+// estimateKey is a coarse fingerprint of a call: calls with the same key are expected to use
+// about the same amount of gas.
+type estimateKey struct {
+	code       cid.Cid
+	head       cid.Cid // the version of the recipient's state
+	method     types.MethodID
+	paramsSize int
+}
+
+type cachedEstimate struct {
+	gas    types.GasUnits
+	height *types.BlockHeight
+}
+
+// EstimateCache caches the gas estimates made by PreviewQueryMethod for calls with the same
+// recipient code and state, method and size of parameters. Estimates are rounded up and
+// expire once the chain has advanced maxAge blocks past the height they were made at.
+type EstimateCache struct {
+	maxAge *types.BlockHeight
+
+	lk      sync.Mutex
+	entries map[estimateKey]cachedEstimate
+	hits    uint64
+}
+
+// NewEstimateCache creates an empty cache keeping estimates for maxAge blocks.
+func NewEstimateCache(maxAge uint64) *EstimateCache {
+	return &EstimateCache{
+		maxAge:  types.NewBlockHeight(maxAge),
+		entries: map[estimateKey]cachedEstimate{},
+	}
+}
+
+// WithEstimateCache makes the processor serve gas estimates from cache where possible.
+func WithEstimateCache(cache *EstimateCache) ProcessorOption {
+	return func(p *DefaultProcessor) {
+		p.estimates = cache
+	}
+}
+
+// Hits returns the number of estimates served from the cache.
+func (c *EstimateCache) Hits() uint64 {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	return c.hits
+}
+
+func newEstimateKey(to *actor.Actor, method types.MethodID, params []byte) estimateKey {
+	return estimateKey{code: to.Code, head: to.Head, method: method, paramsSize: len(params)}
+}
+
+// get returns the cached estimate for key if it was made no more than maxAge blocks before bh.
+func (c *EstimateCache) get(key estimateKey, bh *types.BlockHeight) (types.GasUnits, bool) {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok || entry.height.Add(c.maxAge).LessThan(bh) {
+		return types.GasUnits(0), false
+	}
+	c.hits++
+	return entry.gas, true
+}
+
+// put records an estimate made at bh and returns it rounded up, as it will be reported to
+// all callers hitting the cache.
+func (c *EstimateCache) put(key estimateKey, bh *types.BlockHeight, gas types.GasUnits) types.GasUnits {
+	rounded := (gas + estimateGranularity - 1) / estimateGranularity * estimateGranularity
+
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	c.entries[key] = cachedEstimate{gas: rounded, height: bh}
+	return rounded
+}
@@ -0,0 +1,52 @@
+package consensus_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	. "github.com/filecoin-project/go-filecoin/internal/pkg/consensus"
+	th "github.com/filecoin-project/go-filecoin/internal/pkg/testhelpers"
+	tf "github.com/filecoin-project/go-filecoin/internal/pkg/testhelpers/testflags"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/types"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/actor"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/actor/builtin"
+)
+
+func TestEstimateCache(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+	fakeActorCodeCid := types.NewCidForTestGetter()()
+	actors := builtin.NewBuilder().
+		AddAll(builtin.DefaultActors).
+		Add(fakeActorCodeCid, 0, &actor.FakeActor{}).
+		Build()
+	vms := th.VMStorage()
+	addresses, st := setupActorsForGasTest(t, vms, fakeActorCodeCid, 1000)
+
+	cache := NewEstimateCache(10)
+	processor := NewConfiguredProcessor(NewDefaultMessageValidator(), NewDefaultBlockRewarder(), actors, WithEstimateCache(cache))
+
+	estimate := func(height uint64) types.GasUnits {
+		// HasReturnValue charges 100 gas units
+		gas, err := processor.PreviewQueryMethod(ctx, st, vms, addresses[1], actor.HasReturnValueID, nil, addresses[0], types.NewBlockHeight(height))
+		require.NoError(t, err)
+		return gas
+	}
+
+	first := estimate(5)
+	assert.Equal(t, uint64(0), cache.Hits())
+
+	// A structurally identical call is served from the cache.
+	second := estimate(8)
+	assert.Equal(t, uint64(1), cache.Hits())
+	assert.Equal(t, first, second)
+	assert.True(t, second >= types.NewGasUnits(100))
+
+	// Estimates expire once they are older than the staleness bound.
+	estimate(16)
+	assert.Equal(t, uint64(1), cache.Hits())
+}
@@ -0,0 +1,71 @@
+package consensus
+
+import (
+	"context"
+
+	"github.com/filecoin-project/go-filecoin/internal/pkg/types"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/actor"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/actor/builtin/account"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/address"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/errors"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/state"
+)
+
+// GasRange bounds the gas a message is estimated to use across the states it was run against.
+type GasRange struct {
+	Min types.GasUnits
+	Max types.GasUnits
+}
+
+// EstimateGasRange estimates the gas msg uses, as PreviewQueryMethod does, against st and
+// against st perturbed as by a competing chain, so wallets can pad the gas limit to Max. The
+// perturbed state replaces an existing recipient with the account actor that would be created
+// for it had it not existed. Runs in which the call reverts still count, since a message that
+// reverts pays for the gas it used.
+func (p *DefaultProcessor) EstimateGasRange(ctx context.Context, st state.Tree, vms vm.StorageMap, msg *types.UnsignedMessage, bh *types.BlockHeight) (*GasRange, error) {
+	states := []state.Tree{st}
+
+	toAddr, found, err := p.ResolveAddress(ctx, msg.To, state.NewCachedTree(st), vms)
+	if err != nil {
+		return nil, errors.FaultErrorWrap(err, "could not resolve recipient address")
+	}
+	if found {
+		fresh, err := account.NewActor(types.ZeroAttoFIL)
+		if err != nil {
+			return nil, errors.FaultErrorWrap(err, "could not create account actor")
+		}
+		states = append(states, &freshRecipientTree{Tree: st, addr: toAddr, fresh: fresh})
+	}
+
+	var gasRange *GasRange
+	for _, s := range states {
+		gas, err := p.PreviewQueryMethod(ctx, s, vms, msg.To, msg.Method, msg.Params, msg.From, bh)
+		if err != nil && !errors.ShouldRevert(err) {
+			return nil, err
+		}
+		if gasRange == nil {
+			gasRange = &GasRange{Min: gas, Max: gas}
+		} else if gas < gasRange.Min {
+			gasRange.Min = gas
+		} else if gas > gasRange.Max {
+			gasRange.Max = gas
+		}
+	}
+	return gasRange, nil
+}
+
+// freshRecipientTree is a state tree in which the actor at addr is a newly created account.
+type freshRecipientTree struct {
+	state.Tree
+	addr  address.Address
+	fresh *actor.Actor
+}
+
+func (t *freshRecipientTree) GetActor(ctx context.Context, a address.Address) (*actor.Actor, error) {
+	if a == t.addr {
+		act := *t.fresh
+		return &act, nil
+	}
+	return t.Tree.GetActor(ctx, a)
+}
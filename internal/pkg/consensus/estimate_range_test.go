@@ -0,0 +1,48 @@
+package consensus_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	. "github.com/filecoin-project/go-filecoin/internal/pkg/consensus"
+	th "github.com/filecoin-project/go-filecoin/internal/pkg/testhelpers"
+	tf "github.com/filecoin-project/go-filecoin/internal/pkg/testhelpers/testflags"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/types"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/actor"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/actor/builtin"
+)
+
+func TestEstimateGasRange(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+	fakeActorCodeCid := types.NewCidForTestGetter()()
+	builtinActors := builtin.NewBuilder().
+		AddAll(builtin.DefaultActors).
+		Add(fakeActorCodeCid, 0, &actor.FakeActor{}).
+		Build()
+	processor := NewConfiguredProcessor(NewDefaultMessageValidator(), &FakeBlockRewarder{}, builtinActors)
+
+	vms := th.VMStorage()
+	addresses, st := setupActorsForGasTest(t, vms, fakeActorCodeCid, 1000)
+
+	t.Run("a plain send has a single estimate", func(t *testing.T) {
+		msg := types.NewMeteredMessage(addresses[0], addresses[3], 0, types.NewAttoFILFromFIL(1), types.SendMethodID, nil, types.NewGasPrice(1), types.NewGasUnits(300))
+		gasRange, err := processor.EstimateGasRange(ctx, st, vms, msg, types.NewBlockHeight(0))
+		require.NoError(t, err)
+		assert.Equal(t, gasRange.Min, gasRange.Max)
+	})
+
+	t.Run("a call the recipient might not implement widens the range", func(t *testing.T) {
+		// Had the fake actor not existed, the message would go to a new account actor, which
+		// does not implement the method.
+		msg := types.NewMeteredMessage(addresses[0], addresses[1], 0, types.ZeroAttoFIL, actor.HasReturnValueID, nil, types.NewGasPrice(1), types.NewGasUnits(300))
+		gasRange, err := processor.EstimateGasRange(ctx, st, vms, msg, types.NewBlockHeight(0))
+		require.NoError(t, err)
+		assert.Equal(t, types.NewGasUnits(0), gasRange.Min)
+		assert.Equal(t, types.NewGasUnits(100), gasRange.Max)
+	})
+}
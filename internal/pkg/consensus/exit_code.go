@@ -0,0 +1,26 @@
+package consensus
+
+import (
+	"github.com/ipfs/go-cid"
+
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm"
+)
+
+// UnknownExitCodePolicy determines what happens to a message whose recipient fails it with an
+// exit code the processor does not recognize, such as one defined by a later actor version.
+type UnknownExitCodePolicy int
+
+const (
+	// UnknownExitCodeRevert treats unknown exit codes like any other failure: the message's
+	// changes are reverted and it pays for its gas.
+	UnknownExitCodeRevert UnknownExitCodePolicy = iota
+	// UnknownExitCodeFault fails the message with a fault, so that the block containing it
+	// cannot be processed.
+	UnknownExitCodeFault
+)
+
+// isKnownExitCode reports whether exitCode is produced by the VM or defined by the actor code
+// the message was sent to.
+func (p *DefaultProcessor) isKnownExitCode(code cid.Cid, exitCode uint8) bool {
+	return exitCode == 0 || vm.IsVMExitCode(exitCode) || p.actors.IsActorExitCode(code, exitCode)
+}
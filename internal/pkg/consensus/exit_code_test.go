@@ -0,0 +1,56 @@
+package consensus_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	. "github.com/filecoin-project/go-filecoin/internal/pkg/consensus"
+	th "github.com/filecoin-project/go-filecoin/internal/pkg/testhelpers"
+	tf "github.com/filecoin-project/go-filecoin/internal/pkg/testhelpers/testflags"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/types"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/actor"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/actor/builtin"
+	vmerrors "github.com/filecoin-project/go-filecoin/internal/pkg/vm/errors"
+)
+
+func TestUnknownExitCodePolicy(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+	fakeActorCodeCid := types.NewCidForTestGetter()()
+	actors := builtin.NewBuilder().
+		AddAll(builtin.DefaultActors).
+		Add(fakeActorCodeCid, 0, &actor.FakeActor{}).
+		Build()
+
+	apply := func(policy UnknownExitCodePolicy, method types.MethodID) (*ApplicationResult, error) {
+		processor := NewConfiguredProcessor(NewDefaultMessageValidator(), &FakeBlockRewarder{}, actors, WithUnknownExitCodePolicy(policy))
+		vms := th.VMStorage()
+		addresses, st := setupActorsForGasTest(t, vms, fakeActorCodeCid, 1000)
+		msg := types.NewMeteredMessage(addresses[0], addresses[1], 0, types.ZeroAttoFIL, method, nil, types.NewGasPrice(1), types.NewGasUnits(1000))
+		return processor.ApplyMessage(ctx, st, vms, msg, addresses[3], types.NewBlockHeight(0), vm.NewLegacyGasTracker(), nil)
+	}
+
+	// NonZeroExitCode fails with exit code 42, which the fake actor does not define.
+	t.Run("revert policy reverts unknown exit code", func(t *testing.T) {
+		result, err := apply(UnknownExitCodeRevert, actor.NonZeroExitCodeID)
+		require.NoError(t, err)
+		assert.Equal(t, uint8(42), result.Receipt.ExitCode)
+	})
+
+	t.Run("fault policy faults on unknown exit code", func(t *testing.T) {
+		_, err := apply(UnknownExitCodeFault, actor.NonZeroExitCodeID)
+		require.Error(t, err)
+		assert.True(t, vmerrors.IsFault(err))
+	})
+
+	t.Run("fault policy accepts the generic revert code", func(t *testing.T) {
+		result, err := apply(UnknownExitCodeFault, actor.ChargeGasAndRevertErrorID)
+		require.NoError(t, err)
+		assert.Equal(t, uint8(1), result.Receipt.ExitCode)
+	})
+}
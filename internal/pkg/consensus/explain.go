@@ -0,0 +1,81 @@
+package consensus
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+
+	"github.com/filecoin-project/go-filecoin/internal/pkg/types"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/actor"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/errors"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/state"
+)
+
+// ExplainRejection runs the checks made before a message is executed and describes the first
+// one msg fails, e.g. "permanent rejection by nonce check: nonce 3 but account is at 5". It
+// returns an empty string if msg would be executed. No changes are made to st.
+func (p *DefaultProcessor) ExplainRejection(ctx context.Context, st state.Tree, vms vm.StorageMap, msg *types.UnsignedMessage) (string, error) {
+	cachedSt := state.NewCachedTree(st)
+
+	fromActor, _, err := lookupActor(ctx, cachedSt, vms, p.addressResolver, msg.From)
+	if _, notFound := AsActorNotFound(err); notFound {
+		return fmt.Sprintf("temporary rejection: sender %s does not exist", msg.From), nil
+	} else if err != nil {
+		return "", err
+	}
+
+	if err := p.validator.Validate(ctx, msg, fromActor); err != nil {
+		ve, ok := err.(*ValidationError)
+		if !ok {
+			return fmt.Sprintf("%s rejection: %s", rejectionClass(err), err), nil
+		}
+		return fmt.Sprintf("%s rejection by %s check: %s", rejectionClass(ve.Err), ve.Rule, explainRule(ve, msg, fromActor)), nil
+	}
+
+	if p.strictRecipients {
+		_, _, err := lookupActor(ctx, cachedSt, vms, p.addressResolver, msg.To)
+		if _, notFound := AsActorNotFound(err); notFound {
+			return fmt.Sprintf("temporary rejection: recipient %s does not exist", msg.To), nil
+		} else if err != nil {
+			return "", err
+		}
+	}
+
+	toActor, _, _, err := getOrCreateActor(ctx, cachedSt, vms, p.addressResolver, msg.To, nil, nil)
+	if err != nil {
+		return "", errors.FaultErrorWrap(err, "failed to get To actor")
+	}
+	if p.veto != nil {
+		if reason := p.veto(ctx, msg, fromActor, toActor); reason != nil {
+			return fmt.Sprintf("permanent rejection: %s", &VetoError{Reason: reason}), nil
+		}
+	}
+	return "", nil
+}
+
+// rejectionClass describes whether a message rejected with err might later be accepted.
+func rejectionClass(err error) string {
+	if isPermanentError(err) {
+		return "permanent"
+	}
+	return "temporary"
+}
+
+// explainRule describes the state values a failed validation rule was checked against.
+func explainRule(ve *ValidationError, msg *types.UnsignedMessage, fromActor *actor.Actor) string {
+	switch ve.Rule {
+	case RuleNonce:
+		return fmt.Sprintf("nonce %d but account is at %d", msg.CallSeqNum, fromActor.CallSeqNum)
+	case RuleBalance:
+		maximumGasCharge := msg.GasPrice.MulBigInt(big.NewInt(int64(msg.GasLimit)))
+		return fmt.Sprintf("value %s plus gas limit %d at price %s needs %s but balance is %s",
+			msg.Value, msg.GasLimit, msg.GasPrice, msg.Value.Add(maximumGasCharge), fromActor.Balance)
+	case RuleGasLimit:
+		return fmt.Sprintf("gas limit %d above block limit %d", msg.GasLimit, types.BlockGasLimit)
+	case RuleSenderActor:
+		return fmt.Sprintf("sender has actor code %s", fromActor.Code)
+	default:
+		return ve.Err.Error()
+	}
+}
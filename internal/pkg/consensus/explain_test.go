@@ -0,0 +1,55 @@
+package consensus_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ipfs/go-hamt-ipld"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	. "github.com/filecoin-project/go-filecoin/internal/pkg/consensus"
+	th "github.com/filecoin-project/go-filecoin/internal/pkg/testhelpers"
+	tf "github.com/filecoin-project/go-filecoin/internal/pkg/testhelpers/testflags"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/types"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/address"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/state"
+)
+
+func TestExplainRejection(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+	vms := th.VMStorage()
+	st := state.NewTree(hamt.NewCborStore())
+
+	newAddress := address.NewForTestGetter()
+	from, to := newAddress(), newAddress()
+	sender, senderID := th.RequireInitAccountActor(ctx, t, st, vms, from, types.NewAttoFILFromFIL(1000))
+	sender.CallSeqNum = 5
+	require.NoError(t, st.SetActor(ctx, senderID, sender))
+	before, err := st.Flush(ctx)
+	require.NoError(t, err)
+
+	processor := NewDefaultProcessor()
+
+	t.Run("nonce too low names both nonces", func(t *testing.T) {
+		msg := types.NewMeteredMessage(from, to, 3, types.ZeroAttoFIL, types.SendMethodID, nil, types.NewGasPrice(1), types.NewGasUnits(300))
+		explanation, err := processor.ExplainRejection(ctx, st, vms, msg)
+		require.NoError(t, err)
+		assert.Contains(t, explanation, "permanent")
+		assert.Contains(t, explanation, "nonce 3")
+		assert.Contains(t, explanation, "at 5")
+	})
+
+	t.Run("acceptable message has no explanation", func(t *testing.T) {
+		msg := types.NewMeteredMessage(from, to, 5, types.ZeroAttoFIL, types.SendMethodID, nil, types.NewGasPrice(1), types.NewGasUnits(300))
+		explanation, err := processor.ExplainRejection(ctx, st, vms, msg)
+		require.NoError(t, err)
+		assert.Empty(t, explanation)
+	})
+
+	after, err := st.Flush(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, before, after)
+}
@@ -0,0 +1,142 @@
+package consensus
+
+import (
+	"github.com/pkg/errors"
+
+	vmerrors "github.com/filecoin-project/go-filecoin/internal/pkg/vm/errors"
+)
+
+// FailureReason classifies why a message could not be applied, so that failures may be grouped
+// without matching on error strings. Whether a reason is temporary or permanent is reported
+// alongside it.
+type FailureReason int
+
+// Reasons a message could not be applied.
+const (
+	// FailureNone means the message was applied. It may still have failed to execute.
+	FailureNone FailureReason = iota
+	// FailureUnknown means the failure has no more specific reason.
+	FailureUnknown
+	// FailureNonceGap means the message's nonce is ahead of the sender's.
+	FailureNonceGap
+	// FailureNonceUsed means the message's nonce has already been used by the sender.
+	FailureNonceUsed
+	// FailureInsufficientGas means the sender's balance does not cover the value and the gas
+	// the message reserves.
+	FailureInsufficientGas
+	// FailureGasTooHighThisBlock means the message's gas limit does not fit in the gas left in
+	// the block.
+	FailureGasTooHighThisBlock
+	// FailureGasAboveBlockLimit means the message's gas limit exceeds the block gas limit.
+	FailureGasAboveBlockLimit
+	// FailureBadSignature means the message's signature is invalid.
+	FailureBadSignature
+	// FailureSelfSend means the message is sent by its recipient.
+	FailureSelfSend
+	// FailureInvalidRecipient means the message's recipient address is undefined or invalid.
+	FailureInvalidRecipient
+	// FailureNonAccountSender means the message's sender is not an account actor.
+	FailureNonAccountSender
+	// FailureNegativeValue means the message's value is negative.
+	FailureNegativeValue
+	// FailureActorNotFound means an actor named by the message does not exist.
+	FailureActorNotFound
+	// FailureSenderBudgetExhausted means the sender has used up its gas budget.
+	FailureSenderBudgetExhausted
+	// FailureVetoed means the processor's veto rejected the message.
+	FailureVetoed
+	// FailureActorPaused means the message's recipient is paused.
+	FailureActorPaused
+	// FailureNoOp means the message does nothing and the validator rejects such messages.
+	FailureNoOp
+	// FailureMethodRateLimited means the invoked method has reached its limit in the tipset.
+	FailureMethodRateLimited
+	// FailureMissingCapability means the message called a method requiring a capability its
+	// caller lacks.
+	FailureMissingCapability
+	// FailureValueAboveMax means the message's value exceeds the maximum the validator allows a
+	// message to transfer.
+	FailureValueAboveMax
+)
+
+var failureReasonNames = map[FailureReason]string{
+	FailureNone:                  "none",
+	FailureUnknown:               "unknown",
+	FailureNonceGap:              "nonce gap",
+	FailureNonceUsed:             "nonce used",
+	FailureInsufficientGas:       "insufficient gas",
+	FailureGasTooHighThisBlock:   "gas too high this block",
+	FailureGasAboveBlockLimit:    "gas above block limit",
+	FailureBadSignature:          "bad signature",
+	FailureSelfSend:              "self send",
+	FailureInvalidRecipient:      "invalid recipient",
+	FailureNonAccountSender:      "non-account sender",
+	FailureNegativeValue:         "negative value",
+	FailureActorNotFound:         "actor not found",
+	FailureSenderBudgetExhausted: "sender budget exhausted",
+	FailureVetoed:                "vetoed",
+	FailureActorPaused:           "actor paused",
+	FailureNoOp:                  "no-op",
+	FailureMethodRateLimited:     "method rate limited",
+	FailureMissingCapability:     "missing capability",
+	FailureValueAboveMax:         "value above max",
+}
+
+func (r FailureReason) String() string {
+	if name, ok := failureReasonNames[r]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// FailureReasonOf returns the reason for a failure to apply a message, as returned by
+// ApplyMessage. A nil error has no reason.
+func FailureReasonOf(err error) FailureReason {
+	if err == nil {
+		return FailureNone
+	}
+
+	cause := errors.Cause(err)
+	switch cause {
+	case errNonceTooHigh:
+		return FailureNonceGap
+	case errNonceTooLow:
+		return FailureNonceUsed
+	case errInsufficientGas:
+		return FailureInsufficientGas
+	case errGasTooHighForCurrentBlock:
+		return FailureGasTooHighThisBlock
+	case errGasAboveBlockLimit:
+		return FailureGasAboveBlockLimit
+	case errInvalidSignature:
+		return FailureBadSignature
+	case errSelfSend:
+		return FailureSelfSend
+	case errInvalidRecipient:
+		return FailureInvalidRecipient
+	case errNonAccountActor:
+		return FailureNonAccountSender
+	case errNegativeValue, vmerrors.Errors[vmerrors.ErrCannotTransferNegativeValue]:
+		return FailureNegativeValue
+	case errNoOpMessage:
+		return FailureNoOp
+	case errValueAboveMax:
+		return FailureValueAboveMax
+	}
+
+	switch cause.(type) {
+	case *ErrActorNotFound:
+		return FailureActorNotFound
+	case *SenderBudgetExhaustedError:
+		return FailureSenderBudgetExhausted
+	case *VetoError:
+		return FailureVetoed
+	case *PausedActorError:
+		return FailureActorPaused
+	case *MethodRateLimitedError:
+		return FailureMethodRateLimited
+	case *MissingCapabilityError:
+		return FailureMissingCapability
+	}
+	return FailureUnknown
+}
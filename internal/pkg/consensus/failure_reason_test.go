@@ -0,0 +1,140 @@
+package consensus_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ipfs/go-hamt-ipld"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	. "github.com/filecoin-project/go-filecoin/internal/pkg/consensus"
+	th "github.com/filecoin-project/go-filecoin/internal/pkg/testhelpers"
+	tf "github.com/filecoin-project/go-filecoin/internal/pkg/testhelpers/testflags"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/types"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/actor/builtin"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/address"
+	vmerrors "github.com/filecoin-project/go-filecoin/internal/pkg/vm/errors"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/state"
+)
+
+func TestFailureReasons(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+	newAddress := address.NewForTestGetter()
+	from, to := newAddress(), newAddress()
+	unknown := newAddress()
+
+	send := func(from, to address.Address, nonce uint64, value types.AttoFIL, gasLimit types.GasUnits) *types.UnsignedMessage {
+		return types.NewMeteredMessage(from, to, nonce, value, types.SendMethodID, nil, types.NewGasPrice(1), gasLimit)
+	}
+	oneFIL := types.NewAttoFILFromFIL(1)
+	gas := types.NewGasUnits(100)
+
+	// Each message is applied to the same initial state, in which the sender's nonce is 1.
+	cases := []struct {
+		msg       *types.UnsignedMessage
+		usedGas   types.GasUnits
+		reason    FailureReason
+		permanent bool
+	}{
+		{send(from, to, 1, oneFIL, gas), 0, FailureNone, false},
+		{send(from, to, 5, oneFIL, gas), 0, FailureNonceGap, false},
+		{send(from, to, 0, oneFIL, gas), 0, FailureNonceUsed, true},
+		{send(from, to, 1, types.NewAttoFILFromFIL(2000), gas), 0, FailureInsufficientGas, true},
+		{send(from, to, 1, oneFIL, types.BlockGasLimit+1), 0, FailureGasAboveBlockLimit, true},
+		{send(from, to, 1, oneFIL, gas), types.BlockGasLimit - 10, FailureGasTooHighThisBlock, false},
+		{send(from, from, 1, oneFIL, gas), 0, FailureSelfSend, true},
+		{send(from, address.Undef, 1, oneFIL, gas), 0, FailureInvalidRecipient, true},
+		{send(from, to, 1, types.NewAttoFILFromFIL(-1), gas), 0, FailureNegativeValue, true},
+		{send(unknown, to, 0, oneFIL, gas), 0, FailureActorNotFound, false},
+	}
+
+	processor := NewConfiguredProcessor(NewDefaultMessageValidator(), &FakeBlockRewarder{}, builtin.DefaultActors)
+	for _, tc := range cases {
+		vms := th.VMStorage()
+		st := state.NewTree(hamt.NewCborStore())
+		fromActor, fromID := th.RequireInitAccountActor(ctx, t, st, vms, from, types.NewAttoFILFromFIL(1000))
+		fromActor.CallSeqNum = 1
+		require.NoError(t, st.SetActor(ctx, fromID, fromActor))
+		th.RequireInitAccountActor(ctx, t, st, vms, to, types.ZeroAttoFIL)
+
+		gasTracker := vm.NewLegacyGasTracker()
+		if tc.usedGas > 0 {
+			// Use up most of the block's gas before the message is applied.
+			gasTracker.ResetForNewMessage(&types.UnsignedMessage{GasLimit: types.BlockGasLimit})
+			require.NoError(t, gasTracker.Charge(tc.usedGas))
+		}
+
+		_, err := processor.ApplyMessage(ctx, st, vms, tc.msg, address.TestAddress, types.NewBlockHeight(0), gasTracker, nil)
+		assert.Equal(t, tc.reason, FailureReasonOf(err), "%s: unexpected failure %v", tc.reason, err)
+		if tc.reason != FailureNone {
+			assert.Equal(t, tc.permanent, vmerrors.IsApplyErrorPermanent(err), "%s", tc.reason)
+		}
+	}
+}
+
+func TestValueAboveMaxFailure(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+	vms := th.VMStorage()
+	st := state.NewTree(hamt.NewCborStore())
+	newAddress := address.NewForTestGetter()
+	from, to := newAddress(), newAddress()
+	th.RequireInitAccountActor(ctx, t, st, vms, from, types.NewAttoFILFromFIL(1000))
+	th.RequireInitAccountActor(ctx, t, st, vms, to, types.ZeroAttoFIL)
+
+	validator := NewDefaultMessageValidator(WithMaxValue(types.NewAttoFILFromFIL(1)))
+	processor := NewConfiguredProcessor(validator, &FakeBlockRewarder{}, builtin.DefaultActors)
+	msg := types.NewMeteredMessage(from, to, 0, types.NewAttoFILFromFIL(2), types.SendMethodID, nil, types.NewGasPrice(1), types.NewGasUnits(100))
+	_, err := processor.ApplyMessage(ctx, st, vms, msg, address.TestAddress, types.NewBlockHeight(0), vm.NewLegacyGasTracker(), nil)
+	assert.Equal(t, FailureValueAboveMax, FailureReasonOf(err))
+	assert.True(t, vmerrors.IsApplyErrorPermanent(err))
+}
+
+func TestBlockGasLimitErrorReportsLimits(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+	vms := th.VMStorage()
+	st := state.NewTree(hamt.NewCborStore())
+	newAddress := address.NewForTestGetter()
+	from, to := newAddress(), newAddress()
+	th.RequireInitAccountActor(ctx, t, st, vms, from, types.NewAttoFILFromFIL(1000))
+	th.RequireInitAccountActor(ctx, t, st, vms, to, types.ZeroAttoFIL)
+
+	gasTracker := vm.NewLegacyGasTracker()
+	gasTracker.ResetForNewMessage(&types.UnsignedMessage{GasLimit: types.BlockGasLimit})
+	require.NoError(t, gasTracker.Charge(types.BlockGasLimit-10))
+
+	processor := NewConfiguredProcessor(NewDefaultMessageValidator(), &FakeBlockRewarder{}, builtin.DefaultActors)
+	msg := types.NewMeteredMessage(from, to, 0, types.NewAttoFILFromFIL(1), types.SendMethodID, nil, types.NewGasPrice(1), types.NewGasUnits(100))
+	_, err := processor.ApplyMessage(ctx, st, vms, msg, address.TestAddress, types.NewBlockHeight(0), gasTracker, nil)
+	require.Error(t, err)
+	assert.Equal(t, FailureGasTooHighThisBlock, FailureReasonOf(err))
+
+	applyErr, ok := err.(*vmerrors.ApplyErrorTemporary)
+	require.True(t, ok, "unexpected error %v", err)
+	limitErr, ok := applyErr.Cause().(*BlockGasLimitError)
+	require.True(t, ok, "unexpected cause %v", applyErr.Cause())
+	assert.Equal(t, types.NewGasUnits(100), limitErr.MessageGasLimit)
+	assert.Equal(t, types.BlockGasLimit, limitErr.BlockGasLimit)
+	assert.Equal(t, types.NewGasUnits(10), limitErr.BlockGasRemaining)
+	assert.Contains(t, err.Error(), "message gas limit too high for current block")
+	assert.Equal(t, uint8(1), vmerrors.CodeError(err))
+}
+
+func TestFailureReasonString(t *testing.T) {
+	tf.UnitTest(t)
+
+	seen := map[string]bool{}
+	for r := FailureNone; r <= FailureValueAboveMax; r++ {
+		name := r.String()
+		assert.NotEmpty(t, name)
+		assert.False(t, seen[name], "%d has the same name as another reason: %s", r, name)
+		seen[name] = true
+	}
+}
@@ -0,0 +1,120 @@
+package consensus
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/big"
+
+	"github.com/pkg/errors"
+
+	"github.com/filecoin-project/go-filecoin/internal/pkg/types"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/state"
+)
+
+// ErrFeeCapBelowBaseFee is returned when a message's fee cap cannot cover the base fee.
+var ErrFeeCapBelowBaseFee = errors.New("message fee cap is below the base fee")
+
+// MaxSafeGasEstimate is the largest gas estimate that is returned. Gas charges are computed
+// from gas units converted to int64, so estimates are kept well clear of that range, leaving
+// room for them to be summed.
+const MaxSafeGasEstimate = types.GasUnits(math.MaxInt64 / 2)
+
+// GasEstimateOverflowError is returned when a call is estimated to use more than
+// MaxSafeGasEstimate gas, for which no cost could be reliably computed.
+type GasEstimateOverflowError struct {
+	Estimate types.GasUnits
+}
+
+func (e *GasEstimateOverflowError) Error() string {
+	return fmt.Sprintf("estimated gas %d exceeds the safe maximum %d", e.Estimate, MaxSafeGasEstimate)
+}
+
+// GasCharge returns the charge for units of gas at price per priceUnits gas units. When the
+// price does not divide evenly, the charge is rounded up to the next whole AttoFIL: rounding is
+// part of consensus, so every node must charge the same amount, and rounding up ensures gas is
+// never used for free. A priceUnits of zero is treated as one.
+func GasCharge(price types.AttoFIL, units types.GasUnits, priceUnits uint64) types.AttoFIL {
+	charge := price.MulBigInt(new(big.Int).SetUint64(uint64(units)))
+	if priceUnits <= 1 {
+		return charge
+	}
+	return charge.DivCeil(types.NewAttoFIL(new(big.Int).SetUint64(priceUnits)))
+}
+
+// Messages carry a single gas price. Under a base fee model it is both the maximum
+// price per gas unit the sender is willing to pay (the fee cap) and the premium offered
+// to the miner on top of the base fee, which matches the treatment of legacy messages
+// in EIP-1559.
+
+// gasFeeCap returns the maximum price per gas unit the sender of msg is willing to pay.
+func gasFeeCap(msg *types.UnsignedMessage) types.AttoFIL {
+	return msg.GasPrice
+}
+
+// gasPremium returns the price per gas unit the sender of msg offers to the miner.
+func gasPremium(msg *types.UnsignedMessage) types.AttoFIL {
+	return msg.GasPrice
+}
+
+// EffectivePremium returns the price per gas unit the miner would receive for including msg
+// at the given base fee: the gas premium, bounded by what remains of the fee cap once the base
+// fee is paid. It is negative if the fee cap is below the base fee.
+func EffectivePremium(msg *types.UnsignedMessage, baseFee types.AttoFIL) types.AttoFIL {
+	premium := gasPremium(msg)
+	if headroom := gasFeeCap(msg).Sub(baseFee); headroom.LessThan(premium) {
+		return headroom
+	}
+	return premium
+}
+
+// EffectiveGasPrice returns the price per gas unit the sender of msg actually pays at the given
+// base fee: the base fee plus the effective premium, which is min(feeCap, baseFee + premium).
+func EffectiveGasPrice(msg *types.UnsignedMessage, baseFee types.AttoFIL) types.AttoFIL {
+	return baseFee.Add(EffectivePremium(msg, baseFee))
+}
+
+// FeeEstimate is the cost a message is expected to incur at a given base fee.
+type FeeEstimate struct {
+	// GasUnits is the estimated gas used by the message.
+	GasUnits types.GasUnits
+	// BaseFeeBurn is the portion of the gas charge burnt at the base fee.
+	BaseFeeBurn types.AttoFIL
+	// MinerTip is the portion of the gas charge paid to the miner.
+	MinerTip types.AttoFIL
+	// EffectiveGasPrice is the price per gas unit paid, which may be below the declared price.
+	EffectiveGasPrice types.AttoFIL
+	// Value is the value transferred by the message.
+	Value types.AttoFIL
+}
+
+// Total returns the total amount debited from the sender: burn + tip + value.
+func (fe *FeeEstimate) Total() types.AttoFIL {
+	return fe.BaseFeeBurn.Add(fe.MinerTip).Add(fe.Value)
+}
+
+// EstimateFeeUnderBaseFee estimates the total cost msg would incur if included at the given
+// base fee. Gas usage is estimated as in PreviewQueryMethod and charged as when the message is
+// applied, so each portion is rounded as GasCharge rounds. It returns ErrFeeCapBelowBaseFee
+// if the message could not be included at that base fee.
+func (p *DefaultProcessor) EstimateFeeUnderBaseFee(ctx context.Context, st state.Tree, vms vm.StorageMap, msg *types.UnsignedMessage, bh *types.BlockHeight, baseFee types.AttoFIL) (*FeeEstimate, error) {
+	feeCap := gasFeeCap(msg)
+	if feeCap.LessThan(baseFee) {
+		return nil, ErrFeeCapBelowBaseFee
+	}
+
+	gasUnits, err := p.PreviewQueryMethod(ctx, st, vms, msg.To, msg.Method, msg.Params, msg.From, bh)
+	if err != nil {
+		return nil, err
+	}
+
+	tipPrice := EffectivePremium(msg, baseFee)
+	return &FeeEstimate{
+		GasUnits:          gasUnits,
+		BaseFeeBurn:       GasCharge(baseFee, gasUnits, p.gasPriceUnits),
+		MinerTip:          GasCharge(tipPrice, gasUnits, p.gasPriceUnits),
+		EffectiveGasPrice: EffectiveGasPrice(msg, baseFee),
+		Value:             msg.Value,
+	}, nil
+}
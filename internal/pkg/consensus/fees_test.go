@@ -0,0 +1,163 @@
+package consensus_test
+
+import (
+	"context"
+	"math"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	. "github.com/filecoin-project/go-filecoin/internal/pkg/consensus"
+	th "github.com/filecoin-project/go-filecoin/internal/pkg/testhelpers"
+	tf "github.com/filecoin-project/go-filecoin/internal/pkg/testhelpers/testflags"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/types"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/actor"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/actor/builtin"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/address"
+)
+
+func TestEstimateFeeUnderBaseFee(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+	fakeActorCodeCid := types.NewCidForTestGetter()()
+	actors := builtin.NewBuilder().
+		AddAll(builtin.DefaultActors).
+		Add(fakeActorCodeCid, 0, &actor.FakeActor{}).
+		Build()
+	vms := th.VMStorage()
+	addresses, st := setupActorsForGasTest(t, vms, fakeActorCodeCid, 1000)
+	processor := NewConfiguredProcessor(NewDefaultMessageValidator(), NewDefaultBlockRewarder(), actors)
+
+	// HasReturnValue charges 100 gas units
+	value := types.NewAttoFILFromFIL(1)
+	msg := types.NewMeteredMessage(addresses[0], addresses[1], 0, value, actor.HasReturnValueID, nil, types.NewGasPrice(10), types.NewGasUnits(200))
+
+	low, err := processor.EstimateFeeUnderBaseFee(ctx, st, vms, msg, types.NewBlockHeight(0), types.NewGasPrice(4))
+	require.NoError(t, err)
+	high, err := processor.EstimateFeeUnderBaseFee(ctx, st, vms, msg, types.NewBlockHeight(0), types.NewGasPrice(7))
+	require.NoError(t, err)
+
+	assert.Equal(t, types.NewGasUnits(100), low.GasUnits)
+	assert.Equal(t, types.NewGasPrice(400), low.BaseFeeBurn)
+	assert.Equal(t, types.NewGasPrice(600), low.MinerTip)
+	assert.Equal(t, types.NewGasPrice(700), high.BaseFeeBurn)
+	assert.Equal(t, types.NewGasPrice(300), high.MinerTip)
+
+	// A higher base fee moves cost from the miner tip to the burn, bounded by the fee cap.
+	assert.Equal(t, value.Add(types.NewGasPrice(1000)), low.Total())
+	assert.Equal(t, low.Total(), high.Total())
+
+	_, err = processor.EstimateFeeUnderBaseFee(ctx, st, vms, msg, types.NewBlockHeight(0), types.NewGasPrice(11))
+	assert.Equal(t, ErrFeeCapBelowBaseFee, err)
+}
+
+func TestGasEstimateOverflowGuard(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+	fakeActorCodeCid := types.NewCidForTestGetter()()
+	actors := builtin.NewBuilder().
+		AddAll(builtin.DefaultActors).
+		Add(fakeActorCodeCid, 0, &actor.FakeActor{}).
+		Build()
+	vms := th.VMStorage()
+	addresses, st := setupActorsForGasTest(t, vms, fakeActorCodeCid, 1000)
+
+	// The tracker reports the call as using nearly MaxInt64 gas, whatever it charges.
+	nearMaxInt64 := types.NewGasUnits(math.MaxInt64 - 1)
+	processor := NewConfiguredProcessor(NewDefaultMessageValidator(), NewDefaultBlockRewarder(), actors, WithUnsafeFixedGas(nearMaxInt64))
+
+	_, err := processor.PreviewQueryMethod(ctx, st, vms, addresses[1], actor.HasReturnValueID, nil, addresses[0], types.NewBlockHeight(0))
+	require.Error(t, err)
+	overflow, ok := err.(*GasEstimateOverflowError)
+	require.True(t, ok, "unexpected error %s", err)
+	assert.Equal(t, nearMaxInt64, overflow.Estimate)
+
+	msg := types.NewMeteredMessage(addresses[0], addresses[1], 0, types.ZeroAttoFIL, actor.HasReturnValueID, nil, types.NewGasPrice(10), types.NewGasUnits(200))
+	_, err = processor.EstimateFeeUnderBaseFee(ctx, st, vms, msg, types.NewBlockHeight(0), types.NewGasPrice(4))
+	assert.IsType(t, &GasEstimateOverflowError{}, err)
+}
+
+func TestGasChargeRounding(t *testing.T) {
+	tf.UnitTest(t)
+
+	t.Run("fractional charges round up", func(t *testing.T) {
+		// 3 AttoFIL per 7 units for 100 units is 42.86 AttoFIL.
+		assert.Equal(t, types.NewGasPrice(43), GasCharge(types.NewGasPrice(3), types.NewGasUnits(100), 7))
+		assert.Equal(t, types.NewGasPrice(1), GasCharge(types.NewGasPrice(1), types.NewGasUnits(1), 1000))
+		assert.Equal(t, types.NewGasPrice(300), GasCharge(types.NewGasPrice(3), types.NewGasUnits(100), 1))
+		assert.Equal(t, types.NewGasPrice(50), GasCharge(types.NewGasPrice(7), types.NewGasUnits(50), 7))
+	})
+
+	t.Run("applied and estimated charges agree", func(t *testing.T) {
+		ctx := context.Background()
+		fakeActorCodeCid := types.NewCidForTestGetter()()
+		actors := builtin.NewBuilder().
+			AddAll(builtin.DefaultActors).
+			Add(fakeActorCodeCid, 0, &actor.FakeActor{}).
+			Build()
+		vms := th.VMStorage()
+		addresses, st := setupActorsForGasTest(t, vms, fakeActorCodeCid, 1000)
+		processor := NewConfiguredProcessor(NewDefaultMessageValidator(), &FakeBlockRewarder{}, actors, WithGasPriceUnits(7))
+
+		// HasReturnValue charges 100 gas units
+		msg := types.NewMeteredMessage(addresses[0], addresses[1], 0, types.ZeroAttoFIL, actor.HasReturnValueID, nil, types.NewGasPrice(3), types.NewGasUnits(200))
+		estimate, err := processor.EstimateFeeUnderBaseFee(ctx, st, vms, msg, types.NewBlockHeight(0), types.ZeroAttoFIL)
+		require.NoError(t, err)
+		result, err := processor.ApplyMessage(ctx, st, vms, msg, addresses[3], types.NewBlockHeight(0), vm.NewLegacyGasTracker(), nil)
+		require.NoError(t, err)
+
+		assert.Equal(t, types.NewGasPrice(43), result.Receipt.GasAttoFIL)
+		assert.Equal(t, result.Receipt.GasAttoFIL, estimate.MinerTip)
+	})
+}
+
+func TestEffectiveGasPrice(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+	fakeActorCodeCid := types.NewCidForTestGetter()()
+	actors := builtin.NewBuilder().
+		AddAll(builtin.DefaultActors).
+		Add(fakeActorCodeCid, 0, &actor.FakeActor{}).
+		Build()
+	vms := th.VMStorage()
+	addresses, st := setupActorsForGasTest(t, vms, fakeActorCodeCid, 1000)
+	processor := NewConfiguredProcessor(NewDefaultMessageValidator(), NewDefaultBlockRewarder(), actors)
+
+	msg := types.NewMeteredMessage(addresses[0], addresses[1], 0, types.ZeroAttoFIL, actor.HasReturnValueID, nil, types.NewGasPrice(10), types.NewGasUnits(200))
+	baseFee := types.NewGasPrice(4)
+	estimate, err := processor.EstimateFeeUnderBaseFee(ctx, st, vms, msg, types.NewBlockHeight(0), baseFee)
+	require.NoError(t, err)
+
+	// A message's gas price is both its fee cap and its premium.
+	expected := baseFee.Add(msg.GasPrice)
+	if msg.GasPrice.LessThan(expected) {
+		expected = msg.GasPrice
+	}
+	assert.True(t, expected.Equal(estimate.EffectiveGasPrice), "effective price %s", estimate.EffectiveGasPrice)
+	charged := estimate.EffectiveGasPrice.MulBigInt(big.NewInt(int64(estimate.GasUnits)))
+	assert.True(t, charged.Equal(estimate.BaseFeeBurn.Add(estimate.MinerTip)))
+}
+
+func TestEffectivePremium(t *testing.T) {
+	tf.UnitTest(t)
+
+	addr := address.NewForTestGetter()()
+	msg := types.NewMeteredMessage(addr, addr, 0, types.ZeroAttoFIL, types.SendMethodID, nil, types.NewGasPrice(10), types.NewGasUnits(100))
+
+	t.Run("fee cap above base fee", func(t *testing.T) {
+		// The premium is capped at what the fee cap leaves after paying the base fee.
+		assert.Equal(t, types.NewGasPrice(6), EffectivePremium(msg, types.NewGasPrice(4)))
+	})
+
+	t.Run("fee cap below base fee", func(t *testing.T) {
+		premium := EffectivePremium(msg, types.NewGasPrice(12))
+		assert.True(t, premium.IsNegative())
+		assert.True(t, types.NewGasPrice(2).Equal(types.ZeroAttoFIL.Sub(premium)))
+	})
+}
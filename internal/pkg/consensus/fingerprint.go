@@ -0,0 +1,232 @@
+package consensus
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/ipfs/go-cid"
+	"github.com/pkg/errors"
+
+	"github.com/filecoin-project/go-filecoin/internal/pkg/encoding"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/types"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/address"
+)
+
+func init() {
+	encoding.RegisterIpldCborType(processorConfig{})
+	encoding.RegisterIpldCborType(versionEntry{})
+	encoding.RegisterIpldCborType(gasCostEntry{})
+	encoding.RegisterIpldCborType(validatorConfig{})
+	encoding.RegisterIpldCborType(rewarderConfig{})
+	encoding.RegisterIpldCborType(migrationEntry{})
+	encoding.RegisterIpldCborType(returnLimitConfig{})
+	encoding.RegisterIpldCborType(senderBudgetConfig{})
+	encoding.RegisterIpldCborType(methodLimitEntry{})
+}
+
+// processorConfig is the part of a processor's configuration that decides how messages are
+// processed, encoded canonically for ConfigFingerprint.
+type processorConfig struct {
+	Versions          []versionEntry
+	GasCosts          []gasCostEntry
+	Validator         validatorConfig
+	Rewarder          rewarderConfig
+	MaxNestedSends    uint64
+	MemoryBudget      uint64
+	MaxSteps          uint64
+	MaxAncestors      int
+	GasPriceUnits     uint64
+	NoValueTransfer   bool
+	StrictRecipients  bool
+	FreeActorCreation bool
+	UnknownExitCodes  UnknownExitCodePolicy
+	OutOfGas          OutOfGasPolicy
+	Migrations        []migrationEntry
+	Paused            []address.Address
+	ReturnLimit       *returnLimitConfig
+	DropFailedReturns bool
+	SenderBudget      *senderBudgetConfig
+	MethodLimits      []methodLimitEntry
+	Capabilities      bool
+}
+
+// migrationEntry identifies a state migration by the codes and height it applies to; the
+// migration function itself cannot be encoded.
+type migrationEntry struct {
+	FromCode cid.Cid
+	Height   *types.BlockHeight
+	ToCode   cid.Cid
+}
+
+type returnLimitConfig struct {
+	Max    int
+	Policy ReturnSizePolicy
+}
+
+type senderBudgetConfig struct {
+	Budget types.GasUnits
+	Window *types.BlockHeight
+}
+
+type methodLimitEntry struct {
+	Code   cid.Cid
+	Method types.MethodID
+	Limit  int
+}
+
+type versionEntry struct {
+	Version     uint64
+	EffectiveAt *types.BlockHeight
+}
+
+type gasCostEntry struct {
+	Operation string
+	Cost      types.GasUnits
+}
+
+// validatorConfig identifies a message validator. The rules of a DefaultMessageValidator are
+// spelled out; other validators are known by their type alone.
+type validatorConfig struct {
+	Type           string
+	AllowHighNonce bool
+	RejectNoOps    bool
+	SenderCodes    []cid.Cid
+	MaxValue       *types.AttoFIL
+}
+
+// rewarderConfig identifies a block rewarder by its type and, if it reports one, its block
+// reward.
+type rewarderConfig struct {
+	Type        string
+	BlockReward *types.AttoFIL
+}
+
+// ConfigFingerprint returns a digest of the configuration that decides how the processor
+// processes messages: its protocol version table, gas cost table, validator rules, block reward,
+// execution limits, state migrations, paused actors, sender gas budgets and method rate limits.
+// Processors configured alike have equal fingerprints, so nodes can compare them to confirm
+// they share processing rules. The actor code, and hooks and observers such as the veto or
+// state invariant, are not included; a capability check is only recorded as being present.
+func (p *DefaultProcessor) ConfigFingerprint() (cid.Cid, error) {
+	cfg := processorConfig{
+		Versions:          []versionEntry{},
+		GasCosts:          []gasCostEntry{},
+		Validator:         describeValidator(p.validator),
+		Rewarder:          describeRewarder(p.blockRewarder),
+		MaxNestedSends:    p.maxNestedSends,
+		MemoryBudget:      p.memoryBudget,
+		MaxSteps:          p.maxSteps,
+		MaxAncestors:      p.maxAncestors,
+		GasPriceUnits:     p.gasPriceUnits,
+		NoValueTransfer:   p.noValueTransfer,
+		StrictRecipients:  p.strictRecipients,
+		FreeActorCreation: p.freeActorCreation,
+		UnknownExitCodes:  p.unknownExitCodes,
+		OutOfGas:          p.outOfGas,
+		Migrations:        describeMigrations(p.migrations),
+		Paused:            describePaused(p.paused),
+		DropFailedReturns: p.dropFailedReturns,
+		MethodLimits:      describeMethodLimits(p.methodLimits),
+		Capabilities:      p.capabilities != nil,
+	}
+	if p.returnLimit != nil {
+		cfg.ReturnLimit = &returnLimitConfig{Max: p.returnLimit.max, Policy: p.returnLimit.policy}
+	}
+	if p.senderBudgets != nil {
+		cfg.SenderBudget = &senderBudgetConfig{Budget: p.senderBudgets.budget, Window: p.senderBudgets.window}
+	}
+	if p.versions != nil {
+		p.versions.EachVersion(func(version uint64, effectiveAt *types.BlockHeight) {
+			cfg.Versions = append(cfg.Versions, versionEntry{Version: version, EffectiveAt: effectiveAt})
+		})
+	}
+	for op, cost := range p.gasCosts {
+		cfg.GasCosts = append(cfg.GasCosts, gasCostEntry{Operation: string(op), Cost: cost})
+	}
+	sort.Slice(cfg.GasCosts, func(i, j int) bool { return cfg.GasCosts[i].Operation < cfg.GasCosts[j].Operation })
+
+	raw, err := encoding.Encode(cfg)
+	if err != nil {
+		return cid.Undef, errors.Wrap(err, "could not encode processor configuration")
+	}
+	return cid.NewPrefixV1(cid.DagCBOR, types.DefaultHashFunction).Sum(raw)
+}
+
+func describeValidator(validator MessageValidator) validatorConfig {
+	desc := validatorConfig{Type: fmt.Sprintf("%T", validator), SenderCodes: []cid.Cid{}}
+	v, ok := validator.(*DefaultMessageValidator)
+	if !ok {
+		return desc
+	}
+	desc.AllowHighNonce = v.allowHighNonce
+	desc.RejectNoOps = v.rejectNoOps
+	for c, allowed := range v.senderCodes {
+		if allowed {
+			desc.SenderCodes = append(desc.SenderCodes, c)
+		}
+	}
+	sort.Slice(desc.SenderCodes, func(i, j int) bool { return desc.SenderCodes[i].KeyString() < desc.SenderCodes[j].KeyString() })
+	desc.MaxValue = v.maxValue
+	return desc
+}
+
+func describeRewarder(rewarder BlockRewarder) rewarderConfig {
+	desc := rewarderConfig{Type: fmt.Sprintf("%T", rewarder)}
+	if r, ok := rewarder.(interface{ BlockRewardAmount() types.AttoFIL }); ok {
+		reward := r.BlockRewardAmount()
+		desc.BlockReward = &reward
+	}
+	return desc
+}
+
+func describeMigrations(migrations *StateMigrations) []migrationEntry {
+	desc := []migrationEntry{}
+	if migrations == nil {
+		return desc
+	}
+	for code, ms := range migrations.byCode {
+		for _, m := range ms {
+			desc = append(desc, migrationEntry{FromCode: code, Height: m.height, ToCode: m.toCode})
+		}
+	}
+	sort.Slice(desc, func(i, j int) bool {
+		if !desc[i].FromCode.Equals(desc[j].FromCode) {
+			return desc[i].FromCode.KeyString() < desc[j].FromCode.KeyString()
+		}
+		return desc[i].Height.LessThan(desc[j].Height)
+	})
+	return desc
+}
+
+func describePaused(paused *PausedActors) []address.Address {
+	desc := []address.Address{}
+	if paused == nil {
+		return desc
+	}
+	paused.lk.RLock()
+	defer paused.lk.RUnlock()
+	for addr := range paused.paused {
+		desc = append(desc, addr)
+	}
+	sort.Slice(desc, func(i, j int) bool { return desc[i].String() < desc[j].String() })
+	return desc
+}
+
+func describeMethodLimits(limits *MethodRateLimits) []methodLimitEntry {
+	desc := []methodLimitEntry{}
+	if limits == nil {
+		return desc
+	}
+	limits.lk.Lock()
+	defer limits.lk.Unlock()
+	for key, max := range limits.limits {
+		desc = append(desc, methodLimitEntry{Code: key.code, Method: key.method, Limit: max})
+	}
+	sort.Slice(desc, func(i, j int) bool {
+		if !desc[i].Code.Equals(desc[j].Code) {
+			return desc[i].Code.KeyString() < desc[j].Code.KeyString()
+		}
+		return desc[i].Method < desc[j].Method
+	})
+	return desc
+}
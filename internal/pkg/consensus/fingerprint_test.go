@@ -0,0 +1,76 @@
+package consensus_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	. "github.com/filecoin-project/go-filecoin/internal/pkg/consensus"
+	tf "github.com/filecoin-project/go-filecoin/internal/pkg/testhelpers/testflags"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/types"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/version"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/actor/builtin"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/address"
+)
+
+func TestConfigFingerprint(t *testing.T) {
+	tf.UnitTest(t)
+
+	versions, err := version.NewProtocolVersionTableBuilder(version.TEST).
+		Add(version.TEST, 0, types.NewBlockHeight(0)).
+		Add(version.TEST, 1, types.NewBlockHeight(10)).
+		Build()
+	require.NoError(t, err)
+
+	fingerprint := func(costs vm.GasCostTable) string {
+		processor := NewConfiguredProcessor(
+			NewDefaultMessageValidator(WithNoOpMessagesRejected()),
+			NewDefaultBlockRewarder(),
+			builtin.DefaultActors,
+			WithProtocolVersions(versions),
+			WithGasCostTable(costs),
+		)
+		c, err := processor.ConfigFingerprint()
+		require.NoError(t, err)
+		return c.String()
+	}
+	costs := func(send types.GasUnits) vm.GasCostTable {
+		return vm.GasCostTable{vm.GasOnSend: send, vm.GasOnStorageRead: 5, vm.GasOnStorageWrite: 10}
+	}
+
+	assert.Equal(t, fingerprint(costs(3)), fingerprint(costs(3)))
+	assert.NotEqual(t, fingerprint(costs(3)), fingerprint(costs(4)))
+
+	t.Run("validator rules are included", func(t *testing.T) {
+		strict, err := NewConfiguredProcessor(NewDefaultMessageValidator(WithNoOpMessagesRejected()), NewDefaultBlockRewarder(), builtin.DefaultActors).ConfigFingerprint()
+		require.NoError(t, err)
+		lenient, err := NewConfiguredProcessor(NewDefaultMessageValidator(), NewDefaultBlockRewarder(), builtin.DefaultActors).ConfigFingerprint()
+		require.NoError(t, err)
+		assert.NotEqual(t, strict, lenient)
+	})
+
+	t.Run("execution rules set by options are included", func(t *testing.T) {
+		base, err := NewConfiguredProcessor(NewDefaultMessageValidator(), NewDefaultBlockRewarder(), builtin.DefaultActors).ConfigFingerprint()
+		require.NoError(t, err)
+
+		cidGetter := types.NewCidForTestGetter()
+		migrations := NewStateMigrations()
+		require.NoError(t, migrations.Register(cidGetter(), 5, cidGetter(), nil))
+		options := map[string]ProcessorOption{
+			"migrations":     WithStateMigrations(migrations),
+			"paused":         WithPausedActors(NewPausedActors(address.TestAddress)),
+			"return limit":   WithMaxReturnSize(32, ReturnSizeRevert),
+			"failed returns": WithFailedReturnsDropped(),
+			"sender budgets": WithSenderGasBudgets(NewSenderGasBudgets(types.NewGasUnits(1000), 10)),
+			"method limits":  WithMethodRateLimits(NewMethodRateLimits().Limit(cidGetter(), 2, 1)),
+			"capabilities":   WithCallerCapabilities(func(target, caller address.Address, capability vm.Capability) bool { return true }),
+		}
+		for name, option := range options {
+			configured, err := NewConfiguredProcessor(NewDefaultMessageValidator(), NewDefaultBlockRewarder(), builtin.DefaultActors, option).ConfigFingerprint()
+			require.NoError(t, err)
+			assert.NotEqual(t, base, configured, name)
+		}
+	})
+}
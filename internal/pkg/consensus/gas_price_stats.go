@@ -0,0 +1,88 @@
+package consensus
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/filecoin-project/go-filecoin/internal/pkg/types"
+)
+
+// GasPriceStats accumulates the gas prices of the messages included in the most recent tipsets
+// a processor processed, for gas price suggestions. No base fee is burnt when applying
+// messages, so a message's gas price is the whole premium paid to the miner. Messages that
+// failed to apply are not included and pay nothing.
+type GasPriceStats struct {
+	window int
+
+	lk sync.Mutex
+	// prices holds the gas prices of each tipset in the window, oldest first
+	prices [][]types.AttoFIL
+}
+
+// NewGasPriceStats creates stats over the messages of the last window tipsets processed.
+func NewGasPriceStats(window int) *GasPriceStats {
+	return &GasPriceStats{window: window}
+}
+
+// WithGasPriceStats makes the processor record in stats the gas prices of the messages included
+// in each tipset it processes.
+func WithGasPriceStats(stats *GasPriceStats) ProcessorOption {
+	return func(p *DefaultProcessor) {
+		p.gasPriceStats = stats
+	}
+}
+
+// Count returns the number of messages in the window.
+func (s *GasPriceStats) Count() int {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	count := 0
+	for _, prices := range s.prices {
+		count += len(prices)
+	}
+	return count
+}
+
+// Percentile returns the smallest gas price in the window that is at least as high as pct
+// percent of the prices, pct being between 0 and 100. It fails if the window has no messages.
+func (s *GasPriceStats) Percentile(pct int) (types.AttoFIL, error) {
+	if pct < 0 || pct > 100 {
+		return types.ZeroAttoFIL, fmt.Errorf("percentile %d is not between 0 and 100", pct)
+	}
+
+	s.lk.Lock()
+	var sorted []types.AttoFIL
+	for _, prices := range s.prices {
+		sorted = append(sorted, prices...)
+	}
+	s.lk.Unlock()
+
+	if len(sorted) == 0 {
+		return types.ZeroAttoFIL, fmt.Errorf("no gas prices recorded")
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].LessThan(sorted[j]) })
+
+	// nearest rank, with the 0th percentile being the lowest price
+	rank := (pct*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1], nil
+}
+
+// Median returns the 50th percentile gas price in the window.
+func (s *GasPriceStats) Median() (types.AttoFIL, error) {
+	return s.Percentile(50)
+}
+
+// record adds the gas prices of a tipset's messages, dropping the oldest tipset once the window
+// is full.
+func (s *GasPriceStats) record(prices []types.AttoFIL) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	s.prices = append(s.prices, prices)
+	if len(s.prices) > s.window {
+		s.prices = s.prices[len(s.prices)-s.window:]
+	}
+}
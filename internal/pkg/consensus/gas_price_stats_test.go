@@ -0,0 +1,97 @@
+package consensus_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ipfs/go-hamt-ipld"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-filecoin/internal/pkg/block"
+	. "github.com/filecoin-project/go-filecoin/internal/pkg/consensus"
+	th "github.com/filecoin-project/go-filecoin/internal/pkg/testhelpers"
+	tf "github.com/filecoin-project/go-filecoin/internal/pkg/testhelpers/testflags"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/types"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/actor"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/actor/builtin"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/address"
+)
+
+func TestGasPriceStats(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+	cst := hamt.NewCborStore()
+	vms := th.VMStorage()
+
+	fakeActorCodeCid := types.NewCidForTestGetter()()
+	builtinActors := builtin.NewBuilder().
+		AddAll(builtin.DefaultActors).
+		Add(fakeActorCodeCid, 0, &actor.FakeActor{}).
+		Build()
+
+	mockSigner, _ := types.NewMockSignersAndKeyInfo(2)
+	from, minerOwner := mockSigner.Addresses[0], mockSigner.Addresses[1]
+	fakeAddr, err := address.NewIDAddress(110)
+	require.NoError(t, err)
+
+	_, st := requireMakeStateTree(t, cst, map[address.Address]*actor.Actor{
+		address.LegacyNetworkAddress: th.RequireNewAccountActor(t, types.NewAttoFILFromFIL(1000000)),
+		fakeAddr:                     th.RequireNewFakeActorWithTokens(t, vms, fakeAddr, fakeActorCodeCid, types.ZeroAttoFIL),
+	})
+	th.RequireInitAccountActor(ctx, t, st, vms, from, types.NewAttoFILFromFIL(10000))
+	stCid, _, minerAddr := mustCreateStorageMiner(ctx, t, st, vms, minerOwner)
+
+	stats := NewGasPriceStats(2)
+	processor := NewConfiguredProcessor(NewDefaultMessageValidator(), &FakeBlockRewarder{}, builtinActors, WithGasPriceStats(stats))
+
+	nonce := uint64(0)
+	processAt := func(height uint64, prices ...int64) {
+		var msgs []*types.UnsignedMessage
+		for _, price := range prices {
+			msgs = append(msgs, types.NewMeteredMessage(from, fakeAddr, nonce, types.ZeroAttoFIL, actor.HasReturnValueID, nil, types.NewGasPrice(price), types.NewGasUnits(100)))
+			nonce++
+		}
+		blk := &block.Block{
+			Height:    types.Uint64(height),
+			StateRoot: stCid,
+			Miner:     minerAddr,
+			Ticket:    block.Ticket{VRFProof: []byte{byte(height)}},
+		}
+		_, err := processor.ProcessTipSet(ctx, st, vms, th.RequireNewTipSet(t, blk), [][]*types.UnsignedMessage{msgs}, nil)
+		require.NoError(t, err)
+	}
+	assertPercentile := func(pct int, expected int64) {
+		price, err := stats.Percentile(pct)
+		require.NoError(t, err)
+		assert.True(t, price.Equal(types.NewGasPrice(expected)), "percentile %d is %s, expected %d", pct, price, expected)
+	}
+
+	_, err = stats.Median()
+	assert.Error(t, err)
+
+	processAt(1, 3, 1, 5, 2, 4)
+	processAt(2, 10, 6, 8, 7, 9)
+	assert.Equal(t, 10, stats.Count())
+	assertPercentile(0, 1)
+	assertPercentile(50, 5)
+	assertPercentile(90, 9)
+	assertPercentile(100, 10)
+
+	// The first tipset leaves the window.
+	processAt(3, 20)
+	assert.Equal(t, 6, stats.Count())
+	assertPercentile(0, 6)
+	assertPercentile(50, 8)
+	assertPercentile(100, 20)
+
+	// A message that fails to apply pays no premium.
+	nonce += 5
+	processAt(4, 100)
+	assert.Equal(t, 1, stats.Count())
+	assertPercentile(100, 20)
+
+	_, err = stats.Percentile(101)
+	assert.Error(t, err)
+}
@@ -0,0 +1,51 @@
+package consensus
+
+import (
+	"context"
+
+	"github.com/filecoin-project/go-filecoin/internal/pkg/types"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/actor/builtin"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/errors"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/state"
+)
+
+// GenesisProcessor applies the setup messages that build a genesis state. The messages are
+// trusted: they are not validated, cost no gas and do not advance the sender's nonce. Any
+// setup message that fails aborts the setup.
+type GenesisProcessor struct {
+	processor *DefaultProcessor
+}
+
+// NewGenesisProcessor creates a processor applying setup messages with the given actors.
+func NewGenesisProcessor(actors builtin.Actors) *GenesisProcessor {
+	return &GenesisProcessor{
+		processor: NewConfiguredProcessor(&directMessageValidator{}, &DefaultBlockRewarder{}, actors),
+	}
+}
+
+// ApplyMessages applies msgs to st in order, committing the changes of each before the next
+// is applied, and returns their receipts.
+func (gp *GenesisProcessor) ApplyMessages(ctx context.Context, st state.Tree, vms vm.StorageMap, msgs ...*types.UnsignedMessage) ([]*types.MessageReceipt, error) {
+	receipts := make([]*types.MessageReceipt, len(msgs))
+	for i, msg := range msgs {
+		// Setup messages must not fail for lack of gas and are not charged for it.
+		setup := *msg
+		setup.GasPrice = types.ZeroAttoFIL
+		setup.GasLimit = types.BlockGasLimit
+
+		cachedSt := state.NewCachedTree(st)
+		receipt, err := gp.processor.attemptApplyMessage(ctx, cachedSt, vms, &setup, types.NewBlockHeight(0), vm.NewLegacyGasTracker(), nil, &ExtendedReceipt{})
+		if err != nil {
+			return nil, errors.FaultErrorWrapf(err, "failed to apply setup message %d", i)
+		}
+		if receipt.ExitCode != 0 {
+			return nil, errors.NewFaultErrorf("setup message %d failed with exit code %d", i, receipt.ExitCode)
+		}
+		if err := cachedSt.Commit(ctx); err != nil {
+			return nil, err
+		}
+		receipts[i] = receipt
+	}
+	return receipts, nil
+}
@@ -0,0 +1,67 @@
+package consensus_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ipfs/go-hamt-ipld"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	. "github.com/filecoin-project/go-filecoin/internal/pkg/consensus"
+	th "github.com/filecoin-project/go-filecoin/internal/pkg/testhelpers"
+	tf "github.com/filecoin-project/go-filecoin/internal/pkg/testhelpers/testflags"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/types"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/abi"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/actor/builtin"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/actor/builtin/account"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/actor/builtin/initactor"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/address"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/state"
+)
+
+func TestGenesisProcessor(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+	vms := th.VMStorage()
+	st := state.NewTree(hamt.NewCborStore())
+
+	network, err := account.NewActor(types.NewAttoFILFromFIL(1000000))
+	require.NoError(t, err)
+	require.NoError(t, st.SetActor(ctx, address.LegacyNetworkAddress, network))
+	require.NoError(t, st.SetActor(ctx, address.InitAddress, th.RequireNewInitActor(t, vms)))
+
+	newAddress := address.NewForTestGetter()
+	balances := map[address.Address]types.AttoFIL{
+		newAddress(): types.NewAttoFILFromFIL(100),
+		newAddress(): types.NewAttoFILFromFIL(250),
+	}
+	var msgs []*types.UnsignedMessage
+	for addr, balance := range balances {
+		params, err := abi.ToEncodedValues(types.AccountActorCodeCid, []interface{}{addr})
+		require.NoError(t, err)
+		// Setup messages need neither a nonce, gas nor a signature.
+		msgs = append(msgs, types.NewUnsignedMessage(address.LegacyNetworkAddress, address.InitAddress, 0, balance, initactor.ExecMethodID, params))
+	}
+
+	receipts, err := NewGenesisProcessor(builtin.DefaultActors).ApplyMessages(ctx, st, vms, msgs...)
+	require.NoError(t, err)
+	require.Len(t, receipts, len(msgs))
+
+	for addr, balance := range balances {
+		idAddr, found, err := ResolveAddress(ctx, addr, state.NewCachedTree(st), vms, nil)
+		require.NoError(t, err)
+		require.True(t, found, "no actor for %s", addr)
+
+		act, err := st.GetActor(ctx, idAddr)
+		require.NoError(t, err)
+		assert.Equal(t, types.AccountActorCodeCid, act.Code)
+		assert.True(t, act.Balance.Equal(balance), "balance of %s is %s", addr, act.Balance)
+	}
+
+	network, err = st.GetActor(ctx, address.LegacyNetworkAddress)
+	require.NoError(t, err)
+	assert.True(t, network.Balance.Equal(types.NewAttoFILFromFIL(1000000-350)))
+	assert.Equal(t, uint64(0), uint64(network.CallSeqNum))
+}
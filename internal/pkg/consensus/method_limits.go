@@ -0,0 +1,103 @@
+package consensus
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/ipfs/go-cid"
+
+	"github.com/filecoin-project/go-filecoin/internal/pkg/types"
+)
+
+// MethodRateLimits limits the number of times each of some actor methods may be invoked by the
+// messages of one tipset, e.g. to throttle expensive methods during congestion. Invocations are
+// counted as messages are applied and the counts restart with each tipset the processor
+// processes. Limits may be changed while the processor is in use.
+type MethodRateLimits struct {
+	lk     sync.Mutex
+	limits map[methodKey]int
+	counts map[methodKey]int
+}
+
+type methodKey struct {
+	code   cid.Cid
+	method types.MethodID
+}
+
+// NewMethodRateLimits creates an empty set of limits.
+func NewMethodRateLimits() *MethodRateLimits {
+	return &MethodRateLimits{
+		limits: map[methodKey]int{},
+		counts: map[methodKey]int{},
+	}
+}
+
+// WithMethodRateLimits makes the processor reject messages invoking a method that has been
+// invoked as many times as its limit allows in the tipset.
+func WithMethodRateLimits(limits *MethodRateLimits) ProcessorOption {
+	return func(p *DefaultProcessor) {
+		p.methodLimits = limits
+	}
+}
+
+// Limit allows the method of actor code to be invoked at most max times per tipset.
+func (l *MethodRateLimits) Limit(code cid.Cid, method types.MethodID, max int) *MethodRateLimits {
+	l.lk.Lock()
+	defer l.lk.Unlock()
+	l.limits[methodKey{code: code, method: method}] = max
+	return l
+}
+
+// Count returns the number of times the method of actor code has been invoked in the tipset
+// being processed, or the last one processed.
+func (l *MethodRateLimits) Count(code cid.Cid, method types.MethodID) int {
+	l.lk.Lock()
+	defer l.lk.Unlock()
+	return l.counts[methodKey{code: code, method: method}]
+}
+
+// MethodRateLimitedError is the cause of the failure to apply a message invoking a method
+// that has reached its limit in the tipset. The message may be applied in a later tipset.
+type MethodRateLimitedError struct {
+	Code   cid.Cid
+	Method types.MethodID
+	Limit  int
+}
+
+func (e *MethodRateLimitedError) Error() string {
+	return fmt.Sprintf("method %d of actor code %s has been invoked its limit of %d times in this tipset", e.Method, e.Code, e.Limit)
+}
+
+// ShouldRevert implements the reverterror interface, as a rejected message changes no state.
+func (e *MethodRateLimitedError) ShouldRevert() bool {
+	return true
+}
+
+// take counts an invocation of the method of actor code, or returns an error if the method
+// has reached its limit.
+func (l *MethodRateLimits) take(code cid.Cid, method types.MethodID) error {
+	l.lk.Lock()
+	defer l.lk.Unlock()
+	key := methodKey{code: code, method: method}
+	limit, ok := l.limits[key]
+	if !ok {
+		return nil
+	}
+	if l.counts[key] >= limit {
+		return &MethodRateLimitedError{Code: code, Method: method, Limit: limit}
+	}
+	l.counts[key]++
+	return nil
+}
+
+// reset forgets the invocations counted, as a new tipset is processed.
+func (l *MethodRateLimits) reset() {
+	l.lk.Lock()
+	defer l.lk.Unlock()
+	l.counts = map[methodKey]int{}
+}
+
+func isMethodRateLimited(err error) bool {
+	_, ok := err.(*MethodRateLimitedError)
+	return ok
+}
@@ -0,0 +1,87 @@
+package consensus_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ipfs/go-hamt-ipld"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-filecoin/internal/pkg/block"
+	. "github.com/filecoin-project/go-filecoin/internal/pkg/consensus"
+	th "github.com/filecoin-project/go-filecoin/internal/pkg/testhelpers"
+	tf "github.com/filecoin-project/go-filecoin/internal/pkg/testhelpers/testflags"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/types"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/actor"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/actor/builtin"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/address"
+)
+
+func TestMethodRateLimits(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+	cst := hamt.NewCborStore()
+	vms := th.VMStorage()
+
+	fakeActorCodeCid := types.NewCidForTestGetter()()
+	builtinActors := builtin.NewBuilder().
+		AddAll(builtin.DefaultActors).
+		Add(fakeActorCodeCid, 0, &actor.FakeActor{}).
+		Build()
+
+	mockSigner, _ := types.NewMockSignersAndKeyInfo(3)
+	alice, bob, minerOwner := mockSigner.Addresses[0], mockSigner.Addresses[1], mockSigner.Addresses[2]
+	fakeAddr, err := address.NewIDAddress(110)
+	require.NoError(t, err)
+
+	_, st := requireMakeStateTree(t, cst, map[address.Address]*actor.Actor{
+		address.LegacyNetworkAddress: th.RequireNewAccountActor(t, types.NewAttoFILFromFIL(1000000)),
+		fakeAddr:                     th.RequireNewFakeActorWithTokens(t, vms, fakeAddr, fakeActorCodeCid, types.ZeroAttoFIL),
+	})
+	th.RequireInitAccountActor(ctx, t, st, vms, alice, types.NewAttoFILFromFIL(10000))
+	th.RequireInitAccountActor(ctx, t, st, vms, bob, types.NewAttoFILFromFIL(10000))
+	stCid, _, minerAddr := mustCreateStorageMiner(ctx, t, st, vms, minerOwner)
+
+	limits := NewMethodRateLimits().Limit(fakeActorCodeCid, actor.HasReturnValueID, 2)
+	processor := NewConfiguredProcessor(NewDefaultMessageValidator(), &FakeBlockRewarder{}, builtinActors, WithMethodRateLimits(limits))
+
+	call := func(from address.Address, nonce uint64, method types.MethodID) *types.UnsignedMessage {
+		return types.NewMeteredMessage(from, fakeAddr, nonce, types.ZeroAttoFIL, method, nil, types.NewGasPrice(1), types.NewGasUnits(300))
+	}
+	process := func(height uint64, msgs ...*types.UnsignedMessage) []*ApplyMessageResult {
+		blk := &block.Block{Height: types.Uint64(height), StateRoot: stCid, Miner: minerAddr}
+		results, err := processor.ProcessTipSet(ctx, st, vms, th.RequireNewTipSet(t, blk), [][]*types.UnsignedMessage{msgs}, nil)
+		require.NoError(t, err)
+		require.Len(t, results, len(msgs))
+		return results
+	}
+
+	// Bob's call to the limited method is rejected, so his nonce is not consumed.
+	results := process(20,
+		call(alice, 0, actor.HasReturnValueID),
+		call(alice, 1, actor.HasReturnValueID),
+		call(bob, 0, actor.HasReturnValueID),
+		call(bob, 0, actor.NonZeroExitCodeID),
+	)
+	assert.NoError(t, results[0].Failure)
+	assert.NoError(t, results[1].Failure)
+	require.Error(t, results[2].Failure)
+	assert.False(t, results[2].FailureIsPermanent)
+	assert.Equal(t, FailureMethodRateLimited, results[2].FailureReason)
+	assert.NoError(t, results[3].Failure)
+	assert.Equal(t, 2, limits.Count(fakeActorCodeCid, actor.HasReturnValueID))
+
+	// The count restarts with the next tipset.
+	results = process(21, call(bob, 1, actor.HasReturnValueID))
+	assert.NoError(t, results[0].Failure)
+	assert.Equal(t, 1, limits.Count(fakeActorCodeCid, actor.HasReturnValueID))
+
+	// Simulating a call does not take from the limit.
+	_, err = processor.WouldAffect(ctx, st, vms, call(bob, 2, actor.HasReturnValueID), fakeAddr, types.NewBlockHeight(21))
+	require.NoError(t, err)
+	_, err = processor.ReceiptStable(ctx, call(bob, 2, actor.HasReturnValueID), st, st, vms, types.NewBlockHeight(21))
+	require.NoError(t, err)
+	assert.Equal(t, 1, limits.Count(fakeActorCodeCid, actor.HasReturnValueID))
+}
@@ -0,0 +1,99 @@
+package consensus
+
+import (
+	"context"
+	"sort"
+
+	"github.com/ipfs/go-cid"
+	"github.com/pkg/errors"
+
+	"github.com/filecoin-project/go-filecoin/internal/pkg/types"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/actor"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/address"
+)
+
+// MigrationStorage is the actor storage available to a state migration.
+type MigrationStorage interface {
+	Get(cid.Cid) ([]byte, error)
+	Put(interface{}) (cid.Cid, error)
+}
+
+// StateMigration transforms the state of an actor from an old layout to a new one. It is
+// given the actor's storage and current head, and returns the head of the migrated state.
+type StateMigration func(ctx context.Context, storage MigrationStorage, head cid.Cid) (cid.Cid, error)
+
+type stateMigration struct {
+	height  *types.BlockHeight
+	toCode  cid.Cid
+	migrate StateMigration
+}
+
+// StateMigrations is a registry of actor state migrations keyed by actor code and upgrade height.
+//
+// A migration moves an actor to a new code cid along with its new state layout. Because a
+// migration only applies to actors with its original code, the actor's code records that it
+// has run: each migration runs exactly once per actor and every node agrees on which actors
+// have been migrated.
+type StateMigrations struct {
+	byCode map[cid.Cid][]stateMigration
+}
+
+// NewStateMigrations creates an empty migration registry.
+func NewStateMigrations() *StateMigrations {
+	return &StateMigrations{byCode: map[cid.Cid][]stateMigration{}}
+}
+
+// Register adds a migration of actors with code fromCode to code toCode, which applies from the
+// given height on. The codes must differ.
+func (sm *StateMigrations) Register(fromCode cid.Cid, height uint64, toCode cid.Cid, migrate StateMigration) error {
+	if fromCode.Equals(toCode) {
+		return errors.Errorf("migration at height %d must change actor code %s", height, fromCode)
+	}
+	bh := types.NewBlockHeight(height)
+	for _, m := range sm.byCode[fromCode] {
+		if m.height.Equal(bh) {
+			return errors.Errorf("duplicate migration for actor code %s at height %d", fromCode, height)
+		}
+	}
+
+	migrations := append(sm.byCode[fromCode], stateMigration{height: bh, toCode: toCode, migrate: migrate})
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].height.LessThan(migrations[j].height) })
+	sm.byCode[fromCode] = migrations
+	return nil
+}
+
+// due returns the earliest migration registered for code that applies at height bh.
+func (sm *StateMigrations) due(code cid.Cid, bh *types.BlockHeight) (stateMigration, bool) {
+	for _, m := range sm.byCode[code] {
+		if m.height.LessEqual(bh) {
+			return m, true
+		}
+	}
+	return stateMigration{}, false
+}
+
+// apply runs every migration due at height bh on act, in sequence, changing act in place.
+func (sm *StateMigrations) apply(ctx context.Context, vms vm.StorageMap, addr address.Address, act *actor.Actor, bh *types.BlockHeight) error {
+	visited := map[cid.Cid]struct{}{}
+	for {
+		m, ok := sm.due(act.Code, bh)
+		if !ok {
+			return nil
+		}
+		if _, seen := visited[act.Code]; seen {
+			return errors.Errorf("migrations of actor %s loop back to code %s", addr, act.Code)
+		}
+		visited[act.Code] = struct{}{}
+
+		storage := vms.NewStorage(addr, act)
+		newHead, err := m.migrate(ctx, storage, act.Head)
+		if err != nil {
+			return errors.Wrapf(err, "failed to migrate actor %s", addr)
+		}
+		if err := storage.LegacyCommit(newHead, act.Head); err != nil {
+			return errors.Wrapf(err, "failed to commit migrated state of actor %s", addr)
+		}
+		act.Code = m.toCode
+	}
+}
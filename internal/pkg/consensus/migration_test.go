@@ -0,0 +1,147 @@
+package consensus_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	. "github.com/filecoin-project/go-filecoin/internal/pkg/consensus"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/encoding"
+	th "github.com/filecoin-project/go-filecoin/internal/pkg/testhelpers"
+	tf "github.com/filecoin-project/go-filecoin/internal/pkg/testhelpers/testflags"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/types"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/abi"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/actor"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/actor/builtin"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/address"
+	vmerrors "github.com/filecoin-project/go-filecoin/internal/pkg/vm/errors"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/state"
+)
+
+func TestStateMigrationRunsOncePerActor(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+	cidGetter := types.NewCidForTestGetter()
+	oldCode, newCode := cidGetter(), cidGetter()
+	actors := builtin.NewBuilder().
+		AddAll(builtin.DefaultActors).
+		Add(oldCode, 0, &actor.FakeActor{}).
+		Add(newCode, 0, &actor.FakeActor{}).
+		Build()
+	vms := th.VMStorage()
+	addresses, st := setupActorsForGasTest(t, vms, oldCode, 1000)
+
+	// the mock migration bumps a version field, which the old layout lacks
+	calls := 0
+	bumpVersion := func(ctx context.Context, storage MigrationStorage, head cid.Cid) (cid.Cid, error) {
+		calls++
+		chunk, err := storage.Get(head)
+		if err != nil {
+			return cid.Undef, err
+		}
+		versioned := map[string]uint64{}
+		if err := encoding.Decode(chunk, &versioned); err != nil {
+			versioned = map[string]uint64{"version": 0}
+		}
+		versioned["version"]++
+
+		raw, err := encoding.Encode(versioned)
+		if err != nil {
+			return cid.Undef, err
+		}
+		return storage.Put(raw)
+	}
+	migrations := NewStateMigrations()
+	require.NoError(t, migrations.Register(oldCode, 5, newCode, bumpVersion))
+	assert.Error(t, migrations.Register(oldCode, 5, newCode, bumpVersion))
+
+	processor := NewConfiguredProcessor(NewDefaultMessageValidator(), NewDefaultBlockRewarder(), actors, WithStateMigrations(migrations))
+	apply := func(nonce uint64, height uint64) {
+		msg := types.NewMeteredMessage(addresses[0], addresses[1], nonce, types.NewAttoFILFromFIL(1), types.SendMethodID, nil, types.NewGasPrice(1), types.NewGasUnits(0))
+		result, err := processor.ApplyMessage(ctx, st, vms, msg, addresses[3], types.NewBlockHeight(height), vm.NewLegacyGasTracker(), nil)
+		require.NoError(t, err)
+		require.NoError(t, result.ExecutionError)
+	}
+
+	// before the upgrade height the actor is left alone
+	apply(0, 4)
+	assert.Equal(t, 0, calls)
+	act, err := st.GetActor(ctx, addresses[1])
+	require.NoError(t, err)
+	assert.Equal(t, oldCode, act.Code)
+
+	apply(1, 5)
+	apply(2, 6)
+	assert.Equal(t, 1, calls)
+
+	act, err = st.GetActor(ctx, addresses[1])
+	require.NoError(t, err)
+	assert.Equal(t, newCode, act.Code)
+	assert.Equal(t, types.NewAttoFILFromFIL(103), act.Balance)
+
+	chunk, err := vms.NewStorage(addresses[1], act).Get(act.Head)
+	require.NoError(t, err)
+	versioned := map[string]uint64{}
+	require.NoError(t, encoding.Decode(chunk, &versioned))
+	assert.Equal(t, uint64(1), versioned["version"])
+}
+
+func TestStateMigrationRunsWhereActorsAreLoaded(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+	cidGetter := types.NewCidForTestGetter()
+	oldCode, newCode := cidGetter(), cidGetter()
+	actors := builtin.NewBuilder().
+		AddAll(builtin.DefaultActors).
+		Add(oldCode, 0, &actor.FakeActor{}).
+		Add(newCode, 0, &actor.FakeActor{}).
+		Build()
+
+	// the layout is unchanged, so the migration keeps the head
+	keepHead := func(ctx context.Context, storage MigrationStorage, head cid.Cid) (cid.Cid, error) {
+		return head, nil
+	}
+	migrations := NewStateMigrations()
+	require.NoError(t, migrations.Register(oldCode, 0, newCode, keepHead))
+	processor := NewConfiguredProcessor(NewDefaultMessageValidator(WithAllowedSenderCodes(oldCode)), NewDefaultBlockRewarder(), actors, WithStateMigrations(migrations))
+
+	requireCode := func(st state.Tree, addr address.Address, code cid.Cid) {
+		act, err := st.GetActor(ctx, addr)
+		require.NoError(t, err)
+		assert.Equal(t, code, act.Code)
+	}
+
+	t.Run("the target of a nested send is migrated", func(t *testing.T) {
+		vms := th.VMStorage()
+		addresses, st := setupActorsForGasTest(t, vms, oldCode, 1000)
+		params, err := abi.ToEncodedValues(addresses[2])
+		require.NoError(t, err)
+
+		msg := types.NewMeteredMessage(addresses[0], addresses[1], 0, types.ZeroAttoFIL, actor.RunsAnotherMessageID, params, types.NewGasPrice(1), types.NewGasUnits(300))
+		result, err := processor.ApplyMessage(ctx, st, vms, msg, addresses[3], types.NewBlockHeight(1), vm.NewLegacyGasTracker(), nil)
+		require.NoError(t, err)
+		require.NoError(t, result.ExecutionError)
+
+		requireCode(st, addresses[1], newCode)
+		requireCode(st, addresses[2], newCode)
+	})
+
+	t.Run("a message with an invalid recipient is rejected without migrating", func(t *testing.T) {
+		vms := th.VMStorage()
+		addresses, st := setupActorsForGasTest(t, vms, oldCode, 1000)
+
+		msg := types.NewMeteredMessage(addresses[1], address.Undef, 0, types.ZeroAttoFIL, types.SendMethodID, nil, types.NewGasPrice(1), types.NewGasUnits(300))
+		_, err := processor.ApplyMessage(ctx, st, vms, msg, addresses[3], types.NewBlockHeight(1), vm.NewLegacyGasTracker(), nil)
+		require.Error(t, err)
+		assert.False(t, vmerrors.IsFault(err))
+		assert.True(t, vmerrors.IsApplyErrorPermanent(err))
+
+		requireCode(st, addresses[1], oldCode)
+	})
+}
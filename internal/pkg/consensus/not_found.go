@@ -0,0 +1,56 @@
+package consensus
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/actor"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/address"
+	vmerrors "github.com/filecoin-project/go-filecoin/internal/pkg/vm/errors"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/state"
+)
+
+// ErrActorNotFound is the cause of the error returned when a message or query names an actor
+// that does not exist, whether its address does not resolve or resolves to no actor.
+type ErrActorNotFound struct {
+	// Addr is the address the actor was named by.
+	Addr address.Address
+}
+
+func (e *ErrActorNotFound) Error() string {
+	return fmt.Sprintf("actor %s not found", e.Addr)
+}
+
+// ShouldRevert marks the error as reverting the message that encountered it.
+func (e *ErrActorNotFound) ShouldRevert() bool {
+	return true
+}
+
+// AsActorNotFound returns the *ErrActorNotFound err was caused by, if any.
+func AsActorNotFound(err error) (*ErrActorNotFound, bool) {
+	notFound, ok := errors.Cause(err).(*ErrActorNotFound)
+	return notFound, ok
+}
+
+// lookupActor resolves addr with resolver and returns the actor it names along with its id
+// address. It returns an *ErrActorNotFound if there is no such actor.
+func lookupActor(ctx context.Context, st *state.CachedTree, vms vm.StorageMap, resolver addressResolver, addr address.Address) (*actor.Actor, address.Address, error) {
+	idAddr, found, err := resolver.resolve(ctx, addr, st, vms)
+	if err != nil {
+		return nil, address.Undef, vmerrors.FaultErrorWrapf(err, "Could not resolve actor address")
+	}
+	if !found {
+		return nil, address.Undef, &ErrActorNotFound{Addr: addr}
+	}
+
+	act, err := st.GetActor(ctx, idAddr)
+	if state.IsActorNotFoundError(err) {
+		return nil, address.Undef, &ErrActorNotFound{Addr: addr}
+	} else if err != nil {
+		return nil, address.Undef, vmerrors.FaultErrorWrapf(err, "failed to get actor %s", addr)
+	}
+	return act, idAddr, nil
+}
@@ -0,0 +1,54 @@
+package consensus
+
+import (
+	"github.com/filecoin-project/go-filecoin/internal/pkg/types"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/errors"
+)
+
+// OutOfGasPolicy determines how much gas a message that runs out of gas is charged for.
+type OutOfGasPolicy int
+
+const (
+	// OutOfGasChargeLimit charges a message that runs out of gas for its whole gas limit, as
+	// if it had consumed all of it. It is the default.
+	OutOfGasChargeLimit OutOfGasPolicy = iota
+	// OutOfGasChargeUsed charges a message that runs out of gas only for the gas it consumed
+	// before the charge it could not afford.
+	OutOfGasChargeUsed
+)
+
+// outOfGasMeter wraps the gas tracker metering a message so that a message that runs out of
+// gas uses the gas its policy charges it for, towards the block as well as in its receipt.
+type outOfGasMeter struct {
+	vm.GasTracker
+	policy    OutOfGasPolicy
+	exhausted bool
+}
+
+// Charge charges cost to the wrapped tracker. Under OutOfGasChargeUsed, a charge the message
+// cannot afford is refused without being passed on, since the tracker would count the whole
+// gas limit as used, and so is every charge after it.
+func (m *outOfGasMeter) Charge(cost types.GasUnits) error {
+	if m.policy == OutOfGasChargeUsed {
+		if m.exhausted || m.GasTracker.GasConsumedByMessage()+cost > m.GasTracker.MessageGasLimit() {
+			m.exhausted = true
+			return errors.NewRevertError("gas cost exceeds gas limit")
+		}
+		return m.GasTracker.Charge(cost)
+	}
+
+	err := m.GasTracker.Charge(cost)
+	if err != nil {
+		m.exhausted = true
+	}
+	return err
+}
+
+// gasToCharge returns the gas the message is charged for.
+func (m *outOfGasMeter) gasToCharge() types.GasUnits {
+	if m.exhausted && m.policy == OutOfGasChargeLimit {
+		return m.MessageGasLimit()
+	}
+	return m.GasConsumedByMessage()
+}
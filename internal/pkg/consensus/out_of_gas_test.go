@@ -0,0 +1,63 @@
+package consensus_test
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	. "github.com/filecoin-project/go-filecoin/internal/pkg/consensus"
+	th "github.com/filecoin-project/go-filecoin/internal/pkg/testhelpers"
+	tf "github.com/filecoin-project/go-filecoin/internal/pkg/testhelpers/testflags"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/types"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/actor"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/actor/builtin"
+)
+
+func TestOutOfGasPolicy(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+	fakeActorCodeCid := types.NewCidForTestGetter()()
+	actors := builtin.NewBuilder().
+		AddAll(builtin.DefaultActors).
+		Add(fakeActorCodeCid, 0, &actor.FakeActor{}).
+		Build()
+
+	// The message makes three sends costing 100 gas each with a limit of 250, so it runs out of
+	// gas during the third after consuming 200. It returns the sender's debit and the gas the
+	// message used towards the block.
+	apply := func(opts ...ProcessorOption) (types.AttoFIL, types.GasUnits) {
+		processor := NewConfiguredProcessor(NewDefaultMessageValidator(), &FakeBlockRewarder{}, actors, opts...)
+		vms := th.VMStorage()
+		addresses, st := setupActorsForGasTest(t, vms, fakeActorCodeCid, 1000)
+		params := actor.MustConvertParams(addresses[2], big.NewInt(3))
+		msg := types.NewMeteredMessage(addresses[0], addresses[1], 0, types.ZeroAttoFIL, actor.SendsRepeatedlyID, params, types.NewGasPrice(1), types.NewGasUnits(250))
+		gasTracker := vm.NewLegacyGasTracker()
+		result, err := processor.ApplyMessage(ctx, st, vms, msg, addresses[3], types.NewBlockHeight(0), gasTracker, nil)
+		require.NoError(t, err)
+		require.Error(t, result.ExecutionError)
+		debit := result.Extended.FromBalanceBefore.Sub(result.Extended.FromBalanceAfter)
+		assert.True(t, result.Receipt.GasAttoFIL.Equal(debit))
+		assert.Equal(t, gasTracker.GasConsumedByBlock(), result.Extended.GasUsed)
+		return debit, gasTracker.GasConsumedByBlock()
+	}
+
+	t.Run("charges the full gas limit by default", func(t *testing.T) {
+		debit, blockGas := apply()
+		assert.True(t, debit.Equal(types.NewAttoFIL(big.NewInt(250))), "debit %s", debit)
+		assert.Equal(t, types.NewGasUnits(250), blockGas)
+		debit, blockGas = apply(WithOutOfGasPolicy(OutOfGasChargeLimit))
+		assert.True(t, debit.Equal(types.NewAttoFIL(big.NewInt(250))), "debit %s", debit)
+		assert.Equal(t, types.NewGasUnits(250), blockGas)
+	})
+
+	t.Run("charges only the gas consumed when configured", func(t *testing.T) {
+		debit, blockGas := apply(WithOutOfGasPolicy(OutOfGasChargeUsed))
+		assert.True(t, debit.Equal(types.NewAttoFIL(big.NewInt(200))), "debit %s", debit)
+		assert.Equal(t, types.NewGasUnits(200), blockGas)
+	})
+}
@@ -0,0 +1,34 @@
+package consensus
+
+import (
+	"context"
+
+	"github.com/filecoin-project/go-filecoin/internal/pkg/types"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/address"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/errors"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/state"
+)
+
+// ApplyUntilGas applies msgs in order, for incremental block building, until the gas limit of
+// the next message would take the gas used past gasBudget. Gas is paid to minerOwnerAddr; no
+// block reward is paid. It returns how many messages it consumed from the front of msgs and
+// the remaining messages, which the caller can resume with in a later block. Messages that fail
+// to apply are consumed without using any of the budget.
+func (p *DefaultProcessor) ApplyUntilGas(ctx context.Context, st state.Tree, vms vm.StorageMap, msgs []*types.UnsignedMessage, minerOwnerAddr address.Address, gasBudget types.GasUnits, bh *types.BlockHeight) (int, []*types.UnsignedMessage, error) {
+	gasTracker := p.newBlockGasTracker()
+	used := types.NewGasUnits(0)
+	for i, msg := range msgs {
+		if used+msg.GasLimit > gasBudget {
+			return i, msgs[i:], nil
+		}
+		r, err := p.ApplyMessage(ctx, st, vms, msg, minerOwnerAddr, bh, gasTracker, nil)
+		if errors.IsFault(err) {
+			return i, msgs[i:], err
+		} else if err != nil {
+			continue
+		}
+		used += r.Extended.GasUsed
+	}
+	return len(msgs), nil, nil
+}
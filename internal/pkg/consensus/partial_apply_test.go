@@ -0,0 +1,49 @@
+package consensus_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	. "github.com/filecoin-project/go-filecoin/internal/pkg/consensus"
+	th "github.com/filecoin-project/go-filecoin/internal/pkg/testhelpers"
+	tf "github.com/filecoin-project/go-filecoin/internal/pkg/testhelpers/testflags"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/types"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/actor"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/actor/builtin"
+)
+
+func TestApplyUntilGas(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+	fakeActorCodeCid := types.NewCidForTestGetter()()
+	builtinActors := builtin.NewBuilder().
+		AddAll(builtin.DefaultActors).
+		Add(fakeActorCodeCid, 0, &actor.FakeActor{}).
+		Build()
+	processor := NewConfiguredProcessor(NewDefaultMessageValidator(), &FakeBlockRewarder{}, builtinActors)
+
+	vms := th.VMStorage()
+	addresses, st := setupActorsForGasTest(t, vms, fakeActorCodeCid, 1000)
+
+	// Each message uses all of its 100 gas limit, so only two fit in the budget.
+	var msgs []*types.UnsignedMessage
+	for nonce := uint64(0); nonce < 4; nonce++ {
+		msgs = append(msgs, types.NewMeteredMessage(addresses[0], addresses[1], nonce, types.ZeroAttoFIL, actor.HasReturnValueID, nil, types.NewGasPrice(1), types.NewGasUnits(100)))
+	}
+	budget := types.NewGasUnits(250)
+
+	applied, remaining, err := processor.ApplyUntilGas(ctx, st, vms, msgs, addresses[3], budget, types.NewBlockHeight(0))
+	require.NoError(t, err)
+	assert.Equal(t, 2, applied)
+	assert.Equal(t, msgs[2:], remaining)
+
+	// Resuming in a later block applies the rest.
+	applied, remaining, err = processor.ApplyUntilGas(ctx, st, vms, remaining, addresses[3], budget, types.NewBlockHeight(1))
+	require.NoError(t, err)
+	assert.Equal(t, 2, applied)
+	assert.Empty(t, remaining)
+}
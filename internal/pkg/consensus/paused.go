@@ -0,0 +1,75 @@
+package consensus
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/address"
+)
+
+// PausedActors is a set of actors whose methods are temporarily disabled, e.g. while an
+// incident is investigated. Actors may be paused and resumed while the processor is in use.
+// Actors are named by their ID address; a message naming a paused actor by a key address is
+// rejected too, once the address is resolved.
+type PausedActors struct {
+	lk     sync.RWMutex
+	paused map[address.Address]struct{}
+}
+
+// NewPausedActors creates a set pausing the given actors.
+func NewPausedActors(addrs ...address.Address) *PausedActors {
+	p := &PausedActors{paused: map[address.Address]struct{}{}}
+	for _, addr := range addrs {
+		p.Pause(addr)
+	}
+	return p
+}
+
+// WithPausedActors makes the processor reject messages to the actors in paused without
+// executing them.
+func WithPausedActors(paused *PausedActors) ProcessorOption {
+	return func(p *DefaultProcessor) {
+		p.paused = paused
+	}
+}
+
+// Pause disables the methods of the actor at addr.
+func (p *PausedActors) Pause(addr address.Address) {
+	p.lk.Lock()
+	defer p.lk.Unlock()
+	p.paused[addr] = struct{}{}
+}
+
+// Resume re-enables the methods of the actor at addr.
+func (p *PausedActors) Resume(addr address.Address) {
+	p.lk.Lock()
+	defer p.lk.Unlock()
+	delete(p.paused, addr)
+}
+
+// IsPaused reports whether the actor at addr is paused.
+func (p *PausedActors) IsPaused(addr address.Address) bool {
+	p.lk.RLock()
+	defer p.lk.RUnlock()
+	_, paused := p.paused[addr]
+	return paused
+}
+
+// PausedActorError is the cause of the failure to apply a message to a paused actor.
+type PausedActorError struct {
+	Addr address.Address
+}
+
+func (e *PausedActorError) Error() string {
+	return fmt.Sprintf("actor %s is paused", e.Addr)
+}
+
+// ShouldRevert implements the reverterror interface, as a rejected message changes no state.
+func (e *PausedActorError) ShouldRevert() bool {
+	return true
+}
+
+func isPausedActorError(err error) bool {
+	_, ok := err.(*PausedActorError)
+	return ok
+}
@@ -0,0 +1,58 @@
+package consensus_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	. "github.com/filecoin-project/go-filecoin/internal/pkg/consensus"
+	th "github.com/filecoin-project/go-filecoin/internal/pkg/testhelpers"
+	tf "github.com/filecoin-project/go-filecoin/internal/pkg/testhelpers/testflags"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/types"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/actor"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/actor/builtin"
+)
+
+func TestPausedActors(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+	fakeActorCodeCid := types.NewCidForTestGetter()()
+	actors := builtin.NewBuilder().
+		AddAll(builtin.DefaultActors).
+		Add(fakeActorCodeCid, 0, &actor.FakeActor{}).
+		Build()
+	vms := th.VMStorage()
+	addresses, st := setupActorsForGasTest(t, vms, fakeActorCodeCid, 1000)
+
+	paused := NewPausedActors(addresses[1])
+	processor := NewConfiguredProcessor(NewDefaultMessageValidator(), &FakeBlockRewarder{}, actors, WithPausedActors(paused))
+
+	// The rejected message consumes no nonce, so the next one reuses it.
+	msgs := []*types.UnsignedMessage{
+		types.NewMeteredMessage(addresses[0], addresses[1], 0, types.ZeroAttoFIL, actor.HasReturnValueID, nil, types.NewGasPrice(1), types.NewGasUnits(200)),
+		types.NewMeteredMessage(addresses[0], addresses[2], 0, types.ZeroAttoFIL, actor.HasReturnValueID, nil, types.NewGasPrice(1), types.NewGasUnits(200)),
+	}
+	results, err := processor.ApplyMessagesAndPayRewards(ctx, st, vms, msgs, addresses[3], types.NewBlockHeight(0), nil)
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+
+	require.Error(t, results[0].Failure)
+	assert.True(t, results[0].FailureIsPermanent)
+	pausedErr, ok := errors.Cause(results[0].Failure).(*PausedActorError)
+	require.True(t, ok, "unexpected failure: %v", results[0].Failure)
+	assert.Equal(t, addresses[1], pausedErr.Addr)
+
+	assert.NoError(t, results[1].Failure)
+	assert.NoError(t, results[1].ExecutionError)
+
+	// Once resumed the actor accepts messages again.
+	paused.Resume(addresses[1])
+	msg := types.NewMeteredMessage(addresses[0], addresses[1], 1, types.ZeroAttoFIL, actor.HasReturnValueID, nil, types.NewGasPrice(1), types.NewGasUnits(200))
+	results, err = processor.ApplyMessagesAndPayRewards(ctx, st, vms, []*types.UnsignedMessage{msg}, addresses[3], types.NewBlockHeight(1), nil)
+	require.NoError(t, err)
+	assert.NoError(t, results[0].Failure)
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/abi"
 	"math/big"
+	"time"
 
 	"github.com/ipfs/go-cid"
 	"go.opencensus.io/tag"
@@ -14,6 +15,7 @@ import (
 	"github.com/filecoin-project/go-filecoin/internal/pkg/metrics"
 	"github.com/filecoin-project/go-filecoin/internal/pkg/metrics/tracing"
 	"github.com/filecoin-project/go-filecoin/internal/pkg/types"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/version"
 	"github.com/filecoin-project/go-filecoin/internal/pkg/vm"
 	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/actor"
 	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/actor/builtin"
@@ -53,40 +55,337 @@ type BlockRewarder interface {
 type ApplicationResult struct {
 	Receipt        *types.MessageReceipt
 	ExecutionError error
+	Extended       *ExtendedReceipt
+	// MessageCid is the cid of the applied message. It is also set when the message failed.
+	MessageCid cid.Cid
+	// ValueTransferred is the value the message moved from its sender to its recipient. It is
+	// zero if the message's changes were reverted. The gas paid is in the receipt.
+	ValueTransferred types.AttoFIL
 }
 
 // ApplyMessageResult is the result of applying a single message.
 type ApplyMessageResult struct {
-	ApplicationResult        // Application-level result, if error is nil.
-	Failure            error // Failure to apply the message
-	FailureIsPermanent bool  // Whether failure is permanent, has no chance of succeeding later.
+	ApplicationResult                 // Application-level result, if error is nil.
+	Failure            error          // Failure to apply the message
+	FailureIsPermanent bool           // Whether failure is permanent, has no chance of succeeding later.
+	FailureReason      FailureReason  // Why the message could not be applied, if it failed.
+	CumulativeGasUsed  types.GasUnits // Gas used by the block's messages up to and including this one.
+	Applied            bool           // Whether the message was included in the block, even if its method reverted.
+	NonceConsumed      bool           // Whether the sender's nonce was consumed, so the message must not be re-queued.
 }
 
 // DefaultProcessor handles all block processing.
 type DefaultProcessor struct {
-	validator     MessageValidator
-	blockRewarder BlockRewarder
-	actors        builtin.Actors
+	validator          MessageValidator
+	blockRewarder      BlockRewarder
+	actors             builtin.Actors
+	newBlockGasTracker func() vm.GasTracker
+	migrations         *StateMigrations
+	maxNestedSends     uint64
+	memoryBudget       uint64
+	maxSteps           uint64
+	veto               MessageVeto
+	estimates          *EstimateCache
+	maxAncestors       int
+	noValueTransfer    bool
+	writeObserver      StateWriteObserver
+	gasCosts           vm.GasCostTable
+	strictRecipients   bool
+	gasPriceUnits      uint64
+	coverage           *MethodCoverage
+	readOnlyQueries    bool
+	dropFailedReturns  bool
+	invariant          StateInvariant
+	senderBudgets      *SenderGasBudgets
+	testRandSeed       *int64
+	preApply           PreApplyHook
+	versions           *version.ProtocolVersionTable
+	paused             *PausedActors
+	returnLimit        *returnSizeLimit
+	accessLog          bool
+	gasPriceStats      *GasPriceStats
+	freeActorCreation  bool
+	unknownExitCodes   UnknownExitCodePolicy
+	outOfGas           OutOfGasPolicy
+	capabilities       vm.CapabilityCheck
+	captureParams      bool
+	captureStates      bool
+	methodLimits       *MethodRateLimits
+	addressResolver    addressResolver
 }
 
 var _ Processor = (*DefaultProcessor)(nil)
 
-// NewDefaultProcessor creates a default processor from the given state tree and vms.
-func NewDefaultProcessor() *DefaultProcessor {
-	return &DefaultProcessor{
-		validator:     NewDefaultMessageValidator(),
-		blockRewarder: NewDefaultBlockRewarder(),
-		actors:        builtin.DefaultActors,
+// ProcessorOption configures optional behaviour of a DefaultProcessor. Simulation options, so
+// documented, make the state or receipts the processor produces differ from those on chain, so
+// a processor configured with one must not be used to validate blocks.
+type ProcessorOption func(*DefaultProcessor)
+
+// WithGasTrackerFactory makes the processor meter the messages of each block with a
+// tracker created by newTracker, rather than a legacy gas tracker.
+func WithGasTrackerFactory(newTracker func() vm.GasTracker) ProcessorOption {
+	return func(p *DefaultProcessor) {
+		p.newBlockGasTracker = newTracker
+	}
+}
+
+// WithProtocolVersions makes the processor dispatch messages to the actor code of the protocol
+// version in effect at the height they are applied at, rather than to version 0.
+func WithProtocolVersions(versions *version.ProtocolVersionTable) ProcessorOption {
+	return func(p *DefaultProcessor) {
+		p.versions = versions
+	}
+}
+
+// WithStateMigrations makes the processor migrate the state of the actors a message loads,
+// its sender, recipient and the actors its sends call, according to the given registry.
+// Migrations are part of the message's changes: those of a message that is rejected or
+// reverted are discarded, to run again when a later message loads the actor.
+func WithStateMigrations(migrations *StateMigrations) ProcessorOption {
+	return func(p *DefaultProcessor) {
+		p.migrations = migrations
+	}
+}
+
+// WithMaxNestedSends limits the total number of sends actors may make while executing a
+// message, regardless of call depth. A message that exceeds the limit is reverted.
+func WithMaxNestedSends(limit uint64) ProcessorOption {
+	return func(p *DefaultProcessor) {
+		p.maxNestedSends = limit
+	}
+}
+
+// WithMemoryBudget limits the total size in bytes of the objects actors may store while
+// executing a message. A message that exceeds the budget is reverted.
+func WithMemoryBudget(bytes uint64) ProcessorOption {
+	return func(p *DefaultProcessor) {
+		p.memoryBudget = bytes
+	}
+}
+
+// DefaultMaxExecutionSteps is the number of VM operations a message may make unless
+// WithMaxExecutionSteps says otherwise. It is far more than any message can afford in gas.
+const DefaultMaxExecutionSteps uint64 = 1 << 24
+
+// WithMaxExecutionSteps limits the number of VM operations, such as gas charges, storage
+// accesses and sends, a message may make regardless of the gas it has left. A message that
+// exceeds the limit is a fault, since only a gas metering bug lets it get that far. Zero
+// removes the limit.
+func WithMaxExecutionSteps(limit uint64) ProcessorOption {
+	return func(p *DefaultProcessor) {
+		p.maxSteps = limit
+	}
+}
+
+// WithMaxAncestorsLookback bounds the number of ancestor tipsets made available to actors.
+// Ancestors supplied beyond the bound are ignored, so no method may require more than it.
+// The default bound is AncestorRoundsNeeded.
+func WithMaxAncestorsLookback(max int) ProcessorOption {
+	return func(p *DefaultProcessor) {
+		p.maxAncestors = max
+	}
+}
+
+// WithValueTransferDisabled makes the processor execute messages without moving the value
+// they carry: balances only change to pay for gas, and the value is not required to be
+// covered by the sender's balance. Methods still see the value sent to them. It is a
+// simulation option.
+func WithValueTransferDisabled() ProcessorOption {
+	return func(p *DefaultProcessor) {
+		p.noValueTransfer = true
+	}
+}
+
+// WithGasCostTable makes the processor charge the gas in costs for the VM operations actors
+// perform, such as sends and signature verifications, in addition to the gas actors charge
+// themselves. Estimates are made with the same costs.
+func WithGasCostTable(costs vm.GasCostTable) ProcessorOption {
+	return func(p *DefaultProcessor) {
+		p.gasCosts = costs
+	}
+}
+
+// WithStrictRecipients makes the processor reject messages to recipients that have no actor,
+// rather than creating an account actor for them. Such messages fail with an
+// *ErrActorNotFound as cause and may be applied once the recipient exists.
+func WithStrictRecipients() ProcessorOption {
+	return func(p *DefaultProcessor) {
+		p.strictRecipients = true
+	}
+}
+
+// WithGasPriceUnits makes message gas prices apply per units gas units rather than per unit,
+// allowing gas to be priced below one AttoFIL per unit. Charges are rounded as GasCharge
+// rounds them.
+func WithGasPriceUnits(units uint64) ProcessorOption {
+	return func(p *DefaultProcessor) {
+		p.gasPriceUnits = units
+	}
+}
+
+// WithReadOnlyQueries makes query methods fail if the method they call attempts to change
+// state, at any depth, rather than having the change silently discarded.
+func WithReadOnlyQueries() ProcessorOption {
+	return func(p *DefaultProcessor) {
+		p.readOnlyQueries = true
+	}
+}
+
+// WithFailedReturnsDropped omits return values from the receipts of messages that exit with a
+// non-zero code, saving memory when processing many messages, e.g. while syncing an indexer.
+// It is a simulation option.
+func WithFailedReturnsDropped() ProcessorOption {
+	return func(p *DefaultProcessor) {
+		p.dropFailedReturns = true
+	}
+}
+
+// WithMaxReturnSize bounds the total size in bytes of the return values of each message.
+// Messages returning more are truncated or reverted according to policy.
+func WithMaxReturnSize(max int, policy ReturnSizePolicy) ProcessorOption {
+	return func(p *DefaultProcessor) {
+		p.returnLimit = &returnSizeLimit{max: max, policy: policy}
+	}
+}
+
+// WithUnknownExitCodePolicy determines how messages failed with an exit code that is neither
+// produced by the VM nor defined by the recipient's actor code are treated.
+func WithUnknownExitCodePolicy(policy UnknownExitCodePolicy) ProcessorOption {
+	return func(p *DefaultProcessor) {
+		p.unknownExitCodes = policy
+	}
+}
+
+// WithCallerCapabilities makes check decide the capabilities callers hold over the actors they
+// call. Messages calling a method that requires a capability its caller lacks are rejected.
+// Without it, callers hold no capabilities.
+func WithCallerCapabilities(check vm.CapabilityCheck) ProcessorOption {
+	return func(p *DefaultProcessor) {
+		p.capabilities = check
+	}
+}
+
+// WithCanonicalParams makes extended receipts include the params of each message as the VM
+// re-encodes them from the values its method decoded, for debugging encoding mismatches.
+func WithCanonicalParams() ProcessorOption {
+	return func(p *DefaultProcessor) {
+		p.captureParams = true
+	}
+}
+
+// WithStateCapture makes extended receipts include the serialized state of each actor a message
+// touched, before and after it was applied, for turning live messages into test vectors. It
+// is expensive and meant for tooling, not for validating blocks.
+func WithStateCapture() ProcessorOption {
+	return func(p *DefaultProcessor) {
+		p.captureStates = true
+	}
+}
+
+// WithOutOfGasPolicy determines how much gas messages that run out of gas are charged for. By
+// default they are charged for their whole gas limit.
+func WithOutOfGasPolicy(policy OutOfGasPolicy) ProcessorOption {
+	return func(p *DefaultProcessor) {
+		p.outOfGas = policy
+	}
+}
+
+// WithAccessLog makes the processor record, in the extended receipt of each message, the state
+// reads and writes the message makes in the order it makes them. It is meant for debugging.
+func WithAccessLog() ProcessorOption {
+	return func(p *DefaultProcessor) {
+		p.accessLog = true
+	}
+}
+
+// WithFreeActorCreation makes the creation of an account actor for a message's recipient free,
+// as it was before creation gas was charged to the message. It is a simulation option.
+func WithFreeActorCreation() ProcessorOption {
+	return func(p *DefaultProcessor) {
+		p.freeActorCreation = true
+	}
+}
+
+// WithMethodCoverage records in coverage each actor method dispatched by applied messages.
+func WithMethodCoverage(coverage *MethodCoverage) ProcessorOption {
+	return func(p *DefaultProcessor) {
+		p.coverage = coverage
+	}
+}
+
+// DefaultTestRandSeed is the seed test scenarios give WithTestRand unless they need another.
+const DefaultTestRandSeed int64 = 1
+
+// WithTestRand gives the actors executing each message a pseudo-random number generator
+// seeded with seed, so test scenarios calling for randomness get reproducible sequences. Each
+// message draws from a generator of its own. It must not be used outside tests.
+func WithTestRand(seed int64) ProcessorOption {
+	return func(p *DefaultProcessor) {
+		p.testRandSeed = &seed
+	}
+}
+
+// StateInvariant checks the state after a message has been applied, before its changes are
+// committed. The cached tree holds every actor the message read or wrote. A non-nil error
+// means the state is invalid and is returned as a fault.
+type StateInvariant func(ctx context.Context, st *state.CachedTree, msg *types.UnsignedMessage) error
+
+// WithStateInvariant checks invariant after each message that is successfully applied.
+func WithStateInvariant(invariant StateInvariant) ProcessorOption {
+	return func(p *DefaultProcessor) {
+		p.invariant = invariant
+	}
+}
+
+// PreApplyHook may change the state right before a message executes, for test scenarios that
+// need a specific state, e.g. a precise sender balance. Changes are kept only if the message
+// is applied. A non-nil error is returned as a fault.
+type PreApplyHook func(ctx context.Context, st *state.CachedTree, msg *types.UnsignedMessage) error
+
+// WithPreApplyHook calls hook before each message is executed.
+func WithPreApplyHook(hook PreApplyHook) ProcessorOption {
+	return func(p *DefaultProcessor) {
+		p.preApply = hook
+	}
+}
+
+// StateWriteObserver is notified of the ID address and state head of an actor written by an
+// applied message. The head may be unchanged if only the actor's balance or nonce changed.
+type StateWriteObserver func(addr address.Address, head cid.Cid)
+
+// WithStateWriteObserver makes the processor notify observer of each actor written to the
+// state tree when a message is applied. Writes the processor discards, such as those of a
+// reverted message, are not observed.
+func WithStateWriteObserver(observer StateWriteObserver) ProcessorOption {
+	return func(p *DefaultProcessor) {
+		p.writeObserver = observer
 	}
 }
 
+// NewDefaultProcessor creates a default processor from the given state tree and vms.
+func NewDefaultProcessor(opts ...ProcessorOption) *DefaultProcessor {
+	return NewConfiguredProcessor(NewDefaultMessageValidator(), NewDefaultBlockRewarder(), builtin.DefaultActors, opts...)
+}
+
 // NewConfiguredProcessor creates a default processor with custom validation and rewards.
-func NewConfiguredProcessor(validator MessageValidator, rewarder BlockRewarder, actors builtin.Actors) *DefaultProcessor {
-	return &DefaultProcessor{
-		validator:     validator,
-		blockRewarder: rewarder,
-		actors:        actors,
+func NewConfiguredProcessor(validator MessageValidator, rewarder BlockRewarder, actors builtin.Actors, opts ...ProcessorOption) *DefaultProcessor {
+	p := &DefaultProcessor{
+		validator:          validator,
+		blockRewarder:      rewarder,
+		actors:             actors,
+		newBlockGasTracker: func() vm.GasTracker { return vm.NewLegacyGasTracker() },
+		maxAncestors:       AncestorRoundsNeeded,
+		maxSteps:           DefaultMaxExecutionSteps,
+		addressResolver:    defaultAddressResolver,
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	// the default rewarder pays rewards to and from the addresses the processor resolves
+	if _, ok := rewarder.(*DefaultBlockRewarder); ok {
+		p.blockRewarder = &DefaultBlockRewarder{resolver: p.addressResolver}
 	}
+	return p
 }
 
 // ProcessTipSet computes the state transition specified by the messages in all
@@ -98,7 +397,17 @@ func NewConfiguredProcessor(validator MessageValidator, rewarder BlockRewarder,
 // coming from calls to ApplyMessage can be traced to different blocks in the
 // TipSet containing conflicting messages and are returned in the result slice.
 // Blocks are applied in the sorted order of their tickets.
-func (p *DefaultProcessor) ProcessTipSet(ctx context.Context, st state.Tree, vms vm.StorageMap, ts block.TipSet, tsMessages [][]*types.UnsignedMessage, ancestors []block.TipSet) (results []*ApplyMessageResult, err error) {
+func (p *DefaultProcessor) ProcessTipSet(ctx context.Context, st state.Tree, vms vm.StorageMap, ts block.TipSet, tsMessages [][]*types.UnsignedMessage, ancestors []block.TipSet) ([]*ApplyMessageResult, error) {
+	tsResult, err := p.ProcessTipSetDetailed(ctx, st, vms, ts, tsMessages, ancestors)
+	if err != nil {
+		return nil, err
+	}
+	return tsResult.Results(), nil
+}
+
+// ProcessTipSetDetailed behaves as ProcessTipSet, and additionally reports the results and gas
+// used by the messages of each block.
+func (p *DefaultProcessor) ProcessTipSetDetailed(ctx context.Context, st state.Tree, vms vm.StorageMap, ts block.TipSet, tsMessages [][]*types.UnsignedMessage, ancestors []block.TipSet) (tsResult *TipSetResult, err error) {
 	ctx, span := trace.StartSpan(ctx, "DefaultProcessor.ProcessTipSet")
 	span.AddAttributes(trace.StringAttribute("tipset", ts.String()))
 	defer tracing.AddErrorEndSpan(ctx, span, &err)
@@ -111,6 +420,19 @@ func (p *DefaultProcessor) ProcessTipSet(ctx context.Context, st state.Tree, vms
 
 	dedupedMessages, err := DeduppedMessages(tsMessages)
 
+	if p.methodLimits != nil {
+		p.methodLimits.reset()
+	}
+	tsResult = &TipSetResult{}
+	tsResult.ProtocolVersion, err = p.protocolVersionAt(bh)
+	if err != nil {
+		return nil, err
+	}
+	unissuedBefore, err := unissuedBalance(ctx, st)
+	if err != nil {
+		return nil, errors.FaultErrorWrap(err, "could not compute supply")
+	}
+	var gasPrices []types.AttoFIL
 	for blkIdx := 0; blkIdx < ts.Len(); blkIdx++ {
 		blk := ts.At(blkIdx)
 		minerOwnerAddr, err := p.minerOwnerAddress(ctx, st, vms, blk.Miner)
@@ -124,9 +446,22 @@ func (p *DefaultProcessor) ProcessTipSet(ctx context.Context, st state.Tree, vms
 			return nil, err
 		}
 
-		results = append(results, blkResults...)
+		tsResult.Blocks = append(tsResult.Blocks, newBlockResult(blk.Cid(), blkResults))
+		for i, r := range blkResults {
+			if r.Failure == nil {
+				gasPrices = append(gasPrices, blkMessages[i].GasPrice)
+			}
+		}
+	}
+	if p.gasPriceStats != nil {
+		p.gasPriceStats.record(gasPrices)
 	}
-	return
+	unissuedAfter, err := unissuedBalance(ctx, st)
+	if err != nil {
+		return nil, errors.FaultErrorWrap(err, "could not compute supply")
+	}
+	tsResult.SupplyDelta = unissuedBefore.Sub(unissuedAfter)
+	return tsResult, nil
 }
 
 // DeduppedMessages removes all messages that have the same cid
@@ -151,6 +486,58 @@ func DeduppedMessages(tsMessages [][]*types.UnsignedMessage) ([][]*types.Unsigne
 	return allMessages, nil
 }
 
+// ValidateTipSetMessages runs the message validator over the messages in a TipSet without
+// executing them. Messages are visited in the order ProcessTipSet would apply them, with
+// duplicates removed, and the result holds a validity flag for each visited message.
+// The sender's nonce is advanced past each valid message so that consecutive messages
+// from one sender validate as they would when applied. Balances are not adjusted, so a
+// message that would fail for lack of funds after its predecessors may still be valid here.
+// Only faults are returned as errors.
+func (p *DefaultProcessor) ValidateTipSetMessages(ctx context.Context, st state.Tree, vms vm.StorageMap, ts block.TipSet, tsMessages [][]*types.UnsignedMessage) ([]bool, error) {
+	dedupedMessages, err := DeduppedMessages(tsMessages)
+	if err != nil {
+		return nil, errors.FaultErrorWrap(err, "could not dedup messages")
+	}
+
+	cachedSt := state.NewCachedTree(st)
+	nonces := make(map[address.Address]types.Uint64)
+	var valid []bool
+	for blkIdx := 0; blkIdx < ts.Len(); blkIdx++ {
+		for _, msg := range dedupedMessages[blkIdx] {
+			fromAddr, found, err := p.ResolveAddress(ctx, msg.From, cachedSt, vms)
+			if err != nil {
+				return nil, errors.FaultErrorWrapf(err, "Could not resolve actor address")
+			}
+			if !found {
+				valid = append(valid, false)
+				continue
+			}
+
+			fromActor, err := cachedSt.GetActor(ctx, fromAddr)
+			if state.IsActorNotFoundError(err) {
+				valid = append(valid, false)
+				continue
+			} else if err != nil {
+				return nil, errors.FaultErrorWrapf(err, "failed to get From actor %s", msg.From)
+			}
+
+			// validate against a copy so the cached actor is left untouched
+			sender := *fromActor
+			if nonce, ok := nonces[fromAddr]; ok {
+				sender.CallSeqNum = nonce
+			}
+
+			if err := p.validator.Validate(ctx, msg, &sender); err != nil {
+				valid = append(valid, false)
+				continue
+			}
+			nonces[fromAddr] = sender.CallSeqNum + 1
+			valid = append(valid, true)
+		}
+	}
+	return valid, nil
+}
+
 // ApplyMessage attempts to apply a message to a state tree. It is the
 // sole driver of state tree transitions in the system. Both block
 // validation and mining use this function and we should treat any changes
@@ -212,7 +599,7 @@ func DeduppedMessages(tsMessages [][]*types.UnsignedMessage) ([][]*types.Unsigne
 //       revert errors.
 //   - everything else: successfully applied (include, keep changes)
 //
-func (p *DefaultProcessor) ApplyMessage(ctx context.Context, st state.Tree, vms vm.StorageMap, msg *types.UnsignedMessage, minerOwnerAddr address.Address, bh *types.BlockHeight, gasTracker *vm.LegacyGasTracker, ancestors []block.TipSet) (result *ApplicationResult, err error) {
+func (p *DefaultProcessor) ApplyMessage(ctx context.Context, st state.Tree, vms vm.StorageMap, msg *types.UnsignedMessage, minerOwnerAddr address.Address, bh *types.BlockHeight, gasTracker vm.GasTracker, ancestors []block.TipSet) (result *ApplicationResult, err error) {
 	msgCid, err := msg.Cid()
 	if err != nil {
 		return nil, errors.FaultErrorWrap(err, "could not get message cid")
@@ -232,11 +619,33 @@ func (p *DefaultProcessor) ApplyMessage(ctx context.Context, st state.Tree, vms
 	amsw := amTimer.Start(ctx)
 	defer amsw.Stop(ctx)
 
+	var capture *stateCapture
+	if p.captureStates {
+		capture = newStateCapture(st, vms)
+		st = capture
+	}
+
 	cachedStateTree := state.NewCachedTree(st)
+	var accessLog *state.AccessLog
+	if p.accessLog {
+		accessLog = &state.AccessLog{}
+		cachedStateTree.SetAccessLog(accessLog)
+	}
+	if p.preApply != nil {
+		if err := p.preApply(ctx, cachedStateTree, msg); err != nil {
+			return nil, errors.FaultErrorWrapf(err, "pre-apply hook failed for message %s", msgCid)
+		}
+	}
 
-	r, err := p.attemptApplyMessage(ctx, cachedStateTree, vms, msg, bh, gasTracker, ancestors)
+	ext := &ExtendedReceipt{}
+	r, err := p.attemptApplyMessage(ctx, cachedStateTree, vms, msg, bh, gasTracker, ancestors, ext)
+	if err == nil && p.invariant != nil {
+		if invErr := p.invariant(ctx, cachedStateTree, msg); invErr != nil {
+			return nil, errors.FaultErrorWrapf(invErr, "state invariant violated by message %s", msgCid)
+		}
+	}
 	if err == nil {
-		err = cachedStateTree.Commit(ctx)
+		err = p.commit(ctx, cachedStateTree)
 		if err != nil {
 			return nil, errors.FaultErrorWrap(err, "could not commit state tree")
 		}
@@ -246,12 +655,19 @@ func (p *DefaultProcessor) ApplyMessage(ctx context.Context, st state.Tree, vms
 		return nil, errors.NewFaultError("someone is a bad programmer: only return revert and fault errors")
 	}
 
+	if accessLog != nil {
+		ext.Accesses = accessLog.Accesses
+	}
+
+	ext.MinerTip = types.ZeroAttoFIL
 	if r.GasAttoFIL.IsPositive() {
 		gasError := p.blockRewarder.GasReward(ctx, st, vms, minerOwnerAddr, msg, r.GasAttoFIL)
 		if gasError != nil {
 			return nil, errors.NewFaultError("failed to transfer gas reward to owner of miner")
 		}
+		ext.MinerTip = r.GasAttoFIL
 	}
+	ext.GasRefund = GasCharge(msg.GasPrice, msg.GasLimit, p.gasPriceUnits).Sub(r.GasAttoFIL)
 
 	// Reject invalid state transitions.
 	var executionError error
@@ -267,11 +683,18 @@ func (p *DefaultProcessor) ApplyMessage(ctx context.Context, st state.Tree, vms
 		// to be replayable.
 		executionError = err
 		log.Infof("ApplyMessage failed: %s %s", executionError, msg.String())
+
+		// an actor created for the recipient was rolled back with the rest of the message
+		ext.ActorCreated = false
+		ext.CreatedActorAddr = address.Undef
 	}
 
 	// At this point we consider the message successfully applied so inc
 	// the nonce.
-	fromAddr, _, err := ResolveAddress(ctx, msg.From, state.NewCachedTree(st), vms, gasTracker)
+	if applied, ok := gasTracker.(interface{ MessageApplied() }); ok {
+		applied.MessageApplied()
+	}
+	fromAddr, _, err := p.ResolveAddress(ctx, msg.From, state.NewCachedTree(st), vms)
 	if err != nil {
 		return nil, errors.FaultErrorWrapf(err, "Could not resolve from actor address")
 	}
@@ -283,14 +706,37 @@ func (p *DefaultProcessor) ApplyMessage(ctx context.Context, st state.Tree, vms
 	if err := st.SetActor(ctx, fromAddr, fromActor); err != nil {
 		return nil, errors.FaultErrorWrap(err, "could not set from actor after inc nonce")
 	}
+	if p.senderBudgets != nil {
+		p.senderBudgets.record(fromAddr, bh, ext.GasUsed)
+	}
+
+	ext.FromBalanceAfter = fromActor.Balance
+	ext.FromAddr = fromAddr
+	ext.FromKeyAddr = keyAddress(msg.From)
+	ext.ToKeyAddr = keyAddress(msg.To)
+	ext.ToBalanceAfter, err = balanceOrZero(ctx, st, ext.ToAddr)
+	if err != nil {
+		return nil, errors.FaultErrorWrap(err, "couldn't load to actor")
+	}
+
+	valueTransferred := types.ZeroAttoFIL
+	if executionError == nil {
+		valueTransferred = msg.Value
+	}
+
+	if capture != nil {
+		ext.TouchedActors, err = capture.touchedActors(ctx)
+		if err != nil {
+			return nil, errors.FaultErrorWrap(err, "could not capture touched actors")
+		}
+	}
 
-	return &ApplicationResult{Receipt: r, ExecutionError: executionError}, nil
+	return &ApplicationResult{Receipt: r, ExecutionError: executionError, Extended: ext, MessageCid: msgCid, ValueTransferred: valueTransferred}, nil
 }
 
 var (
 	// These errors are only to be used by ApplyMessage; they shouldn't be
 	// used in any other context as they are an implementation detail.
-	errFromAccountNotFound       = errors.NewRevertError("from (sender) account not found")
 	errGasAboveBlockLimit        = errors.NewRevertError("message gas limit above block gas limit")
 	errGasPriceZero              = errors.NewRevertError("message gas price is zero")
 	errGasTooHighForCurrentBlock = errors.NewRevertError("message gas limit too high for current block")
@@ -300,6 +746,10 @@ var (
 	errNegativeValue             = errors.NewRevertError("negative value")
 	errInsufficientGas           = errors.NewRevertError("balance insufficient to cover transfer+gas")
 	errInvalidSignature          = errors.NewRevertError("invalid signature by sender over message data")
+	errInvalidRecipient          = errors.NewRevertError("message recipient address is undefined or invalid")
+	errInsufficientCreationGas   = errors.NewRevertError("insufficient gas to create recipient actor")
+	errNoOpMessage               = errors.NewRevertError("message transfers no value and calls no method")
+	errValueAboveMax             = errors.NewRevertError("message value above the maximum a message may transfer")
 	// TODO we'll eventually handle sending to self.
 	errSelfSend = errors.NewRevertError("cannot send to self")
 )
@@ -308,6 +758,43 @@ var (
 // not make any changes to the state/blockchain and is useful for interrogating
 // actor state. Block height bh is optional; some methods will ignore it.
 func (p *DefaultProcessor) CallQueryMethod(ctx context.Context, st state.Tree, vms vm.StorageMap, to address.Address, method types.MethodID, params []byte, from address.Address, optBh *types.BlockHeight) ([][]byte, uint8, error) {
+	result, err := p.CallQueryMethodExtended(ctx, st, vms, to, method, params, from, optBh)
+	if result == nil {
+		return nil, 1, err
+	}
+	return result.Return, result.ExitCode, err
+}
+
+// QueryResult is the result of calling a query method.
+type QueryResult struct {
+	Return   [][]byte
+	ExitCode uint8
+	// Code is the code cid of the recipient actor, which determines how to decode Return.
+	Code cid.Cid
+	// ToAddr is the ID address the recipient resolved to.
+	ToAddr address.Address
+	// ToKeyAddr is the key address the recipient was named by, or undefined if it was named
+	// by its ID address.
+	ToKeyAddr address.Address
+	// ReturnTypes are the declared types of the values in Return, if the method's signature is
+	// known.
+	ReturnTypes []abi.Type
+}
+
+// NonReadOnlyMethodError is returned by queries under WithReadOnlyQueries when the queried
+// method attempts to change state.
+type NonReadOnlyMethodError struct {
+	Code   cid.Cid
+	Method types.MethodID
+}
+
+func (e *NonReadOnlyMethodError) Error() string {
+	return fmt.Sprintf("method %d of actor code %s is not read-only", e.Method, e.Code)
+}
+
+// CallQueryMethodExtended calls a method as CallQueryMethod does and also returns the code
+// of the recipient. The result is set whenever the method was invoked, even if it failed.
+func (p *DefaultProcessor) CallQueryMethodExtended(ctx context.Context, st state.Tree, vms vm.StorageMap, to address.Address, method types.MethodID, params []byte, from address.Address, optBh *types.BlockHeight) (*QueryResult, error) {
 	// not committing or flushing storage structures guarantees changes won't make it to stored state tree or datastore
 	cachedSt := state.NewCachedTree(st)
 
@@ -324,41 +811,53 @@ func (p *DefaultProcessor) CallQueryMethod(ctx context.Context, st state.Tree, v
 	gasTracker := vm.NewLegacyGasTracker()
 	gasTracker.MsgGasLimit = types.BlockGasLimit
 
-	// translate address before retrieving from actor
-	toAddr, found, err := ResolveAddress(ctx, msg.To, cachedSt, vms, gasTracker)
-	if err != nil {
-		return nil, 1, errors.FaultErrorWrapf(err, "Could not resolve actor address")
-	}
-
-	if !found {
-		return nil, 1, errors.ApplyErrorPermanentWrapf(err, "failed to resolve To actor")
+	toActor, toAddr, err := lookupActor(ctx, cachedSt, vms, p.addressResolver, msg.To)
+	if _, notFound := AsActorNotFound(err); notFound {
+		return nil, errors.ApplyErrorPermanentWrapf(err, "failed to get To actor")
+	} else if err != nil {
+		return nil, err
 	}
 
-	toActor, err := st.GetActor(ctx, toAddr)
-	if err != nil {
-		return nil, 1, errors.ApplyErrorPermanentWrapf(err, "failed to get To actor")
+	// queries are never persisted, so a mutating method is most likely a caller mistake
+	if readOnly, known := p.actors.IsReadOnlyMethod(toActor.Code, method); known && !readOnly {
+		log.Warnf("CallQueryMethod called with mutating method %d on actor %s", method, to.String())
 	}
 
 	vmCtxParams := vm.NewContextParams{
-		To:          toActor,
-		ToAddr:      toAddr,
-		Message:     msg,
-		OriginMsg:   msg,
-		State:       cachedSt,
-		StorageMap:  vms,
-		GasTracker:  gasTracker,
-		BlockHeight: optBh,
-		Actors:      p.actors,
+		To:              toActor,
+		ToAddr:          toAddr,
+		Message:         msg,
+		OriginMsg:       msg,
+		State:           cachedSt,
+		StorageMap:      vms,
+		GasTracker:      gasTracker,
+		BlockHeight:     optBh,
+		Actors:          p.actors,
+		ReadOnly:        p.readOnlyQueries,
+		AddressResolver: p.addressResolver.forVM(cachedSt, vms),
 	}
 
 	vmCtx := vm.NewVMContext(vmCtxParams)
 	ret, retCode, err := vm.Send(ctx, vmCtx)
-	return ret, retCode, err
+	result := &QueryResult{Return: ret, ExitCode: retCode, Code: toActor.Code, ToAddr: toAddr, ToKeyAddr: keyAddress(to)}
+	result.ReturnTypes, _ = p.actors.ReturnTypes(toActor.Code, method)
+	if vmCtx.WriteAttempted() {
+		return result, &NonReadOnlyMethodError{Code: toActor.Code, Method: method}
+	}
+	return result, err
 }
 
 // PreviewQueryMethod estimates the amount of gas that will be used by a method
 // call. It accepts all the same arguments as CallQueryMethod.
 func (p *DefaultProcessor) PreviewQueryMethod(ctx context.Context, st state.Tree, vms vm.StorageMap, to address.Address, method types.MethodID, params []byte, from address.Address, optBh *types.BlockHeight) (types.GasUnits, error) {
+	return p.PreviewQueryMethodWithAncestors(ctx, st, vms, to, method, params, from, optBh, nil)
+}
+
+// PreviewQueryMethodWithAncestors estimates gas as PreviewQueryMethod does, making ancestors
+// available to the method so that methods consulting past tipsets are estimated as they would
+// be applied. Ancestors are bounded as when applying a message. Estimates made with ancestors
+// are not cached.
+func (p *DefaultProcessor) PreviewQueryMethodWithAncestors(ctx context.Context, st state.Tree, vms vm.StorageMap, to address.Address, method types.MethodID, params []byte, from address.Address, optBh *types.BlockHeight, ancestors []block.TipSet) (types.GasUnits, error) {
 	// not committing or flushing storage structures guarantees changes won't make it to stored state tree or datastore
 	cachedSt := state.NewCachedTree(st)
 
@@ -369,32 +868,62 @@ func (p *DefaultProcessor) PreviewQueryMethod(ctx context.Context, st state.Tree
 		Value:      types.ZeroAttoFIL,
 		Method:     method,
 		Params:     params,
+		GasLimit:   types.BlockGasLimit,
 	}
 
 	// Set the gas limit to the max because this message send should always succeed; it doesn't cost gas.
-	gasTracker := vm.NewLegacyGasTracker()
-	gasTracker.MsgGasLimit = types.BlockGasLimit
+	// The call is metered as the processor meters the messages it applies.
+	gasTracker := p.newBlockGasTracker()
+	gasTracker.ResetForNewMessage(msg)
 
 	// ensure actor exists
-	toActor, toAddr, err := getOrCreateActor(ctx, cachedSt, vms, msg.To, gasTracker)
+	toActor, toAddr, _, err := p.getOrCreateRecipient(ctx, cachedSt, vms, msg.To, gasTracker)
 	if err != nil {
 		return types.GasUnits(0), errors.FaultErrorWrap(err, "failed to get To actor")
 	}
 
+	protocolVersion, err := p.protocolVersionAt(optBh)
+	if err != nil {
+		return types.GasUnits(0), err
+	}
+	ancestors, err = p.boundAncestors(toActor, method, protocolVersion, ancestors)
+	if err != nil {
+		return types.GasUnits(0), err
+	}
+
+	// the cache needs a height to expire estimates, and is only keyed by the recipient's state
+	useCache := p.estimates != nil && optBh != nil && len(ancestors) == 0
+	key := newEstimateKey(toActor, method, params)
+	if useCache {
+		if gas, ok := p.estimates.get(key, optBh); ok {
+			return gas, nil
+		}
+	}
+
 	vmCtxParams := vm.NewContextParams{
-		To:          toActor,
-		ToAddr:      toAddr,
-		Message:     msg,
-		OriginMsg:   msg,
-		State:       cachedSt,
-		StorageMap:  vms,
-		GasTracker:  gasTracker,
-		BlockHeight: optBh,
-		Actors:      p.actors,
+		To:              toActor,
+		ToAddr:          toAddr,
+		Message:         msg,
+		OriginMsg:       msg,
+		State:           cachedSt,
+		StorageMap:      vms,
+		GasTracker:      gasTracker,
+		BlockHeight:     optBh,
+		Ancestors:       ancestors,
+		Actors:          p.actors,
+		GasCosts:        p.gasCosts,
+		ProtocolVersion: protocolVersion,
+		AddressResolver: p.addressResolver.forVM(cachedSt, vms),
 	}
 	vmCtx := vm.NewVMContext(vmCtxParams)
 	_, _, err = vm.Send(ctx, vmCtx)
+	if err == nil && vmCtx.GasUnits() > MaxSafeGasEstimate {
+		return types.GasUnits(0), &GasEstimateOverflowError{Estimate: vmCtx.GasUnits()}
+	}
 
+	if useCache && err == nil {
+		return p.estimates.put(key, optBh, vmCtx.GasUnits()), nil
+	}
 	return vmCtx.GasUnits(), err
 }
 
@@ -403,116 +932,201 @@ func (p *DefaultProcessor) PreviewQueryMethod(ctx context.Context, st state.Tree
 // should deal with trying to apply the message to the state tree whereas
 // ApplyMessage should deal with any side effects and how it should be presented
 // to the caller. attemptApplyMessage should only be called from ApplyMessage.
-func (p *DefaultProcessor) attemptApplyMessage(ctx context.Context, st *state.CachedTree, store vm.StorageMap, msg *types.UnsignedMessage, bh *types.BlockHeight, gasTracker *vm.LegacyGasTracker, ancestors []block.TipSet) (*types.MessageReceipt, error) {
+// Details that are not part of the receipt are recorded in ext.
+func (p *DefaultProcessor) attemptApplyMessage(ctx context.Context, st *state.CachedTree, store vm.StorageMap, msg *types.UnsignedMessage, bh *types.BlockHeight, gasTracker vm.GasTracker, ancestors []block.TipSet, ext *ExtendedReceipt) (*types.MessageReceipt, error) {
 	gasTracker.ResetForNewMessage(msg)
 	if err := blockGasLimitError(gasTracker); err != nil {
-		return &types.MessageReceipt{
-			ExitCode:   errors.CodeError(err),
-			GasAttoFIL: types.ZeroAttoFIL,
-		}, err
+		return rejectedReceipt(err), err
 	}
 
-	fromAddr, found, err := ResolveAddress(ctx, msg.From, st, store, gasTracker)
+	// The gas charged to a message that runs out depends on the out-of-gas policy.
+	meter := &outOfGasMeter{GasTracker: gasTracker, policy: p.outOfGas}
+	gasTracker = meter
+
+	fromActor, fromAddr, err := lookupActor(ctx, st, store, p.addressResolver, msg.From)
+	if _, notFound := AsActorNotFound(err); notFound {
+		return rejectedReceipt(err), err
+	} else if err != nil {
+		return nil, err
+	}
+
+	validated := msg
+	if p.noValueTransfer {
+		// The value is not moved, so the sender need not be able to cover it.
+		withoutValue := *msg
+		withoutValue.Value = types.ZeroAttoFIL
+		validated = &withoutValue
+	}
+	err = validationCause(p.validator.Validate(ctx, validated, fromActor))
 	if err != nil {
-		return nil, errors.FaultErrorWrapf(err, "Could not resolve actor address")
+		return rejectedReceipt(err), err
 	}
-	if !found {
-		return &types.MessageReceipt{
-			ExitCode:   errors.CodeError(err),
-			GasAttoFIL: types.ZeroAttoFIL,
-		}, errFromAccountNotFound
+
+	if p.senderBudgets != nil {
+		if err := p.senderBudgets.check(fromAddr, bh, msg.GasLimit); err != nil {
+			return rejectedReceipt(err), err
+		}
 	}
 
-	fromActor, err := st.GetActor(ctx, fromAddr)
-	if state.IsActorNotFoundError(err) {
-		return &types.MessageReceipt{
-			ExitCode:   errors.CodeError(err),
-			GasAttoFIL: types.ZeroAttoFIL,
-		}, errFromAccountNotFound
-	} else if err != nil {
-		return nil, errors.FaultErrorWrapf(err, "failed to get From actor %s", msg.From)
+	if p.strictRecipients {
+		if _, _, err := lookupActor(ctx, st, store, p.addressResolver, msg.To); err != nil {
+			if _, notFound := AsActorNotFound(err); notFound {
+				return rejectedReceipt(err), err
+			}
+			return nil, err
+		}
 	}
 
-	err = p.validator.Validate(ctx, msg, fromActor)
-	if err != nil {
+	// ensure actor exists
+	toActor, toAddr, created, err := p.getOrCreateRecipient(ctx, st, store, msg.To, gasTracker)
+	if err == errInsufficientCreationGas {
+		// The message is applied and pays for the gas it used, but the new actor is reverted.
 		return &types.MessageReceipt{
 			ExitCode:   errors.CodeError(err),
-			GasAttoFIL: types.ZeroAttoFIL,
+			GasAttoFIL: GasCharge(msg.GasPrice, meter.gasToCharge(), p.gasPriceUnits),
 		}, err
+	} else if err != nil {
+		return nil, errors.FaultErrorWrap(err, "failed to get To actor")
 	}
 
-	// ensure actor exists
-	toActor, toAddr, err := getOrCreateActor(ctx, st, store, msg.To, gasTracker)
+	// The actors are migrated in the message's cached tree, so a migration is kept only if the
+	// message is. Sends load their actors through the same hook.
+	migrate := p.migrationHook(ctx, store, bh)
+	if migrate != nil {
+		if err := migrate(fromAddr, fromActor); err != nil {
+			return nil, errors.FaultErrorWrapf(err, "failed to migrate From actor %s", fromAddr)
+		}
+		if err := migrate(toAddr, toActor); err != nil {
+			return nil, errors.FaultErrorWrapf(err, "failed to migrate To actor %s", toAddr)
+		}
+	}
+
+	if p.veto != nil {
+		if reason := p.veto(ctx, msg, fromActor, toActor); reason != nil {
+			err := &VetoError{Reason: reason}
+			return rejectedReceipt(err), err
+		}
+	}
+
+	if p.paused != nil && p.paused.IsPaused(toAddr) {
+		err := &PausedActorError{Addr: toAddr}
+		return rejectedReceipt(err), err
+	}
+
+	protocolVersion, err := p.protocolVersionAt(bh)
 	if err != nil {
-		return nil, errors.FaultErrorWrap(err, "failed to get To actor")
+		return nil, err
+	}
+	ancestors, err = p.boundAncestors(toActor, msg.Method, protocolVersion, ancestors)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.methodLimits != nil {
+		if err := p.methodLimits.take(toActor.Code, msg.Method); err != nil {
+			return rejectedReceipt(err), err
+		}
+	}
+
+	ext.FromBalanceBefore = fromActor.Balance
+	ext.ToBalanceBefore = toActor.Balance
+	ext.ToAddr = toAddr
+	if created {
+		ext.ActorCreated = true
+		ext.CreatedActorAddr = toAddr
 	}
 
 	vmCtxParams := vm.NewContextParams{
-		From:        fromActor,
-		To:          toActor,
-		ToAddr:      toAddr,
-		Message:     msg,
-		OriginMsg:   msg,
-		State:       st,
-		StorageMap:  store,
-		GasTracker:  gasTracker,
-		BlockHeight: bh,
-		Ancestors:   ancestors,
-		Actors:      p.actors,
+		From:                 fromActor,
+		To:                   toActor,
+		ToAddr:               toAddr,
+		Message:              msg,
+		OriginMsg:            msg,
+		State:                st,
+		StorageMap:           store,
+		GasTracker:           gasTracker,
+		BlockHeight:          bh,
+		Ancestors:            ancestors,
+		Actors:               p.actors,
+		MaxNestedSends:       p.maxNestedSends,
+		MemoryBudget:         p.memoryBudget,
+		MaxSteps:             p.maxSteps,
+		FromAddr:             fromAddr,
+		CapabilityCheck:      p.capabilities,
+		CaptureParams:        p.captureParams,
+		DisableValueTransfer: p.noValueTransfer,
+		GasCosts:             p.gasCosts,
+		TestRandSeed:         p.testRandSeed,
+		ProtocolVersion:      protocolVersion,
+		ActorLoadHook:        migrate,
+		AddressResolver:      p.addressResolver.forVM(st, store),
+	}
+	if p.coverage != nil {
+		vmCtxParams.DispatchTracer = p.coverage.Trace
 	}
 	vmCtx := vm.NewVMContext(vmCtxParams)
 
+	start := time.Now()
 	ret, exitCode, vmErr := vm.Send(ctx, vmCtx)
+	ext.Duration = time.Since(start)
+	ext.GasUsed = vmCtx.GasUnits()
+	storageRead, storageWrite := vmCtx.StorageGasUsed()
+	ext.GasBreakdown = newGasBreakdown(ext.GasUsed, storageRead, storageWrite)
+	ext.SubCalls = vmCtx.SubCalls()
+	ext.NodesRead = vmCtx.NodesRead()
+	ext.CanonicalParams = vmCtx.CanonicalParams()
 	if errors.IsFault(vmErr) {
 		return nil, vmErr
 	}
+	if denial := vmCtx.CapabilityDenied(); denial != nil {
+		err := &MissingCapabilityError{Caller: denial.Caller, Target: denial.Target, Capability: denial.Capability}
+		return rejectedReceipt(err), err
+	}
+	if p.unknownExitCodes == UnknownExitCodeFault && !p.isKnownExitCode(toActor.Code, exitCode) {
+		return nil, errors.NewFaultErrorf("actor %s failed message with unknown exit code %d", toAddr, exitCode)
+	}
+	ret, exitCode, vmErr = p.returnLimit.enforce(ret, exitCode, vmErr)
+	if vmErr == nil && exitCode == 0 {
+		ext.Events = vmCtx.Events()
+	}
 
-	// compute gas charge
-	gasCharge := msg.GasPrice.MulBigInt(big.NewInt(int64(vmCtx.GasUnits())))
+	// compute gas charge, rounding up any fraction of an AttoFIL
+	gasCharge := GasCharge(msg.GasPrice, meter.gasToCharge(), p.gasPriceUnits)
 
 	receipt := &types.MessageReceipt{
 		ExitCode:   exitCode,
 		GasAttoFIL: gasCharge,
 	}
 
-	receipt.Return = append(receipt.Return, ret...)
+	if exitCode == 0 || !p.dropFailedReturns {
+		receipt.Return = append(receipt.Return, ret...)
+	}
 
 	return receipt, vmErr
 }
 
-// ResolveAddress looks up associated id address. If the given address is already and id address, it is returned unchanged.
-func ResolveAddress(ctx context.Context, addr address.Address, st *state.CachedTree, vms vm.StorageMap, gt *vm.LegacyGasTracker) (address.Address, bool, error) {
-	if addr.Protocol() == address.ID {
-		return addr, true, nil
-	}
-
-	init, err := st.GetActor(ctx, address.InitAddress)
-	if err != nil {
-		return address.Undef, false, err
-	}
-
-	vmCtx := vm.NewVMContext(vm.NewContextParams{
-		State:      st,
-		StorageMap: vms,
-		ToAddr:     address.InitAddress,
-		To:         init,
-	})
-
-	id, found, err := initactor.LookupIDAddress(vmCtx, addr)
-	if err != nil {
-		return address.Undef, false, err
-	}
-
-	if !found {
-		return address.Undef, false, nil
+// rejectedReceipt is the receipt of a message rejected with err, permanently or temporarily,
+// rather than applied. The message pays no gas and is left out of the block, so the receipt is
+// discarded along with it.
+func rejectedReceipt(err error) *types.MessageReceipt {
+	return &types.MessageReceipt{
+		ExitCode:   errors.CodeError(err),
+		GasAttoFIL: types.ZeroAttoFIL,
 	}
+}
 
-	idAddr, err := address.NewIDAddress(id)
-	if err != nil {
-		return address.Undef, false, err
-	}
+// ResolveAddress looks up associated id address. If the given address is already and id address, it is returned unchanged.
+// Resolution is dispatched on the address protocol to the built-in handlers; DefaultProcessor.ResolveAddress
+// uses the handlers a processor was configured with.
+func ResolveAddress(ctx context.Context, addr address.Address, st *state.CachedTree, vms vm.StorageMap, gt vm.GasTracker) (address.Address, bool, error) {
+	return defaultAddressResolver.resolve(ctx, addr, st, vms)
+}
 
-	return idAddr, true, nil
+// DroppedMessage is a message that was not applied because it did not fit in the block's
+// remaining gas budget. It may be included in a later block.
+type DroppedMessage struct {
+	Message *types.UnsignedMessage
+	Reason  error
 }
 
 // ApplyMessagesAndPayRewards pays the block mining reward to the miner's owner and then applies
@@ -521,68 +1135,81 @@ func ResolveAddress(ctx context.Context, addr address.Address, st *state.CachedT
 func (p *DefaultProcessor) ApplyMessagesAndPayRewards(ctx context.Context, st state.Tree, vms vm.StorageMap,
 	messages []*types.UnsignedMessage, minerOwnerAddr address.Address, bh *types.BlockHeight,
 	ancestors []block.TipSet) ([]*ApplyMessageResult, error) {
+	results, _, err := p.ApplyMessagesAndPayRewardsReportingDropped(ctx, st, vms, messages, minerOwnerAddr, bh, ancestors)
+	return results, err
+}
+
+// ApplyMessagesAndPayRewardsReportingDropped behaves as ApplyMessagesAndPayRewards and additionally
+// returns, in order, the messages that were not applied because the block gas budget was exhausted.
+// Their failures are also present in the results.
+func (p *DefaultProcessor) ApplyMessagesAndPayRewardsReportingDropped(ctx context.Context, st state.Tree, vms vm.StorageMap,
+	messages []*types.UnsignedMessage, minerOwnerAddr address.Address, bh *types.BlockHeight,
+	ancestors []block.TipSet) ([]*ApplyMessageResult, []DroppedMessage, error) {
 	var results []*ApplyMessageResult
+	var dropped []DroppedMessage
 
 	// Pay block reward.
 	if err := p.blockRewarder.BlockReward(ctx, st, vms, minerOwnerAddr); err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	// Process all messages.
-	gasTracker := vm.NewLegacyGasTracker()
+	gasTracker := p.newBlockGasTracker()
 	for _, msg := range messages {
 		r, err := p.ApplyMessage(ctx, st, vms, msg, minerOwnerAddr, bh, gasTracker, ancestors)
+		if err != nil && !errors.IsFault(err) {
+			msgCid, cidErr := msg.Cid()
+			if cidErr != nil {
+				return nil, nil, errors.FaultErrorWrap(cidErr, "could not get message cid")
+			}
+			r = &ApplicationResult{MessageCid: msgCid}
+		}
 		switch {
 		case errors.IsFault(err):
-			return nil, err
+			return nil, nil, err
 		case errors.IsApplyErrorPermanent(err):
-			results = append(results, &ApplyMessageResult{ApplicationResult{}, err, true})
+			results = append(results, &ApplyMessageResult{ApplicationResult: *r, Failure: err, FailureIsPermanent: true, FailureReason: FailureReasonOf(err)})
 		case errors.IsApplyErrorTemporary(err):
-			results = append(results, &ApplyMessageResult{ApplicationResult{}, err, false})
+			results = append(results, &ApplyMessageResult{ApplicationResult: *r, Failure: err, FailureReason: FailureReasonOf(err)})
+			if isGasBudgetExhausted(err) {
+				dropped = append(dropped, DroppedMessage{Message: msg, Reason: err})
+			}
 		case err != nil:
 			panic("someone is a bad programmer: error is neither fault, perm or temp")
 		default:
-			results = append(results, &ApplyMessageResult{*r, nil, false})
+			results = append(results, &ApplyMessageResult{ApplicationResult: *r, Applied: true, NonceConsumed: true})
 		}
 	}
-	return results, nil
+	return results, dropped, nil
 }
 
 // ApplyMessageDirect applies a given message directly to the given state tree and storage map and returns the result of the message.
 // This is a shortcut to allow internal code to use built-in actor functionality to alter state.
 func ApplyMessageDirect(ctx context.Context, st state.Tree, vms vm.StorageMap, from, to address.Address, nonce uint64, value types.AttoFIL, method types.MethodID, params ...interface{}) ([]byte, error) {
-	cst := state.NewCachedTree(st)
-
 	encodedParams, err := abi.ToEncodedValues(params...)
 	if err != nil {
 		return nil, err
 	}
 
 	msg := types.NewUnsignedMessage(from, to, nonce, value, method, encodedParams)
-	msg.GasLimit = 10000
-	processor := NewConfiguredProcessor(&directMessageValidator{}, &DefaultBlockRewarder{}, builtin.DefaultActors)
-	receipt, err := processor.attemptApplyMessage(ctx, cst, vms, msg, types.NewBlockHeight(0), vm.NewLegacyGasTracker(), nil)
+	receipts, err := NewGenesisProcessor(builtin.DefaultActors).ApplyMessages(ctx, st, vms, msg)
 	if err != nil {
 		return nil, err
 	}
-	if receipt.ExitCode != 0 {
-		return nil, errors.NewFaultError("non-zero exit code for direct message")
-	}
-
-	if err = cst.Commit(ctx); err != nil {
-		return nil, err
-	}
-
-	if len(receipt.Return) > 0 {
 
-		return receipt.Return[0], nil
+	if len(receipts[0].Return) > 0 {
+		return receipts[0].Return[0], nil
 	}
 
 	return []byte{}, nil
 }
 
 // DefaultBlockRewarder pays the block reward from the network actor to the miner's owner.
-type DefaultBlockRewarder struct{}
+type DefaultBlockRewarder struct {
+	// resolver resolves the addresses rewards are paid to and from. If it is nil the built-in
+	// handlers are used.
+	resolver addressResolver
+}
 
 // NewDefaultBlockRewarder creates a new rewarder that actually pays the appropriate rewards.
 func NewDefaultBlockRewarder() *DefaultBlockRewarder {
@@ -594,7 +1221,7 @@ var _ BlockRewarder = (*DefaultBlockRewarder)(nil)
 // BlockReward transfers the block reward from the network actor to the miner's owner.
 func (br *DefaultBlockRewarder) BlockReward(ctx context.Context, st state.Tree, vms vm.StorageMap, minerOwnerAddr address.Address) error {
 	cachedTree := state.NewCachedTree(st)
-	if err := rewardTransfer(ctx, address.LegacyNetworkAddress, minerOwnerAddr, br.BlockRewardAmount(), cachedTree, vms, vm.NewLegacyGasTracker()); err != nil {
+	if err := rewardTransfer(ctx, address.LegacyNetworkAddress, minerOwnerAddr, br.BlockRewardAmount(), cachedTree, vms, br.addressResolver()); err != nil {
 		return errors.FaultErrorWrap(err, "Error attempting to pay block reward")
 	}
 	return cachedTree.Commit(ctx)
@@ -603,7 +1230,7 @@ func (br *DefaultBlockRewarder) BlockReward(ctx context.Context, st state.Tree,
 // GasReward transfers the gas cost reward from the sender actor to the minerOwnerAddr
 func (br *DefaultBlockRewarder) GasReward(ctx context.Context, st state.Tree, vms vm.StorageMap, minerOwnerAddr address.Address, msg *types.UnsignedMessage, cost types.AttoFIL) error {
 	cachedTree := state.NewCachedTree(st)
-	fromAddr, found, err := ResolveAddress(ctx, msg.From, cachedTree, vms, vm.NewLegacyGasTracker())
+	fromAddr, found, err := br.addressResolver().resolve(ctx, msg.From, cachedTree, vms)
 	if err != nil {
 		return errors.FaultErrorWrapf(err, "Could not resolve from address for gas")
 	}
@@ -611,12 +1238,20 @@ func (br *DefaultBlockRewarder) GasReward(ctx context.Context, st state.Tree, vm
 		return errors.FaultErrorWrapf(err, "Could not resolve from address for gas")
 	}
 
-	if err := rewardTransfer(ctx, fromAddr, minerOwnerAddr, cost, cachedTree, vms, vm.NewLegacyGasTracker()); err != nil {
+	if err := rewardTransfer(ctx, fromAddr, minerOwnerAddr, cost, cachedTree, vms, br.addressResolver()); err != nil {
 		return errors.FaultErrorWrap(err, "Error attempting to pay gas reward")
 	}
 	return cachedTree.Commit(ctx)
 }
 
+// addressResolver returns the resolver the rewarder resolves addresses with.
+func (br *DefaultBlockRewarder) addressResolver() addressResolver {
+	if br.resolver == nil {
+		return defaultAddressResolver
+	}
+	return br.resolver
+}
+
 // BlockRewardAmount returns the max FIL value miners can claim as the block reward.
 // TODO this is one of the system parameters that should be configured as part of
 // https://github.com/filecoin-project/go-filecoin/issues/884.
@@ -624,14 +1259,36 @@ func (br *DefaultBlockRewarder) BlockRewardAmount() types.AttoFIL {
 	return types.NewAttoFILFromFIL(1000)
 }
 
+// migrationHook returns a hook that runs the state migrations due at height bh on the actors
+// it is given, or nil if the processor has none.
+func (p *DefaultProcessor) migrationHook(ctx context.Context, store vm.StorageMap, bh *types.BlockHeight) vm.ActorLoadHook {
+	if p.migrations == nil || bh == nil {
+		return nil
+	}
+	return func(addr address.Address, act *actor.Actor) error {
+		return p.migrations.apply(ctx, store, addr, act, bh)
+	}
+}
+
+// commit sets the actors cached in st into its underlying tree, notifying the write observer.
+func (p *DefaultProcessor) commit(ctx context.Context, st *state.CachedTree) error {
+	if p.writeObserver == nil {
+		return st.Commit(ctx)
+	}
+	return st.CommitObserved(ctx, func(addr address.Address, act *actor.Actor) {
+		p.writeObserver(addr, act.Head)
+	})
+}
+
 // rewardTransfer retrieves two actors from the given addresses and attempts to transfer the given value from the balance of the first's to the second.
-func rewardTransfer(ctx context.Context, fromAddr, toAddr address.Address, value types.AttoFIL, st *state.CachedTree, vms vm.StorageMap, gt *vm.LegacyGasTracker) error {
+// The second address is resolved with resolver.
+func rewardTransfer(ctx context.Context, fromAddr, toAddr address.Address, value types.AttoFIL, st *state.CachedTree, vms vm.StorageMap, resolver addressResolver) error {
 	fromActor, err := st.GetActor(ctx, fromAddr)
 	if err != nil {
 		return errors.FaultErrorWrap(err, "could not retrieve from actor for reward transfer.")
 	}
 
-	toActor, _, err := getOrCreateActor(ctx, st, vms, toAddr, gt)
+	toActor, _, _, err := getOrCreateActor(ctx, st, vms, resolver, toAddr, nil, nil)
 	if err != nil {
 		return errors.FaultErrorWrap(err, "failed to get To actor")
 	}
@@ -639,30 +1296,129 @@ func rewardTransfer(ctx context.Context, fromAddr, toAddr address.Address, value
 	return vm.Transfer(fromActor, toActor, value)
 }
 
-func blockGasLimitError(gasTracker *vm.LegacyGasTracker) error {
+// BlockGasLimitError is the cause of the failure to apply a message whose gas limit does not
+// fit in the block, with the numbers the decision was made on. Its Cause is
+// errGasAboveBlockLimit if the limit exceeds the block gas limit itself, or
+// errGasTooHighForCurrentBlock if it exceeds just the gas the block has left.
+type BlockGasLimitError struct {
+	MessageGasLimit types.GasUnits
+	BlockGasLimit   types.GasUnits
+	// BlockGasRemaining is the block gas left unused by the block's earlier messages.
+	BlockGasRemaining types.GasUnits
+	cause             error
+}
+
+func (e *BlockGasLimitError) Error() string {
+	return fmt.Sprintf("%s: message gas limit %d, block gas limit %d, %d remaining in block",
+		e.cause, e.MessageGasLimit, e.BlockGasLimit, e.BlockGasRemaining)
+}
+
+// Cause returns the sentinel for the limit the message exceeded.
+func (e *BlockGasLimitError) Cause() error {
+	return e.cause
+}
+
+// ShouldRevert implements the reverterror interface, as a rejected message changes no state.
+func (e *BlockGasLimitError) ShouldRevert() bool {
+	return true
+}
+
+func blockGasLimitError(gasTracker vm.GasTracker) error {
+	var cause error
 	if gasTracker.GasAboveBlockLimit() {
-		return errGasAboveBlockLimit
+		cause = errGasAboveBlockLimit
 	} else if gasTracker.GasTooHighForCurrentBlock() {
-		return errGasTooHighForCurrentBlock
+		cause = errGasTooHighForCurrentBlock
+	} else {
+		return nil
+	}
+
+	remaining := types.NewGasUnits(0)
+	if used := gasTracker.GasConsumedByBlock(); used < types.BlockGasLimit {
+		remaining = types.BlockGasLimit - used
+	}
+	return &BlockGasLimitError{
+		MessageGasLimit:   gasTracker.MessageGasLimit(),
+		BlockGasLimit:     types.BlockGasLimit,
+		BlockGasRemaining: remaining,
+		cause:             cause,
+	}
+}
+
+// blockGasLimitCause returns the limit a message exceeded, if err is a *BlockGasLimitError.
+func blockGasLimitCause(err error) error {
+	if e, ok := err.(*BlockGasLimitError); ok {
+		return e.cause
 	}
 	return nil
 }
 
+// isGasBudgetExhausted returns true if an error returned by ApplyMessage means the message
+// did not fit in the remaining block gas.
+func isGasBudgetExhausted(err error) bool {
+	cause, ok := err.(interface{ Cause() error })
+	return ok && blockGasLimitCause(cause.Cause()) == errGasTooHighForCurrentBlock
+}
+
 func isTemporaryError(err error) bool {
-	return err == errFromAccountNotFound ||
+	_, actorNotFound := AsActorNotFound(err)
+	return actorNotFound ||
+		isSenderBudgetExhausted(err) ||
+		isMethodRateLimited(err) ||
 		err == errNonceTooHigh ||
-		err == errGasTooHighForCurrentBlock
+		blockGasLimitCause(err) == errGasTooHighForCurrentBlock
 }
 
 func isPermanentError(err error) bool {
 	return err == errInsufficientGas ||
 		err == errSelfSend ||
+		err == errInvalidRecipient ||
 		err == errInvalidSignature ||
 		err == errNonceTooLow ||
 		err == errNonAccountActor ||
 		err == errNegativeValue ||
 		err == errors.Errors[errors.ErrCannotTransferNegativeValue] ||
-		err == errGasAboveBlockLimit
+		err == errGasAboveBlockLimit ||
+		blockGasLimitCause(err) == errGasAboveBlockLimit ||
+		err == errNoOpMessage ||
+		err == errValueAboveMax ||
+		isVetoError(err) ||
+		isPausedActorError(err) ||
+		isMissingCapabilityError(err)
+}
+
+// boundAncestors truncates ancestors to the maximum lookback and checks that the method
+// invoked on the recipient, at the given protocol version, is supplied with as many ancestors
+// as it requires.
+func (p *DefaultProcessor) boundAncestors(to *actor.Actor, method types.MethodID, protocolVersion uint64, ancestors []block.TipSet) ([]block.TipSet, error) {
+	if len(ancestors) > p.maxAncestors {
+		ancestors = ancestors[:p.maxAncestors]
+	}
+
+	code, err := p.actors.GetActorCode(to.Code, protocolVersion)
+	if err != nil {
+		// The vm reports the missing code when the message is sent.
+		return ancestors, nil
+	}
+	_, signature, ok := code.Method(method)
+	if !ok || signature.Ancestors <= len(ancestors) {
+		return ancestors, nil
+	}
+	return nil, errors.NewFaultErrorf("method %d of actor code %s requires %d ancestors but only %d were supplied (%d short)",
+		method, to.Code, signature.Ancestors, len(ancestors), signature.Ancestors-len(ancestors))
+}
+
+// protocolVersionAt returns the protocol version in effect at bh. It is 0 if the processor has
+// no version table or no height is given.
+func (p *DefaultProcessor) protocolVersionAt(bh *types.BlockHeight) (uint64, error) {
+	if p.versions == nil || bh == nil {
+		return 0, nil
+	}
+	v, err := p.versions.VersionAt(bh)
+	if err != nil {
+		return 0, errors.FaultErrorWrap(err, "could not determine protocol version")
+	}
+	return v, nil
 }
 
 // minerOwnerAddress finds the address of the owner of the given miner
@@ -677,44 +1433,69 @@ func (p *DefaultProcessor) minerOwnerAddress(ctx context.Context, st state.Tree,
 	return address.NewFromBytes(ret[0])
 }
 
-func getOrCreateActor(ctx context.Context, st *state.CachedTree, store vm.StorageMap, addr address.Address, gt *vm.LegacyGasTracker) (*actor.Actor, address.Address, error) {
+// getOrCreateRecipient returns the recipient of a message as getOrCreateActor does, charging
+// the creation of an account actor for it to the message unless creation is free.
+func (p *DefaultProcessor) getOrCreateRecipient(ctx context.Context, st *state.CachedTree, store vm.StorageMap, addr address.Address, gt vm.GasTracker) (*actor.Actor, address.Address, bool, error) {
+	payer := gt
+	if p.freeActorCreation {
+		payer = nil
+	}
+	return getOrCreateActor(ctx, st, store, p.addressResolver, addr, p.gasCosts, payer)
+}
+
+// getOrCreateActor returns the actor at addr and its ID address, creating an account actor
+// for addr if it has none. created reports whether an actor was created. The init actor gives
+// each actor it creates the next ID in sequence, so actors created while applying a message
+// are numbered in the order they are first referenced by a send, which depends on nothing but
+// the state and the message. The gas the init actor uses to create the actor, priced with
+// costs, is charged to payer unless it is nil. errInsufficientCreationGas is returned if payer
+// cannot cover it.
+func getOrCreateActor(ctx context.Context, st *state.CachedTree, store vm.StorageMap, resolver addressResolver, addr address.Address, costs vm.GasCostTable, payer vm.GasTracker) (act *actor.Actor, idAddr address.Address, created bool, err error) {
 	// resolve address before lookup
-	idAddr, found, err := ResolveAddress(ctx, addr, st, store, gt)
+	idAddr, found, err := resolver.resolve(ctx, addr, st, store)
 	if err != nil {
-		return nil, address.Undef, err
+		return nil, address.Undef, false, err
 	}
 
 	if found {
 		act, err := st.GetActor(ctx, idAddr)
-		return act, idAddr, err
+		return act, idAddr, false, err
 	}
 
 	initAct, err := st.GetActor(ctx, address.InitAddress)
 	if err != nil {
-		return nil, address.Undef, err
+		return nil, address.Undef, false, err
 	}
 
-	// this should never fail due to lack of gas since gas doesn't have meaning here
-	noopGT := vm.NewLegacyGasTracker()
-	noopGT.MsgGasLimit = 10000 // must exceed gas units consumed by init.Exec+account.Constructor+init.GetActorIDForAddress
-	vmctx := vm.NewVMContext(vm.NewContextParams{Actors: builtin.DefaultActors, To: initAct, State: st, StorageMap: store, GasTracker: noopGT})
+	// this should never fail due to lack of gas: the gas used is charged to payer afterwards
+	createGT := vm.NewLegacyGasTracker()
+	createGT.MsgGasLimit = 10000 // must exceed gas units consumed by init.Exec+account.Constructor
+	vmctx := vm.NewVMContext(vm.NewContextParams{Actors: builtin.DefaultActors, To: initAct, State: st, StorageMap: store, GasTracker: createGT, GasCosts: costs})
 	vmctx.Send(address.InitAddress, initactor.ExecMethodID, types.ZeroAttoFIL, []interface{}{types.AccountActorCodeCid, []interface{}{addr}})
+	if payer != nil {
+		if err := payer.Charge(createGT.GasConsumedByMessage()); err != nil {
+			return nil, address.Undef, false, errInsufficientCreationGas
+		}
+	}
 
+	// looking up the new actor's id is bookkeeping and free
+	noopGT := vm.NewLegacyGasTracker()
+	noopGT.MsgGasLimit = 10000 // must exceed gas units consumed by init.GetActorIDForAddress
 	vmctx = vm.NewVMContext(vm.NewContextParams{Actors: builtin.DefaultActors, To: initAct, State: st, StorageMap: store, GasTracker: noopGT})
 	idAddrInt := vmctx.Send(address.InitAddress, initactor.GetActorIDForAddressMethodID, types.ZeroAttoFIL, []interface{}{addr})
 
 	id, ok := idAddrInt.(*big.Int)
 	if !ok {
-		return nil, address.Undef, errors.NewFaultError("non-integer return from GetActorIDForAddress")
+		return nil, address.Undef, false, errors.NewFaultError("non-integer return from GetActorIDForAddress")
 	}
 
 	idAddr, err = address.NewIDAddress(id.Uint64())
 	if err != nil {
-		return nil, address.Undef, err
+		return nil, address.Undef, false, err
 	}
 
-	act, err := st.GetActor(ctx, idAddr)
-	return act, idAddr, err
+	act, err = st.GetActor(ctx, idAddr)
+	return act, idAddr, err == nil, err
 }
 
 // directMessageValidator is a validator that doesn't validate to simplify message creation in tests.
@@ -2,8 +2,12 @@ package consensus_test
 
 import (
 	"context"
+	"fmt"
+	"math/big"
 	"testing"
+	"time"
 
+	"github.com/filecoin-project/go-leb128"
 	"github.com/ipfs/go-cid"
 	"github.com/ipfs/go-datastore"
 	"github.com/ipfs/go-hamt-ipld"
@@ -13,9 +17,11 @@ import (
 
 	"github.com/filecoin-project/go-filecoin/internal/pkg/block"
 	. "github.com/filecoin-project/go-filecoin/internal/pkg/consensus"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/encoding"
 	th "github.com/filecoin-project/go-filecoin/internal/pkg/testhelpers"
 	tf "github.com/filecoin-project/go-filecoin/internal/pkg/testhelpers/testflags"
 	"github.com/filecoin-project/go-filecoin/internal/pkg/types"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/version"
 	"github.com/filecoin-project/go-filecoin/internal/pkg/vm"
 	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/abi"
 	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/actor"
@@ -100,6 +106,416 @@ func TestProcessTipSetSuccess(t *testing.T) {
 	require.NoError(t, err)
 }
 
+func TestProcessTipSetDetailedReportsBlockGas(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+	cst := hamt.NewCborStore()
+	vms := th.VMStorage()
+
+	fakeActorCodeCid := types.NewCidForTestGetter()()
+	builtinActors := builtin.NewBuilder().
+		AddAll(builtin.DefaultActors).
+		Add(fakeActorCodeCid, 0, &actor.FakeActor{}).
+		Build()
+
+	mockSigner, _ := types.NewMockSignersAndKeyInfo(3)
+	fromAddr1, fromAddr2, minerOwner := mockSigner.Addresses[0], mockSigner.Addresses[1], mockSigner.Addresses[2]
+	fakeAddr, err := address.NewIDAddress(110)
+	require.NoError(t, err)
+
+	_, st := requireMakeStateTree(t, cst, map[address.Address]*actor.Actor{
+		address.LegacyNetworkAddress: th.RequireNewAccountActor(t, types.NewAttoFILFromFIL(1000000)),
+		fakeAddr:                     th.RequireNewFakeActorWithTokens(t, vms, fakeAddr, fakeActorCodeCid, types.ZeroAttoFIL),
+	})
+	th.RequireInitAccountActor(ctx, t, st, vms, fromAddr1, types.NewAttoFILFromFIL(10000))
+	th.RequireInitAccountActor(ctx, t, st, vms, fromAddr2, types.NewAttoFILFromFIL(10000))
+	stCid, _, minerAddr := mustCreateStorageMiner(ctx, t, st, vms, minerOwner)
+
+	// HasReturnValue charges 100 gas units: the second block costs twice the first.
+	callFake := func(from address.Address, nonce uint64) *types.UnsignedMessage {
+		return types.NewMeteredMessage(from, fakeAddr, nonce, types.ZeroAttoFIL, actor.HasReturnValueID, nil, types.NewGasPrice(1), types.NewGasUnits(300))
+	}
+	tsMsgs := [][]*types.UnsignedMessage{
+		{callFake(fromAddr1, 0)},
+		{callFake(fromAddr2, 0), callFake(fromAddr2, 1)},
+	}
+
+	cidGetter := types.NewCidForTestGetter()
+	newBlock := func(ticket byte) *block.Block {
+		return &block.Block{
+			Height:    20,
+			StateRoot: stCid,
+			Miner:     minerAddr,
+			Messages:  types.TxMeta{SecpRoot: cidGetter(), BLSRoot: types.EmptyMessagesCID},
+			Ticket:    block.Ticket{VRFProof: []byte{ticket}},
+		}
+	}
+	blk1, blk2 := newBlock(0x1), newBlock(0x2)
+
+	processor := NewConfiguredProcessor(NewDefaultMessageValidator(), &FakeBlockRewarder{}, builtinActors)
+	tsResult, err := processor.ProcessTipSetDetailed(ctx, st, vms, th.RequireNewTipSet(t, blk1, blk2), tsMsgs, nil)
+	require.NoError(t, err)
+	require.Len(t, tsResult.Blocks, 2)
+
+	assert.Equal(t, blk1.Cid(), tsResult.Blocks[0].Block)
+	assert.Len(t, tsResult.Blocks[0].Results, 1)
+	assert.Equal(t, types.NewGasUnits(100), tsResult.Blocks[0].GasUsed)
+	assert.Equal(t, blk2.Cid(), tsResult.Blocks[1].Block)
+	assert.Len(t, tsResult.Blocks[1].Results, 2)
+	assert.Equal(t, types.NewGasUnits(200), tsResult.Blocks[1].GasUsed)
+
+	assert.Equal(t, types.NewGasUnits(300), tsResult.GasUsed())
+	assert.Equal(t, 1, tsResult.HeaviestBlock())
+	assert.Len(t, tsResult.Results(), 3)
+
+	// The running total of gas used restarts with each block.
+	assert.Equal(t, types.NewGasUnits(100), tsResult.Blocks[0].Results[0].CumulativeGasUsed)
+	assert.Equal(t, types.NewGasUnits(100), tsResult.Blocks[1].Results[0].CumulativeGasUsed)
+	assert.Equal(t, types.NewGasUnits(200), tsResult.Blocks[1].Results[1].CumulativeGasUsed)
+}
+
+func TestProcessTipSetReportsSupplyDelta(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+	cst := hamt.NewCborStore()
+	vms := th.VMStorage()
+
+	mockSigner, _ := types.NewMockSignersAndKeyInfo(2)
+	from, minerOwner := mockSigner.Addresses[0], mockSigner.Addresses[1]
+
+	_, st := requireMakeStateTree(t, cst, map[address.Address]*actor.Actor{
+		address.LegacyNetworkAddress: th.RequireNewAccountActor(t, types.NewAttoFILFromFIL(1000000)),
+		address.BurntFundsAddress:    th.RequireNewAccountActor(t, types.ZeroAttoFIL),
+	})
+	th.RequireInitAccountActor(ctx, t, st, vms, from, types.NewAttoFILFromFIL(10000))
+	stCid, _, minerAddr := mustCreateStorageMiner(ctx, t, st, vms, minerOwner)
+
+	burn := types.NewAttoFILFromFIL(30)
+	msgs := [][]*types.UnsignedMessage{{
+		types.NewMeteredMessage(from, address.BurntFundsAddress, 0, burn, types.SendMethodID, nil, types.NewGasPrice(1), types.NewGasUnits(300)),
+	}}
+	blk := &block.Block{Height: 20, StateRoot: stCid, Miner: minerAddr}
+
+	rewarder := &DefaultBlockRewarder{}
+	processor := NewConfiguredProcessor(NewDefaultMessageValidator(), rewarder, builtin.DefaultActors)
+	tsResult, err := processor.ProcessTipSetDetailed(ctx, st, vms, th.RequireNewTipSet(t, blk), msgs, nil)
+	require.NoError(t, err)
+	require.Len(t, tsResult.Results(), 1)
+	require.NoError(t, tsResult.Results()[0].Failure)
+
+	// Gas is paid to the miner, so neither mints nor burns.
+	expected := rewarder.BlockRewardAmount().Sub(burn)
+	assert.True(t, tsResult.SupplyDelta.Equal(expected), "supply delta is %s", tsResult.SupplyDelta)
+}
+
+func TestProcessTipSetReportsProtocolVersion(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+	cst := hamt.NewCborStore()
+	vms := th.VMStorage()
+
+	// The fake actor only has code from the upgrade at height 10 on.
+	fakeActorCodeCid := types.NewCidForTestGetter()()
+	builtinActors := builtin.NewBuilder().
+		AddAll(builtin.DefaultActors).
+		Add(fakeActorCodeCid, 1, &actor.FakeActor{}).
+		Build()
+	versions, err := version.NewProtocolVersionTableBuilder(version.TEST).
+		Add(version.TEST, 0, types.NewBlockHeight(0)).
+		Add(version.TEST, 1, types.NewBlockHeight(10)).
+		Build()
+	require.NoError(t, err)
+
+	mockSigner, _ := types.NewMockSignersAndKeyInfo(2)
+	from, minerOwner := mockSigner.Addresses[0], mockSigner.Addresses[1]
+	fakeAddr, err := address.NewIDAddress(110)
+	require.NoError(t, err)
+
+	_, st := requireMakeStateTree(t, cst, map[address.Address]*actor.Actor{
+		address.LegacyNetworkAddress: th.RequireNewAccountActor(t, types.NewAttoFILFromFIL(1000000)),
+		fakeAddr:                     th.RequireNewFakeActorWithTokens(t, vms, fakeAddr, fakeActorCodeCid, types.ZeroAttoFIL),
+	})
+	th.RequireInitAccountActor(ctx, t, st, vms, from, types.NewAttoFILFromFIL(10000))
+	stCid, _, minerAddr := mustCreateStorageMiner(ctx, t, st, vms, minerOwner)
+
+	processor := NewConfiguredProcessor(NewDefaultMessageValidator(), &FakeBlockRewarder{}, builtinActors, WithProtocolVersions(versions))
+	process := func(height uint64, nonce uint64) *TipSetResult {
+		blk := &block.Block{Height: types.Uint64(height), StateRoot: stCid, Miner: minerAddr}
+		msgs := [][]*types.UnsignedMessage{{
+			types.NewMeteredMessage(from, fakeAddr, nonce, types.ZeroAttoFIL, actor.HasReturnValueID, nil, types.NewGasPrice(1), types.NewGasUnits(300)),
+		}}
+		tsResult, err := processor.ProcessTipSetDetailed(ctx, st, vms, th.RequireNewTipSet(t, blk), msgs, nil)
+		require.NoError(t, err)
+		require.Len(t, tsResult.Results(), 1)
+		require.NoError(t, tsResult.Results()[0].Failure)
+		return tsResult
+	}
+
+	before := process(5, 0)
+	assert.Equal(t, uint64(0), before.ProtocolVersion)
+	assert.Error(t, before.Results()[0].ExecutionError)
+
+	after := process(15, 1)
+	assert.Equal(t, uint64(1), after.ProtocolVersion)
+	assert.NoError(t, after.Results()[0].ExecutionError)
+}
+
+func TestSuccessfulReceipts(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+	fakeActorCodeCid := types.NewCidForTestGetter()()
+	builtinActors := builtin.NewBuilder().
+		AddAll(builtin.DefaultActors).
+		Add(fakeActorCodeCid, 0, &actor.FakeActor{}).
+		Build()
+
+	// NonZeroExitCode fails with exit code 42 while HasReturnValue succeeds.
+	process := func(opts ...ProcessorOption) []*ApplyMessageResult {
+		cst := hamt.NewCborStore()
+		vms := th.VMStorage()
+		mockSigner, _ := types.NewMockSignersAndKeyInfo(2)
+		from, minerOwner := mockSigner.Addresses[0], mockSigner.Addresses[1]
+		fakeAddr, err := address.NewIDAddress(110)
+		require.NoError(t, err)
+
+		_, st := requireMakeStateTree(t, cst, map[address.Address]*actor.Actor{
+			address.LegacyNetworkAddress: th.RequireNewAccountActor(t, types.NewAttoFILFromFIL(1000000)),
+			fakeAddr:                     th.RequireNewFakeActorWithTokens(t, vms, fakeAddr, fakeActorCodeCid, types.ZeroAttoFIL),
+		})
+		th.RequireInitAccountActor(ctx, t, st, vms, from, types.NewAttoFILFromFIL(10000))
+		stCid, _, minerAddr := mustCreateStorageMiner(ctx, t, st, vms, minerOwner)
+
+		tsMsgs := [][]*types.UnsignedMessage{{
+			types.NewMeteredMessage(from, fakeAddr, 0, types.ZeroAttoFIL, actor.HasReturnValueID, nil, types.NewGasPrice(1), types.NewGasUnits(300)),
+			types.NewMeteredMessage(from, fakeAddr, 1, types.ZeroAttoFIL, actor.NonZeroExitCodeID, nil, types.NewGasPrice(1), types.NewGasUnits(300)),
+		}}
+		blk := &block.Block{Height: 20, StateRoot: stCid, Miner: minerAddr}
+
+		processor := NewConfiguredProcessor(NewDefaultMessageValidator(), &FakeBlockRewarder{}, builtinActors, opts...)
+		results, err := processor.ProcessTipSet(ctx, st, vms, th.RequireNewTipSet(t, blk), tsMsgs, nil)
+		require.NoError(t, err)
+		require.Len(t, results, 2)
+		return results
+	}
+
+	results := process()
+	assert.Equal(t, uint8(42), results[1].Receipt.ExitCode)
+	successful := SuccessfulReceipts(results)
+	require.Len(t, successful, 1)
+	assert.Equal(t, results[0].Receipt, successful[0])
+
+	dropped := process(WithFailedReturnsDropped())
+	assert.Equal(t, successful, SuccessfulReceipts(dropped))
+	assert.Len(t, dropped[1].Receipt.Return, 0)
+}
+
+func TestReceiptsDigest(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+	fakeActorCodeCid := types.NewCidForTestGetter()()
+	builtinActors := builtin.NewBuilder().
+		AddAll(builtin.DefaultActors).
+		Add(fakeActorCodeCid, 0, &actor.FakeActor{}).
+		Build()
+
+	process := func() []*ApplyMessageResult {
+		cst := hamt.NewCborStore()
+		vms := th.VMStorage()
+		mockSigner, _ := types.NewMockSignersAndKeyInfo(2)
+		from, minerOwner := mockSigner.Addresses[0], mockSigner.Addresses[1]
+		fakeAddr, err := address.NewIDAddress(110)
+		require.NoError(t, err)
+
+		_, st := requireMakeStateTree(t, cst, map[address.Address]*actor.Actor{
+			address.LegacyNetworkAddress: th.RequireNewAccountActor(t, types.NewAttoFILFromFIL(1000000)),
+			fakeAddr:                     th.RequireNewFakeActorWithTokens(t, vms, fakeAddr, fakeActorCodeCid, types.ZeroAttoFIL),
+		})
+		th.RequireInitAccountActor(ctx, t, st, vms, from, types.NewAttoFILFromFIL(10000))
+		stCid, _, minerAddr := mustCreateStorageMiner(ctx, t, st, vms, minerOwner)
+
+		tsMsgs := [][]*types.UnsignedMessage{{
+			types.NewMeteredMessage(from, fakeAddr, 0, types.ZeroAttoFIL, actor.HasReturnValueID, nil, types.NewGasPrice(1), types.NewGasUnits(300)),
+			types.NewMeteredMessage(from, fakeAddr, 1, types.ZeroAttoFIL, actor.NonZeroExitCodeID, nil, types.NewGasPrice(1), types.NewGasUnits(300)),
+		}}
+		blk := &block.Block{Height: 20, StateRoot: stCid, Miner: minerAddr}
+
+		processor := NewConfiguredProcessor(NewDefaultMessageValidator(), &FakeBlockRewarder{}, builtinActors)
+		results, err := processor.ProcessTipSet(ctx, st, vms, th.RequireNewTipSet(t, blk), tsMsgs, nil)
+		require.NoError(t, err)
+		require.Len(t, results, 2)
+		return results
+	}
+
+	results := process()
+	digest := ReceiptsDigest(results)
+	assert.Equal(t, digest, ReceiptsDigest(process()))
+
+	differing := *results[1].Receipt
+	differing.ExitCode = 1
+	results[1] = &ApplyMessageResult{ApplicationResult: ApplicationResult{Receipt: &differing}}
+	assert.NotEqual(t, digest, ReceiptsDigest(results))
+}
+
+func TestResultsIncludeMessageCid(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+	fakeActorCodeCid := types.NewCidForTestGetter()()
+	builtinActors := builtin.NewBuilder().
+		AddAll(builtin.DefaultActors).
+		Add(fakeActorCodeCid, 0, &actor.FakeActor{}).
+		Build()
+	processor := NewConfiguredProcessor(NewDefaultMessageValidator(), &FakeBlockRewarder{}, builtinActors)
+
+	vms := th.VMStorage()
+	addresses, st := setupActorsForGasTest(t, vms, fakeActorCodeCid, 1000)
+
+	// The second message's nonce is too high, so it fails to apply.
+	msgs := []*types.UnsignedMessage{
+		types.NewMeteredMessage(addresses[0], addresses[1], 0, types.ZeroAttoFIL, actor.HasReturnValueID, nil, types.NewGasPrice(1), types.NewGasUnits(300)),
+		types.NewMeteredMessage(addresses[0], addresses[1], 5, types.ZeroAttoFIL, actor.HasReturnValueID, nil, types.NewGasPrice(1), types.NewGasUnits(300)),
+	}
+	results, err := processor.ApplyMessagesAndPayRewards(ctx, st, vms, msgs, addresses[3], types.NewBlockHeight(0), nil)
+	require.NoError(t, err)
+	require.Len(t, results, len(msgs))
+	assert.NoError(t, results[0].Failure)
+	assert.Error(t, results[1].Failure)
+
+	for i, msg := range msgs {
+		msgCid, err := msg.Cid()
+		require.NoError(t, err)
+		assert.Equal(t, msgCid, results[i].MessageCid)
+	}
+}
+
+func TestResultsIncludeValueTransferred(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+	fakeActorCodeCid := types.NewCidForTestGetter()()
+	builtinActors := builtin.NewBuilder().
+		AddAll(builtin.DefaultActors).
+		Add(fakeActorCodeCid, 0, &actor.FakeActor{}).
+		Build()
+	processor := NewConfiguredProcessor(NewDefaultMessageValidator(), &FakeBlockRewarder{}, builtinActors)
+
+	vms := th.VMStorage()
+	addresses, st := setupActorsForGasTest(t, vms, fakeActorCodeCid, 1000)
+
+	value := types.NewAttoFILFromFIL(5)
+	msg := types.NewMeteredMessage(addresses[0], addresses[1], 0, value, actor.HasReturnValueID, nil, types.NewGasPrice(2), types.NewGasUnits(300))
+	r, err := processor.ApplyMessage(ctx, st, vms, msg, addresses[3], types.NewBlockHeight(0), vm.NewLegacyGasTracker(), nil)
+	require.NoError(t, err)
+	require.NoError(t, r.ExecutionError)
+	assert.True(t, r.ValueTransferred.Equal(value), "value transferred is %s", r.ValueTransferred)
+	assert.True(t, r.Receipt.GasAttoFIL.Equal(types.NewAttoFIL(big.NewInt(200))), "gas paid is %s", r.Receipt.GasAttoFIL)
+
+	// A message that reverts moves no value.
+	msg = types.NewMeteredMessage(addresses[0], addresses[1], 1, value, actor.ReturnRevertErrorID, nil, types.NewGasPrice(2), types.NewGasUnits(300))
+	r, err = processor.ApplyMessage(ctx, st, vms, msg, addresses[3], types.NewBlockHeight(0), vm.NewLegacyGasTracker(), nil)
+	require.NoError(t, err)
+	require.Error(t, r.ExecutionError)
+	assert.True(t, r.ValueTransferred.IsZero())
+}
+
+func TestApplyMessageResultReportsApplied(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+	fakeActorCodeCid := types.NewCidForTestGetter()()
+	builtinActors := builtin.NewBuilder().
+		AddAll(builtin.DefaultActors).
+		Add(fakeActorCodeCid, 0, &actor.FakeActor{}).
+		Build()
+	processor := NewConfiguredProcessor(NewDefaultMessageValidator(), &FakeBlockRewarder{}, builtinActors)
+
+	vms := th.VMStorage()
+	addresses, st := setupActorsForGasTest(t, vms, fakeActorCodeCid, 1000)
+
+	msgs := []*types.UnsignedMessage{
+		types.NewMeteredMessage(addresses[0], addresses[1], 0, types.ZeroAttoFIL, actor.ReturnRevertErrorID, nil, types.NewGasPrice(1), types.NewGasUnits(300)),
+		types.NewMeteredMessage(addresses[0], addresses[1], 5, types.ZeroAttoFIL, actor.HasReturnValueID, nil, types.NewGasPrice(1), types.NewGasUnits(300)),
+	}
+	results, err := processor.ApplyMessagesAndPayRewards(ctx, st, vms, msgs, addresses[3], types.NewBlockHeight(0), nil)
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+
+	t.Run("reverted message is applied and consumes its nonce", func(t *testing.T) {
+		reverted := results[0]
+		require.NoError(t, reverted.Failure)
+		require.Error(t, reverted.ExecutionError)
+		assert.True(t, reverted.Applied)
+		assert.True(t, reverted.NonceConsumed)
+
+		sender, err := st.GetActor(ctx, reverted.Extended.FromAddr)
+		require.NoError(t, err)
+		assert.Equal(t, types.Uint64(1), sender.CallSeqNum)
+	})
+
+	t.Run("message after a nonce gap is not applied", func(t *testing.T) {
+		gapped := results[1]
+		require.Error(t, gapped.Failure)
+		assert.Equal(t, FailureNonceGap, gapped.FailureReason)
+		assert.False(t, gapped.Applied)
+		assert.False(t, gapped.NonceConsumed)
+	})
+}
+
+func TestExtendedReceiptReportsGasRefund(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+	fakeActorCodeCid := types.NewCidForTestGetter()()
+	builtinActors := builtin.NewBuilder().
+		AddAll(builtin.DefaultActors).
+		Add(fakeActorCodeCid, 0, &actor.FakeActor{}).
+		Build()
+	processor := NewConfiguredProcessor(NewDefaultMessageValidator(), &FakeBlockRewarder{}, builtinActors)
+
+	vms := th.VMStorage()
+	addresses, st := setupActorsForGasTest(t, vms, fakeActorCodeCid, 1000)
+
+	// HasReturnValue uses 100 of the 1000 gas units reserved.
+	msg := types.NewMeteredMessage(addresses[0], addresses[1], 0, types.ZeroAttoFIL, actor.HasReturnValueID, nil, types.NewGasPrice(3), types.NewGasUnits(1000))
+	r, err := processor.ApplyMessage(ctx, st, vms, msg, addresses[3], types.NewBlockHeight(0), vm.NewLegacyGasTracker(), nil)
+	require.NoError(t, err)
+	require.NoError(t, r.ExecutionError)
+	assert.Equal(t, types.NewGasUnits(100), r.Extended.GasUsed)
+	assert.True(t, r.Extended.GasRefund.Equal(types.NewAttoFIL(big.NewInt(900*3))), "refund is %s", r.Extended.GasRefund)
+}
+
+func TestExtendedReceiptReportsGasBreakdown(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+	fakeActorCodeCid := types.NewCidForTestGetter()()
+	builtinActors := builtin.NewBuilder().
+		AddAll(builtin.DefaultActors).
+		Add(fakeActorCodeCid, 0, &actor.FakeActor{}).
+		Build()
+	costs := vm.GasCostTable{vm.GasOnStorageRead: 7, vm.GasOnStorageWrite: 20}
+	processor := NewConfiguredProcessor(NewDefaultMessageValidator(), &FakeBlockRewarder{}, builtinActors, WithGasCostTable(costs))
+
+	vms := th.VMStorage()
+	addresses, st := setupActorsForGasTest(t, vms, fakeActorCodeCid, 1000)
+
+	// Allocates stores a single object.
+	params := actor.MustConvertParams(big.NewInt(64))
+	msg := types.NewMeteredMessage(addresses[0], addresses[1], 0, types.ZeroAttoFIL, actor.AllocatesID, params, types.NewGasPrice(1), types.NewGasUnits(1000))
+	r, err := processor.ApplyMessage(ctx, st, vms, msg, addresses[3], types.NewBlockHeight(0), vm.NewLegacyGasTracker(), nil)
+	require.NoError(t, err)
+	require.NoError(t, r.ExecutionError)
+
+	breakdown := r.Extended.GasBreakdown
+	assert.Equal(t, types.NewGasUnits(20), breakdown.StorageWrite)
+	assert.Equal(t, r.Extended.GasUsed, breakdown.Inclusion+breakdown.Compute+breakdown.StorageRead+breakdown.StorageWrite)
+}
+
 func TestProcessTipsConflicts(t *testing.T) {
 	tf.UnitTest(t)
 
@@ -152,6 +568,47 @@ func TestProcessTipsConflicts(t *testing.T) {
 	require.NoError(t, err)
 }
 
+func TestValidateTipSetMessages(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+	cst := hamt.NewCborStore()
+	vms := th.VMStorage()
+	mockSigner, _ := types.NewMockSignersAndKeyInfo(3)
+
+	fromAddr, toAddr, unknownAddr := mockSigner.Addresses[0], mockSigner.Addresses[1], mockSigner.Addresses[2]
+	_, st := th.RequireMakeStateTree(t, cst, map[address.Address]*actor.Actor{})
+	th.RequireInitAccountActor(ctx, t, st, vms, fromAddr, types.NewAttoFILFromFIL(1000))
+
+	send := func(from address.Address, nonce uint64, gasPrice int64) *types.UnsignedMessage {
+		return types.NewMeteredMessage(from, toAddr, nonce, types.NewAttoFILFromFIL(1), types.SendMethodID, nil, types.NewGasPrice(gasPrice), types.NewGasUnits(0))
+	}
+	msgs1 := []*types.UnsignedMessage{
+		send(fromAddr, 0, 1),
+		send(fromAddr, 1, 1),
+		send(fromAddr, 5, 1), // nonce too high
+	}
+	msgs2 := []*types.UnsignedMessage{
+		send(unknownAddr, 0, 1), // sender does not exist
+		send(fromAddr, 2, 0),    // zero gas price
+		send(fromAddr, 2, 1),
+	}
+	blk1 := &block.Block{Height: 20, Ticket: block.Ticket{VRFProof: []byte{0, 0}}}
+	blk2 := &block.Block{Height: 20, Ticket: block.Ticket{VRFProof: []byte{1, 1}}}
+
+	stCid, err := st.Flush(ctx)
+	require.NoError(t, err)
+
+	valid, err := NewDefaultProcessor().ValidateTipSetMessages(ctx, st, vms, th.RequireNewTipSet(t, blk1, blk2), [][]*types.UnsignedMessage{msgs1, msgs2})
+	require.NoError(t, err)
+	assert.Equal(t, []bool{true, true, false, false, false, true}, valid)
+
+	// validation does not touch state
+	afterCid, err := st.Flush(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, stCid, afterCid)
+}
+
 // ProcessTipset should not fail with an unsigned block reward message.
 func TestProcessTipsetReward(t *testing.T) {
 	tf.UnitTest(t)
@@ -380,7 +837,7 @@ func TestApplyMessagesValidation(t *testing.T) {
 		_, err := NewDefaultProcessor().ApplyMessage(context.Background(), st, vms, msg, addr2,
 			types.NewBlockHeight(0), vm.NewLegacyGasTracker(), nil)
 		require.Error(t, err)
-		assert.Equal(t, "from (sender) account not found", err.(*errors.ApplyErrorTemporary).Cause().Error())
+		assert.Equal(t, &ErrActorNotFound{Addr: addr1}, err.(*errors.ApplyErrorTemporary).Cause())
 	})
 
 	t.Run("errors on attempt to transfer negative value", func(t *testing.T) {
@@ -581,42 +1038,131 @@ func TestSendToNonexistentAddressThenSpendFromIt(t *testing.T) {
 	assert.Equal(t, types.NewAttoFILFromFIL(300), act3.Balance)
 }
 
-func TestApplyQueryMessageWillNotAlterState(t *testing.T) {
-	tf.BadUnitTestWithSideEffects(t)
+func TestApplyMessageReportsBalances(t *testing.T) {
+	tf.UnitTest(t)
 
-	newAddress := address.NewForTestGetter()
 	ctx := context.Background()
 	cst := hamt.NewCborStore()
 	vms := th.VMStorage()
+	mockSigner, _ := types.NewMockSignersAndKeyInfo(3)
+	fromAddr, toAddr, minerOwner := mockSigner.Addresses[0], mockSigner.Addresses[1], mockSigner.Addresses[2]
 
-	// Install the fake actor so we can execute it.
+	_, st := requireMakeStateTree(t, cst, map[address.Address]*actor.Actor{})
+	th.RequireInitAccountActor(ctx, t, st, vms, fromAddr, types.NewAttoFILFromFIL(1000))
+	th.RequireInitAccountActor(ctx, t, st, vms, toAddr, types.NewAttoFILFromFIL(100))
+	th.RequireInitAccountActor(ctx, t, st, vms, minerOwner, types.ZeroAttoFIL)
+
+	msg := types.NewMeteredMessage(fromAddr, toAddr, 0, types.NewAttoFILFromFIL(550), types.SendMethodID, []byte{}, types.NewGasPrice(1), types.NewGasUnits(0))
+	result, err := NewDefaultProcessor().ApplyMessage(ctx, st, vms, msg, minerOwner, types.NewBlockHeight(0), vm.NewLegacyGasTracker(), nil)
+	require.NoError(t, err)
+	require.NotNil(t, result.Extended)
+
+	assert.Equal(t, types.NewAttoFILFromFIL(1000), result.Extended.FromBalanceBefore)
+	assert.Equal(t, types.NewAttoFILFromFIL(450), result.Extended.FromBalanceAfter)
+	assert.Equal(t, types.NewAttoFILFromFIL(100), result.Extended.ToBalanceBefore)
+	assert.Equal(t, types.NewAttoFILFromFIL(650), result.Extended.ToBalanceAfter)
+}
+
+func TestMinerTipsSumToFeeRevenue(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
 	fakeActorCodeCid := types.NewCidForTestGetter()()
-	actors := builtin.NewBuilder().
+	builtinActors := builtin.NewBuilder().
 		AddAll(builtin.DefaultActors).
 		Add(fakeActorCodeCid, 0, &actor.FakeActor{}).
 		Build()
-
-	addr1, err := address.NewIDAddress(110)
-	require.NoError(t, err)
-	addr2, err := address.NewIDAddress(111)
+	vms := th.VMStorage()
+	addresses, st := setupActorsForGasTest(t, vms, fakeActorCodeCid, 1000)
+	rewarder := NewDefaultBlockRewarder()
+	processor := NewConfiguredProcessor(NewDefaultMessageValidator(), rewarder, builtinActors)
+
+	// HasReturnValue charges 100 gas units
+	msgs := []*types.UnsignedMessage{
+		types.NewMeteredMessage(addresses[0], addresses[1], 0, types.ZeroAttoFIL, actor.HasReturnValueID, nil, types.NewGasPrice(3), types.NewGasUnits(200)),
+		types.NewMeteredMessage(addresses[0], addresses[1], 1, types.ZeroAttoFIL, actor.HasReturnValueID, nil, types.NewGasPrice(5), types.NewGasUnits(200)),
+	}
+	results, err := processor.ApplyMessagesAndPayRewards(ctx, st, vms, msgs, addresses[3], types.NewBlockHeight(0), nil)
 	require.NoError(t, err)
-	act1 := th.RequireNewFakeActorWithTokens(t, vms, addr1, fakeActorCodeCid, types.NewAttoFILFromFIL(102))
-	act2 := th.RequireNewFakeActorWithTokens(t, vms, addr2, fakeActorCodeCid, types.NewAttoFILFromFIL(0))
 
-	_, st := th.RequireMakeStateTree(t, cst, map[address.Address]*actor.Actor{
-		addr1: act1,
-		addr2: act2,
-	})
-	addr0 := newAddress()
-	th.RequireInitAccountActor(ctx, t, st, vms, addr0, types.NewAttoFILFromFIL(101))
+	tips := types.ZeroAttoFIL
+	for _, r := range results {
+		require.NoError(t, r.Failure)
+		tips = tips.Add(r.Extended.MinerTip)
+	}
+	assert.True(t, types.NewGasPrice(800).Equal(tips))
 
-	// pre-execution state
-	preCid, err := st.Flush(ctx)
+	owner, err := st.GetActor(ctx, addresses[3])
 	require.NoError(t, err)
+	assert.True(t, rewarder.BlockRewardAmount().Add(tips).Equal(owner.Balance))
+}
 
-	// send 100 from addr1 -> addr2, by sending a message from addr0 to addr1
-	args1, err := abi.ToEncodedValues(addr2)
-	require.NoError(t, err)
+func TestApplyMessageReportsDuration(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+	fakeActorCodeCid := types.NewCidForTestGetter()()
+	builtinActors := builtin.NewBuilder().
+		AddAll(builtin.DefaultActors).
+		Add(fakeActorCodeCid, 0, &actor.FakeActor{}).
+		Build()
+	vms := th.VMStorage()
+	addresses, st := setupActorsForGasTest(t, vms, fakeActorCodeCid, 1000)
+	processor := NewConfiguredProcessor(NewDefaultMessageValidator(), &FakeBlockRewarder{}, builtinActors)
+
+	msgs := []*types.UnsignedMessage{
+		types.NewMeteredMessage(addresses[0], addresses[1], 0, types.ZeroAttoFIL, actor.SleepsID, actor.MustConvertParams(big.NewInt(1)), types.NewGasPrice(1), types.NewGasUnits(100)),
+		types.NewMeteredMessage(addresses[0], addresses[1], 1, types.ZeroAttoFIL, actor.SleepsID, actor.MustConvertParams(big.NewInt(50)), types.NewGasPrice(1), types.NewGasUnits(100)),
+	}
+	results, err := processor.ApplyMessagesAndPayRewards(ctx, st, vms, msgs, addresses[3], types.NewBlockHeight(0), nil)
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	for _, r := range results {
+		require.NoError(t, r.Failure)
+	}
+
+	assert.True(t, results[0].Extended.Duration >= time.Millisecond)
+	assert.True(t, results[1].Extended.Duration >= 50*time.Millisecond)
+	assert.True(t, results[1].Extended.Duration < 5*time.Second)
+	assert.Equal(t, 1, SlowestMessage(results))
+}
+
+func TestApplyQueryMessageWillNotAlterState(t *testing.T) {
+	tf.BadUnitTestWithSideEffects(t)
+
+	newAddress := address.NewForTestGetter()
+	ctx := context.Background()
+	cst := hamt.NewCborStore()
+	vms := th.VMStorage()
+
+	// Install the fake actor so we can execute it.
+	fakeActorCodeCid := types.NewCidForTestGetter()()
+	actors := builtin.NewBuilder().
+		AddAll(builtin.DefaultActors).
+		Add(fakeActorCodeCid, 0, &actor.FakeActor{}).
+		Build()
+
+	addr1, err := address.NewIDAddress(110)
+	require.NoError(t, err)
+	addr2, err := address.NewIDAddress(111)
+	require.NoError(t, err)
+	act1 := th.RequireNewFakeActorWithTokens(t, vms, addr1, fakeActorCodeCid, types.NewAttoFILFromFIL(102))
+	act2 := th.RequireNewFakeActorWithTokens(t, vms, addr2, fakeActorCodeCid, types.NewAttoFILFromFIL(0))
+
+	_, st := th.RequireMakeStateTree(t, cst, map[address.Address]*actor.Actor{
+		addr1: act1,
+		addr2: act2,
+	})
+	addr0 := newAddress()
+	th.RequireInitAccountActor(ctx, t, st, vms, addr0, types.NewAttoFILFromFIL(101))
+
+	// pre-execution state
+	preCid, err := st.Flush(ctx)
+	require.NoError(t, err)
+
+	// send 100 from addr1 -> addr2, by sending a message from addr0 to addr1
+	args1, err := abi.ToEncodedValues(addr2)
+	require.NoError(t, err)
 
 	processor := NewConfiguredProcessor(NewDefaultMessageValidator(), NewDefaultBlockRewarder(), actors)
 	_, exitCode, err := processor.CallQueryMethod(ctx, st, vms, addr1, actor.NestedBalanceID, args1, addr0, types.NewBlockHeight(0))
@@ -629,6 +1175,59 @@ func TestApplyQueryMessageWillNotAlterState(t *testing.T) {
 	assert.True(t, preCid.Equals(postCid))
 }
 
+func TestCallQueryMethodReturnsRecipientCode(t *testing.T) {
+	tf.UnitTest(t)
+
+	newAddress := address.NewForTestGetter()
+	ctx := context.Background()
+	cst := hamt.NewCborStore()
+	vms := th.VMStorage()
+	_, st := requireMakeStateTree(t, cst, map[address.Address]*actor.Actor{})
+
+	owner := newAddress()
+	th.RequireInitAccountActor(ctx, t, st, vms, owner, types.NewAttoFILFromFIL(1000))
+	_, minerAddr := th.RequireNewMinerActor(ctx, t, st, vms, owner, 10, th.RequireRandomPeerID(t), types.NewAttoFILFromFIL(100))
+
+	result, err := NewDefaultProcessor().CallQueryMethodExtended(ctx, st, vms, minerAddr, miner.GetOwner, []byte{}, owner, types.NewBlockHeight(0))
+	require.NoError(t, err)
+	assert.Equal(t, uint8(0), result.ExitCode)
+	assert.Equal(t, types.MinerActorCodeCid, result.Code)
+	assert.Equal(t, []abi.Type{abi.Address}, result.ReturnTypes)
+
+	returnedOwner, err := address.NewFromBytes(result.Return[0])
+	require.NoError(t, err)
+	assert.Equal(t, owner, returnedOwner)
+}
+
+func TestReadOnlyQueries(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+	fakeActorCodeCid := types.NewCidForTestGetter()()
+	builtinActors := builtin.NewBuilder().
+		AddAll(builtin.DefaultActors).
+		Add(fakeActorCodeCid, 0, &actor.FakeActor{}).
+		Build()
+	processor := NewConfiguredProcessor(NewDefaultMessageValidator(), &FakeBlockRewarder{}, builtinActors, WithReadOnlyQueries())
+
+	vms := th.VMStorage()
+	addresses, st := setupActorsForGasTest(t, vms, fakeActorCodeCid, 1000)
+
+	t.Run("read-only method succeeds", func(t *testing.T) {
+		result, err := processor.CallQueryMethodExtended(ctx, st, vms, addresses[1], actor.HasReturnValueID, nil, addresses[0], types.NewBlockHeight(0))
+		require.NoError(t, err)
+		assert.Equal(t, uint8(0), result.ExitCode)
+	})
+
+	t.Run("mutating method fails", func(t *testing.T) {
+		// NestedBalance transfers funds to its target.
+		params, err := abi.ToEncodedValues(addresses[2])
+		require.NoError(t, err)
+		_, _, err = processor.CallQueryMethod(ctx, st, vms, addresses[1], actor.NestedBalanceID, params, addresses[0], types.NewBlockHeight(0))
+		assert.Equal(t, &NonReadOnlyMethodError{Code: fakeActorCodeCid, Method: actor.NestedBalanceID}, err)
+	})
+}
+
 func TestApplyMessageChargesGas(t *testing.T) {
 	tf.BadUnitTestWithSideEffects(t)
 
@@ -840,6 +1439,930 @@ func TestBlockGasLimitBehavior(t *testing.T) {
 		assert.False(t, result[0].FailureIsPermanent)
 		assert.Nil(t, result[2].Failure)
 	})
+
+	t.Run("messages that exceed the remaining block gas are reported as dropped", func(t *testing.T) {
+		msg1 := types.NewMeteredMessage(sender, receiver, 0, types.ZeroAttoFIL, actor.BlockLimitTestMethodID, []byte{}, types.ZeroAttoFIL, types.BlockGasLimit*5/8)
+		msg2 := types.NewMeteredMessage(sender, receiver, 1, types.ZeroAttoFIL, actor.BlockLimitTestMethodID, []byte{}, types.ZeroAttoFIL, types.BlockGasLimit*7/8)
+		msg3 := types.NewMeteredMessage(sender, receiver, 2, types.ZeroAttoFIL, actor.BlockLimitTestMethodID, []byte{}, types.ZeroAttoFIL, types.BlockGasLimit*2)
+		msg4 := types.NewMeteredMessage(sender, receiver, 3, types.ZeroAttoFIL, actor.BlockLimitTestMethodID, []byte{}, types.ZeroAttoFIL, types.BlockGasLimit*7/8)
+
+		result, dropped, err := processor.ApplyMessagesAndPayRewardsReportingDropped(ctx, stateTree, th.VMStorage(), []*types.UnsignedMessage{msg1, msg2, msg3, msg4}, sender, types.NewBlockHeight(0), nil)
+		require.NoError(t, err)
+		require.Len(t, result, 4)
+
+		// msg3 can never fit in a block, so it is not carried over
+		require.Len(t, dropped, 2)
+		assert.Equal(t, msg2, dropped[0].Message)
+		assert.Equal(t, result[1].Failure, dropped[0].Reason)
+		assert.Equal(t, msg4, dropped[1].Message)
+		assert.Equal(t, result[3].Failure, dropped[1].Reason)
+	})
+}
+
+// countingGasTracker meters like the legacy tracker but records how it is used.
+type countingGasTracker struct {
+	*vm.LegacyGasTracker
+	resets  int
+	charged types.GasUnits
+}
+
+func (gt *countingGasTracker) ResetForNewMessage(msg *types.UnsignedMessage) {
+	gt.resets++
+	gt.LegacyGasTracker.ResetForNewMessage(msg)
+}
+
+func (gt *countingGasTracker) Charge(cost types.GasUnits) error {
+	gt.charged += cost
+	return gt.LegacyGasTracker.Charge(cost)
+}
+
+func TestCustomGasTrackerIsUsed(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+	fakeActorCodeCid := types.NewCidForTestGetter()()
+	builtinActors := builtin.NewBuilder().
+		AddAll(builtin.DefaultActors).
+		Add(fakeActorCodeCid, 0, &actor.FakeActor{}).
+		Build()
+	vms := th.VMStorage()
+	addresses, st := setupActorsForGasTest(t, vms, fakeActorCodeCid, 1000)
+
+	var trackers []*countingGasTracker
+	newTracker := func() vm.GasTracker {
+		gt := &countingGasTracker{LegacyGasTracker: vm.NewLegacyGasTracker()}
+		trackers = append(trackers, gt)
+		return gt
+	}
+	processor := NewConfiguredProcessor(NewDefaultMessageValidator(), &FakeBlockRewarder{}, builtinActors, WithGasTrackerFactory(newTracker))
+
+	// HasReturnValue charges 100 gas units
+	msgs := []*types.UnsignedMessage{
+		types.NewMeteredMessage(addresses[0], addresses[1], 0, types.ZeroAttoFIL, actor.HasReturnValueID, nil, types.NewGasPrice(1), types.NewGasUnits(200)),
+		types.NewMeteredMessage(addresses[0], addresses[1], 1, types.ZeroAttoFIL, actor.HasReturnValueID, nil, types.NewGasPrice(1), types.NewGasUnits(200)),
+	}
+	results, err := processor.ApplyMessagesAndPayRewards(ctx, st, vms, msgs, addresses[3], types.NewBlockHeight(0), nil)
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	for _, r := range results {
+		require.NoError(t, r.Failure)
+		assert.Equal(t, types.NewGasPrice(100), r.Receipt.GasAttoFIL)
+	}
+
+	require.Len(t, trackers, 1)
+	assert.Equal(t, 2, trackers[0].resets)
+	assert.Equal(t, types.NewGasUnits(200), trackers[0].charged)
+}
+
+func TestMaxNestedSends(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+	fakeActorCodeCid := types.NewCidForTestGetter()()
+	builtinActors := builtin.NewBuilder().
+		AddAll(builtin.DefaultActors).
+		Add(fakeActorCodeCid, 0, &actor.FakeActor{}).
+		Build()
+	processor := NewConfiguredProcessor(NewDefaultMessageValidator(), &FakeBlockRewarder{}, builtinActors, WithMaxNestedSends(3))
+
+	apply := func(times int64) *ApplicationResult {
+		vms := th.VMStorage()
+		addresses, st := setupActorsForGasTest(t, vms, fakeActorCodeCid, 1000)
+		params := actor.MustConvertParams(addresses[2], big.NewInt(times))
+		msg := types.NewMeteredMessage(addresses[0], addresses[1], 0, types.ZeroAttoFIL, actor.SendsRepeatedlyID, params, types.NewGasPrice(1), types.NewGasUnits(1000))
+		result, err := processor.ApplyMessage(ctx, st, vms, msg, addresses[3], types.NewBlockHeight(0), vm.NewLegacyGasTracker(), nil)
+		require.NoError(t, err)
+		return result
+	}
+
+	t.Run("sends within the limit succeed", func(t *testing.T) {
+		result := apply(3)
+		assert.NoError(t, result.ExecutionError)
+		assert.Equal(t, uint8(0), result.Receipt.ExitCode)
+	})
+
+	t.Run("sends beyond the limit revert the message", func(t *testing.T) {
+		result := apply(4)
+		assert.Error(t, result.ExecutionError)
+		assert.NotEqual(t, uint8(0), result.Receipt.ExitCode)
+	})
+}
+
+func TestActorCreationOrder(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+	fakeActorCodeCid := types.NewCidForTestGetter()()
+	builtinActors := builtin.NewBuilder().
+		AddAll(builtin.DefaultActors).
+		Add(fakeActorCodeCid, 0, &actor.FakeActor{}).
+		Build()
+	processor := NewConfiguredProcessor(NewDefaultMessageValidator(), &FakeBlockRewarder{}, builtinActors)
+	newAddress := address.NewForTestGetter()
+	first, second := newAddress(), newAddress()
+
+	// createBoth applies a message creating actors for a and b, in that order, to a fresh state
+	// and returns the ID addresses they were assigned.
+	createBoth := func(a, b address.Address) (address.Address, address.Address) {
+		vms := th.VMStorage()
+		addresses, st := setupActorsForGasTest(t, vms, fakeActorCodeCid, 1000)
+		params, err := abi.ToEncodedValues(a, b)
+		require.NoError(t, err)
+		msg := types.NewMeteredMessage(addresses[0], addresses[1], 0, types.NewAttoFILFromFIL(200), actor.SendsToBothID, params, types.NewGasPrice(1), types.NewGasUnits(10000))
+		result, err := processor.ApplyMessage(ctx, st, vms, msg, addresses[3], types.NewBlockHeight(0), vm.NewLegacyGasTracker(), nil)
+		require.NoError(t, err)
+		require.NoError(t, result.ExecutionError)
+
+		ids := make([]address.Address, 2)
+		for i, addr := range []address.Address{a, b} {
+			id, found, err := ResolveAddress(ctx, addr, state.NewCachedTree(st), vms, nil)
+			require.NoError(t, err)
+			require.True(t, found, "no actor created for %s", addr)
+			ids[i] = id
+		}
+		return ids[0], ids[1]
+	}
+
+	firstID, secondID := createBoth(first, second)
+	firstIDAgain, secondIDAgain := createBoth(first, second)
+	assert.Equal(t, firstID, firstIDAgain)
+	assert.Equal(t, secondID, secondIDAgain)
+
+	assert.Equal(t, leb128.ToUInt64(firstID.Payload())+1, leb128.ToUInt64(secondID.Payload()))
+
+	// IDs follow the order of reference, not the addresses.
+	secondIDSwapped, firstIDSwapped := createBoth(second, first)
+	assert.Equal(t, firstID, secondIDSwapped)
+	assert.Equal(t, secondID, firstIDSwapped)
+}
+
+func TestNodesRead(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+	fakeActorCodeCid := types.NewCidForTestGetter()()
+	builtinActors := builtin.NewBuilder().
+		AddAll(builtin.DefaultActors).
+		Add(fakeActorCodeCid, 0, &actor.FakeActor{}).
+		Build()
+	processor := NewConfiguredProcessor(NewDefaultMessageValidator(), &FakeBlockRewarder{}, builtinActors)
+
+	nodesRead := func(to func(addresses []address.Address) address.Address, method types.MethodID) int {
+		vms := th.VMStorage()
+		addresses, st := setupActorsForGasTest(t, vms, fakeActorCodeCid, 1000)
+		msg := types.NewMeteredMessage(addresses[0], to(addresses), 0, types.NewAttoFILFromFIL(1), method, nil, types.NewGasPrice(1), types.NewGasUnits(1000))
+		result, err := processor.ApplyMessage(ctx, st, vms, msg, addresses[3], types.NewBlockHeight(0), vm.NewLegacyGasTracker(), nil)
+		require.NoError(t, err)
+		return result.Extended.NodesRead
+	}
+
+	transfer := nodesRead(func(addresses []address.Address) address.Address { return addresses[3] }, types.SendMethodID)
+	// ReturnRevertError reads and updates the fake actor's state before failing. Its reads are
+	// counted though its changes are reverted.
+	stateful := nodesRead(func(addresses []address.Address) address.Address { return addresses[1] }, actor.ReturnRevertErrorID)
+	assert.Equal(t, 0, transfer)
+	assert.True(t, stateful > transfer, "state-changing call read %d nodes, transfer %d", stateful, transfer)
+}
+
+func TestCanonicalParams(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+	fakeActorCodeCid := types.NewCidForTestGetter()()
+	builtinActors := builtin.NewBuilder().
+		AddAll(builtin.DefaultActors).
+		Add(fakeActorCodeCid, 0, &actor.FakeActor{}).
+		Build()
+
+	apply := func(processor *DefaultProcessor) *ApplicationResult {
+		vms := th.VMStorage()
+		addresses, st := setupActorsForGasTest(t, vms, fakeActorCodeCid, 1000)
+		params, err := abi.ToEncodedValues([]byte("payload"))
+		require.NoError(t, err)
+		msg := types.NewMeteredMessage(addresses[0], addresses[1], 0, types.ZeroAttoFIL, actor.EmitsEventID, params, types.NewGasPrice(1), types.NewGasUnits(1000))
+		result, err := processor.ApplyMessage(ctx, st, vms, msg, addresses[3], types.NewBlockHeight(0), vm.NewLegacyGasTracker(), nil)
+		require.NoError(t, err)
+		require.NoError(t, result.ExecutionError)
+		return result
+	}
+
+	t.Run("captured params match a direct encoding", func(t *testing.T) {
+		result := apply(NewConfiguredProcessor(NewDefaultMessageValidator(), &FakeBlockRewarder{}, builtinActors, WithCanonicalParams()))
+
+		expected, err := encoding.Encode([][]byte{[]byte("payload")})
+		require.NoError(t, err)
+		assert.Equal(t, expected, result.Extended.CanonicalParams)
+	})
+
+	t.Run("params are not captured by default", func(t *testing.T) {
+		result := apply(NewConfiguredProcessor(NewDefaultMessageValidator(), &FakeBlockRewarder{}, builtinActors))
+
+		assert.Nil(t, result.Extended.CanonicalParams)
+	})
+}
+
+func TestGasForSubCall(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+	fakeActorCodeCid := types.NewCidForTestGetter()()
+	builtinActors := builtin.NewBuilder().
+		AddAll(builtin.DefaultActors).
+		Add(fakeActorCodeCid, 0, &actor.FakeActor{}).
+		Build()
+	processor := NewConfiguredProcessor(NewDefaultMessageValidator(), &FakeBlockRewarder{}, builtinActors)
+
+	t.Run("sub-calls of a message are traced with their gas", func(t *testing.T) {
+		vms := th.VMStorage()
+		addresses, st := setupActorsForGasTest(t, vms, fakeActorCodeCid, 1000)
+		params := actor.MustConvertParams(addresses[2], big.NewInt(2))
+		msg := types.NewMeteredMessage(addresses[0], addresses[1], 0, types.ZeroAttoFIL, actor.SendsRepeatedlyID, params, types.NewGasPrice(1), types.NewGasUnits(1000))
+		result, err := processor.ApplyMessage(ctx, st, vms, msg, addresses[3], types.NewBlockHeight(0), vm.NewLegacyGasTracker(), nil)
+		require.NoError(t, err)
+		require.NoError(t, result.ExecutionError)
+
+		trace := result.Extended.SubCalls
+		require.Len(t, trace, 2)
+		for _, call := range trace {
+			assert.Equal(t, addresses[1], call.From)
+			assert.Equal(t, addresses[2], call.To)
+			assert.Equal(t, actor.HasReturnValueID, call.Method)
+			assert.Equal(t, types.NewGasUnits(100), call.GasUsed)
+		}
+		gas, found := GasForSubCall(trace, addresses[2], actor.HasReturnValueID)
+		assert.True(t, found)
+		assert.Equal(t, types.NewGasUnits(200), gas)
+	})
+
+	t.Run("sub-calls are told apart by target and method", func(t *testing.T) {
+		newAddress := address.NewForTestGetter()
+		a, b := newAddress(), newAddress()
+		trace := []vm.SubCall{
+			{To: a, Method: actor.HasReturnValueID, GasUsed: types.NewGasUnits(100)},
+			{To: b, Method: actor.ChargeGasAndRevertErrorID, GasUsed: types.NewGasUnits(250)},
+		}
+
+		gas, found := GasForSubCall(trace, a, actor.HasReturnValueID)
+		assert.True(t, found)
+		assert.Equal(t, types.NewGasUnits(100), gas)
+
+		gas, found = GasForSubCall(trace, b, actor.ChargeGasAndRevertErrorID)
+		assert.True(t, found)
+		assert.Equal(t, types.NewGasUnits(250), gas)
+
+		_, found = GasForSubCall(trace, a, actor.ChargeGasAndRevertErrorID)
+		assert.False(t, found)
+	})
+}
+
+func TestMemoryBudget(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+	fakeActorCodeCid := types.NewCidForTestGetter()()
+	builtinActors := builtin.NewBuilder().
+		AddAll(builtin.DefaultActors).
+		Add(fakeActorCodeCid, 0, &actor.FakeActor{}).
+		Build()
+	processor := NewConfiguredProcessor(NewDefaultMessageValidator(), &FakeBlockRewarder{}, builtinActors, WithMemoryBudget(1024))
+
+	apply := func(size int64) *ApplicationResult {
+		vms := th.VMStorage()
+		addresses, st := setupActorsForGasTest(t, vms, fakeActorCodeCid, 1000)
+		params := actor.MustConvertParams(big.NewInt(size))
+		msg := types.NewMeteredMessage(addresses[0], addresses[1], 0, types.ZeroAttoFIL, actor.AllocatesID, params, types.NewGasPrice(1), types.NewGasUnits(100))
+		result, err := processor.ApplyMessage(ctx, st, vms, msg, addresses[3], types.NewBlockHeight(0), vm.NewLegacyGasTracker(), nil)
+		require.NoError(t, err)
+		return result
+	}
+
+	t.Run("allocation within the budget succeeds", func(t *testing.T) {
+		result := apply(100)
+		assert.NoError(t, result.ExecutionError)
+		assert.Equal(t, uint8(0), result.Receipt.ExitCode)
+	})
+
+	t.Run("allocation beyond the budget reverts the message", func(t *testing.T) {
+		result := apply(4096)
+		require.Error(t, result.ExecutionError)
+		assert.Contains(t, result.ExecutionError.Error(), "memory budget")
+	})
+}
+
+func TestMaxExecutionSteps(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+	fakeActorCodeCid := types.NewCidForTestGetter()()
+	builtinActors := builtin.NewBuilder().
+		AddAll(builtin.DefaultActors).
+		Add(fakeActorCodeCid, 0, &actor.FakeActor{}).
+		Build()
+	processor := NewConfiguredProcessor(NewDefaultMessageValidator(), &FakeBlockRewarder{}, builtinActors, WithMaxExecutionSteps(1000))
+
+	apply := func(iterations int64) (*ApplicationResult, error) {
+		vms := th.VMStorage()
+		addresses, st := setupActorsForGasTest(t, vms, fakeActorCodeCid, 1000)
+		params := actor.MustConvertParams(big.NewInt(iterations))
+		msg := types.NewMeteredMessage(addresses[0], addresses[1], 0, types.ZeroAttoFIL, actor.LoopsID, params, types.NewGasPrice(1), types.NewGasUnits(100))
+		return processor.ApplyMessage(ctx, st, vms, msg, addresses[3], types.NewBlockHeight(0), vm.NewLegacyGasTracker(), nil)
+	}
+
+	t.Run("short loop succeeds", func(t *testing.T) {
+		result, err := apply(100)
+		require.NoError(t, err)
+		assert.NoError(t, result.ExecutionError)
+		assert.Equal(t, uint8(0), result.Receipt.ExitCode)
+	})
+
+	t.Run("loop beyond the limit is a fault though gas remains", func(t *testing.T) {
+		_, err := apply(100000)
+		require.Error(t, err)
+		assert.True(t, errors.IsFault(err))
+		assert.Contains(t, err.Error(), "execution steps")
+	})
+}
+
+func TestAncestorsLookback(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+	fakeActorCodeCid := types.NewCidForTestGetter()()
+	builtinActors := builtin.NewBuilder().
+		AddAll(builtin.DefaultActors).
+		Add(fakeActorCodeCid, 0, &actor.FakeActor{}).
+		Build()
+
+	apply := func(processor *DefaultProcessor, ancestors []block.TipSet) error {
+		vms := th.VMStorage()
+		addresses, st := setupActorsForGasTest(t, vms, fakeActorCodeCid, 1000)
+		msg := types.NewMeteredMessage(addresses[0], addresses[1], 0, types.ZeroAttoFIL, actor.SamplesRandomnessID, nil, types.NewGasPrice(1), types.NewGasUnits(1000))
+		_, err := processor.ApplyMessage(ctx, st, vms, msg, addresses[3], types.NewBlockHeight(0), vm.NewLegacyGasTracker(), ancestors)
+		return err
+	}
+
+	t.Run("too few ancestors is a fault naming the method and shortfall", func(t *testing.T) {
+		processor := NewConfiguredProcessor(NewDefaultMessageValidator(), &FakeBlockRewarder{}, builtinActors)
+		err := apply(processor, make([]block.TipSet, 1))
+		require.Error(t, err)
+		assert.True(t, errors.IsFault(err))
+		assert.Contains(t, err.Error(), fmt.Sprintf("method %d", actor.SamplesRandomnessID))
+		assert.Contains(t, err.Error(), fmt.Sprintf("(%d short)", actor.SamplesRandomnessAncestors-1))
+	})
+
+	t.Run("ancestors beyond the maximum lookback are not available", func(t *testing.T) {
+		processor := NewConfiguredProcessor(NewDefaultMessageValidator(), &FakeBlockRewarder{}, builtinActors, WithMaxAncestorsLookback(actor.SamplesRandomnessAncestors-1))
+		err := apply(processor, make([]block.TipSet, actor.SamplesRandomnessAncestors))
+		require.Error(t, err)
+		assert.True(t, errors.IsFault(err))
+	})
+}
+
+func TestPreviewQueryMethodWithAncestors(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+	fakeActorCodeCid := types.NewCidForTestGetter()()
+	builtinActors := builtin.NewBuilder().
+		AddAll(builtin.DefaultActors).
+		Add(fakeActorCodeCid, 0, &actor.FakeActor{}).
+		Build()
+	processor := NewConfiguredProcessor(NewDefaultMessageValidator(), &FakeBlockRewarder{}, builtinActors)
+
+	// Tipsets descending from the current height, 5.
+	var ancestors []block.TipSet
+	for h := uint64(5); h > 0; h-- {
+		ancestors = append(ancestors, th.RequireNewTipSet(t, &block.Block{Height: types.Uint64(h), Ticket: block.Ticket{VRFProof: []byte{byte(h)}}}))
+	}
+
+	for _, count := range []int{0, 2, 4} {
+		vms := th.VMStorage()
+		addresses, st := setupActorsForGasTest(t, vms, fakeActorCodeCid, 1000)
+		gas, err := processor.PreviewQueryMethodWithAncestors(ctx, st, vms, addresses[1], actor.WalksAncestorsID, nil, addresses[0], types.NewBlockHeight(5), ancestors[:count])
+		require.NoError(t, err)
+		assert.Equal(t, types.GasUnits(count*actor.WalksAncestorsGasPerEpoch), gas, "estimate with %d ancestors", count)
+	}
+}
+
+func TestGasCostTable(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+	fakeActorCodeCid := types.NewCidForTestGetter()()
+	builtinActors := builtin.NewBuilder().
+		AddAll(builtin.DefaultActors).
+		Add(fakeActorCodeCid, 0, &actor.FakeActor{}).
+		Build()
+
+	var ancestors []block.TipSet
+	for h := uint64(actor.SamplesRandomnessAncestors); h > 0; h-- {
+		ancestors = append(ancestors, th.RequireNewTipSet(t, &block.Block{Height: types.Uint64(h - 1), Ticket: block.Ticket{VRFProof: []byte{byte(h)}}}))
+	}
+
+	// SamplesRandomness charges no gas itself, so all its gas is for sampling randomness.
+	gasCharged := func(opts ...ProcessorOption) types.AttoFIL {
+		processor := NewConfiguredProcessor(NewDefaultMessageValidator(), &FakeBlockRewarder{}, builtinActors, opts...)
+		vms := th.VMStorage()
+		addresses, st := setupActorsForGasTest(t, vms, fakeActorCodeCid, 1000)
+		msg := types.NewMeteredMessage(addresses[0], addresses[1], 0, types.ZeroAttoFIL, actor.SamplesRandomnessID, nil, types.NewGasPrice(1), types.NewGasUnits(1000))
+		result, err := processor.ApplyMessage(ctx, st, vms, msg, addresses[3], types.NewBlockHeight(actor.SamplesRandomnessAncestors), vm.NewLegacyGasTracker(), ancestors)
+		require.NoError(t, err)
+		require.NoError(t, result.ExecutionError)
+		return result.Receipt.GasAttoFIL
+	}
+
+	assert.True(t, gasCharged().IsZero())
+	costs := vm.GasCostTable{vm.GasOnRandomness: 500}
+	assert.True(t, gasCharged(WithGasCostTable(costs)).Equal(types.NewAttoFIL(big.NewInt(500))))
+
+	// An operation the message cannot afford reverts it rather than aborting the VM.
+	processor := NewConfiguredProcessor(NewDefaultMessageValidator(), &FakeBlockRewarder{}, builtinActors, WithGasCostTable(vm.GasCostTable{vm.GasOnRandomness: 5000}))
+	vms := th.VMStorage()
+	addresses, st := setupActorsForGasTest(t, vms, fakeActorCodeCid, 1000)
+	msg := types.NewMeteredMessage(addresses[0], addresses[1], 0, types.ZeroAttoFIL, actor.SamplesRandomnessID, nil, types.NewGasPrice(1), types.NewGasUnits(1000))
+	result, err := processor.ApplyMessage(ctx, st, vms, msg, addresses[3], types.NewBlockHeight(actor.SamplesRandomnessAncestors), vm.NewLegacyGasTracker(), ancestors)
+	require.NoError(t, err)
+	assert.EqualError(t, result.ExecutionError, "Insufficient gas: gas cost exceeds gas limit")
+	assert.NotEqual(t, uint8(0), result.Receipt.ExitCode)
+	assert.True(t, result.Receipt.GasAttoFIL.Equal(types.NewAttoFIL(big.NewInt(1000))))
+}
+
+func TestMethodCoverage(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+	fakeActorCodeCid := types.NewCidForTestGetter()()
+	builtinActors := builtin.NewBuilder().
+		AddAll(builtin.DefaultActors).
+		Add(fakeActorCodeCid, 0, &actor.FakeActor{}).
+		Build()
+	coverage := NewMethodCoverage()
+	processor := NewConfiguredProcessor(NewDefaultMessageValidator(), &FakeBlockRewarder{}, builtinActors, WithMethodCoverage(coverage))
+
+	vms := th.VMStorage()
+	addresses, st := setupActorsForGasTest(t, vms, fakeActorCodeCid, 1000)
+	params, err := abi.ToEncodedValues(addresses[2])
+	require.NoError(t, err)
+
+	// RunsAnotherMessage invokes HasReturnValue on another fake actor.
+	msgs := []*types.UnsignedMessage{
+		types.NewMeteredMessage(addresses[0], addresses[1], 0, types.ZeroAttoFIL, actor.HasReturnValueID, nil, types.NewGasPrice(1), types.NewGasUnits(300)),
+		types.NewMeteredMessage(addresses[0], addresses[1], 1, types.ZeroAttoFIL, actor.RunsAnotherMessageID, params, types.NewGasPrice(1), types.NewGasUnits(300)),
+	}
+	for _, msg := range msgs {
+		result, err := processor.ApplyMessage(ctx, st, vms, msg, addresses[3], types.NewBlockHeight(0), vm.NewLegacyGasTracker(), nil)
+		require.NoError(t, err)
+		require.NoError(t, result.ExecutionError)
+	}
+
+	assert.True(t, coverage.Covered(fakeActorCodeCid, actor.HasReturnValueID))
+	assert.True(t, coverage.Covered(fakeActorCodeCid, actor.RunsAnotherMessageID))
+	assert.False(t, coverage.Covered(fakeActorCodeCid, actor.SamplesRandomnessID))
+	assert.Equal(t, []MethodInvocations{
+		{MethodKey: MethodKey{Code: fakeActorCodeCid, Method: actor.HasReturnValueID}, Count: 2},
+		{MethodKey: MethodKey{Code: fakeActorCodeCid, Method: actor.RunsAnotherMessageID}, Count: 1},
+	}, coverage.Report())
+}
+
+func TestTestRand(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+	fakeActorCodeCid := types.NewCidForTestGetter()()
+	builtinActors := builtin.NewBuilder().
+		AddAll(builtin.DefaultActors).
+		Add(fakeActorCodeCid, 0, &actor.FakeActor{}).
+		Build()
+
+	draw := func(opts ...ProcessorOption) *ApplicationResult {
+		processor := NewConfiguredProcessor(NewDefaultMessageValidator(), &FakeBlockRewarder{}, builtinActors, opts...)
+		vms := th.VMStorage()
+		addresses, st := setupActorsForGasTest(t, vms, fakeActorCodeCid, 1000)
+		msg := types.NewMeteredMessage(addresses[0], addresses[1], 0, types.ZeroAttoFIL, actor.DrawsTestRandID, nil, types.NewGasPrice(1), types.NewGasUnits(300))
+		result, err := processor.ApplyMessage(ctx, st, vms, msg, addresses[3], types.NewBlockHeight(0), vm.NewLegacyGasTracker(), nil)
+		require.NoError(t, err)
+		return result
+	}
+
+	first := draw(WithTestRand(DefaultTestRandSeed))
+	require.NoError(t, first.ExecutionError)
+	require.Len(t, first.Receipt.Return, 1)
+	assert.Equal(t, first.Receipt.Return, draw(WithTestRand(DefaultTestRandSeed)).Receipt.Return)
+	assert.NotEqual(t, first.Receipt.Return, draw(WithTestRand(DefaultTestRandSeed+1)).Receipt.Return)
+
+	// Without a seed actors have no generator to draw from.
+	assert.Error(t, draw().ExecutionError)
+}
+
+func TestApplyMessageReportsEvents(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+	fakeActorCodeCid := types.NewCidForTestGetter()()
+	builtinActors := builtin.NewBuilder().
+		AddAll(builtin.DefaultActors).
+		Add(fakeActorCodeCid, 0, &actor.FakeActor{}).
+		Build()
+	processor := NewConfiguredProcessor(NewDefaultMessageValidator(), &FakeBlockRewarder{}, builtinActors)
+
+	vms := th.VMStorage()
+	addresses, st := setupActorsForGasTest(t, vms, fakeActorCodeCid, 1000)
+	params, err := abi.ToEncodedValues([]byte("payload"))
+	require.NoError(t, err)
+
+	msg := types.NewMeteredMessage(addresses[0], addresses[1], 0, types.ZeroAttoFIL, actor.EmitsEventID, params, types.NewGasPrice(1), types.NewGasUnits(300))
+	result, err := processor.ApplyMessage(ctx, st, vms, msg, addresses[3], types.NewBlockHeight(0), vm.NewLegacyGasTracker(), nil)
+	require.NoError(t, err)
+	require.NoError(t, result.ExecutionError)
+
+	require.Len(t, result.Extended.Events, 1)
+	event := result.Extended.Events[0]
+	assert.Equal(t, addresses[1], event.Emitter)
+	assert.Equal(t, actor.EmittedEventTopics, event.Topics)
+	assert.Equal(t, []byte("payload"), event.Data)
+}
+
+func TestStateInvariant(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+	fakeActorCodeCid := types.NewCidForTestGetter()()
+	builtinActors := builtin.NewBuilder().
+		AddAll(builtin.DefaultActors).
+		Add(fakeActorCodeCid, 0, &actor.FakeActor{}).
+		Build()
+	nonNegativeBalances := func(ctx context.Context, st *state.CachedTree, msg *types.UnsignedMessage) error {
+		return st.ForEachCachedActor(func(addr address.Address, act *actor.Actor) error {
+			if act.Balance.IsNegative() {
+				return fmt.Errorf("actor %s has negative balance %s", addr, act.Balance)
+			}
+			return nil
+		})
+	}
+	processor := NewConfiguredProcessor(NewDefaultMessageValidator(), &FakeBlockRewarder{}, builtinActors, WithStateInvariant(nonNegativeBalances))
+
+	vms := th.VMStorage()
+	addresses, st := setupActorsForGasTest(t, vms, fakeActorCodeCid, 1000)
+
+	msg := types.NewMeteredMessage(addresses[0], addresses[1], 0, types.ZeroAttoFIL, actor.HasReturnValueID, nil, types.NewGasPrice(1), types.NewGasUnits(300))
+	_, err := processor.ApplyMessage(ctx, st, vms, msg, addresses[3], types.NewBlockHeight(0), vm.NewLegacyGasTracker(), nil)
+	require.NoError(t, err)
+
+	msg = types.NewMeteredMessage(addresses[0], addresses[1], 1, types.ZeroAttoFIL, actor.CorruptsBalanceID, nil, types.NewGasPrice(1), types.NewGasUnits(300))
+	_, err = processor.ApplyMessage(ctx, st, vms, msg, addresses[3], types.NewBlockHeight(0), vm.NewLegacyGasTracker(), nil)
+	require.Error(t, err)
+	assert.True(t, errors.IsFault(err))
+	assert.Contains(t, err.Error(), "negative balance")
+}
+
+func TestPreApplyHook(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+	value := types.NewAttoFILFromFIL(10)
+	gasPrice, gasLimit := types.NewGasPrice(1), types.NewGasUnits(300)
+	// the most the message can cost its sender
+	maxCost := value.Add(gasPrice.MulBigInt(big.NewInt(int64(gasLimit))))
+
+	apply := func(senderBalance types.AttoFIL) error {
+		vms := th.VMStorage()
+		addresses, st := setupActorsForGasTest(t, vms, types.NewCidForTestGetter()(), 1000)
+		setBalance := func(ctx context.Context, st *state.CachedTree, msg *types.UnsignedMessage) error {
+			fromAddr, _, err := ResolveAddress(ctx, msg.From, st, vms, nil)
+			if err != nil {
+				return err
+			}
+			from, err := st.GetActor(ctx, fromAddr)
+			if err != nil {
+				return err
+			}
+			from.Balance = senderBalance
+			return nil
+		}
+		processor := NewDefaultProcessor(WithPreApplyHook(setBalance))
+
+		msg := types.NewMeteredMessage(addresses[0], addresses[3], 0, value, types.SendMethodID, nil, gasPrice, gasLimit)
+		_, err := processor.ApplyMessage(ctx, st, vms, msg, addresses[3], types.NewBlockHeight(0), vm.NewLegacyGasTracker(), nil)
+		return err
+	}
+
+	assert.NoError(t, apply(maxCost))
+	err := apply(maxCost.Sub(types.NewAttoFIL(big.NewInt(1))))
+	require.Error(t, err)
+	assert.True(t, errors.IsApplyErrorPermanent(err))
+}
+
+func TestValueTransferDisabled(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+	fakeActorCodeCid := types.NewCidForTestGetter()()
+	builtinActors := builtin.NewBuilder().
+		AddAll(builtin.DefaultActors).
+		Add(fakeActorCodeCid, 0, &actor.FakeActor{}).
+		Build()
+	processor := NewConfiguredProcessor(NewDefaultMessageValidator(), &FakeBlockRewarder{}, builtinActors, WithValueTransferDisabled())
+
+	vms := th.VMStorage()
+	addresses, st := setupActorsForGasTest(t, vms, fakeActorCodeCid, 1000)
+
+	// The value exceeds the sender's balance, which is fine since it is not moved.
+	msg := types.NewMeteredMessage(addresses[0], addresses[1], 0, types.NewAttoFILFromFIL(5000), actor.HasReturnValueID, nil, types.NewGasPrice(1), types.NewGasUnits(300))
+	result, err := processor.ApplyMessage(ctx, st, vms, msg, addresses[3], types.NewBlockHeight(0), vm.NewLegacyGasTracker(), nil)
+	require.NoError(t, err)
+	require.NoError(t, result.ExecutionError)
+
+	// HasReturnValue charges gas, so a gas charge shows the method ran. The fake rewarder
+	// does not collect gas, so balances are unchanged.
+	assert.True(t, result.Receipt.GasAttoFIL.Equal(types.NewAttoFIL(big.NewInt(100))))
+	assert.True(t, result.Extended.FromBalanceAfter.Equal(types.NewAttoFILFromFIL(1000)))
+	assert.True(t, result.Extended.ToBalanceAfter.Equal(types.NewAttoFILFromFIL(100)))
+}
+
+func TestBalanceOverflowIsFault(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+	fakeActorCodeCid := types.NewCidForTestGetter()()
+	builtinActors := builtin.NewBuilder().
+		AddAll(builtin.DefaultActors).
+		Add(fakeActorCodeCid, 0, &actor.FakeActor{}).
+		Build()
+	processor := NewConfiguredProcessor(NewDefaultMessageValidator(), &FakeBlockRewarder{}, builtinActors)
+
+	// setup returns a state in which crediting more than 1 FIL to addresses[2] overflows it.
+	setup := func() ([]address.Address, state.Tree, vm.StorageMap) {
+		vms := th.VMStorage()
+		addresses, st := setupActorsForGasTest(t, vms, fakeActorCodeCid, 1000)
+		nearMax, err := st.GetActor(ctx, addresses[2])
+		require.NoError(t, err)
+		nearMax.Balance = types.MaxAttoFIL.Sub(types.NewAttoFILFromFIL(1))
+		require.NoError(t, st.SetActor(ctx, addresses[2], nearMax))
+		return addresses, st, vms
+	}
+
+	t.Run("crediting the recipient", func(t *testing.T) {
+		addresses, st, vms := setup()
+		msg := types.NewMeteredMessage(addresses[0], addresses[2], 0, types.NewAttoFILFromFIL(2), types.SendMethodID, nil, types.NewGasPrice(1), types.NewGasUnits(300))
+		_, err := processor.ApplyMessage(ctx, st, vms, msg, addresses[3], types.NewBlockHeight(0), vm.NewLegacyGasTracker(), nil)
+		assert.True(t, errors.IsFault(err), "unexpected error %v", err)
+	})
+
+	t.Run("crediting an actor sent to by the recipient", func(t *testing.T) {
+		addresses, st, vms := setup()
+		params := actor.MustConvertParams(addresses[2], addresses[3])
+		msg := types.NewMeteredMessage(addresses[0], addresses[1], 0, types.ZeroAttoFIL, actor.SendsToBothID, params, types.NewGasPrice(1), types.NewGasUnits(300))
+		_, err := processor.ApplyMessage(ctx, st, vms, msg, addresses[3], types.NewBlockHeight(0), vm.NewLegacyGasTracker(), nil)
+		assert.True(t, errors.IsFault(err), "unexpected error %v", err)
+	})
+}
+
+func TestStateWriteObserver(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+	vms := th.VMStorage()
+	st := state.NewTree(hamt.NewCborStore())
+
+	newAddress := address.NewForTestGetter()
+	_, fromID := th.RequireInitAccountActor(ctx, t, st, vms, newAddress(), types.NewAttoFILFromFIL(1000))
+	to := newAddress()
+	_, toID := th.RequireInitAccountActor(ctx, t, st, vms, to, types.ZeroAttoFIL)
+
+	observed := make(map[address.Address]cid.Cid)
+	observer := func(addr address.Address, head cid.Cid) {
+		observed[addr] = head
+	}
+	processor := NewConfiguredProcessor(NewDefaultMessageValidator(), &FakeBlockRewarder{}, builtin.DefaultActors, WithStateWriteObserver(observer))
+
+	msg := types.NewMeteredMessage(fromID, to, 0, types.NewAttoFILFromFIL(10), types.SendMethodID, nil, types.NewGasPrice(1), types.NewGasUnits(300))
+	result, err := processor.ApplyMessage(ctx, st, vms, msg, address.TestAddress, types.NewBlockHeight(0), vm.NewLegacyGasTracker(), nil)
+	require.NoError(t, err)
+	require.NoError(t, result.ExecutionError)
+
+	for _, addr := range []address.Address{fromID, toID} {
+		act, err := st.GetActor(ctx, addr)
+		require.NoError(t, err)
+		head, ok := observed[addr]
+		require.True(t, ok, "no write observed for %s", addr)
+		assert.Equal(t, act.Head, head)
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+	vms := th.VMStorage()
+	st := state.NewTree(hamt.NewCborStore())
+
+	newAddress := address.NewForTestGetter()
+	_, fromID := th.RequireInitAccountActor(ctx, t, st, vms, newAddress(), types.NewAttoFILFromFIL(1000))
+	to := newAddress()
+	_, toID := th.RequireInitAccountActor(ctx, t, st, vms, to, types.ZeroAttoFIL)
+	processor := NewConfiguredProcessor(NewDefaultMessageValidator(), &FakeBlockRewarder{}, builtin.DefaultActors, WithAccessLog())
+
+	msg := types.NewMeteredMessage(fromID, to, 0, types.NewAttoFILFromFIL(10), types.SendMethodID, nil, types.NewGasPrice(1), types.NewGasUnits(300))
+	result, err := processor.ApplyMessage(ctx, st, vms, msg, address.TestAddress, types.NewBlockHeight(0), vm.NewLegacyGasTracker(), nil)
+	require.NoError(t, err)
+	require.NoError(t, result.ExecutionError)
+
+	// A transfer reads the actors it involves while executing and writes them when committed.
+	accesses := result.Extended.Accesses
+	firstWrite := len(accesses)
+	for i, access := range accesses {
+		if access.Kind == state.AccessWrite {
+			firstWrite = i
+			break
+		}
+	}
+	for i, access := range accesses[firstWrite:] {
+		assert.Equal(t, state.AccessWrite, access.Kind, "access %d is a read after a write", firstWrite+i)
+	}
+
+	for _, addr := range []address.Address{fromID, toID} {
+		read, written := -1, -1
+		for i, access := range accesses {
+			if access.Addr != addr {
+				continue
+			}
+			if access.Kind == state.AccessRead && read < 0 {
+				read = i
+			} else if access.Kind == state.AccessWrite {
+				written = i
+			}
+		}
+		require.True(t, read >= 0, "no read of %s", addr)
+		require.True(t, written > read, "no write of %s after its read", addr)
+
+		act, err := st.GetActor(ctx, addr)
+		require.NoError(t, err)
+		assert.Equal(t, act.Head, accesses[written].Cid)
+	}
+}
+
+func TestApplyMessageReportsCreatedActor(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+	vms := th.VMStorage()
+	st := state.NewTree(hamt.NewCborStore())
+	processor := NewConfiguredProcessor(NewDefaultMessageValidator(), &FakeBlockRewarder{}, builtin.DefaultActors)
+
+	newAddress := address.NewForTestGetter()
+	_, from := th.RequireInitAccountActor(ctx, t, st, vms, newAddress(), types.NewAttoFILFromFIL(1000))
+	to := newAddress()
+
+	send := func(nonce uint64) *ExtendedReceipt {
+		msg := types.NewMeteredMessage(from, to, nonce, types.NewAttoFILFromFIL(10), types.SendMethodID, nil, types.NewGasPrice(1), types.NewGasUnits(300))
+		result, err := processor.ApplyMessage(ctx, st, vms, msg, address.TestAddress, types.NewBlockHeight(0), vm.NewLegacyGasTracker(), nil)
+		require.NoError(t, err)
+		require.NoError(t, result.ExecutionError)
+		return result.Extended
+	}
+
+	t.Run("send to a fresh address creates its actor", func(t *testing.T) {
+		ext := send(0)
+		assert.True(t, ext.ActorCreated)
+		require.Equal(t, address.ID, ext.CreatedActorAddr.Protocol())
+
+		created, err := st.GetActor(ctx, ext.CreatedActorAddr)
+		require.NoError(t, err)
+		assert.True(t, created.Balance.Equal(types.NewAttoFILFromFIL(10)))
+	})
+
+	t.Run("send to an existing address creates nothing", func(t *testing.T) {
+		ext := send(1)
+		assert.False(t, ext.ActorCreated)
+		assert.Equal(t, address.Undef, ext.CreatedActorAddr)
+	})
+}
+
+func TestActorCreationGas(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+	costs := vm.GasCostTable{vm.GasOnCreateActor: 40}
+
+	sendToFreshAddress := func(gasLimit types.GasUnits, opts ...ProcessorOption) *ApplicationResult {
+		vms := th.VMStorage()
+		st := state.NewTree(hamt.NewCborStore())
+		processor := NewConfiguredProcessor(NewDefaultMessageValidator(), &FakeBlockRewarder{}, builtin.DefaultActors, append(opts, WithGasCostTable(costs))...)
+
+		newAddress := address.NewForTestGetter()
+		_, from := th.RequireInitAccountActor(ctx, t, st, vms, newAddress(), types.NewAttoFILFromFIL(1000))
+		msg := types.NewMeteredMessage(from, newAddress(), 0, types.NewAttoFILFromFIL(10), types.SendMethodID, nil, types.NewGasPrice(1), gasLimit)
+		result, err := processor.ApplyMessage(ctx, st, vms, msg, address.TestAddress, types.NewBlockHeight(0), vm.NewLegacyGasTracker(), nil)
+		require.NoError(t, err)
+		return result
+	}
+
+	// A plain send uses no gas itself, so all the gas charged is for creating the recipient.
+	charged := sendToFreshAddress(types.NewGasUnits(300))
+	require.NoError(t, charged.ExecutionError)
+	assert.True(t, charged.Extended.ActorCreated)
+	assert.True(t, charged.Receipt.GasAttoFIL.Equal(types.NewGasPrice(40)), "gas paid is %s", charged.Receipt.GasAttoFIL)
+
+	free := sendToFreshAddress(types.NewGasUnits(300), WithFreeActorCreation())
+	require.NoError(t, free.ExecutionError)
+	assert.True(t, free.Extended.ActorCreated)
+	assert.True(t, free.Receipt.GasAttoFIL.IsZero(), "gas paid is %s", free.Receipt.GasAttoFIL)
+
+	// A message that cannot pay for the creation is applied but creates nothing.
+	outOfGas := sendToFreshAddress(types.NewGasUnits(30))
+	require.Error(t, outOfGas.ExecutionError)
+	assert.False(t, outOfGas.Extended.ActorCreated)
+	assert.True(t, outOfGas.Receipt.GasAttoFIL.Equal(types.NewGasPrice(30)), "gas paid is %s", outOfGas.Receipt.GasAttoFIL)
+	assert.True(t, outOfGas.ValueTransferred.IsZero())
+}
+
+func TestResultsIncludeKeyAddresses(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+	vms := th.VMStorage()
+	st := state.NewTree(hamt.NewCborStore())
+	processor := NewConfiguredProcessor(NewDefaultMessageValidator(), &FakeBlockRewarder{}, builtin.DefaultActors)
+
+	newAddress := address.NewForTestGetter()
+	from, to := newAddress(), newAddress()
+	_, fromID := th.RequireInitAccountActor(ctx, t, st, vms, from, types.NewAttoFILFromFIL(1000))
+	_, toID := th.RequireInitAccountActor(ctx, t, st, vms, to, types.ZeroAttoFIL)
+
+	t.Run("apply result", func(t *testing.T) {
+		msg := types.NewMeteredMessage(from, to, 0, types.NewAttoFILFromFIL(10), types.SendMethodID, nil, types.NewGasPrice(1), types.NewGasUnits(300))
+		result, err := processor.ApplyMessage(ctx, st, vms, msg, address.TestAddress, types.NewBlockHeight(0), vm.NewLegacyGasTracker(), nil)
+		require.NoError(t, err)
+
+		assert.Equal(t, fromID, result.Extended.FromAddr)
+		assert.Equal(t, from, result.Extended.FromKeyAddr)
+		assert.Equal(t, toID, result.Extended.ToAddr)
+		assert.Equal(t, to, result.Extended.ToKeyAddr)
+	})
+
+	t.Run("apply result for ID addresses", func(t *testing.T) {
+		msg := types.NewMeteredMessage(fromID, toID, 1, types.NewAttoFILFromFIL(10), types.SendMethodID, nil, types.NewGasPrice(1), types.NewGasUnits(300))
+		result, err := processor.ApplyMessage(ctx, st, vms, msg, address.TestAddress, types.NewBlockHeight(0), vm.NewLegacyGasTracker(), nil)
+		require.NoError(t, err)
+
+		assert.Equal(t, fromID, result.Extended.FromAddr)
+		assert.Equal(t, address.Undef, result.Extended.FromKeyAddr)
+		assert.Equal(t, toID, result.Extended.ToAddr)
+		assert.Equal(t, address.Undef, result.Extended.ToKeyAddr)
+	})
+
+	t.Run("query result", func(t *testing.T) {
+		result, err := processor.CallQueryMethodExtended(ctx, st, vms, to, types.SendMethodID, nil, from, types.NewBlockHeight(0))
+		require.NoError(t, err)
+
+		assert.Equal(t, toID, result.ToAddr)
+		assert.Equal(t, to, result.ToKeyAddr)
+	})
+}
+
+func TestActorNotFoundIsUnified(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+	vms := th.VMStorage()
+	st := state.NewTree(hamt.NewCborStore())
+
+	newAddress := address.NewForTestGetter()
+	from := newAddress()
+	th.RequireInitAccountActor(ctx, t, st, vms, from, types.NewAttoFILFromFIL(1000))
+	missing := newAddress()
+
+	requireNotFound := func(t *testing.T, err error, addr address.Address) {
+		require.Error(t, err)
+		notFound, ok := AsActorNotFound(err)
+		require.True(t, ok, "unexpected error %s", err)
+		assert.Equal(t, addr, notFound.Addr)
+	}
+
+	t.Run("missing sender", func(t *testing.T) {
+		msg := types.NewMeteredMessage(missing, from, 0, types.ZeroAttoFIL, types.SendMethodID, nil, types.NewGasPrice(1), types.NewGasUnits(300))
+		_, err := NewDefaultProcessor().ApplyMessage(ctx, st, vms, msg, address.TestAddress, types.NewBlockHeight(0), vm.NewLegacyGasTracker(), nil)
+		requireNotFound(t, err, missing)
+		assert.True(t, errors.IsApplyErrorTemporary(err))
+	})
+
+	t.Run("missing recipient in strict mode", func(t *testing.T) {
+		processor := NewConfiguredProcessor(NewDefaultMessageValidator(), &FakeBlockRewarder{}, builtin.DefaultActors, WithStrictRecipients())
+		msg := types.NewMeteredMessage(from, missing, 0, types.NewAttoFILFromFIL(10), types.SendMethodID, nil, types.NewGasPrice(1), types.NewGasUnits(300))
+		_, err := processor.ApplyMessage(ctx, st, vms, msg, address.TestAddress, types.NewBlockHeight(0), vm.NewLegacyGasTracker(), nil)
+		requireNotFound(t, err, missing)
+		assert.True(t, errors.IsApplyErrorTemporary(err))
+
+		// No actor was created for the recipient.
+		_, found, err := ResolveAddress(ctx, missing, state.NewCachedTree(st), vms, nil)
+		require.NoError(t, err)
+		assert.False(t, found)
+	})
+
+	t.Run("query to a missing actor", func(t *testing.T) {
+		_, err := NewDefaultProcessor().CallQueryMethodExtended(ctx, st, vms, missing, types.SendMethodID, nil, from, types.NewBlockHeight(0))
+		requireNotFound(t, err, missing)
+	})
 }
 
 func setupActorsForGasTest(t *testing.T, vms vm.StorageMap, fakeActorCodeCid cid.Cid, senderBalance uint64) ([]address.Address, state.Tree) {
@@ -0,0 +1,153 @@
+package consensus
+
+import (
+	"context"
+	"time"
+
+	"github.com/filecoin-project/go-filecoin/internal/pkg/types"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/address"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/state"
+)
+
+// ExtendedReceipt carries details about the application of a message that are not
+// part of the on-chain receipt. It is intended for tooling such as wallets and indexers
+// and has no bearing on consensus.
+type ExtendedReceipt struct {
+	// FromBalanceBefore is the sender's balance before the message was applied.
+	FromBalanceBefore types.AttoFIL
+	// FromBalanceAfter is the sender's balance after the message was applied and gas was paid.
+	FromBalanceAfter types.AttoFIL
+	// ToBalanceBefore is the recipient's balance before the message was applied.
+	ToBalanceBefore types.AttoFIL
+	// ToBalanceAfter is the recipient's balance after the message was applied and gas was paid.
+	ToBalanceAfter types.AttoFIL
+	// Duration is the wall-clock time spent executing the message in the VM.
+	Duration time.Duration
+	// GasUsed is the number of gas units consumed executing the message.
+	GasUsed types.GasUnits
+	// MinerTip is the portion of the gas charge paid to the miner. No base fee is burnt when
+	// applying messages, so this is the whole gas charge.
+	MinerTip types.AttoFIL
+	// GasBreakdown splits GasUsed by what the gas was charged for.
+	GasBreakdown GasBreakdown
+	// NodesRead is the number of distinct objects read from actor storage executing the
+	// message, at any depth, for finding messages that make excessive state reads.
+	NodesRead int
+	// CanonicalParams are the message's params as the VM re-encoded them from the values its
+	// method decoded, if the processor captures them. They are nil if the method was never
+	// dispatched.
+	CanonicalParams []byte
+	// GasRefund is the part of the gas reserved by the message's gas limit that was not
+	// charged: (limit - used) * price.
+	GasRefund types.AttoFIL
+	// ActorCreated is set if an account actor was created for the recipient because it had
+	// none. Actors created while the message executes, e.g. by the recipient, are not included.
+	ActorCreated bool
+	// CreatedActorAddr is the ID address of the actor created for the recipient, if any.
+	CreatedActorAddr address.Address
+
+	// FromAddr is the ID address the sender resolved to.
+	FromAddr address.Address
+	// FromKeyAddr is the key address the message named the sender by, or undefined if it
+	// named the sender by its ID address.
+	FromKeyAddr address.Address
+	// ToAddr is the ID address the recipient resolved to, if resolution got that far.
+	ToAddr address.Address
+	// ToKeyAddr is the key address the message named the recipient by, or undefined if it
+	// named the recipient by its ID address.
+	ToKeyAddr address.Address
+
+	// Accesses are the state reads and writes made by the message, in order, if the processor
+	// records them. Those of a reverted message are recorded even though its writes are not
+	// committed. Gas payment and the nonce increment are not included.
+	Accesses []state.Access
+
+	// Events are the events emitted by actors executing the message, in order. They are only
+	// set if the message succeeded.
+	Events []vm.Event
+
+	// SubCalls trace the sends actors made executing the message, in the order they were made,
+	// with the gas each used. They are set whether or not the message succeeded.
+	SubCalls []vm.SubCall
+
+	// TouchedActors hold the state of each actor read or written applying the message, in the
+	// order they were first touched, before and after it was applied, if the processor captures
+	// them.
+	TouchedActors []TouchedActor
+}
+
+// GasForSubCall returns the gas used by the sub-calls in trace to method on the actor with ID
+// address to, summed if there were several, and whether there were any.
+func GasForSubCall(trace []vm.SubCall, to address.Address, method types.MethodID) (types.GasUnits, bool) {
+	total := types.NewGasUnits(0)
+	found := false
+	for _, call := range trace {
+		if call.To == to && call.Method == method {
+			total += call.GasUsed
+			found = true
+		}
+	}
+	return total, found
+}
+
+// GasBreakdown splits the gas used by a message into its components, which sum to the total.
+type GasBreakdown struct {
+	// Inclusion is the gas charged for including the message in a block. Messages are not
+	// charged for inclusion yet, so it is always zero.
+	Inclusion types.GasUnits
+	// Compute is the gas charged for executing actor code, including sends and any gas
+	// actors charge themselves; everything not attributed to another component.
+	Compute types.GasUnits
+	// StorageRead is the gas charged for reading objects from actor storage.
+	StorageRead types.GasUnits
+	// StorageWrite is the gas charged for storing objects and committing actor storage heads.
+	StorageWrite types.GasUnits
+}
+
+// newGasBreakdown attributes used gas to storage reads and writes, and the rest to compute.
+// A tracker that fixes the gas used can report less than was charged for storage, in which
+// case everything is attributed to compute.
+func newGasBreakdown(used, read, write types.GasUnits) GasBreakdown {
+	if read+write > used {
+		return GasBreakdown{Compute: used}
+	}
+	return GasBreakdown{Compute: used - read - write, StorageRead: read, StorageWrite: write}
+}
+
+// keyAddress returns addr if it is a key address rather than an ID address.
+func keyAddress(addr address.Address) address.Address {
+	if addr.Protocol() == address.ID {
+		return address.Undef
+	}
+	return addr
+}
+
+// balanceOrZero returns the balance of the actor at the given id address, or zero if there is no such actor.
+func balanceOrZero(ctx context.Context, st state.Tree, idAddr address.Address) (types.AttoFIL, error) {
+	if idAddr.Empty() {
+		return types.ZeroAttoFIL, nil
+	}
+	act, err := st.GetActor(ctx, idAddr)
+	if state.IsActorNotFoundError(err) {
+		return types.ZeroAttoFIL, nil
+	} else if err != nil {
+		return types.ZeroAttoFIL, err
+	}
+	return act.Balance, nil
+}
+
+// SlowestMessage returns the index of the successfully applied message that took longest to
+// execute, or -1 if no message was applied.
+func SlowestMessage(results []*ApplyMessageResult) int {
+	slowest := -1
+	for i, r := range results {
+		if r.Failure != nil || r.Extended == nil {
+			continue
+		}
+		if slowest < 0 || r.Extended.Duration > results[slowest].Extended.Duration {
+			slowest = i
+		}
+	}
+	return slowest
+}
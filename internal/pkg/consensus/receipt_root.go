@@ -0,0 +1,52 @@
+package consensus
+
+import (
+	"github.com/filecoin-project/go-amt-ipld"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	"github.com/pkg/errors"
+	typegen "github.com/whyrusleeping/cbor-gen"
+
+	"github.com/filecoin-project/go-filecoin/internal/pkg/types"
+)
+
+// VerifyReceiptRoot computes the receipt root of the applied messages of results, as the chain
+// stores it for a tipset, and reports whether it equals declared, typically the
+// MessageReceipts of a child block header. The computed root is returned either way so a
+// mismatch can be reported.
+func VerifyReceiptRoot(results []*ApplyMessageResult, declared cid.Cid) (bool, cid.Cid, error) {
+	root, err := receiptRoot(results)
+	if err != nil {
+		return false, cid.Undef, err
+	}
+	return root.Equals(declared), root, nil
+}
+
+// receiptRoot builds the AMT of receipt cids in a scratch blockstore and returns its root.
+// The receipts themselves need not be stored for the root to be computed. Results that failed
+// to apply have no receipt on chain and are skipped.
+func receiptRoot(results []*ApplyMessageResult) (cid.Cid, error) {
+	cids := []typegen.CBORMarshaler{}
+	for i, r := range results {
+		if r.Failure != nil {
+			continue
+		}
+		data, err := types.EncodeReceipt(r.Receipt)
+		if err != nil {
+			return cid.Undef, errors.Wrapf(err, "could not encode receipt %d", i)
+		}
+		c, err := cid.NewPrefixV1(cid.DagCBOR, types.DefaultHashFunction).Sum(data)
+		if err != nil {
+			return cid.Undef, errors.Wrapf(err, "could not compute cid of receipt %d", i)
+		}
+		receiptCid := typegen.CborCid(c)
+		cids = append(cids, &receiptCid)
+	}
+	bs := blockstore.NewBlockstore(datastore.NewMapDatastore())
+	root, err := amt.FromArray(amt.WrapBlockstore(bs), cids)
+	if err != nil {
+		return cid.Undef, errors.Wrap(err, "could not build receipt AMT")
+	}
+	return root, nil
+}
@@ -0,0 +1,57 @@
+package consensus_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ipfs/go-datastore"
+	bstore "github.com/ipfs/go-ipfs-blockstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-filecoin/internal/pkg/chain"
+	. "github.com/filecoin-project/go-filecoin/internal/pkg/consensus"
+	tf "github.com/filecoin-project/go-filecoin/internal/pkg/testhelpers/testflags"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/types"
+)
+
+func TestVerifyReceiptRoot(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+	receipts := []*types.MessageReceipt{
+		{ExitCode: 0, Return: [][]byte{{1, 2, 3}}, GasAttoFIL: types.NewAttoFILFromFIL(1)},
+		{ExitCode: 42, GasAttoFIL: types.NewAttoFILFromFIL(2)},
+	}
+	results := []*ApplyMessageResult{
+		{ApplicationResult: ApplicationResult{Receipt: receipts[0]}},
+		{Failure: errors.New("message was not applied")},
+		{ApplicationResult: ApplicationResult{Receipt: receipts[1]}},
+	}
+
+	// The root the chain stores for the applied messages' receipts.
+	stored, err := chain.NewMessageStore(bstore.NewBlockstore(datastore.NewMapDatastore())).StoreReceipts(ctx, receipts)
+	require.NoError(t, err)
+
+	t.Run("matching declared root", func(t *testing.T) {
+		ok, root, err := VerifyReceiptRoot(results, stored)
+		require.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, stored, root)
+	})
+
+	t.Run("mismatching declared root", func(t *testing.T) {
+		ok, root, err := VerifyReceiptRoot(results, types.EmptyReceiptsCID)
+		require.NoError(t, err)
+		assert.False(t, ok)
+		assert.Equal(t, stored, root)
+	})
+
+	t.Run("no applied messages give the empty root", func(t *testing.T) {
+		ok, root, err := VerifyReceiptRoot(nil, types.EmptyReceiptsCID)
+		require.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, types.EmptyReceiptsCID, root)
+	})
+}
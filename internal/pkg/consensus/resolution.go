@@ -0,0 +1,223 @@
+package consensus
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/actor/builtin/initactor"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/address"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/state"
+)
+
+// maxAddressResolutionHops bounds the number of lookups made to resolve an address to an id address.
+const maxAddressResolutionHops = 16
+
+// AddressLookup maps an address to the address it is registered under, which is either an id
+// address or another address that resolves further. It returns false if addr is not registered.
+type AddressLookup func(ctx context.Context, addr address.Address) (address.Address, bool, error)
+
+// AddressResolutionCycleError is a fault returned when resolving an address revisits an address
+// or fails to reach an id address within a bounded number of lookups.
+type AddressResolutionCycleError struct {
+	// Addr is the address being resolved.
+	Addr address.Address
+	// Path holds the addresses visited, in order, starting with Addr.
+	Path []address.Address
+}
+
+func (e *AddressResolutionCycleError) Error() string {
+	return fmt.Sprintf("resolution of address %s does not terminate after %v", e.Addr, e.Path)
+}
+
+// IsFault marks a resolution cycle as a fault: the init actor mappings are corrupt.
+func (e *AddressResolutionCycleError) IsFault() bool {
+	return true
+}
+
+// ResolveAddressVia resolves addr to an id address by repeatedly applying lookup until an id
+// address is reached. If addr is already an id address, it is returned unchanged.
+func ResolveAddressVia(ctx context.Context, addr address.Address, lookup AddressLookup) (address.Address, bool, error) {
+	visited := make(map[address.Address]struct{})
+	path := []address.Address{}
+	current := addr
+	for hops := 0; current.Protocol() != address.ID; hops++ {
+		if _, seen := visited[current]; seen || hops >= maxAddressResolutionHops {
+			return address.Undef, false, &AddressResolutionCycleError{Addr: addr, Path: append(path, current)}
+		}
+		visited[current] = struct{}{}
+		path = append(path, current)
+
+		next, found, err := lookup(ctx, current)
+		if err != nil || !found {
+			return address.Undef, false, err
+		}
+		current = next
+	}
+	return current, true, nil
+}
+
+// AddressProtocolHandler resolves an address of a particular protocol to an id address. It
+// returns false if the address is not registered.
+type AddressProtocolHandler func(ctx context.Context, addr address.Address, st *state.CachedTree, vms vm.StorageMap) (address.Address, bool, error)
+
+// addressResolver resolves addresses with the handler it holds for their protocol.
+type addressResolver map[address.Protocol]AddressProtocolHandler
+
+// defaultAddressResolver holds the built-in handlers. It must not be modified.
+var defaultAddressResolver = addressResolver{
+	address.ID:        resolveIDAddress,
+	address.SECP256K1: resolveViaInitActor,
+	address.Actor:     resolveViaInitActor,
+	address.BLS:       resolveViaInitActor,
+}
+
+// resolve returns the id address addr resolves to, and whether it resolves at all. Addresses
+// of a protocol with no handler do not resolve.
+func (r addressResolver) resolve(ctx context.Context, addr address.Address, st *state.CachedTree, vms vm.StorageMap) (address.Address, bool, error) {
+	handler, ok := r[addr.Protocol()]
+	if !ok {
+		return address.Undef, false, nil
+	}
+	return handler(ctx, addr, st, vms)
+}
+
+// forVM returns a resolver for the VM that resolves the addresses actors send to in st.
+func (r addressResolver) forVM(st *state.CachedTree, vms vm.StorageMap) vm.AddressResolver {
+	return func(ctx context.Context, addr address.Address) (address.Address, bool, error) {
+		return r.resolve(ctx, addr, st, vms)
+	}
+}
+
+// WithAddressProtocol makes the processor resolve addresses of the given protocol with
+// handler rather than with the built-in handler, if any, wherever it resolves them: for the
+// messages it applies, the sends actors make and the rewards it pays. A nil handler leaves
+// addresses of the protocol unresolvable, so actors named by them are not found.
+func WithAddressProtocol(protocol address.Protocol, handler AddressProtocolHandler) ProcessorOption {
+	return func(p *DefaultProcessor) {
+		handlers := make(addressResolver, len(p.addressResolver)+1)
+		for proto, h := range p.addressResolver {
+			handlers[proto] = h
+		}
+		if handler == nil {
+			delete(handlers, protocol)
+		} else {
+			handlers[protocol] = handler
+		}
+		p.addressResolver = handlers
+	}
+}
+
+// ResolveAddress resolves addr as ResolveAddress does, with the handlers the processor was
+// configured with.
+func (p *DefaultProcessor) ResolveAddress(ctx context.Context, addr address.Address, st *state.CachedTree, vms vm.StorageMap) (address.Address, bool, error) {
+	return p.addressResolver.resolve(ctx, addr, st, vms)
+}
+
+// resolveIDAddress returns id addresses unchanged.
+func resolveIDAddress(_ context.Context, addr address.Address, _ *state.CachedTree, _ vm.StorageMap) (address.Address, bool, error) {
+	return addr, true, nil
+}
+
+// resolveViaInitActor resolves addresses through the mappings held by the init actor.
+func resolveViaInitActor(ctx context.Context, addr address.Address, st *state.CachedTree, vms vm.StorageMap) (address.Address, bool, error) {
+	return ResolveAddressVia(ctx, addr, initActorLookup(st, vms))
+}
+
+// initActorLookup looks addresses up in the init actor's address map.
+func initActorLookup(st *state.CachedTree, vms vm.StorageMap) AddressLookup {
+	return func(ctx context.Context, addr address.Address) (address.Address, bool, error) {
+		params, err := initActorContextParams(ctx, st, vms)
+		if err != nil {
+			return address.Undef, false, err
+		}
+
+		id, found, err := initactor.LookupIDAddress(vm.NewVMContext(params), addr)
+		if err != nil {
+			return address.Undef, false, err
+		}
+
+		if !found {
+			return address.Undef, false, nil
+		}
+
+		idAddr, err := address.NewIDAddress(id)
+		if err != nil {
+			return address.Undef, false, err
+		}
+
+		return idAddr, true, nil
+	}
+}
+
+// DumpAddressMappings returns every address registered with the init actor alongside the
+// actor id it resolves to, sorted by address so that dumps of the same state are identical.
+func DumpAddressMappings(ctx context.Context, st *state.CachedTree, vms vm.StorageMap) ([]initactor.AddressMapping, error) {
+	params, err := initActorContextParams(ctx, st, vms)
+	if err != nil {
+		return nil, err
+	}
+	return initactor.AddressMappings(vm.NewVMContext(params))
+}
+
+// DumpAddressMappingsByID behaves as DumpAddressMappings but sorts the mappings by the actor id
+// they resolve to, i.e. in the order the actors were created.
+func DumpAddressMappingsByID(ctx context.Context, st *state.CachedTree, vms vm.StorageMap) ([]initactor.AddressMapping, error) {
+	mappings, err := DumpAddressMappings(ctx, st, vms)
+	if err != nil {
+		return nil, err
+	}
+	// Stable so that addresses mapping to the same id keep their address order.
+	sort.SliceStable(mappings, func(i, j int) bool { return mappings[i].ID < mappings[j].ID })
+	return mappings, nil
+}
+
+// NextActorID returns the id the init actor will assign to the next actor it creates.
+func NextActorID(ctx context.Context, st *state.CachedTree, vms vm.StorageMap) (uint64, error) {
+	params, err := initActorContextParams(ctx, st, vms)
+	if err != nil {
+		return 0, err
+	}
+	return initactor.NextActorID(vm.NewVMContext(params)), nil
+}
+
+// WouldCreateActor reports whether a message sent to addr would create an account actor for
+// it, and if so, the id the new actor would be assigned.
+func WouldCreateActor(ctx context.Context, st *state.CachedTree, vms vm.StorageMap, addr address.Address) (bool, uint64, error) {
+	return wouldCreateActor(ctx, st, vms, defaultAddressResolver, addr)
+}
+
+// WouldCreateActor reports what WouldCreateActor does, with the handlers the processor was
+// configured with.
+func (p *DefaultProcessor) WouldCreateActor(ctx context.Context, st *state.CachedTree, vms vm.StorageMap, addr address.Address) (bool, uint64, error) {
+	return wouldCreateActor(ctx, st, vms, p.addressResolver, addr)
+}
+
+// wouldCreateActor reports what WouldCreateActor does, resolving addr with resolver.
+func wouldCreateActor(ctx context.Context, st *state.CachedTree, vms vm.StorageMap, resolver addressResolver, addr address.Address) (bool, uint64, error) {
+	_, found, err := resolver.resolve(ctx, addr, st, vms)
+	if err != nil || found {
+		return false, 0, err
+	}
+	id, err := NextActorID(ctx, st, vms)
+	if err != nil {
+		return false, 0, err
+	}
+	return true, id, nil
+}
+
+// initActorContextParams returns the parameters of a context for reading the init actor's state.
+func initActorContextParams(ctx context.Context, st *state.CachedTree, vms vm.StorageMap) (vm.NewContextParams, error) {
+	init, err := st.GetActor(ctx, address.InitAddress)
+	if err != nil {
+		return vm.NewContextParams{}, err
+	}
+
+	return vm.NewContextParams{
+		State:      st,
+		StorageMap: vms,
+		ToAddr:     address.InitAddress,
+		To:         init,
+	}, nil
+}
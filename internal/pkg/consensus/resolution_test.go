@@ -0,0 +1,239 @@
+package consensus_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ipfs/go-hamt-ipld"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	. "github.com/filecoin-project/go-filecoin/internal/pkg/consensus"
+	th "github.com/filecoin-project/go-filecoin/internal/pkg/testhelpers"
+	tf "github.com/filecoin-project/go-filecoin/internal/pkg/testhelpers/testflags"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/types"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/abi"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/actor"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/actor/builtin"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/actor/builtin/initactor"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/address"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/errors"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/state"
+)
+
+// mapLookup stands in for an init actor with the given address mappings.
+func mapLookup(mapping map[address.Address]address.Address) AddressLookup {
+	return func(_ context.Context, addr address.Address) (address.Address, bool, error) {
+		next, ok := mapping[addr]
+		return next, ok, nil
+	}
+}
+
+func TestResolveAddressVia(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+	newAddress := address.NewForTestGetter()
+	a, b := newAddress(), newAddress()
+	id, err := address.NewIDAddress(100)
+	require.NoError(t, err)
+
+	t.Run("follows a chain of mappings to an id address", func(t *testing.T) {
+		resolved, found, err := ResolveAddressVia(ctx, a, mapLookup(map[address.Address]address.Address{a: b, b: id}))
+		require.NoError(t, err)
+		assert.True(t, found)
+		assert.Equal(t, id, resolved)
+	})
+
+	t.Run("reports unmapped addresses as not found", func(t *testing.T) {
+		_, found, err := ResolveAddressVia(ctx, a, mapLookup(map[address.Address]address.Address{}))
+		require.NoError(t, err)
+		assert.False(t, found)
+	})
+
+	t.Run("faults on a cyclic mapping", func(t *testing.T) {
+		_, found, err := ResolveAddressVia(ctx, a, mapLookup(map[address.Address]address.Address{a: b, b: a}))
+		require.Error(t, err)
+		assert.False(t, found)
+		assert.True(t, errors.IsFault(err))
+
+		cycleErr, ok := err.(*AddressResolutionCycleError)
+		require.True(t, ok)
+		assert.Equal(t, a, cycleErr.Addr)
+		assert.Equal(t, []address.Address{a, b, a}, cycleErr.Path)
+	})
+}
+
+func TestWithAddressProtocol(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+	actorAddr, err := address.NewActorAddress([]byte("delegated"))
+	require.NoError(t, err)
+	id, err := address.NewIDAddress(100)
+	require.NoError(t, err)
+
+	var handled []address.Address
+	mock := func(_ context.Context, addr address.Address, _ *state.CachedTree, _ vm.StorageMap) (address.Address, bool, error) {
+		handled = append(handled, addr)
+		return id, true, nil
+	}
+	processor := NewDefaultProcessor(WithAddressProtocol(address.Actor, mock))
+
+	resolved, found, err := processor.ResolveAddress(ctx, actorAddr, nil, nil)
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, id, resolved)
+	assert.Equal(t, []address.Address{actorAddr}, handled)
+
+	// Id addresses are still passed through by the built-in handler.
+	resolved, found, err = processor.ResolveAddress(ctx, id, nil, nil)
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, id, resolved)
+	assert.Len(t, handled, 1)
+
+	// Other processors keep the built-in handlers, which look the address up in the init actor.
+	vms := th.VMStorage()
+	st := state.NewTree(hamt.NewCborStore())
+	th.RequireInitAccountActor(ctx, t, st, vms, address.NewForTestGetter()(), types.ZeroAttoFIL)
+	_, found, err = NewDefaultProcessor().ResolveAddress(ctx, actorAddr, state.NewCachedTree(st), vms)
+	require.NoError(t, err)
+	assert.False(t, found)
+	assert.Len(t, handled, 1)
+
+	// Removing a handler leaves its protocol unresolvable.
+	withoutActor := NewDefaultProcessor(WithAddressProtocol(address.Actor, nil))
+	_, found, err = withoutActor.ResolveAddress(ctx, actorAddr, nil, nil)
+	require.NoError(t, err)
+	assert.False(t, found)
+}
+
+func TestAddressProtocolHandlerUsedThroughout(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+	fakeActorCodeCid := types.NewCidForTestGetter()()
+	builtinActors := builtin.NewBuilder().
+		AddAll(builtin.DefaultActors).
+		Add(fakeActorCodeCid, 0, &actor.FakeActor{}).
+		Build()
+	vms := th.VMStorage()
+	addresses, st := setupActorsForGasTest(t, vms, fakeActorCodeCid, 1000)
+
+	recipient, err := address.NewActorAddress([]byte("recipient"))
+	require.NoError(t, err)
+	owner, err := address.NewActorAddress([]byte("owner"))
+	require.NoError(t, err)
+	mapping := map[address.Address]address.Address{recipient: addresses[2], owner: addresses[3]}
+	var handled []address.Address
+	mock := func(_ context.Context, addr address.Address, _ *state.CachedTree, _ vm.StorageMap) (address.Address, bool, error) {
+		handled = append(handled, addr)
+		idAddr, found := mapping[addr]
+		return idAddr, found, nil
+	}
+	processor := NewConfiguredProcessor(NewDefaultMessageValidator(), NewDefaultBlockRewarder(), builtinActors, WithAddressProtocol(address.Actor, mock))
+
+	// The fake actor sends 100 FIL on to the recipient, and the gas is paid to the owner.
+	params, err := abi.ToEncodedValues(recipient)
+	require.NoError(t, err)
+	msg := types.NewMeteredMessage(addresses[0], addresses[1], 0, types.ZeroAttoFIL, actor.NestedBalanceID, params, types.NewGasPrice(1), types.NewGasUnits(1000))
+	result, err := processor.ApplyMessage(ctx, st, vms, msg, owner, types.NewBlockHeight(0), vm.NewLegacyGasTracker(), nil)
+	require.NoError(t, err)
+	require.NoError(t, result.ExecutionError)
+	assert.Equal(t, []address.Address{recipient, owner}, handled)
+
+	recipientActor, err := st.GetActor(ctx, addresses[2])
+	require.NoError(t, err)
+	assert.Equal(t, types.NewAttoFILFromFIL(100), recipientActor.Balance)
+	ownerActor, err := st.GetActor(ctx, addresses[3])
+	require.NoError(t, err)
+	assert.Equal(t, result.Receipt.GasAttoFIL, ownerActor.Balance)
+}
+
+func TestDumpAddressMappings(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+	vms := th.VMStorage()
+	st := state.NewTree(hamt.NewCborStore())
+
+	newAddress := address.NewForTestGetter()
+	expected := map[address.Address]address.Address{}
+	for i := 0; i < 8; i++ {
+		addr := newAddress()
+		_, idAddr := th.RequireInitAccountActor(ctx, t, st, vms, addr, types.ZeroAttoFIL)
+		expected[addr] = idAddr
+	}
+
+	dump := func() []initactor.AddressMapping {
+		mappings, err := DumpAddressMappings(ctx, state.NewCachedTree(st), vms)
+		require.NoError(t, err)
+		return mappings
+	}
+
+	first := dump()
+	require.Len(t, first, len(expected))
+	for i, m := range first {
+		idAddr, err := address.NewIDAddress(m.ID)
+		require.NoError(t, err)
+		assert.Equal(t, expected[m.Address], idAddr)
+		if i > 0 {
+			assert.True(t, first[i-1].Address.String() < m.Address.String())
+		}
+	}
+
+	for run := 0; run < 5; run++ {
+		assert.Equal(t, first, dump())
+	}
+}
+
+func TestDumpAddressMappingsByID(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+	vms := th.VMStorage()
+	st := state.NewTree(hamt.NewCborStore())
+
+	newAddress := address.NewForTestGetter()
+	for i := 0; i < 8; i++ {
+		th.RequireInitAccountActor(ctx, t, st, vms, newAddress(), types.ZeroAttoFIL)
+	}
+
+	mappings, err := DumpAddressMappingsByID(ctx, state.NewCachedTree(st), vms)
+	require.NoError(t, err)
+	require.Len(t, mappings, 8)
+	for i := 1; i < len(mappings); i++ {
+		assert.True(t, mappings[i-1].ID < mappings[i].ID, "mapping %d has id %d after %d", i, mappings[i].ID, mappings[i-1].ID)
+	}
+}
+
+func TestNextActorID(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+	vms := th.VMStorage()
+	st := state.NewTree(hamt.NewCborStore())
+
+	newAddress := address.NewForTestGetter()
+	th.RequireInitAccountActor(ctx, t, st, vms, newAddress(), types.ZeroAttoFIL)
+
+	next, err := NextActorID(ctx, state.NewCachedTree(st), vms)
+	require.NoError(t, err)
+
+	addr := newAddress()
+	creates, id, err := WouldCreateActor(ctx, state.NewCachedTree(st), vms, addr)
+	require.NoError(t, err)
+	assert.True(t, creates)
+	assert.Equal(t, next, id)
+
+	_, idAddr := th.RequireInitAccountActor(ctx, t, st, vms, addr, types.ZeroAttoFIL)
+	expected, err := address.NewIDAddress(next)
+	require.NoError(t, err)
+	assert.Equal(t, expected, idAddr)
+
+	creates, _, err = WouldCreateActor(ctx, state.NewCachedTree(st), vms, addr)
+	require.NoError(t, err)
+	assert.False(t, creates)
+}
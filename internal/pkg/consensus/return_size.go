@@ -0,0 +1,82 @@
+package consensus
+
+import (
+	"fmt"
+
+	vmerrors "github.com/filecoin-project/go-filecoin/internal/pkg/vm/errors"
+)
+
+// ReturnSizePolicy determines what happens to a message whose return values exceed the
+// maximum return size.
+type ReturnSizePolicy int
+
+const (
+	// ReturnSizeRevert reverts the message's changes and drops its return values. The message
+	// is still applied and pays for its gas.
+	ReturnSizeRevert ReturnSizePolicy = iota
+	// ReturnSizeTruncate keeps the message's changes and truncates its return values to the
+	// maximum size.
+	ReturnSizeTruncate
+)
+
+// ReturnTooLargeError is the execution error of a message reverted because its return values
+// exceeded the maximum return size.
+type ReturnTooLargeError struct {
+	Size  int
+	Limit int
+}
+
+func (e *ReturnTooLargeError) Error() string {
+	return fmt.Sprintf("return values of %d bytes exceed the limit of %d bytes", e.Size, e.Limit)
+}
+
+// ShouldRevert marks the error as reverting the message's changes.
+func (e *ReturnTooLargeError) ShouldRevert() bool {
+	return true
+}
+
+// returnSizeLimit bounds the total size of the return values of a message.
+type returnSizeLimit struct {
+	max    int
+	policy ReturnSizePolicy
+}
+
+// enforce applies the limit to the outcome of sending a message. A message that already
+// failed only has its return values dropped by the revert policy.
+func (l *returnSizeLimit) enforce(ret [][]byte, exitCode uint8, vmErr error) ([][]byte, uint8, error) {
+	if l == nil {
+		return ret, exitCode, vmErr
+	}
+	size := 0
+	for _, r := range ret {
+		size += len(r)
+	}
+	if size <= l.max {
+		return ret, exitCode, vmErr
+	}
+
+	if l.policy == ReturnSizeTruncate {
+		return truncateReturn(ret, l.max), exitCode, vmErr
+	}
+	if vmErr != nil {
+		return nil, exitCode, vmErr
+	}
+	err := &ReturnTooLargeError{Size: size, Limit: l.max}
+	return nil, vmerrors.CodeError(err), err
+}
+
+// truncateReturn keeps the first max bytes of the return values, dropping values past them.
+func truncateReturn(ret [][]byte, max int) [][]byte {
+	var truncated [][]byte
+	for _, r := range ret {
+		if len(r) > max {
+			r = r[:max]
+		}
+		truncated = append(truncated, r)
+		max -= len(r)
+		if max == 0 {
+			break
+		}
+	}
+	return truncated
+}
@@ -0,0 +1,71 @@
+package consensus_test
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	. "github.com/filecoin-project/go-filecoin/internal/pkg/consensus"
+	th "github.com/filecoin-project/go-filecoin/internal/pkg/testhelpers"
+	tf "github.com/filecoin-project/go-filecoin/internal/pkg/testhelpers/testflags"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/types"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/actor"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/actor/builtin"
+)
+
+func TestMaxReturnSize(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+	fakeActorCodeCid := types.NewCidForTestGetter()()
+	actors := builtin.NewBuilder().
+		AddAll(builtin.DefaultActors).
+		Add(fakeActorCodeCid, 0, &actor.FakeActor{}).
+		Build()
+
+	const limit = 16
+	apply := func(policy ReturnSizePolicy, size int64) *ApplicationResult {
+		processor := NewConfiguredProcessor(NewDefaultMessageValidator(), &FakeBlockRewarder{}, actors, WithMaxReturnSize(limit, policy))
+		vms := th.VMStorage()
+		addresses, st := setupActorsForGasTest(t, vms, fakeActorCodeCid, 1000)
+		params := actor.MustConvertParams(big.NewInt(size))
+		msg := types.NewMeteredMessage(addresses[0], addresses[1], 0, types.NewAttoFILFromFIL(1), actor.ReturnsBytesID, params, types.NewGasPrice(1), types.NewGasUnits(1000))
+		result, err := processor.ApplyMessage(ctx, st, vms, msg, addresses[3], types.NewBlockHeight(0), vm.NewLegacyGasTracker(), nil)
+		require.NoError(t, err)
+		return result
+	}
+
+	t.Run("return within the limit is kept", func(t *testing.T) {
+		for _, policy := range []ReturnSizePolicy{ReturnSizeRevert, ReturnSizeTruncate} {
+			result := apply(policy, 4)
+			require.NoError(t, result.ExecutionError)
+			require.Len(t, result.Receipt.Return, 1)
+			assert.True(t, len(result.Receipt.Return[0]) <= limit)
+		}
+	})
+
+	t.Run("truncate policy truncates oversized return", func(t *testing.T) {
+		result := apply(ReturnSizeTruncate, 64)
+		require.NoError(t, result.ExecutionError)
+		assert.Equal(t, uint8(0), result.Receipt.ExitCode)
+		require.Len(t, result.Receipt.Return, 1)
+		assert.Len(t, result.Receipt.Return[0], limit)
+		assert.True(t, result.ValueTransferred.Equal(types.NewAttoFILFromFIL(1)))
+	})
+
+	t.Run("revert policy reverts oversized return", func(t *testing.T) {
+		result := apply(ReturnSizeRevert, 64)
+		require.Error(t, result.ExecutionError)
+		tooLarge, ok := errors.Cause(result.ExecutionError).(*ReturnTooLargeError)
+		require.True(t, ok, "unexpected execution error: %v", result.ExecutionError)
+		assert.Equal(t, limit, tooLarge.Limit)
+		assert.NotEqual(t, uint8(0), result.Receipt.ExitCode)
+		assert.Empty(t, result.Receipt.Return)
+		assert.True(t, result.ValueTransferred.IsZero())
+	})
+}
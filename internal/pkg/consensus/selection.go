@@ -0,0 +1,106 @@
+package consensus
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/filecoin-project/go-filecoin/internal/pkg/types"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/address"
+)
+
+// OrderKey is a comparable key giving the canonical order of a message: by decreasing gas
+// price, then by sender address, then by increasing nonce.
+type OrderKey struct {
+	GasPrice types.AttoFIL
+	Sender   address.Address
+	Nonce    uint64
+}
+
+// MessageOrderKey returns the canonical ordering key for msg.
+func MessageOrderKey(msg *types.UnsignedMessage) OrderKey {
+	return OrderKey{
+		GasPrice: msg.GasPrice,
+		Sender:   msg.From,
+		Nonce:    uint64(msg.CallSeqNum),
+	}
+}
+
+// Less reports whether k orders before other.
+func (k OrderKey) Less(other OrderKey) bool {
+	if !k.GasPrice.Equal(other.GasPrice) {
+		return k.GasPrice.GreaterThan(other.GasPrice)
+	}
+	if c := bytes.Compare(k.Sender.Bytes(), other.Sender.Bytes()); c != 0 {
+		return c < 0
+	}
+	return k.Nonce < other.Nonce
+}
+
+// SelectMessages chooses messages for a block with at most gasLimit gas in total, in the
+// order they should be applied. Each time, the next message of the sender offering the
+// highest EffectivePremium at baseFee is taken, so messages from a single sender are always
+// in nonce order; ties fall back to the canonical order, and a sender's messages with the
+// same nonce are ordered by CID, so the selection does not depend on the order of msgs.
+// Once one of a sender's messages cannot be included, because it does not fit or its fee cap
+// is below the base fee, none of their later messages are selected either, as they could not
+// be applied.
+func SelectMessages(msgs []*types.UnsignedMessage, gasLimit types.GasUnits, baseFee types.AttoFIL) []*types.UnsignedMessage {
+	// Group messages by sender and order each sender's messages by nonce.
+	bySender := make(map[address.Address][]*types.UnsignedMessage)
+	for _, msg := range msgs {
+		bySender[msg.From] = append(bySender[msg.From], msg)
+	}
+	queues := make([][]*types.UnsignedMessage, 0, len(bySender))
+	for _, queue := range bySender {
+		sort.Slice(queue, func(i, j int) bool {
+			if queue[i].CallSeqNum != queue[j].CallSeqNum {
+				return queue[i].CallSeqNum < queue[j].CallSeqNum
+			}
+			return bytes.Compare(messageCidBytes(queue[i]), messageCidBytes(queue[j])) < 0
+		})
+		queues = append(queues, queue)
+	}
+
+	before := func(a, b *types.UnsignedMessage) bool {
+		premiumA, premiumB := EffectivePremium(a, baseFee), EffectivePremium(b, baseFee)
+		if !premiumA.Equal(premiumB) {
+			return premiumA.GreaterThan(premiumB)
+		}
+		return MessageOrderKey(a).Less(MessageOrderKey(b))
+	}
+
+	var selected []*types.UnsignedMessage
+	remaining := gasLimit
+	for len(queues) > 0 {
+		best := 0
+		for i := 1; i < len(queues); i++ {
+			if before(queues[i][0], queues[best][0]) {
+				best = i
+			}
+		}
+
+		msg := queues[best][0]
+		if msg.GasLimit > remaining || gasFeeCap(msg).LessThan(baseFee) {
+			queues = append(queues[:best], queues[best+1:]...)
+			continue
+		}
+		selected = append(selected, msg)
+		remaining -= msg.GasLimit
+
+		if len(queues[best]) == 1 {
+			queues = append(queues[:best], queues[best+1:]...)
+		} else {
+			queues[best] = queues[best][1:]
+		}
+	}
+	return selected
+}
+
+// messageCidBytes returns the bytes of the CID of msg, or nil if it cannot be computed.
+func messageCidBytes(msg *types.UnsignedMessage) []byte {
+	c, err := msg.Cid()
+	if err != nil {
+		return nil
+	}
+	return c.Bytes()
+}
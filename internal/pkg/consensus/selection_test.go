@@ -0,0 +1,119 @@
+package consensus_test
+
+import (
+	"bytes"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	. "github.com/filecoin-project/go-filecoin/internal/pkg/consensus"
+	tf "github.com/filecoin-project/go-filecoin/internal/pkg/testhelpers/testflags"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/types"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/address"
+)
+
+// orderedAddresses returns n test addresses in increasing byte order.
+func orderedAddresses(n int) []address.Address {
+	newAddress := address.NewForTestGetter()
+	addrs := make([]address.Address, n)
+	for i := range addrs {
+		addrs[i] = newAddress()
+	}
+	sort.Slice(addrs, func(i, j int) bool { return bytes.Compare(addrs[i].Bytes(), addrs[j].Bytes()) < 0 })
+	return addrs
+}
+
+func newPricedMessage(from address.Address, nonce, price, limit uint64) *types.UnsignedMessage {
+	return types.NewMeteredMessage(from, from, nonce, types.ZeroAttoFIL, types.SendMethodID, nil, types.NewGasPrice(int64(price)), types.NewGasUnits(limit))
+}
+
+func TestMessageOrderKey(t *testing.T) {
+	tf.UnitTest(t)
+
+	addrs := orderedAddresses(2)
+	a, b := addrs[0], addrs[1]
+
+	// Highest gas price first, then by sender, then by nonce.
+	canonical := []*types.UnsignedMessage{
+		newPricedMessage(b, 0, 5, 0),
+		newPricedMessage(a, 0, 3, 0),
+		newPricedMessage(a, 1, 3, 0),
+		newPricedMessage(b, 1, 3, 0),
+		newPricedMessage(a, 2, 1, 0),
+	}
+
+	shuffled := append([]*types.UnsignedMessage{}, canonical...)
+	rand.New(rand.NewSource(7)).Shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+	sort.Slice(shuffled, func(i, j int) bool { return MessageOrderKey(shuffled[i]).Less(MessageOrderKey(shuffled[j])) })
+
+	assert.Equal(t, canonical, shuffled)
+}
+
+func TestSelectMessages(t *testing.T) {
+	tf.UnitTest(t)
+
+	addrs := orderedAddresses(2)
+	a, b := addrs[0], addrs[1]
+
+	t.Run("keeps each sender in nonce order", func(t *testing.T) {
+		a0 := newPricedMessage(a, 0, 1, 10)
+		a1 := newPricedMessage(a, 1, 9, 10)
+		b0 := newPricedMessage(b, 0, 5, 10)
+
+		selected := SelectMessages([]*types.UnsignedMessage{a1, b0, a0}, types.NewGasUnits(100), types.ZeroAttoFIL)
+		assert.Equal(t, []*types.UnsignedMessage{b0, a0, a1}, selected)
+	})
+
+	t.Run("stops a sender once their message does not fit", func(t *testing.T) {
+		a0 := newPricedMessage(a, 0, 5, 60)
+		a1 := newPricedMessage(a, 1, 5, 10)
+		b0 := newPricedMessage(b, 0, 9, 50)
+
+		selected := SelectMessages([]*types.UnsignedMessage{a0, a1, b0}, types.NewGasUnits(100), types.ZeroAttoFIL)
+		assert.Equal(t, []*types.UnsignedMessage{b0}, selected)
+	})
+
+	t.Run("skips senders whose fee cap is below the base fee", func(t *testing.T) {
+		a0 := newPricedMessage(a, 0, 3, 10)
+		a1 := newPricedMessage(a, 1, 9, 10)
+		b0 := newPricedMessage(b, 0, 5, 10)
+
+		selected := SelectMessages([]*types.UnsignedMessage{a0, a1, b0}, types.NewGasUnits(100), types.NewGasPrice(4))
+		assert.Equal(t, []*types.UnsignedMessage{b0}, selected)
+	})
+
+	t.Run("orders equal premiums reproducibly", func(t *testing.T) {
+		addrs := orderedAddresses(3)
+		a0 := newPricedMessage(addrs[0], 0, 5, 10)
+		a1 := newPricedMessage(addrs[0], 1, 5, 10)
+		b0 := newPricedMessage(addrs[1], 0, 5, 10)
+		c0 := newPricedMessage(addrs[2], 0, 5, 10)
+		c1 := newPricedMessage(addrs[2], 1, 5, 10)
+		// A message from the same sender with the same nonce, differing only by CID.
+		dup := newPricedMessage(addrs[1], 0, 5, 10)
+		dup.Value = types.NewAttoFILFromFIL(1)
+
+		first, second := b0, dup
+		if bytes.Compare(mustCid(t, dup).Bytes(), mustCid(t, b0).Bytes()) < 0 {
+			first, second = dup, b0
+		}
+		expected := []*types.UnsignedMessage{a0, a1, first, second, c0, c1}
+
+		msgs := []*types.UnsignedMessage{a0, a1, b0, dup, c0, c1}
+		r := rand.New(rand.NewSource(11))
+		for i := 0; i < 10; i++ {
+			r.Shuffle(len(msgs), func(i, j int) { msgs[i], msgs[j] = msgs[j], msgs[i] })
+			assert.Equal(t, expected, SelectMessages(msgs, types.NewGasUnits(100), types.ZeroAttoFIL))
+		}
+	})
+}
+
+func mustCid(t *testing.T, msg *types.UnsignedMessage) cid.Cid {
+	c, err := msg.Cid()
+	require.NoError(t, err)
+	return c
+}
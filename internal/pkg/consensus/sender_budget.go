@@ -0,0 +1,114 @@
+package consensus
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/filecoin-project/go-filecoin/internal/pkg/types"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/address"
+)
+
+// SenderGasBudgets limits the gas each sender may use within a sliding window of block
+// heights. Gas is recorded as messages are applied, so one set of budgets may be shared by all
+// the tipsets a processor applies. A message whose gas limit would take its sender past the
+// budget is rejected until enough of the sender's earlier gas use has left the window.
+type SenderGasBudgets struct {
+	budget types.GasUnits
+	window *types.BlockHeight
+
+	lk    sync.Mutex
+	spent map[address.Address][]gasSpend
+}
+
+type gasSpend struct {
+	height *types.BlockHeight
+	gas    types.GasUnits
+}
+
+// NewSenderGasBudgets creates budgets allowing each sender budget gas units over any window
+// consecutive block heights.
+func NewSenderGasBudgets(budget types.GasUnits, window uint64) *SenderGasBudgets {
+	return &SenderGasBudgets{
+		budget: budget,
+		window: types.NewBlockHeight(window),
+		spent:  map[address.Address][]gasSpend{},
+	}
+}
+
+// WithSenderGasBudgets makes the processor enforce budgets on the gas used by each sender.
+func WithSenderGasBudgets(budgets *SenderGasBudgets) ProcessorOption {
+	return func(p *DefaultProcessor) {
+		p.senderBudgets = budgets
+	}
+}
+
+// SenderBudgetExhaustedError is the cause of the failure to apply a message whose sender has
+// too little of its windowed gas budget left. The message may be applied at a later height.
+type SenderBudgetExhaustedError struct {
+	Sender   address.Address
+	Spent    types.GasUnits
+	GasLimit types.GasUnits
+	Budget   types.GasUnits
+}
+
+func (e *SenderBudgetExhaustedError) Error() string {
+	return fmt.Sprintf("sender %s has used %d of its gas budget of %d, leaving too little for a gas limit of %d",
+		e.Sender, e.Spent, e.Budget, e.GasLimit)
+}
+
+// ShouldRevert implements the reverterror interface, as a rejected message changes no state.
+func (e *SenderBudgetExhaustedError) ShouldRevert() bool {
+	return true
+}
+
+// Spent returns the gas used by sender within the window ending at height bh.
+func (b *SenderGasBudgets) Spent(sender address.Address, bh *types.BlockHeight) types.GasUnits {
+	b.lk.Lock()
+	defer b.lk.Unlock()
+	return b.spentLocked(sender, bh)
+}
+
+// check returns an error if a message from sender with the given gas limit does not fit in
+// what remains of the sender's budget at height bh.
+func (b *SenderGasBudgets) check(sender address.Address, bh *types.BlockHeight, gasLimit types.GasUnits) error {
+	b.lk.Lock()
+	defer b.lk.Unlock()
+	if spent := b.spentLocked(sender, bh); spent+gasLimit > b.budget {
+		return &SenderBudgetExhaustedError{Sender: sender, Spent: spent, GasLimit: gasLimit, Budget: b.budget}
+	}
+	return nil
+}
+
+// record charges gas used at height bh to sender, forgetting gas that has left the window.
+func (b *SenderGasBudgets) record(sender address.Address, bh *types.BlockHeight, gas types.GasUnits) {
+	b.lk.Lock()
+	defer b.lk.Unlock()
+
+	var kept []gasSpend
+	for _, s := range b.spent[sender] {
+		if b.inWindow(s, bh) {
+			kept = append(kept, s)
+		}
+	}
+	b.spent[sender] = append(kept, gasSpend{height: bh, gas: gas})
+}
+
+func (b *SenderGasBudgets) spentLocked(sender address.Address, bh *types.BlockHeight) types.GasUnits {
+	total := types.NewGasUnits(0)
+	for _, s := range b.spent[sender] {
+		if b.inWindow(s, bh) {
+			total += s.gas
+		}
+	}
+	return total
+}
+
+// inWindow reports whether s falls in the window ending at height bh.
+func (b *SenderGasBudgets) inWindow(s gasSpend, bh *types.BlockHeight) bool {
+	return s.height.LessEqual(bh) && s.height.Add(b.window).GreaterThan(bh)
+}
+
+func isSenderBudgetExhausted(err error) bool {
+	_, ok := err.(*SenderBudgetExhaustedError)
+	return ok
+}
@@ -0,0 +1,82 @@
+package consensus_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ipfs/go-hamt-ipld"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-filecoin/internal/pkg/block"
+	. "github.com/filecoin-project/go-filecoin/internal/pkg/consensus"
+	th "github.com/filecoin-project/go-filecoin/internal/pkg/testhelpers"
+	tf "github.com/filecoin-project/go-filecoin/internal/pkg/testhelpers/testflags"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/types"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/actor"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/actor/builtin"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/address"
+)
+
+func TestSenderGasBudgets(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+	cst := hamt.NewCborStore()
+	vms := th.VMStorage()
+
+	fakeActorCodeCid := types.NewCidForTestGetter()()
+	builtinActors := builtin.NewBuilder().
+		AddAll(builtin.DefaultActors).
+		Add(fakeActorCodeCid, 0, &actor.FakeActor{}).
+		Build()
+
+	mockSigner, _ := types.NewMockSignersAndKeyInfo(2)
+	from, minerOwner := mockSigner.Addresses[0], mockSigner.Addresses[1]
+	fakeAddr, err := address.NewIDAddress(110)
+	require.NoError(t, err)
+
+	_, st := requireMakeStateTree(t, cst, map[address.Address]*actor.Actor{
+		address.LegacyNetworkAddress: th.RequireNewAccountActor(t, types.NewAttoFILFromFIL(1000000)),
+		fakeAddr:                     th.RequireNewFakeActorWithTokens(t, vms, fakeAddr, fakeActorCodeCid, types.ZeroAttoFIL),
+	})
+	_, fromID := th.RequireInitAccountActor(ctx, t, st, vms, from, types.NewAttoFILFromFIL(10000))
+	stCid, _, minerAddr := mustCreateStorageMiner(ctx, t, st, vms, minerOwner)
+
+	// HasReturnValue uses all of its 100 gas limit, so the budget covers two messages per window.
+	budgets := NewSenderGasBudgets(types.NewGasUnits(250), 10)
+	processor := NewConfiguredProcessor(NewDefaultMessageValidator(), &FakeBlockRewarder{}, builtinActors, WithSenderGasBudgets(budgets))
+	callFake := func(nonce uint64) *types.UnsignedMessage {
+		return types.NewMeteredMessage(from, fakeAddr, nonce, types.ZeroAttoFIL, actor.HasReturnValueID, nil, types.NewGasPrice(1), types.NewGasUnits(100))
+	}
+	processAt := func(height uint64, msgs ...*types.UnsignedMessage) []*ApplyMessageResult {
+		blk := &block.Block{
+			Height:    types.Uint64(height),
+			StateRoot: stCid,
+			Miner:     minerAddr,
+			Ticket:    block.Ticket{VRFProof: []byte{byte(height)}},
+		}
+		results, err := processor.ProcessTipSet(ctx, st, vms, th.RequireNewTipSet(t, blk), [][]*types.UnsignedMessage{msgs}, nil)
+		require.NoError(t, err)
+		return results
+	}
+
+	results := processAt(1, callFake(0), callFake(1))
+	for _, r := range results {
+		require.NoError(t, r.Failure)
+	}
+	assert.Equal(t, types.NewGasUnits(200), budgets.Spent(fromID, types.NewBlockHeight(1)))
+
+	// The next tipset is still within the window, so the sender's budget is spent.
+	results = processAt(2, callFake(2))
+	require.Len(t, results, 1)
+	_, exhausted := errors.Cause(results[0].Failure).(*SenderBudgetExhaustedError)
+	assert.True(t, exhausted, "unexpected failure: %v", results[0].Failure)
+	assert.False(t, results[0].FailureIsPermanent)
+
+	// Once the window has moved past the first tipset the sender may send again.
+	results = processAt(11, callFake(2))
+	require.Len(t, results, 1)
+	assert.NoError(t, results[0].Failure)
+}
@@ -0,0 +1,58 @@
+package consensus
+
+import (
+	"github.com/filecoin-project/go-filecoin/internal/pkg/types"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/address"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/errors"
+)
+
+// SignatureVerifier reports whether sig is a valid signature of data by the key address addr.
+type SignatureVerifier func(data []byte, addr address.Address, sig types.Signature) bool
+
+// SignatureSchemes is a registry of the schemes messages may be signed with, keyed by the
+// protocol of the addresses that sign with them, so that schemes can be added without changing
+// the validators.
+type SignatureSchemes struct {
+	verifiers map[address.Protocol]SignatureVerifier
+}
+
+// NewSignatureSchemes creates a registry of the Secp256k1 and BLS schemes. A Secp256k1
+// signature identifies its signer, whose key address is recovered from it and compared with
+// the address; a BLS signature is checked against the public key in the address.
+func NewSignatureSchemes() *SignatureSchemes {
+	return &SignatureSchemes{
+		verifiers: map[address.Protocol]SignatureVerifier{
+			address.SECP256K1: types.IsValidSignature,
+			address.BLS:       types.IsValidSignature,
+		},
+	}
+}
+
+// defaultSignatureSchemes verifies signatures for VerifyMessageSignature. It must not be
+// registered with.
+var defaultSignatureSchemes = NewSignatureSchemes()
+
+// Register makes verify check the signatures by addresses of the given protocol, replacing
+// the scheme registered for it, if any.
+func (s *SignatureSchemes) Register(protocol address.Protocol, verify SignatureVerifier) {
+	s.verifiers[protocol] = verify
+}
+
+// Verify checks that sig is a signature of msg by from, with the scheme registered for the
+// protocol of from, without applying msg. Addresses with no scheme, such as ID addresses, must
+// be resolved to their key address first.
+func (s *SignatureSchemes) Verify(msg *types.UnsignedMessage, sig types.Signature, from address.Address) error {
+	verify, ok := s.verifiers[from.Protocol()]
+	if !ok {
+		return errors.NewRevertErrorf("cannot verify a signature by %s, which is not a key address of a registered signature scheme", from)
+	}
+
+	data, err := msg.Marshal()
+	if err != nil {
+		return errors.RevertErrorWrap(err, "could not encode message")
+	}
+	if !verify(data, from, sig) {
+		return errInvalidSignature
+	}
+	return nil
+}
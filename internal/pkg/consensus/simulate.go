@@ -0,0 +1,165 @@
+package consensus
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/filecoin-project/go-filecoin/internal/pkg/types"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/actor"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/address"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/errors"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/state"
+)
+
+// UnsafeFixedGasTracker is a gas tracker that does not meter messages: each message it is reset
+// for uses exactly a fixed amount of gas, however much work it actually does, and counts
+// towards the block for that amount once it is applied. Charges never fail.
+type UnsafeFixedGasTracker struct {
+	gasUsed     types.GasUnits
+	msgGasLimit types.GasUnits
+	// gas used by the block's applied messages
+	gasUsedByBlock types.GasUnits
+}
+
+var _ vm.GasTracker = (*UnsafeFixedGasTracker)(nil)
+
+// NewUnsafeFixedGasTracker creates a tracker charging each message exactly gasUsed units.
+func NewUnsafeFixedGasTracker(gasUsed types.GasUnits) *UnsafeFixedGasTracker {
+	return &UnsafeFixedGasTracker{gasUsed: gasUsed}
+}
+
+// WithUnsafeFixedGas makes the processor meter the messages of each block with an
+// UnsafeFixedGasTracker charging gasUsed units, for simulations that need to isolate behaviour
+// from gas costs. It is a simulation option.
+func WithUnsafeFixedGas(gasUsed types.GasUnits) ProcessorOption {
+	return WithGasTrackerFactory(func() vm.GasTracker {
+		return NewUnsafeFixedGasTracker(gasUsed)
+	})
+}
+
+// ResetForNewMessage starts metering message.
+func (t *UnsafeFixedGasTracker) ResetForNewMessage(message *types.UnsignedMessage) {
+	t.msgGasLimit = message.GasLimit
+}
+
+// MessageApplied counts the current message towards the block. The processor calls it once the
+// message is applied, so messages rejected from the block use none of its gas.
+func (t *UnsafeFixedGasTracker) MessageApplied() {
+	t.gasUsedByBlock += t.gasUsed
+}
+
+// Charge ignores cost.
+func (t *UnsafeFixedGasTracker) Charge(cost types.GasUnits) error {
+	return nil
+}
+
+// MessageGasLimit returns the gas limit of the current message.
+func (t *UnsafeFixedGasTracker) MessageGasLimit() types.GasUnits {
+	return t.msgGasLimit
+}
+
+// GasAboveBlockLimit returns true if the current message's limit is above the block gas limit.
+func (t *UnsafeFixedGasTracker) GasAboveBlockLimit() bool {
+	return t.msgGasLimit > types.BlockGasLimit
+}
+
+// GasTooHighForCurrentBlock returns true if the current message's limit would not fit in the
+// gas left by the messages applied before it.
+func (t *UnsafeFixedGasTracker) GasTooHighForCurrentBlock() bool {
+	return t.msgGasLimit+t.gasUsedByBlock > types.BlockGasLimit
+}
+
+// GasConsumedByMessage returns the fixed gas used by every message.
+func (t *UnsafeFixedGasTracker) GasConsumedByMessage() types.GasUnits {
+	return t.gasUsed
+}
+
+// GasConsumedByBlock returns the gas used by the block's applied messages.
+func (t *UnsafeFixedGasTracker) GasConsumedByBlock() types.GasUnits {
+	return t.gasUsedByBlock
+}
+
+// ReceiptStable reports whether msg would produce the same receipt when applied to stateA as
+// when applied to stateB, e.g. the states at the heads of two competing chains. Neither state
+// is changed.
+func (p *DefaultProcessor) ReceiptStable(ctx context.Context, msg *types.UnsignedMessage, stateA, stateB state.Tree, vms vm.StorageMap, bh *types.BlockHeight) (bool, error) {
+	receiptA, err := p.speculativeReceipt(ctx, stateA, vms, msg, bh)
+	if err != nil {
+		return false, err
+	}
+	receiptB, err := p.speculativeReceipt(ctx, stateB, vms, msg, bh)
+	if err != nil {
+		return false, err
+	}
+	return receiptsEqual(receiptA, receiptB), nil
+}
+
+// WouldAffect reports whether applying msg to st would change the head, balance or nonce of the
+// actor target names, or create it. Only the execution of the message counts: the sender's
+// nonce increment and gas payment, which every applied message makes, do not. A message that
+// would fail to apply, or whose execution reverts, affects nothing. st is not changed.
+func (p *DefaultProcessor) WouldAffect(ctx context.Context, st state.Tree, vms vm.StorageMap, msg *types.UnsignedMessage, target address.Address, bh *types.BlockHeight) (bool, error) {
+	before, err := p.actorOrNil(ctx, state.NewCachedTree(st), vms, target)
+	if err != nil {
+		return false, err
+	}
+
+	cachedSt := state.NewCachedTree(st)
+	_, err = p.speculative().attemptApplyMessage(ctx, cachedSt, vms, msg, bh, p.newBlockGasTracker(), nil, &ExtendedReceipt{})
+	if errors.IsFault(err) {
+		return false, err
+	} else if err != nil {
+		return false, nil
+	}
+
+	after, err := p.actorOrNil(ctx, cachedSt, vms, target)
+	if err != nil {
+		return false, err
+	}
+	if before == nil || after == nil {
+		return before != after, nil
+	}
+	return !before.Head.Equals(after.Head) || !before.Balance.Equal(after.Balance) || before.CallSeqNum != after.CallSeqNum, nil
+}
+
+// actorOrNil returns the actor addr names in st, or nil if there is none.
+func (p *DefaultProcessor) actorOrNil(ctx context.Context, st *state.CachedTree, vms vm.StorageMap, addr address.Address) (*actor.Actor, error) {
+	act, _, err := lookupActor(ctx, st, vms, p.addressResolver, addr)
+	if _, notFound := AsActorNotFound(err); notFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return act, nil
+}
+
+// speculativeReceipt computes the receipt of applying msg to st without committing any changes.
+func (p *DefaultProcessor) speculativeReceipt(ctx context.Context, st state.Tree, vms vm.StorageMap, msg *types.UnsignedMessage, bh *types.BlockHeight) (*types.MessageReceipt, error) {
+	receipt, err := p.speculative().attemptApplyMessage(ctx, state.NewCachedTree(st), vms, msg, bh, p.newBlockGasTracker(), nil, &ExtendedReceipt{})
+	if errors.IsFault(err) {
+		return nil, err
+	}
+	return receipt, nil
+}
+
+// speculative returns a copy of p for applying messages whose changes are discarded. It neither
+// takes from the method rate limits nor records method coverage.
+func (p *DefaultProcessor) speculative() *DefaultProcessor {
+	sp := *p
+	sp.methodLimits = nil
+	sp.coverage = nil
+	return &sp
+}
+
+func receiptsEqual(a, b *types.MessageReceipt) bool {
+	if a.ExitCode != b.ExitCode || !a.GasAttoFIL.Equal(b.GasAttoFIL) || len(a.Return) != len(b.Return) {
+		return false
+	}
+	for i := range a.Return {
+		if !bytes.Equal(a.Return[i], b.Return[i]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,175 @@
+package consensus_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	. "github.com/filecoin-project/go-filecoin/internal/pkg/consensus"
+	th "github.com/filecoin-project/go-filecoin/internal/pkg/testhelpers"
+	tf "github.com/filecoin-project/go-filecoin/internal/pkg/testhelpers/testflags"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/types"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/actor"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/actor/builtin"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/address"
+)
+
+func TestUnsafeFixedGas(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+	fakeActorCodeCid := types.NewCidForTestGetter()()
+	actors := builtin.NewBuilder().
+		AddAll(builtin.DefaultActors).
+		Add(fakeActorCodeCid, 0, &actor.FakeActor{}).
+		Build()
+	vms := th.VMStorage()
+	addresses, st := setupActorsForGasTest(t, vms, fakeActorCodeCid, 1000)
+	processor := NewConfiguredProcessor(NewDefaultMessageValidator(), &FakeBlockRewarder{}, actors, WithUnsafeFixedGas(types.NewGasUnits(37)))
+
+	// HasReturnValue charges 100 gas units, which is above these messages' limit when metered.
+	gasPrice := types.NewAttoFILFromFIL(1)
+	msgs := []*types.UnsignedMessage{
+		types.NewMeteredMessage(addresses[0], addresses[1], 0, types.ZeroAttoFIL, actor.HasReturnValueID, nil, gasPrice, types.NewGasUnits(50)),
+		types.NewMeteredMessage(addresses[0], addresses[1], 1, types.ZeroAttoFIL, actor.HasReturnValueID, nil, gasPrice, types.NewGasUnits(50)),
+	}
+
+	results, err := processor.ApplyMessagesAndPayRewards(ctx, st, vms, msgs, addresses[3], types.NewBlockHeight(0), nil)
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	for _, result := range results {
+		require.NoError(t, result.Failure)
+		require.NoError(t, result.ExecutionError)
+		assert.Equal(t, uint8(0), result.Receipt.ExitCode)
+		assert.Equal(t, types.NewAttoFILFromFIL(37), result.Receipt.GasAttoFIL)
+		assert.Equal(t, types.NewGasUnits(37), result.Extended.GasUsed)
+	}
+
+	sender, err := st.GetActor(ctx, results[0].Extended.FromAddr)
+	require.NoError(t, err)
+	assert.Equal(t, types.NewAttoFILFromFIL(1000-2*37), sender.Balance)
+}
+
+func TestUnsafeFixedGasTrackerBlockAccounting(t *testing.T) {
+	tf.UnitTest(t)
+
+	tracker := NewUnsafeFixedGasTracker(types.NewGasUnits(37))
+	assert.Equal(t, types.NewGasUnits(0), tracker.GasConsumedByBlock())
+
+	tracker.ResetForNewMessage(&types.UnsignedMessage{GasLimit: types.NewGasUnits(50)})
+	require.NoError(t, tracker.Charge(types.NewGasUnits(1000)))
+	assert.Equal(t, types.NewGasUnits(37), tracker.GasConsumedByMessage())
+	assert.Equal(t, types.NewGasUnits(0), tracker.GasConsumedByBlock())
+	tracker.MessageApplied()
+	assert.Equal(t, types.NewGasUnits(37), tracker.GasConsumedByBlock())
+
+	// A rejected message uses none of the block's gas.
+	tracker.ResetForNewMessage(&types.UnsignedMessage{GasLimit: types.NewGasUnits(50)})
+	assert.Equal(t, types.NewGasUnits(37), tracker.GasConsumedByBlock())
+
+	// The message after them fits only in the gas the first left.
+	tracker.ResetForNewMessage(&types.UnsignedMessage{GasLimit: types.BlockGasLimit - 37})
+	assert.False(t, tracker.GasTooHighForCurrentBlock())
+	tracker.ResetForNewMessage(&types.UnsignedMessage{GasLimit: types.BlockGasLimit - 36})
+	assert.True(t, tracker.GasTooHighForCurrentBlock())
+}
+
+func TestUnsafeFixedGasIgnoresRejectedMessages(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+	fakeActorCodeCid := types.NewCidForTestGetter()()
+	actors := builtin.NewBuilder().
+		AddAll(builtin.DefaultActors).
+		Add(fakeActorCodeCid, 0, &actor.FakeActor{}).
+		Build()
+	vms := th.VMStorage()
+	addresses, st := setupActorsForGasTest(t, vms, fakeActorCodeCid, 1000)
+	processor := NewConfiguredProcessor(NewDefaultMessageValidator(), &FakeBlockRewarder{}, actors, WithUnsafeFixedGas(types.NewGasUnits(37)))
+
+	// The second message is rejected for its nonce, so the third fits in the gas the first left.
+	gasPrice := types.NewGasPrice(1)
+	msgs := []*types.UnsignedMessage{
+		types.NewMeteredMessage(addresses[0], addresses[1], 0, types.ZeroAttoFIL, actor.HasReturnValueID, nil, gasPrice, types.NewGasUnits(50)),
+		types.NewMeteredMessage(addresses[0], addresses[1], 5, types.ZeroAttoFIL, actor.HasReturnValueID, nil, gasPrice, types.NewGasUnits(50)),
+		types.NewMeteredMessage(addresses[0], addresses[1], 1, types.ZeroAttoFIL, actor.HasReturnValueID, nil, gasPrice, types.BlockGasLimit-37),
+	}
+
+	results, err := processor.ApplyMessagesAndPayRewards(ctx, st, vms, msgs, addresses[3], types.NewBlockHeight(0), nil)
+	require.NoError(t, err)
+	require.Len(t, results, 3)
+	assert.NoError(t, results[0].Failure)
+	assert.Error(t, results[1].Failure)
+	assert.False(t, results[1].Applied)
+	assert.NoError(t, results[2].Failure)
+	assert.True(t, results[2].Applied)
+}
+
+func TestReceiptStable(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+	fakeActorCodeCid := types.NewCidForTestGetter()()
+	actors := builtin.NewBuilder().
+		AddAll(builtin.DefaultActors).
+		Add(fakeActorCodeCid, 0, &actor.FakeActor{}).
+		Build()
+	vms := th.VMStorage()
+	processor := NewConfiguredProcessor(NewDefaultMessageValidator(), NewDefaultBlockRewarder(), actors)
+
+	addresses, withRecipient := setupActorsForGasTest(t, vms, fakeActorCodeCid, 1000)
+	_, withoutRecipient := setupActorsForGasTest(t, vms, fakeActorCodeCid, 1000)
+
+	// Only one state has a fake actor behind the recipient's address; in the other, the
+	// message creates an account actor, which has no HasReturnValue method.
+	recipient := address.NewForTestGetter()()
+	_, recipientID := th.RequireInitAccountActor(ctx, t, withRecipient, vms, recipient, types.ZeroAttoFIL)
+	fake := th.RequireNewFakeActorWithTokens(t, vms, recipientID, fakeActorCodeCid, types.ZeroAttoFIL)
+	require.NoError(t, withRecipient.SetActor(ctx, recipientID, fake))
+
+	msg := types.NewMeteredMessage(addresses[0], recipient, 0, types.ZeroAttoFIL, actor.HasReturnValueID, nil, types.NewGasPrice(1), types.NewGasUnits(200))
+
+	stable, err := processor.ReceiptStable(ctx, msg, withRecipient, withRecipient, vms, types.NewBlockHeight(0))
+	require.NoError(t, err)
+	assert.True(t, stable)
+
+	stable, err = processor.ReceiptStable(ctx, msg, withRecipient, withoutRecipient, vms, types.NewBlockHeight(0))
+	require.NoError(t, err)
+	assert.False(t, stable)
+
+	// Neither state was changed.
+	_, err = withoutRecipient.GetActor(ctx, recipientID)
+	assert.Error(t, err)
+}
+
+func TestWouldAffect(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+	fakeActorCodeCid := types.NewCidForTestGetter()()
+	actors := builtin.NewBuilder().
+		AddAll(builtin.DefaultActors).
+		Add(fakeActorCodeCid, 0, &actor.FakeActor{}).
+		Build()
+	vms := th.VMStorage()
+	addresses, st := setupActorsForGasTest(t, vms, fakeActorCodeCid, 1000)
+	processor := NewConfiguredProcessor(NewDefaultMessageValidator(), NewDefaultBlockRewarder(), actors)
+
+	recipient, unrelated := addresses[3], addresses[2]
+	msg := types.NewMeteredMessage(addresses[0], recipient, 0, types.NewAttoFILFromFIL(5), types.SendMethodID, nil, types.NewGasPrice(1), types.NewGasUnits(100))
+
+	affected, err := processor.WouldAffect(ctx, st, vms, msg, recipient, types.NewBlockHeight(0))
+	require.NoError(t, err)
+	assert.True(t, affected)
+
+	affected, err = processor.WouldAffect(ctx, st, vms, msg, unrelated, types.NewBlockHeight(0))
+	require.NoError(t, err)
+	assert.False(t, affected)
+
+	// The transfer was only simulated.
+	recipientActor, err := st.GetActor(ctx, recipient)
+	require.NoError(t, err)
+	assert.True(t, recipientActor.Balance.IsZero(), "recipient balance is %s", recipientActor.Balance)
+}
@@ -0,0 +1,123 @@
+package consensus
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/actor"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/address"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/state"
+)
+
+// ActorState is the serialized state of an actor.
+type ActorState struct {
+	// Actor is the encoded actor record, or nil if there was no actor.
+	Actor []byte
+	// Head is the encoded object at the head of the actor's storage, or nil if it has none.
+	Head []byte
+}
+
+// TouchedActor holds the state of an actor a message touched, before and after the message
+// was applied.
+type TouchedActor struct {
+	// Addr is the address the actor was touched by.
+	Addr address.Address
+	Pre  ActorState
+	Post ActorState
+}
+
+// stateCapture wraps a state tree to record the state of each actor before it is first read or
+// written through it.
+type stateCapture struct {
+	state.Tree
+	vms     vm.StorageMap
+	touched []address.Address
+	pre     map[address.Address]ActorState
+}
+
+func newStateCapture(st state.Tree, vms vm.StorageMap) *stateCapture {
+	return &stateCapture{Tree: st, vms: vms, pre: make(map[address.Address]ActorState)}
+}
+
+// GetActor records the actor's state, if this is its first touch, and reads it.
+func (c *stateCapture) GetActor(ctx context.Context, a address.Address) (*actor.Actor, error) {
+	if err := c.touch(ctx, a); err != nil {
+		return nil, err
+	}
+	return c.Tree.GetActor(ctx, a)
+}
+
+// GetOrCreateActor records the actor's state, if this is its first touch, and reads it or
+// creates it.
+func (c *stateCapture) GetOrCreateActor(ctx context.Context, a address.Address, creator func() (*actor.Actor, address.Address, error)) (*actor.Actor, address.Address, error) {
+	if err := c.touch(ctx, a); err != nil {
+		return nil, address.Undef, err
+	}
+	return c.Tree.GetOrCreateActor(ctx, a, creator)
+}
+
+// SetActor records the actor's state, if this is its first touch, and overwrites it.
+func (c *stateCapture) SetActor(ctx context.Context, a address.Address, act *actor.Actor) error {
+	if err := c.touch(ctx, a); err != nil {
+		return err
+	}
+	return c.Tree.SetActor(ctx, a, act)
+}
+
+// DeleteActor records the actor's state, if this is its first touch, and deletes it.
+func (c *stateCapture) DeleteActor(ctx context.Context, a address.Address) error {
+	if err := c.touch(ctx, a); err != nil {
+		return err
+	}
+	return c.Tree.DeleteActor(ctx, a)
+}
+
+func (c *stateCapture) touch(ctx context.Context, addr address.Address) error {
+	if _, ok := c.pre[addr]; ok {
+		return nil
+	}
+	s, err := c.actorState(ctx, addr)
+	if err != nil {
+		return err
+	}
+	c.touched = append(c.touched, addr)
+	c.pre[addr] = s
+	return nil
+}
+
+// touchedActors returns the actors touched, in the order they were first touched, with their
+// state before and now.
+func (c *stateCapture) touchedActors(ctx context.Context) ([]TouchedActor, error) {
+	actors := make([]TouchedActor, len(c.touched))
+	for i, addr := range c.touched {
+		post, err := c.actorState(ctx, addr)
+		if err != nil {
+			return nil, err
+		}
+		actors[i] = TouchedActor{Addr: addr, Pre: c.pre[addr], Post: post}
+	}
+	return actors, nil
+}
+
+// actorState serializes the actor at addr in the underlying tree, along with its head.
+func (c *stateCapture) actorState(ctx context.Context, addr address.Address) (ActorState, error) {
+	act, err := c.Tree.GetActor(ctx, addr)
+	if state.IsActorNotFoundError(err) {
+		return ActorState{}, nil
+	} else if err != nil {
+		return ActorState{}, errors.Wrapf(err, "could not get actor %s", addr)
+	}
+
+	var s ActorState
+	if s.Actor, err = act.Marshal(); err != nil {
+		return ActorState{}, errors.Wrapf(err, "could not encode actor %s", addr)
+	}
+	if act.Head.Defined() {
+		if s.Head, err = c.vms.NewStorage(addr, act).Get(act.Head); err != nil {
+			return ActorState{}, errors.Wrapf(err, "could not get head of actor %s", addr)
+		}
+	}
+	return s, nil
+}
@@ -0,0 +1,80 @@
+package consensus_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ipfs/go-hamt-ipld"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	. "github.com/filecoin-project/go-filecoin/internal/pkg/consensus"
+	th "github.com/filecoin-project/go-filecoin/internal/pkg/testhelpers"
+	tf "github.com/filecoin-project/go-filecoin/internal/pkg/testhelpers/testflags"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/types"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/actor"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/actor/builtin"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/address"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/state"
+)
+
+func TestStateCapture(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+	vms := th.VMStorage()
+	st := state.NewTree(hamt.NewCborStore())
+	newAddress := address.NewForTestGetter()
+	from, to := newAddress(), newAddress()
+	_, fromID := th.RequireInitAccountActor(ctx, t, st, vms, from, types.NewAttoFILFromFIL(1000))
+	_, toID := th.RequireInitAccountActor(ctx, t, st, vms, to, types.NewAttoFILFromFIL(10))
+
+	value := types.NewAttoFILFromFIL(50)
+	msg := types.NewMeteredMessage(from, to, 0, value, types.SendMethodID, nil, types.NewGasPrice(1), types.NewGasUnits(100))
+	processor := NewConfiguredProcessor(NewDefaultMessageValidator(), &FakeBlockRewarder{}, builtin.DefaultActors, WithStateCapture())
+	result, err := processor.ApplyMessage(ctx, st, vms, msg, address.TestAddress, types.NewBlockHeight(0), vm.NewLegacyGasTracker(), nil)
+	require.NoError(t, err)
+	require.NoError(t, result.ExecutionError)
+
+	decode := func(s ActorState) *actor.Actor {
+		require.NotNil(t, s.Actor)
+		var act actor.Actor
+		require.NoError(t, act.Unmarshal(s.Actor))
+		return &act
+	}
+	touched := map[address.Address]TouchedActor{}
+	for _, ta := range result.Extended.TouchedActors {
+		touched[ta.Addr] = ta
+	}
+
+	t.Run("sender pays the value and advances its nonce", func(t *testing.T) {
+		ta, ok := touched[fromID]
+		require.True(t, ok)
+		pre, post := decode(ta.Pre), decode(ta.Post)
+		assert.True(t, post.Balance.Equal(pre.Balance.Sub(value)), "balance %s after %s", post.Balance, pre.Balance)
+		assert.Equal(t, pre.CallSeqNum+1, post.CallSeqNum)
+		assert.Equal(t, pre.Code, post.Code)
+		assert.Equal(t, pre.Head, post.Head)
+		assert.Equal(t, ta.Pre.Head, ta.Post.Head)
+	})
+
+	t.Run("recipient receives the value", func(t *testing.T) {
+		ta, ok := touched[toID]
+		require.True(t, ok)
+		pre, post := decode(ta.Pre), decode(ta.Post)
+		assert.True(t, post.Balance.Equal(pre.Balance.Add(value)), "balance %s after %s", post.Balance, pre.Balance)
+		assert.Equal(t, pre.CallSeqNum, post.CallSeqNum)
+		assert.Equal(t, pre.Code, post.Code)
+		assert.Equal(t, pre.Head, post.Head)
+		assert.Equal(t, ta.Pre.Head, ta.Post.Head)
+	})
+
+	t.Run("actors only read are unchanged", func(t *testing.T) {
+		for addr, ta := range touched {
+			if addr != fromID && addr != toID {
+				assert.Equal(t, ta.Pre, ta.Post, "actor %s changed", addr)
+			}
+		}
+	})
+}
@@ -0,0 +1,79 @@
+package consensus
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	"github.com/filecoin-project/go-filecoin/internal/pkg/types"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/actor"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/address"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/state"
+)
+
+// CirculatingSupply returns the FIL in circulation in st: the FIL minted, less what is locked and
+// what was burnt. FIL is minted as block rewards are paid out of the network actor, so the minted
+// supply is everything outside it. Miner balances, which hold pledged collateral, and the payment
+// broker's balance, which funds payment channels, are locked.
+func CirculatingSupply(ctx context.Context, st state.Tree) (types.AttoFIL, error) {
+	supply := types.ZeroAttoFIL
+	err := st.ForEachActor(ctx, func(addr address.Address, act *actor.Actor) error {
+		if !isCirculating(addr, act) {
+			return nil
+		}
+		supply = supply.Add(act.Balance)
+		return nil
+	})
+	if err != nil {
+		return types.ZeroAttoFIL, errors.Wrap(err, "could not walk actors")
+	}
+	return supply, nil
+}
+
+// isCirculating reports whether the balance of act, at addr, is in circulation.
+func isCirculating(addr address.Address, act *actor.Actor) bool {
+	switch addr {
+	case address.LegacyNetworkAddress, address.BurntFundsAddress, address.LegacyPaymentBrokerAddress:
+		return false
+	}
+	return !act.Code.Equals(types.MinerActorCodeCid) && !act.Code.Equals(types.BootstrapMinerActorCodeCid)
+}
+
+// unissuedBalance returns the FIL held by the network actor, which has not been minted yet,
+// and the burnt funds actor, which can no longer be spent. Its decrease over a tipset is the
+// net FIL issued. Missing actors hold nothing.
+func unissuedBalance(ctx context.Context, st state.Tree) (types.AttoFIL, error) {
+	total := types.ZeroAttoFIL
+	for _, addr := range []address.Address{address.LegacyNetworkAddress, address.BurntFundsAddress} {
+		act, err := st.GetActor(ctx, addr)
+		if state.IsActorNotFoundError(err) {
+			continue
+		} else if err != nil {
+			return types.ZeroAttoFIL, errors.Wrapf(err, "could not get actor %s", addr)
+		}
+		total = total.Add(act.Balance)
+	}
+	return total, nil
+}
+
+// ActorBalance returns the balance of the actor addr names in st, read from its actor record
+// rather than by calling a method. It returns an *ErrActorNotFound if there is no such actor.
+func ActorBalance(ctx context.Context, st state.Tree, vms vm.StorageMap, addr address.Address) (types.AttoFIL, error) {
+	return actorBalance(ctx, st, vms, defaultAddressResolver, addr)
+}
+
+// ActorBalance returns what ActorBalance does, with the address handlers the processor was
+// configured with.
+func (p *DefaultProcessor) ActorBalance(ctx context.Context, st state.Tree, vms vm.StorageMap, addr address.Address) (types.AttoFIL, error) {
+	return actorBalance(ctx, st, vms, p.addressResolver, addr)
+}
+
+// actorBalance returns what ActorBalance does, resolving addr with resolver.
+func actorBalance(ctx context.Context, st state.Tree, vms vm.StorageMap, resolver addressResolver, addr address.Address) (types.AttoFIL, error) {
+	act, _, err := lookupActor(ctx, state.NewCachedTree(st), vms, resolver, addr)
+	if err != nil {
+		return types.ZeroAttoFIL, err
+	}
+	return act.Balance, nil
+}
@@ -0,0 +1,73 @@
+package consensus_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ipfs/go-hamt-ipld"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	. "github.com/filecoin-project/go-filecoin/internal/pkg/consensus"
+	th "github.com/filecoin-project/go-filecoin/internal/pkg/testhelpers"
+	tf "github.com/filecoin-project/go-filecoin/internal/pkg/testhelpers/testflags"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/types"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/actor"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/address"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/state"
+)
+
+func TestCirculatingSupply(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+	st := state.NewTree(hamt.NewCborStore())
+	newAddress := address.NewForTestGetter()
+
+	actors := map[address.Address]*actor.Actor{
+		address.LegacyNetworkAddress:       th.RequireNewAccountActor(t, types.NewAttoFILFromFIL(1000)),
+		address.BurntFundsAddress:          th.RequireNewAccountActor(t, types.NewAttoFILFromFIL(10)),
+		address.LegacyPaymentBrokerAddress: actor.NewActor(types.PaymentBrokerActorCodeCid, types.NewAttoFILFromFIL(20)),
+		newAddress():                       actor.NewActor(types.MinerActorCodeCid, types.NewAttoFILFromFIL(50)),
+		newAddress():                       th.RequireNewAccountActor(t, types.NewAttoFILFromFIL(100)),
+		newAddress():                       th.RequireNewAccountActor(t, types.NewAttoFILFromFIL(200)),
+	}
+	for addr, act := range actors {
+		require.NoError(t, st.SetActor(ctx, addr, act))
+	}
+
+	// 1380 FIL in total, of which 1000 are unminted, 10 burnt and 70 locked.
+	supply, err := CirculatingSupply(ctx, st)
+	require.NoError(t, err)
+	assert.True(t, supply.Equal(types.NewAttoFILFromFIL(300)), "supply is %s", supply)
+}
+
+func TestActorBalance(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+	vms := th.VMStorage()
+	st := state.NewTree(hamt.NewCborStore())
+	newAddress := address.NewForTestGetter()
+
+	addr := newAddress()
+	_, idAddr := th.RequireInitAccountActor(ctx, t, st, vms, addr, types.NewAttoFILFromFIL(42))
+
+	t.Run("funded account", func(t *testing.T) {
+		balance, err := ActorBalance(ctx, st, vms, addr)
+		require.NoError(t, err)
+		assert.True(t, balance.Equal(types.NewAttoFILFromFIL(42)), "balance is %s", balance)
+
+		balance, err = ActorBalance(ctx, st, vms, idAddr)
+		require.NoError(t, err)
+		assert.True(t, balance.Equal(types.NewAttoFILFromFIL(42)), "balance is %s", balance)
+	})
+
+	t.Run("nonexistent address", func(t *testing.T) {
+		missing := newAddress()
+		_, err := ActorBalance(ctx, st, vms, missing)
+		notFound, ok := AsActorNotFound(err)
+		require.True(t, ok, "error is %v", err)
+		assert.Equal(t, missing, notFound.Addr)
+	})
+}
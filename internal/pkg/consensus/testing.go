@@ -141,11 +141,7 @@ func (tbr *FakeBlockRewarder) GasReward(ctx context.Context, st state.Tree, vms
 
 // NewFakeProcessor creates a processor with a test validator and test rewarder
 func NewFakeProcessor(actors builtin.Actors) *DefaultProcessor {
-	return &DefaultProcessor{
-		validator:     &FakeMessageValidator{},
-		blockRewarder: &FakeBlockRewarder{},
-		actors:        actors,
-	}
+	return NewConfiguredProcessor(&FakeMessageValidator{}, &FakeBlockRewarder{}, actors)
 }
 
 // FakeElectionMachine generates fake election proofs and verifies all proofs
@@ -0,0 +1,110 @@
+package consensus
+
+import (
+	"github.com/ipfs/go-cid"
+
+	"github.com/filecoin-project/go-filecoin/internal/pkg/encoding"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/types"
+)
+
+// BlockResult holds the results of applying the messages of one block of a tipset.
+type BlockResult struct {
+	// Block is the cid of the block.
+	Block cid.Cid
+	// Results holds the result of each message of the block that was not a duplicate of a
+	// message in an earlier block, in order.
+	Results []*ApplyMessageResult
+	// GasUsed is the total gas consumed by the block's successfully applied messages.
+	GasUsed types.GasUnits
+}
+
+func newBlockResult(blk cid.Cid, results []*ApplyMessageResult) *BlockResult {
+	br := &BlockResult{Block: blk, Results: results, GasUsed: types.NewGasUnits(0)}
+	for _, r := range results {
+		if r.Failure == nil && r.Extended != nil {
+			br.GasUsed += r.Extended.GasUsed
+		}
+		r.CumulativeGasUsed = br.GasUsed
+	}
+	return br
+}
+
+// TipSetResult holds the results of applying the messages of a tipset, by block.
+type TipSetResult struct {
+	// Blocks holds a result for each block, in the order the blocks were applied.
+	Blocks []*BlockResult
+	// ProtocolVersion is the protocol version whose actor code processed the tipset.
+	ProtocolVersion uint64
+	// SupplyDelta is the net change in the supply of FIL over the tipset: the FIL minted
+	// paying block rewards out of the network actor, less the FIL burnt. It is negative if
+	// more was burnt than minted.
+	SupplyDelta types.AttoFIL
+}
+
+// Results returns the results of all messages in the tipset, in the order they were applied.
+func (r *TipSetResult) Results() []*ApplyMessageResult {
+	var results []*ApplyMessageResult
+	for _, br := range r.Blocks {
+		results = append(results, br.Results...)
+	}
+	return results
+}
+
+// GasUsed returns the total gas consumed by the messages of the tipset.
+func (r *TipSetResult) GasUsed() types.GasUnits {
+	total := types.NewGasUnits(0)
+	for _, br := range r.Blocks {
+		total += br.GasUsed
+	}
+	return total
+}
+
+// HeaviestBlock returns the index of the block whose messages used the most gas, or -1 if
+// the tipset has no blocks.
+func (r *TipSetResult) HeaviestBlock() int {
+	heaviest := -1
+	for i, br := range r.Blocks {
+		if heaviest < 0 || br.GasUsed > r.Blocks[heaviest].GasUsed {
+			heaviest = i
+		}
+	}
+	return heaviest
+}
+
+// SuccessfulReceipts returns, in order, the receipts of the results whose message was applied
+// and exited with code zero.
+func SuccessfulReceipts(results []*ApplyMessageResult) []*types.MessageReceipt {
+	var receipts []*types.MessageReceipt
+	for _, r := range results {
+		if r.Failure == nil && r.Receipt != nil && r.Receipt.ExitCode == 0 {
+			receipts = append(receipts, r.Receipt)
+		}
+	}
+	return receipts
+}
+
+// ReceiptsDigest returns a cid committing to the receipts of results in order, so that nodes
+// can check they processed a tipset identically without exchanging its receipts. Results of
+// messages that were not applied, and so have no receipt, are skipped.
+func ReceiptsDigest(results []*ApplyMessageResult) cid.Cid {
+	receipts := []*types.MessageReceipt{}
+	for _, r := range results {
+		if r.Receipt != nil {
+			receipts = append(receipts, r.Receipt)
+		}
+	}
+	raw, err := encoding.Encode(receipts)
+	if err != nil {
+		panic(err)
+	}
+	c, err := cid.Prefix{
+		Version:  1,
+		Codec:    cid.DagCBOR,
+		MhType:   types.DefaultHashFunction,
+		MhLength: -1,
+	}.Sum(raw)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
@@ -0,0 +1,155 @@
+package consensus
+
+import (
+	"github.com/ipfs/go-cid"
+	"github.com/pkg/errors"
+
+	"github.com/filecoin-project/go-filecoin/internal/pkg/encoding"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/types"
+)
+
+func init() {
+	encoding.RegisterIpldCborType(encodedTipSetResult{})
+	encoding.RegisterIpldCborType(encodedBlockResult{})
+	encoding.RegisterIpldCborType(encodedMessageResult{})
+	encoding.RegisterIpldCborType(GasBreakdown{})
+}
+
+// TipSetResultVersion is the version of the encoding EncodeTipSetResult produces.
+// DecodeTipSetResult decodes encodings of this and every earlier version. Fields added to the
+// encoding must decode to a sensible value when absent, and bump the version.
+const TipSetResultVersion uint64 = 1
+
+// encodedTipSetResult is the persisted form of a TipSetResult.
+type encodedTipSetResult struct {
+	Version         uint64
+	ProtocolVersion uint64
+	Blocks          []encodedBlockResult
+}
+
+type encodedBlockResult struct {
+	Block   cid.Cid
+	GasUsed types.GasUnits
+	Results []encodedMessageResult
+}
+
+// encodedMessageResult is the persisted form of an ApplyMessageResult. Errors are kept by
+// their message only, and of the extended receipt only the gas used and its breakdown.
+type encodedMessageResult struct {
+	MessageCid         cid.Cid
+	Receipt            *types.MessageReceipt
+	ExecutionError     string
+	ValueTransferred   types.AttoFIL
+	Failure            string
+	FailureIsPermanent bool
+	FailureReason      FailureReason
+	CumulativeGasUsed  types.GasUnits
+	HasExtended        bool
+	GasUsed            types.GasUnits
+	GasBreakdown       GasBreakdown
+}
+
+// EncodeTipSetResult encodes r for persistence, tagged with TipSetResultVersion. The encoding
+// is deterministic: equal results encode to the same bytes.
+func EncodeTipSetResult(r *TipSetResult) ([]byte, error) {
+	enc := encodedTipSetResult{
+		Version:         TipSetResultVersion,
+		ProtocolVersion: r.ProtocolVersion,
+		Blocks:          make([]encodedBlockResult, len(r.Blocks)),
+	}
+	for i, br := range r.Blocks {
+		enc.Blocks[i] = encodedBlockResult{
+			Block:   br.Block,
+			GasUsed: br.GasUsed,
+			Results: make([]encodedMessageResult, len(br.Results)),
+		}
+		for j, res := range br.Results {
+			enc.Blocks[i].Results[j] = encodeMessageResult(res)
+		}
+	}
+	return encoding.Encode(enc)
+}
+
+// DecodeTipSetResult decodes a result encoded by EncodeTipSetResult, of this or an earlier
+// version. Errors are restored with their message but not their type.
+func DecodeTipSetResult(raw []byte) (*TipSetResult, error) {
+	var enc encodedTipSetResult
+	if err := encoding.Decode(raw, &enc); err != nil {
+		return nil, errors.Wrap(err, "could not decode tipset result")
+	}
+	if enc.Version > TipSetResultVersion {
+		return nil, errors.Errorf("tipset result encoding version %d is newer than supported version %d", enc.Version, TipSetResultVersion)
+	}
+
+	r := &TipSetResult{
+		ProtocolVersion: enc.ProtocolVersion,
+		Blocks:          make([]*BlockResult, len(enc.Blocks)),
+	}
+	for i, eb := range enc.Blocks {
+		br := &BlockResult{
+			Block:   eb.Block,
+			GasUsed: eb.GasUsed,
+			Results: make([]*ApplyMessageResult, len(eb.Results)),
+		}
+		for j, er := range eb.Results {
+			br.Results[j] = decodeMessageResult(er)
+		}
+		r.Blocks[i] = br
+	}
+	return r, nil
+}
+
+func encodeMessageResult(r *ApplyMessageResult) encodedMessageResult {
+	enc := encodedMessageResult{
+		MessageCid:         r.MessageCid,
+		Receipt:            r.Receipt,
+		ExecutionError:     errorMessage(r.ExecutionError),
+		ValueTransferred:   r.ValueTransferred,
+		Failure:            errorMessage(r.Failure),
+		FailureIsPermanent: r.FailureIsPermanent,
+		FailureReason:      r.FailureReason,
+		CumulativeGasUsed:  r.CumulativeGasUsed,
+	}
+	if r.Extended != nil {
+		enc.HasExtended = true
+		enc.GasUsed = r.Extended.GasUsed
+		enc.GasBreakdown = r.Extended.GasBreakdown
+	}
+	return enc
+}
+
+func decodeMessageResult(enc encodedMessageResult) *ApplyMessageResult {
+	r := &ApplyMessageResult{
+		ApplicationResult: ApplicationResult{
+			Receipt:          enc.Receipt,
+			ExecutionError:   messageError(enc.ExecutionError),
+			MessageCid:       enc.MessageCid,
+			ValueTransferred: enc.ValueTransferred,
+		},
+		Failure:            messageError(enc.Failure),
+		FailureIsPermanent: enc.FailureIsPermanent,
+		FailureReason:      enc.FailureReason,
+		CumulativeGasUsed:  enc.CumulativeGasUsed,
+		// Only messages that failed to apply leave the nonce unconsumed.
+		Applied:       enc.Failure == "",
+		NonceConsumed: enc.Failure == "",
+	}
+	if enc.HasExtended {
+		r.Extended = &ExtendedReceipt{GasUsed: enc.GasUsed, GasBreakdown: enc.GasBreakdown}
+	}
+	return r
+}
+
+func errorMessage(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+func messageError(msg string) error {
+	if msg == "" {
+		return nil
+	}
+	return errors.New(msg)
+}
@@ -0,0 +1,137 @@
+package consensus_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	. "github.com/filecoin-project/go-filecoin/internal/pkg/consensus"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/encoding"
+	tf "github.com/filecoin-project/go-filecoin/internal/pkg/testhelpers/testflags"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/types"
+)
+
+// priorTipSetResult has the shape of an earlier encoding, which carries fewer fields than the
+// current one.
+type priorTipSetResult struct {
+	Version uint64
+	Blocks  []priorBlockResult
+}
+
+type priorBlockResult struct {
+	Block   cid.Cid
+	GasUsed types.GasUnits
+	Results []priorMessageResult
+}
+
+type priorMessageResult struct {
+	MessageCid cid.Cid
+	Receipt    *types.MessageReceipt
+}
+
+func init() {
+	encoding.RegisterIpldCborType(priorTipSetResult{})
+	encoding.RegisterIpldCborType(priorBlockResult{})
+	encoding.RegisterIpldCborType(priorMessageResult{})
+}
+
+func TestTipSetResultEncoding(t *testing.T) {
+	tf.UnitTest(t)
+
+	newCid := types.NewCidForTestGetter()
+	blkCid, applied, failed := newCid(), newCid(), newCid()
+	receipt := &types.MessageReceipt{ExitCode: 0, Return: [][]byte{{1, 2}}, GasAttoFIL: types.NewAttoFILFromFIL(3)}
+
+	t.Run("round trip", func(t *testing.T) {
+		r := &TipSetResult{
+			ProtocolVersion: 2,
+			Blocks: []*BlockResult{{
+				Block:   blkCid,
+				GasUsed: types.NewGasUnits(100),
+				Results: []*ApplyMessageResult{
+					{
+						ApplicationResult: ApplicationResult{
+							Receipt:          receipt,
+							ExecutionError:   errors.New("reverted"),
+							Extended:         &ExtendedReceipt{GasUsed: types.NewGasUnits(100), GasBreakdown: GasBreakdown{Compute: types.NewGasUnits(100)}},
+							MessageCid:       applied,
+							ValueTransferred: types.NewAttoFILFromFIL(1),
+						},
+						CumulativeGasUsed: types.NewGasUnits(100),
+					},
+					{
+						ApplicationResult: ApplicationResult{MessageCid: failed},
+						Failure:           errors.New("nonce gap"),
+						FailureReason:     FailureNonceGap,
+						CumulativeGasUsed: types.NewGasUnits(100),
+					},
+				},
+			}},
+		}
+
+		raw, err := EncodeTipSetResult(r)
+		require.NoError(t, err)
+		again, err := EncodeTipSetResult(r)
+		require.NoError(t, err)
+		assert.Equal(t, raw, again)
+
+		decoded, err := DecodeTipSetResult(raw)
+		require.NoError(t, err)
+		assert.Equal(t, uint64(2), decoded.ProtocolVersion)
+		require.Len(t, decoded.Blocks, 1)
+		assert.Equal(t, blkCid, decoded.Blocks[0].Block)
+		assert.Equal(t, types.NewGasUnits(100), decoded.GasUsed())
+
+		results := decoded.Results()
+		require.Len(t, results, 2)
+		assert.Equal(t, applied, results[0].MessageCid)
+		assert.Equal(t, receipt, results[0].Receipt)
+		assert.EqualError(t, results[0].ExecutionError, "reverted")
+		assert.True(t, results[0].ValueTransferred.Equal(types.NewAttoFILFromFIL(1)))
+		require.NotNil(t, results[0].Extended)
+		assert.Equal(t, r.Blocks[0].Results[0].Extended.GasBreakdown, results[0].Extended.GasBreakdown)
+		assert.NoError(t, results[0].Failure)
+		assert.True(t, results[0].Applied)
+		assert.True(t, results[0].NonceConsumed)
+
+		assert.Equal(t, failed, results[1].MessageCid)
+		assert.EqualError(t, results[1].Failure, "nonce gap")
+		assert.Equal(t, FailureNonceGap, results[1].FailureReason)
+		assert.Nil(t, results[1].Extended)
+		assert.False(t, results[1].Applied)
+		assert.False(t, results[1].NonceConsumed)
+		assert.Equal(t, SuccessfulReceipts(r.Results()), SuccessfulReceipts(results))
+	})
+
+	t.Run("decodes a prior version", func(t *testing.T) {
+		raw, err := encoding.Encode(priorTipSetResult{
+			Version: 0,
+			Blocks: []priorBlockResult{{
+				Block:   blkCid,
+				GasUsed: types.NewGasUnits(50),
+				Results: []priorMessageResult{{MessageCid: applied, Receipt: receipt}},
+			}},
+		})
+		require.NoError(t, err)
+
+		decoded, err := DecodeTipSetResult(raw)
+		require.NoError(t, err)
+		assert.Equal(t, uint64(0), decoded.ProtocolVersion)
+		results := decoded.Results()
+		require.Len(t, results, 1)
+		assert.Equal(t, receipt, results[0].Receipt)
+		assert.NoError(t, results[0].Failure)
+		assert.Nil(t, results[0].Extended)
+		assert.Equal(t, types.NewGasUnits(50), decoded.GasUsed())
+	})
+
+	t.Run("rejects a newer version", func(t *testing.T) {
+		raw, err := encoding.Encode(priorTipSetResult{Version: TipSetResultVersion + 1})
+		require.NoError(t, err)
+		_, err = DecodeTipSetResult(raw)
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,141 @@
+package consensus
+
+import (
+	"context"
+	"sort"
+
+	"github.com/ipfs/go-cid"
+	"github.com/pkg/errors"
+
+	"github.com/filecoin-project/go-filecoin/internal/pkg/block"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/types"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/actor"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/address"
+	vmerrors "github.com/filecoin-project/go-filecoin/internal/pkg/vm/errors"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/state"
+)
+
+// UndoRecord holds the state tree changes that revert a post-state to its pre-state.
+// Like a StateDelta, it refers to actor state by cid, so the blocks of the pre-state must
+// still be available when it is applied.
+type UndoRecord struct {
+	// Messages are the cids of the messages whose application is undone.
+	Messages []cid.Cid
+	// Restore holds the pre-state entries of actors that were changed or removed.
+	Restore []ActorEntry
+	// Remove holds the addresses of actors that did not exist in the pre-state.
+	Remove []address.Address
+}
+
+// ApplyMessagesWithUndo applies messages as ApplyMessagesAndPayRewards does and additionally
+// returns the record that reverts st to its state before them. The record is built from the
+// actors the messages wrote, so its cost follows the size of the messages' changes rather
+// than that of the state.
+func (p *DefaultProcessor) ApplyMessagesWithUndo(ctx context.Context, st state.Tree, vms vm.StorageMap,
+	messages []*types.UnsignedMessage, minerOwnerAddr address.Address, bh *types.BlockHeight,
+	ancestors []block.TipSet) ([]*ApplyMessageResult, UndoRecord, error) {
+	recorder := newRecordingTree(st)
+	results, err := p.ApplyMessagesAndPayRewards(ctx, recorder, vms, messages, minerOwnerAddr, bh, ancestors)
+	if err != nil {
+		return nil, UndoRecord{}, err
+	}
+
+	undo, err := newUndoRecord(messages)
+	if err != nil {
+		return nil, UndoRecord{}, err
+	}
+	for _, addr := range recorder.addresses() {
+		after, err := st.GetActor(ctx, addr)
+		if err != nil && !state.IsActorNotFoundError(err) {
+			return nil, UndoRecord{}, vmerrors.FaultErrorWrapf(err, "could not get actor %s", addr)
+		}
+		before, existed := recorder.before[addr]
+		switch {
+		case existed && (after == nil || !sameActor(before, after)):
+			undo.Restore = append(undo.Restore, ActorEntry{Address: addr, Actor: before})
+		case !existed && after != nil:
+			undo.Remove = append(undo.Remove, addr)
+		}
+	}
+	return results, undo, nil
+}
+
+// ComputeUndo records the actor changes needed to revert postState to preState, where
+// postState is the result of applying msgs to preState. Both trees must be flushed.
+// It compares every actor of the two trees, so its cost grows with the size of the state;
+// ApplyMessagesWithUndo records the same changes while applying the messages.
+func ComputeUndo(ctx context.Context, preState, postState state.Tree, msgs []*types.UnsignedMessage) (UndoRecord, error) {
+	undo, err := newUndoRecord(msgs)
+	if err != nil {
+		return UndoRecord{}, err
+	}
+
+	pre, err := actorsByAddress(ctx, preState)
+	if err != nil {
+		return UndoRecord{}, errors.Wrap(err, "could not read pre-state")
+	}
+	post, err := actorsByAddress(ctx, postState)
+	if err != nil {
+		return UndoRecord{}, errors.Wrap(err, "could not read post-state")
+	}
+
+	for addr, before := range pre {
+		if after, ok := post[addr]; !ok || !sameActor(before, after) {
+			undo.Restore = append(undo.Restore, ActorEntry{Address: addr, Actor: before})
+		}
+	}
+	for addr := range post {
+		if _, ok := pre[addr]; !ok {
+			undo.Remove = append(undo.Remove, addr)
+		}
+	}
+
+	sort.Slice(undo.Restore, func(i, j int) bool { return undo.Restore[i].Address.String() < undo.Restore[j].Address.String() })
+	sort.Slice(undo.Remove, func(i, j int) bool { return undo.Remove[i].String() < undo.Remove[j].String() })
+	return undo, nil
+}
+
+func newUndoRecord(msgs []*types.UnsignedMessage) (UndoRecord, error) {
+	var undo UndoRecord
+	for _, msg := range msgs {
+		c, err := msg.Cid()
+		if err != nil {
+			return UndoRecord{}, errors.Wrap(err, "could not get message cid")
+		}
+		undo.Messages = append(undo.Messages, c)
+	}
+	return undo, nil
+}
+
+// ApplyUndo reverts the changes recorded in undo on st, which must hold the post-state the
+// record was computed from.
+func ApplyUndo(ctx context.Context, st state.Tree, undo UndoRecord) error {
+	for _, addr := range undo.Remove {
+		if err := st.DeleteActor(ctx, addr); err != nil {
+			return errors.Wrapf(err, "could not remove actor %s", addr)
+		}
+	}
+	for _, entry := range undo.Restore {
+		if err := st.SetActor(ctx, entry.Address, entry.Actor); err != nil {
+			return errors.Wrapf(err, "could not restore actor %s", entry.Address)
+		}
+	}
+	return nil
+}
+
+func actorsByAddress(ctx context.Context, st state.Tree) (map[address.Address]*actor.Actor, error) {
+	actors := map[address.Address]*actor.Actor{}
+	err := st.ForEachActor(ctx, func(addr address.Address, act *actor.Actor) error {
+		actors[addr] = act
+		return nil
+	})
+	return actors, err
+}
+
+func sameActor(a, b *actor.Actor) bool {
+	return a.Code.Equals(b.Code) &&
+		a.Head.Equals(b.Head) &&
+		a.CallSeqNum == b.CallSeqNum &&
+		a.Balance.Equal(b.Balance)
+}
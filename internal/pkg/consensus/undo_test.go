@@ -0,0 +1,103 @@
+package consensus_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	. "github.com/filecoin-project/go-filecoin/internal/pkg/consensus"
+	th "github.com/filecoin-project/go-filecoin/internal/pkg/testhelpers"
+	tf "github.com/filecoin-project/go-filecoin/internal/pkg/testhelpers/testflags"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/types"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/actor"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/actor/builtin"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/address"
+)
+
+func TestComputeUndo(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+	fakeActorCodeCid := types.NewCidForTestGetter()()
+	actors := builtin.NewBuilder().
+		AddAll(builtin.DefaultActors).
+		Add(fakeActorCodeCid, 0, &actor.FakeActor{}).
+		Build()
+	processor := NewConfiguredProcessor(NewDefaultMessageValidator(), NewDefaultBlockRewarder(), actors)
+
+	_, pre := setupActorsForGasTest(t, th.VMStorage(), fakeActorCodeCid, 1000)
+	vms := th.VMStorage()
+	addresses, post := setupActorsForGasTest(t, vms, fakeActorCodeCid, 1000)
+
+	// The first message creates an account actor for its recipient.
+	newAddr := address.NewForTestGetter()()
+	msgs := []*types.UnsignedMessage{
+		types.NewMeteredMessage(addresses[0], newAddr, 0, types.NewAttoFILFromFIL(10), types.SendMethodID, nil, types.NewGasPrice(1), types.NewGasUnits(300)),
+		types.NewMeteredMessage(addresses[0], addresses[1], 1, types.NewAttoFILFromFIL(5), actor.HasReturnValueID, nil, types.NewGasPrice(1), types.NewGasUnits(300)),
+	}
+	results, err := processor.ApplyMessagesAndPayRewards(ctx, post, vms, msgs, addresses[3], types.NewBlockHeight(0), nil)
+	require.NoError(t, err)
+	for _, r := range results {
+		require.NoError(t, r.Failure)
+	}
+
+	preRoot, err := pre.Flush(ctx)
+	require.NoError(t, err)
+	postRoot, err := post.Flush(ctx)
+	require.NoError(t, err)
+	require.NotEqual(t, preRoot, postRoot)
+
+	undo, err := ComputeUndo(ctx, pre, post, msgs)
+	require.NoError(t, err)
+	assert.Len(t, undo.Messages, 2)
+	assert.Len(t, undo.Remove, 1)
+
+	require.NoError(t, ApplyUndo(ctx, post, undo))
+	undoneRoot, err := post.Flush(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, preRoot, undoneRoot)
+}
+
+func TestApplyMessagesWithUndo(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+	fakeActorCodeCid := types.NewCidForTestGetter()()
+	actors := builtin.NewBuilder().
+		AddAll(builtin.DefaultActors).
+		Add(fakeActorCodeCid, 0, &actor.FakeActor{}).
+		Build()
+	processor := NewConfiguredProcessor(NewDefaultMessageValidator(), NewDefaultBlockRewarder(), actors)
+
+	_, pre := setupActorsForGasTest(t, th.VMStorage(), fakeActorCodeCid, 1000)
+	vms := th.VMStorage()
+	addresses, post := setupActorsForGasTest(t, vms, fakeActorCodeCid, 1000)
+
+	newAddr := address.NewForTestGetter()()
+	msgs := []*types.UnsignedMessage{
+		types.NewMeteredMessage(addresses[0], newAddr, 0, types.NewAttoFILFromFIL(10), types.SendMethodID, nil, types.NewGasPrice(1), types.NewGasUnits(300)),
+		types.NewMeteredMessage(addresses[0], addresses[1], 1, types.NewAttoFILFromFIL(5), actor.HasReturnValueID, nil, types.NewGasPrice(1), types.NewGasUnits(300)),
+	}
+	results, undo, err := processor.ApplyMessagesWithUndo(ctx, post, vms, msgs, addresses[3], types.NewBlockHeight(0), nil)
+	require.NoError(t, err)
+	for _, r := range results {
+		require.NoError(t, r.Failure)
+	}
+
+	preRoot, err := pre.Flush(ctx)
+	require.NoError(t, err)
+	_, err = post.Flush(ctx)
+	require.NoError(t, err)
+
+	// the record matches the one computed by comparing the whole trees
+	computed, err := ComputeUndo(ctx, pre, post, msgs)
+	require.NoError(t, err)
+	assert.Equal(t, computed, undo)
+
+	require.NoError(t, ApplyUndo(ctx, post, undo))
+	undoneRoot, err := post.Flush(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, preRoot, undoneRoot)
+}
@@ -2,8 +2,11 @@ package consensus
 
 import (
 	"context"
+	"fmt"
 	"math/big"
 
+	"github.com/ipfs/go-cid"
+
 	"github.com/filecoin-project/go-filecoin/internal/pkg/config"
 	"github.com/filecoin-project/go-filecoin/internal/pkg/metrics"
 	"github.com/filecoin-project/go-filecoin/internal/pkg/types"
@@ -27,76 +30,192 @@ func init() {
 	errNonceTooHighCt = metrics.NewInt64Counter("consensus/msg_nonce_high_err", "Number of messages with nonce too high")
 }
 
+// ValidationRule names a check made by a message validator.
+type ValidationRule string
+
+// The rules checked by the message validators.
+const (
+	RuleRecipient   = ValidationRule("recipient")
+	RuleSelfSend    = ValidationRule("self send")
+	RuleGasPrice    = ValidationRule("gas price")
+	RuleSenderActor = ValidationRule("sender actor")
+	RuleValue       = ValidationRule("value")
+	RuleGasLimit    = ValidationRule("gas limit")
+	RuleBalance     = ValidationRule("balance")
+	RuleNonce       = ValidationRule("nonce")
+	RuleSignature   = ValidationRule("signature")
+	RuleNonceGap    = ValidationRule("nonce gap")
+	RuleNoOp        = ValidationRule("no-op")
+	RuleMaxValue    = ValidationRule("max value")
+)
+
+// ValidationError reports the rule that rejected a message along with the underlying error.
+type ValidationError struct {
+	Rule ValidationRule
+	Err  error
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("rejected by %s check: %s", e.Rule, e.Err)
+}
+
+// Cause returns the underlying error.
+func (e *ValidationError) Cause() error {
+	return e.Err
+}
+
+func rejectedBy(rule ValidationRule, err error) error {
+	return &ValidationError{Rule: rule, Err: err}
+}
+
+// validationCause returns the error underlying a validation failure, so that it may be
+// compared with the sentinel errors.
+func validationCause(err error) error {
+	if ve, ok := err.(*ValidationError); ok {
+		return ve.Err
+	}
+	return err
+}
+
 // DefaultMessageValidator validates incoming signed messages.
 type DefaultMessageValidator struct {
 	allowHighNonce bool
+	rejectNoOps    bool
+	senderCodes    map[cid.Cid]bool
+	maxValue       *types.AttoFIL
+}
+
+// MessageValidatorOption configures optional behaviour of a DefaultMessageValidator.
+type MessageValidatorOption func(*DefaultMessageValidator)
+
+// WithNoOpMessagesRejected makes the validator reject messages that transfer no value and call
+// no method with no params, which do nothing but advance the sender's nonce. Such messages are
+// accepted by default, so that senders may use them to skip a nonce.
+func WithNoOpMessagesRejected() MessageValidatorOption {
+	return func(v *DefaultMessageValidator) {
+		v.rejectNoOps = true
+	}
+}
+
+// WithAllowedSenderCodes permits actors with the given code, such as multisig or miner actors,
+// to send messages. Account actors may always send them.
+func WithAllowedSenderCodes(codes ...cid.Cid) MessageValidatorOption {
+	return func(v *DefaultMessageValidator) {
+		if v.senderCodes == nil {
+			v.senderCodes = make(map[cid.Cid]bool)
+		}
+		for _, c := range codes {
+			v.senderCodes[c] = true
+		}
+	}
+}
+
+// WithMaxValue makes the validator reject messages transferring more than max, e.g. to contain
+// bugs in faucets and test scenarios on a sandboxed network. Value transferred by the methods
+// a message calls is not limited.
+func WithMaxValue(max types.AttoFIL) MessageValidatorOption {
+	return func(v *DefaultMessageValidator) {
+		v.maxValue = &max
+	}
 }
 
 // NewDefaultMessageValidator creates a new default validator.
 // A default validator checks for both permanent semantic problems (e.g. invalid signature)
 // as well as temporary conditions which may change (e.g. actor can't cover gas limit).
-func NewDefaultMessageValidator() *DefaultMessageValidator {
-	return &DefaultMessageValidator{}
+func NewDefaultMessageValidator(opts ...MessageValidatorOption) *DefaultMessageValidator {
+	v := &DefaultMessageValidator{}
+	for _, opt := range opts {
+		opt(v)
+	}
+	return v
 }
 
 // NewOutboundMessageValidator creates a new default validator for outbound messages. This
 // validator matches the default behaviour but allows nonces higher than the actor's current nonce
 // (allowing multiple messages to enter the mpool at once).
-func NewOutboundMessageValidator() *DefaultMessageValidator {
-	return &DefaultMessageValidator{allowHighNonce: true}
+func NewOutboundMessageValidator(opts ...MessageValidatorOption) *DefaultMessageValidator {
+	v := NewDefaultMessageValidator(opts...)
+	v.allowHighNonce = true
+	return v
 }
 
 // Validate checks that a message is semantically valid for processing, returning any
 // invalidity as an error.
 func (v *DefaultMessageValidator) Validate(ctx context.Context, msg *types.UnsignedMessage, fromActor *actor.Actor) error {
+	if !isValidRecipient(msg.To) {
+		return rejectedBy(RuleRecipient, errInvalidRecipient)
+	}
+
 	if msg.From == msg.To {
-		return errSelfSend
+		return rejectedBy(RuleSelfSend, errSelfSend)
 	}
 
 	if msg.GasPrice.LessEqual(types.ZeroAttoFIL) {
-		return errGasPriceZero
+		return rejectedBy(RuleGasPrice, errGasPriceZero)
 	}
 
 	// Sender must be an account actor, or an empty actor which will be upgraded to an account actor
-	// when the message is processed.
-	if !(fromActor.Empty() || types.AccountActorCodeCid.Equals(fromActor.Code)) {
-		return errNonAccountActor
+	// when the message is processed, unless its code is configured as allowed to send.
+	if !(fromActor.Empty() || types.AccountActorCodeCid.Equals(fromActor.Code) || v.senderCodes[fromActor.Code]) {
+		return rejectedBy(RuleSenderActor, errNonAccountActor)
 	}
 
 	if msg.Value.IsNegative() {
 		log.Debugf("Cannot transfer negative value: %s from actor: %s", msg.Value.String(), msg.From.String())
 		errNegativeValueCt.Inc(ctx, 1)
-		return errNegativeValue
+		return rejectedBy(RuleValue, errNegativeValue)
+	}
+
+	if v.maxValue != nil && msg.Value.GreaterThan(*v.maxValue) {
+		return rejectedBy(RuleMaxValue, errValueAboveMax)
+	}
+
+	if v.rejectNoOps && isNoOp(msg) {
+		return rejectedBy(RuleNoOp, errNoOpMessage)
 	}
 
 	if msg.GasLimit > types.BlockGasLimit {
 		log.Debugf("Message: %s gas limit from actor: %s above block limit: %s", msg.String(), msg.From.String(), string(types.BlockGasLimit))
 		errGasAboveBlockLimitCt.Inc(ctx, 1)
-		return errGasAboveBlockLimit
+		return rejectedBy(RuleGasLimit, errGasAboveBlockLimit)
 	}
 
 	// Avoid processing messages for actors that cannot pay.
 	if !canCoverGasLimit(msg, fromActor) {
 		log.Debugf("Insufficient funds for message: %s to cover gas limit from actor: %s", msg.String(), msg.From.String())
 		errInsufficientGasCt.Inc(ctx, 1)
-		return errInsufficientGas
+		return rejectedBy(RuleBalance, errInsufficientGas)
 	}
 
 	if msg.CallSeqNum < fromActor.CallSeqNum {
 		log.Debugf("Message: %s nonce lower than actor nonce: %s from actor: %s", msg.String(), fromActor.CallSeqNum, msg.From.String())
 		errNonceTooLowCt.Inc(ctx, 1)
-		return errNonceTooLow
+		return rejectedBy(RuleNonce, errNonceTooLow)
 	}
 
 	if !v.allowHighNonce && msg.CallSeqNum > fromActor.CallSeqNum {
 		log.Debugf("Message: %s nonce greater than actor nonce: %s from actor: %s", msg.String(), fromActor.CallSeqNum, msg.From.String())
 		errNonceTooHighCt.Inc(ctx, 1)
-		return errNonceTooHigh
+		return rejectedBy(RuleNonce, errNonceTooHigh)
 	}
 
 	return nil
 }
 
+// isNoOp checks whether msg does nothing but advance its sender's nonce.
+func isNoOp(msg *types.UnsignedMessage) bool {
+	return msg.Value.IsZero() && msg.Method == types.SendMethodID && len(msg.Params) == 0
+}
+
+// isValidRecipient checks that addr is defined and well formed.
+func isValidRecipient(addr address.Address) bool {
+	if addr.Empty() {
+		return false
+	}
+	_, err := address.NewFromBytes(addr.Bytes())
+	return err == nil
+}
+
 // Check's whether the maximum gas charge + message value is within the actor's balance.
 // Note that this is an imperfect test, since nested messages invoked by this one may transfer
 // more value from the actor's balance.
@@ -105,6 +224,13 @@ func canCoverGasLimit(msg *types.UnsignedMessage, actor *actor.Actor) bool {
 	return maximumGasCharge.LessEqual(actor.Balance.Sub(msg.Value))
 }
 
+// VerifyMessageSignature checks that sig is a signature of msg by from, without applying msg,
+// with the Secp256k1 and BLS schemes of NewSignatureSchemes. Other kinds of address, such as ID
+// addresses, must be resolved to their key address first.
+func VerifyMessageSignature(msg *types.UnsignedMessage, sig types.Signature, from address.Address) error {
+	return defaultSignatureSchemes.Verify(msg, sig, from)
+}
+
 // IngestionValidatorAPI allows the validator to access latest state
 type ingestionValidatorAPI interface {
 	GetActor(context.Context, address.Address) (*actor.Actor, error)
@@ -115,23 +241,40 @@ type IngestionValidator struct {
 	api       ingestionValidatorAPI
 	cfg       *config.MessagePoolConfig
 	validator *DefaultMessageValidator
+	schemes   *SignatureSchemes
+}
+
+// IngestionValidatorOption configures optional behaviour of an IngestionValidator.
+type IngestionValidatorOption func(*IngestionValidator)
+
+// WithSignatureSchemes makes the validator verify signatures with the given schemes, rather
+// than the Secp256k1 and BLS schemes alone.
+func WithSignatureSchemes(schemes *SignatureSchemes) IngestionValidatorOption {
+	return func(v *IngestionValidator) {
+		v.schemes = schemes
+	}
 }
 
 // NewIngestionValidator creates a new validator with an api
-func NewIngestionValidator(api ingestionValidatorAPI, cfg *config.MessagePoolConfig) *IngestionValidator {
-	return &IngestionValidator{
+func NewIngestionValidator(api ingestionValidatorAPI, cfg *config.MessagePoolConfig, opts ...IngestionValidatorOption) *IngestionValidator {
+	v := &IngestionValidator{
 		api:       api,
 		cfg:       cfg,
 		validator: &DefaultMessageValidator{allowHighNonce: true},
+		schemes:   defaultSignatureSchemes,
+	}
+	for _, opt := range opts {
+		opt(v)
 	}
+	return v
 }
 
 // Validate validates the signed message.
 // Errors probably mean the validation failed, but possibly indicate a failure to retrieve state
 func (v *IngestionValidator) Validate(ctx context.Context, smsg *types.SignedMessage) error {
 	// ensure message is properly signed
-	if !smsg.VerifySignature() {
-		return errInvalidSignature
+	if err := v.schemes.Verify(&smsg.Message, smsg.Signature, smsg.Message.From); err != nil {
+		return rejectedBy(RuleSignature, err)
 	}
 
 	// retrieve from actor
@@ -147,7 +290,7 @@ func (v *IngestionValidator) Validate(ctx context.Context, smsg *types.SignedMes
 
 	// check that message nonce is not too high
 	if msg.CallSeqNum > fromActor.CallSeqNum && msg.CallSeqNum-fromActor.CallSeqNum > v.cfg.MaxNonceGap {
-		return errors.NewRevertErrorf("message nonce (%d) is too much greater than actor nonce (%d)", msg.CallSeqNum, fromActor.CallSeqNum)
+		return rejectedBy(RuleNonceGap, errors.NewRevertErrorf("message nonce (%d) is too much greater than actor nonce (%d)", msg.CallSeqNum, fromActor.CallSeqNum))
 	}
 
 	return v.validator.Validate(ctx, &msg, fromActor)
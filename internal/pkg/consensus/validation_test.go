@@ -1,6 +1,7 @@
 package consensus_test
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"testing"
@@ -14,6 +15,7 @@ import (
 	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/actor/builtin/account"
 	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/address"
 
+	"github.com/ipfs/go-cid"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -46,6 +48,13 @@ func TestMessageValidator(t *testing.T) {
 		assert.NoError(t, validator.Validate(ctx, msg, actor))
 	})
 
+	t.Run("undefined recipient fails", func(t *testing.T) {
+		msg := newMessage(t, alice, address.Undef, 100, 5, 1, 0)
+		err := validator.Validate(ctx, msg, actor)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "recipient")
+	})
+
 	t.Run("self send fails", func(t *testing.T) {
 		msg := newMessage(t, alice, alice, 100, 5, 1, 0)
 		assert.Errorf(t, validator.Validate(ctx, msg, actor), "self")
@@ -87,6 +96,22 @@ func TestMessageValidator(t *testing.T) {
 	})
 }
 
+func TestValidationErrorNamesRule(t *testing.T) {
+	tf.UnitTest(t)
+
+	actor := newActor(t, 1000, 100)
+	validator := consensus.NewDefaultMessageValidator()
+
+	msg := newMessage(t, addresses[0], addresses[1], 100, 2000, 1, 0) // more value than the balance
+	err := validator.Validate(context.Background(), msg, actor)
+	require.Error(t, err)
+
+	validationErr, ok := err.(*consensus.ValidationError)
+	require.True(t, ok)
+	assert.Equal(t, consensus.RuleBalance, validationErr.Rule)
+	assert.Contains(t, err.Error(), "balance check")
+}
+
 func TestBLSSignatureValidationConfiguration(t *testing.T) {
 	tf.UnitTest(t)
 	ctx := context.Background()
@@ -114,6 +139,88 @@ func TestBLSSignatureValidationConfiguration(t *testing.T) {
 	})
 }
 
+func TestVerifyMessageSignature(t *testing.T) {
+	tf.UnitTest(t)
+
+	blsKey := bls.PrivateKeyGenerate()
+	blsSigner := types.NewMockSigner([]types.KeyInfo{{PrivateKey: blsKey[:], CryptSystem: types.BLS}})
+
+	for name, tc := range map[string]struct {
+		signer types.MockSigner
+		from   address.Address
+	}{
+		"secp": {signer, addresses[0]},
+		"bls":  {blsSigner, blsSigner.Addresses[0]},
+	} {
+		t.Run(name, func(t *testing.T) {
+			smsg, err := types.NewSignedMessage(*newMessage(t, tc.from, addresses[1], 0, 5, 1, 300), tc.signer)
+			require.NoError(t, err)
+			assert.NoError(t, consensus.VerifyMessageSignature(&smsg.Message, smsg.Signature, tc.from))
+
+			tampered := smsg.Message
+			tampered.Value = types.NewAttoFILFromFIL(1000)
+			err = consensus.VerifyMessageSignature(&tampered, smsg.Signature, tc.from)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), "invalid signature")
+		})
+	}
+
+	t.Run("id address", func(t *testing.T) {
+		smsg, err := types.NewSignedMessage(*newMessage(t, addresses[0], addresses[1], 0, 5, 1, 300), signer)
+		require.NoError(t, err)
+		idAddr, err := address.NewIDAddress(100)
+		require.NoError(t, err)
+		assert.Error(t, consensus.VerifyMessageSignature(&smsg.Message, smsg.Signature, idAddr))
+	})
+}
+
+func TestSignatureSchemes(t *testing.T) {
+	tf.UnitTest(t)
+
+	// The mock scheme signs data by reversing it, so the address plays no part.
+	mockSign := func(data []byte) types.Signature {
+		sig := make(types.Signature, len(data))
+		for i, b := range data {
+			sig[len(data)-1-i] = b
+		}
+		return sig
+	}
+	mockVerify := func(data []byte, _ address.Address, sig types.Signature) bool {
+		return bytes.Equal(sig, mockSign(data))
+	}
+
+	from, err := address.NewActorAddress([]byte("mock scheme signer"))
+	require.NoError(t, err)
+	msg := newMessage(t, from, addresses[1], 0, 5, 1, 300)
+	data, err := msg.Marshal()
+	require.NoError(t, err)
+	sig := mockSign(data)
+
+	schemes := consensus.NewSignatureSchemes()
+	schemes.Register(from.Protocol(), mockVerify)
+
+	t.Run("registered scheme verifies its signatures", func(t *testing.T) {
+		assert.NoError(t, schemes.Verify(msg, sig, from))
+
+		tampered := *msg
+		tampered.Value = types.NewAttoFILFromFIL(1000)
+		err := schemes.Verify(&tampered, sig, from)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "invalid signature")
+	})
+
+	t.Run("default schemes still verify", func(t *testing.T) {
+		smsg, err := types.NewSignedMessage(*newMessage(t, addresses[0], addresses[1], 0, 5, 1, 300), signer)
+		require.NoError(t, err)
+		assert.NoError(t, schemes.Verify(&smsg.Message, smsg.Signature, addresses[0]))
+	})
+
+	t.Run("unregistered scheme is rejected", func(t *testing.T) {
+		assert.Error(t, consensus.NewSignatureSchemes().Verify(msg, sig, from))
+		assert.Error(t, consensus.VerifyMessageSignature(msg, sig, from))
+	})
+}
+
 func TestOutboundMessageValidator(t *testing.T) {
 	tf.UnitTest(t)
 
@@ -132,6 +239,63 @@ func TestOutboundMessageValidator(t *testing.T) {
 	})
 }
 
+func TestNoOpMessageValidation(t *testing.T) {
+	tf.UnitTest(t)
+
+	actor := newActor(t, 1000, 100)
+	ctx := context.Background()
+	noOp := types.NewMeteredMessage(addresses[0], addresses[1], 100, types.ZeroAttoFIL, types.SendMethodID, nil, types.NewGasPrice(1), types.NewGasUnits(0))
+
+	t.Run("accepted by default", func(t *testing.T) {
+		assert.NoError(t, consensus.NewDefaultMessageValidator().Validate(ctx, noOp, actor))
+	})
+
+	t.Run("rejected when configured", func(t *testing.T) {
+		validator := consensus.NewDefaultMessageValidator(consensus.WithNoOpMessagesRejected())
+		err := validator.Validate(ctx, noOp, actor)
+		require.Error(t, err)
+		validationErr, ok := err.(*consensus.ValidationError)
+		require.True(t, ok)
+		assert.Equal(t, consensus.RuleNoOp, validationErr.Rule)
+
+		// Messages transferring value or calling a method are not no-ops.
+		assert.NoError(t, validator.Validate(ctx, newMessage(t, addresses[0], addresses[1], 100, 5, 1, 0), actor))
+		assert.NoError(t, validator.Validate(ctx, newMessage(t, addresses[0], addresses[1], 100, 0, 1, 0), actor))
+	})
+}
+
+func TestAllowedSenderCodes(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+	multisigCode := types.CidFromString(t, "multisig")
+	msg := newMessage(t, addresses[0], addresses[1], 100, 5, 1, 0)
+	senderWithCode := func(code cid.Cid) *actor.Actor {
+		sender := newActor(t, 1000, 100)
+		sender.Code = code
+		return sender
+	}
+	validator := consensus.NewDefaultMessageValidator(consensus.WithAllowedSenderCodes(multisigCode))
+
+	t.Run("account sender is always allowed", func(t *testing.T) {
+		assert.NoError(t, consensus.NewDefaultMessageValidator().Validate(ctx, msg, senderWithCode(types.AccountActorCodeCid)))
+		assert.NoError(t, validator.Validate(ctx, msg, senderWithCode(types.AccountActorCodeCid)))
+	})
+
+	t.Run("multisig sender is allowed when configured", func(t *testing.T) {
+		assert.Error(t, consensus.NewDefaultMessageValidator().Validate(ctx, msg, senderWithCode(multisigCode)))
+		assert.NoError(t, validator.Validate(ctx, msg, senderWithCode(multisigCode)))
+	})
+
+	t.Run("disallowed code is rejected", func(t *testing.T) {
+		err := validator.Validate(ctx, msg, senderWithCode(types.MinerActorCodeCid))
+		require.Error(t, err)
+		validationErr, ok := err.(*consensus.ValidationError)
+		require.True(t, ok)
+		assert.Equal(t, consensus.RuleSenderActor, validationErr.Rule)
+	})
+}
+
 func TestIngestionValidator(t *testing.T) {
 	tf.UnitTest(t)
 
@@ -180,6 +344,29 @@ func TestIngestionValidator(t *testing.T) {
 	})
 }
 
+func TestMaxValue(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+	actor := newActor(t, 1000, 100)
+	validator := consensus.NewDefaultMessageValidator(consensus.WithMaxValue(attoFil(50)))
+
+	t.Run("transfer at the cap is allowed", func(t *testing.T) {
+		assert.NoError(t, validator.Validate(ctx, newMessage(t, addresses[0], addresses[1], 100, 50, 1, 0), actor))
+	})
+
+	t.Run("transfer above the cap is rejected", func(t *testing.T) {
+		msg := newMessage(t, addresses[0], addresses[1], 100, 51, 1, 0)
+		assert.NoError(t, consensus.NewDefaultMessageValidator().Validate(ctx, msg, actor))
+
+		err := validator.Validate(ctx, msg, actor)
+		require.Error(t, err)
+		validationErr, ok := err.(*consensus.ValidationError)
+		require.True(t, ok)
+		assert.Equal(t, consensus.RuleMaxValue, validationErr.Rule)
+	})
+}
+
 func newActor(t *testing.T, balanceAF int, nonce uint64) *actor.Actor {
 	actor, err := account.NewActor(attoFil(balanceAF))
 	require.NoError(t, err)
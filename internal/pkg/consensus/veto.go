@@ -0,0 +1,40 @@
+package consensus
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/filecoin-project/go-filecoin/internal/pkg/types"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/actor"
+)
+
+// MessageVeto is consulted right before a message is executed, once its sender and recipient
+// actors have been resolved. Returning a non-nil reason vetoes the message, which is then
+// permanently rejected.
+type MessageVeto func(ctx context.Context, msg *types.UnsignedMessage, from, to *actor.Actor) error
+
+// VetoError is the cause of the failure to apply a message vetoed by a MessageVeto.
+type VetoError struct {
+	Reason error
+}
+
+func (e *VetoError) Error() string {
+	return fmt.Sprintf("message vetoed: %s", e.Reason)
+}
+
+// ShouldRevert implements the reverterror interface, as a vetoed message changes no state.
+func (e *VetoError) ShouldRevert() bool {
+	return true
+}
+
+// WithMessageVeto makes the processor consult veto before executing each message.
+func WithMessageVeto(veto MessageVeto) ProcessorOption {
+	return func(p *DefaultProcessor) {
+		p.veto = veto
+	}
+}
+
+func isVetoError(err error) bool {
+	_, ok := err.(*VetoError)
+	return ok
+}
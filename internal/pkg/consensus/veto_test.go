@@ -0,0 +1,61 @@
+package consensus_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	. "github.com/filecoin-project/go-filecoin/internal/pkg/consensus"
+	th "github.com/filecoin-project/go-filecoin/internal/pkg/testhelpers"
+	tf "github.com/filecoin-project/go-filecoin/internal/pkg/testhelpers/testflags"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/types"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/actor"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/actor/builtin"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/errors"
+)
+
+func TestMessageVeto(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+	fakeActorCodeCid := types.NewCidForTestGetter()()
+	actors := builtin.NewBuilder().
+		AddAll(builtin.DefaultActors).
+		Add(fakeActorCodeCid, 0, &actor.FakeActor{}).
+		Build()
+	vms := th.VMStorage()
+	addresses, st := setupActorsForGasTest(t, vms, fakeActorCodeCid, 1000)
+
+	threshold := types.NewAttoFILFromFIL(10)
+	var vetoedRecipientCode []string
+	veto := func(_ context.Context, msg *types.UnsignedMessage, from, to *actor.Actor) error {
+		if msg.Value.GreaterThan(threshold) {
+			vetoedRecipientCode = append(vetoedRecipientCode, to.Code.String())
+			return errors.NewRevertErrorf("value %s above threshold", msg.Value)
+		}
+		return nil
+	}
+	processor := NewConfiguredProcessor(NewDefaultMessageValidator(), &FakeBlockRewarder{}, actors, WithMessageVeto(veto))
+
+	msgs := []*types.UnsignedMessage{
+		types.NewMeteredMessage(addresses[0], addresses[1], 0, types.NewAttoFILFromFIL(1), actor.HasReturnValueID, nil, types.NewGasPrice(1), types.NewGasUnits(200)),
+		types.NewMeteredMessage(addresses[0], addresses[1], 1, types.NewAttoFILFromFIL(50), actor.HasReturnValueID, nil, types.NewGasPrice(1), types.NewGasUnits(200)),
+	}
+	results, err := processor.ApplyMessagesAndPayRewards(ctx, st, vms, msgs, addresses[3], types.NewBlockHeight(0), nil)
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+
+	assert.NoError(t, results[0].Failure)
+
+	require.Error(t, results[1].Failure)
+	assert.True(t, results[1].FailureIsPermanent)
+	assert.Contains(t, results[1].Failure.Error(), "above threshold")
+	assert.Equal(t, []string{fakeActorCodeCid.String()}, vetoedRecipientCode)
+
+	// The vetoed message moved no funds.
+	recipient, err := st.GetActor(ctx, addresses[1])
+	require.NoError(t, err)
+	assert.True(t, types.NewAttoFILFromFIL(101).Equal(recipient.Balance))
+}
@@ -0,0 +1,153 @@
+package testvectors
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"math/big"
+	"os"
+
+	"github.com/ipfs/go-car"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-hamt-ipld"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	"github.com/pkg/errors"
+
+	"github.com/filecoin-project/go-filecoin/internal/pkg/consensus"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/encoding"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/types"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/address"
+	vmerrors "github.com/filecoin-project/go-filecoin/internal/pkg/vm/errors"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/state"
+)
+
+// Result is the outcome of running a vector.
+type Result struct {
+	ID string
+	// Failures describes each postcondition the vector did not meet.
+	Failures []string
+}
+
+// Passed reports whether the vector met all its postconditions.
+func (r *Result) Passed() bool {
+	return len(r.Failures) == 0
+}
+
+// Run applies the messages of v to its pre-state with processor and checks the resulting
+// receipts and state root. Gas is paid to the reward actor, which must be present in the
+// pre-state. An error is returned only if the vector cannot be run at all, such as when it is
+// not a message vector or does not expect a receipt for each of its messages.
+func Run(ctx context.Context, v *TestVector, processor *consensus.DefaultProcessor) (*Result, error) {
+	if v.Class != ClassMessage {
+		return nil, errors.Errorf("unsupported test vector class %q", v.Class)
+	}
+	if len(v.Postconditions.Receipts) != len(v.ApplyMessages) {
+		return nil, errors.Errorf("test vector has %d messages but %d receipts", len(v.ApplyMessages), len(v.Postconditions.Receipts))
+	}
+
+	bs := blockstore.NewBlockstore(datastore.NewMapDatastore())
+	if err := loadCAR(bs, v.CAR); err != nil {
+		return nil, err
+	}
+	vms := vm.NewStorageMap(bs)
+	st, err := state.NewTreeLoader().LoadStateTree(ctx, hamt.CSTFromBstore(bs), v.Preconditions.StateTree.RootCID)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not load pre-state")
+	}
+
+	result := &Result{ID: v.ID()}
+	for i, m := range v.ApplyMessages {
+		var msg types.UnsignedMessage
+		if err := encoding.Decode(m.Bytes, &msg); err != nil {
+			return nil, errors.Wrapf(err, "could not decode message %d", i)
+		}
+
+		epoch := v.Preconditions.Epoch
+		if m.Epoch != nil {
+			epoch = *m.Epoch
+		}
+
+		expected := expectedReceipt(v.Postconditions.Receipts[i], &msg)
+		err := processor.ApplyAndAssert(ctx, st, vms, &msg, address.RewardAddress, types.NewBlockHeight(epoch), expected)
+		if vmerrors.IsFault(err) {
+			return nil, errors.Wrapf(err, "fault applying message %d", i)
+		} else if err != nil {
+			result.Failures = append(result.Failures, fmt.Sprintf("message %d: %s", i, err))
+		}
+	}
+
+	if err := vms.Flush(); err != nil {
+		return nil, errors.Wrap(err, "could not flush actor storage")
+	}
+	root, err := st.Flush(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not flush post-state")
+	}
+	if expected := v.Postconditions.StateTree.RootCID; !root.Equals(expected) {
+		result.Failures = append(result.Failures, fmt.Sprintf("state root: expected %s, got %s", expected, root))
+	}
+	return result, nil
+}
+
+// RunFiles parses and runs each of the vectors in paths, in order. Vectors without an id are
+// reported under their path.
+func RunFiles(ctx context.Context, processor *consensus.DefaultProcessor, paths ...string) ([]*Result, error) {
+	var results []*Result
+	for _, path := range paths {
+		v, err := parseFile(path)
+		if err != nil {
+			return nil, err
+		}
+		result, err := Run(ctx, v, processor)
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not run test vector %s", path)
+		}
+		if result.ID == "" {
+			result.ID = path
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func parseFile(path string) (*TestVector, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	v, err := Parse(f)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not parse test vector %s", path)
+	}
+	return v, nil
+}
+
+func loadCAR(bs blockstore.Blockstore, gzipped []byte) error {
+	r, err := gzip.NewReader(bytes.NewReader(gzipped))
+	if err != nil {
+		return errors.Wrap(err, "could not decompress pre-state")
+	}
+	defer func() { _ = r.Close() }()
+
+	if _, err := car.LoadCar(bs, r); err != nil {
+		return errors.Wrap(err, "could not load pre-state")
+	}
+	return nil
+}
+
+// expectedReceipt converts a vector receipt to the receipt the processor produces. Vectors
+// record the gas used rather than the amount charged, and at most one return value.
+func expectedReceipt(r Receipt, msg *types.UnsignedMessage) *types.MessageReceipt {
+	receipt := &types.MessageReceipt{
+		ExitCode:   r.ExitCode,
+		GasAttoFIL: msg.GasPrice.MulBigInt(new(big.Int).SetUint64(r.GasUsed)),
+	}
+	if len(r.ReturnValue) > 0 {
+		receipt.Return = [][]byte{r.ReturnValue}
+	}
+	return receipt
+}
@@ -0,0 +1,144 @@
+package testvectors_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/ipfs/go-car"
+	carutil "github.com/ipfs/go-car/util"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-hamt-ipld"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-filecoin/internal/pkg/consensus"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/encoding"
+	th "github.com/filecoin-project/go-filecoin/internal/pkg/testhelpers"
+	tf "github.com/filecoin-project/go-filecoin/internal/pkg/testhelpers/testflags"
+	. "github.com/filecoin-project/go-filecoin/internal/pkg/testvectors"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/types"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/address"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/state"
+)
+
+// fixtureVector builds a vector transferring funds between two accounts, recording the
+// receipt and post-state root the processor produces for it.
+func fixtureVector(t *testing.T) *TestVector {
+	ctx := context.Background()
+	bs := blockstore.NewBlockstore(datastore.NewMapDatastore())
+	vms := vm.NewStorageMap(bs)
+	st := state.NewTree(hamt.CSTFromBstore(bs))
+
+	newAddress := address.NewForTestGetter()
+	from, to := newAddress(), newAddress()
+	th.RequireInitAccountActor(ctx, t, st, vms, from, types.NewAttoFILFromFIL(1000))
+	th.RequireInitAccountActor(ctx, t, st, vms, to, types.ZeroAttoFIL)
+	require.NoError(t, st.SetActor(ctx, address.RewardAddress, th.RequireNewAccountActor(t, types.ZeroAttoFIL)))
+	preRoot, err := st.Flush(ctx)
+	require.NoError(t, err)
+	preState := exportCAR(t, bs, preRoot)
+
+	msg := types.NewMeteredMessage(from, to, 0, types.NewAttoFILFromFIL(10), types.SendMethodID, nil, types.NewGasPrice(1), types.NewGasUnits(300))
+	msgBytes, err := encoding.Encode(msg)
+	require.NoError(t, err)
+
+	result, err := consensus.NewDefaultProcessor().ApplyMessage(ctx, st, vms, msg, address.RewardAddress, types.NewBlockHeight(1), vm.NewLegacyGasTracker(), nil)
+	require.NoError(t, err)
+	require.NoError(t, vms.Flush())
+	postRoot, err := st.Flush(ctx)
+	require.NoError(t, err)
+
+	var ret []byte
+	if len(result.Receipt.Return) > 0 {
+		ret = result.Receipt.Return[0]
+	}
+	return &TestVector{
+		Class:         ClassMessage,
+		Meta:          &Metadata{ID: "transfer-between-accounts"},
+		CAR:           preState,
+		Preconditions: Preconditions{Epoch: 1, StateTree: StateTree{RootCID: preRoot}},
+		ApplyMessages: []Message{{Bytes: msgBytes}},
+		Postconditions: Postconditions{
+			StateTree: StateTree{RootCID: postRoot},
+			Receipts: []Receipt{{
+				ExitCode:    result.Receipt.ExitCode,
+				ReturnValue: ret,
+				GasUsed:     result.Receipt.GasAttoFIL.AsBigInt().Uint64(),
+			}},
+		},
+	}
+}
+
+// exportCAR writes every block in bs to a gzipped CAR file rooted at root.
+func exportCAR(t *testing.T, bs blockstore.Blockstore, root cid.Cid) []byte {
+	var buf bytes.Buffer
+	out := gzip.NewWriter(&buf)
+
+	header, err := encoding.Encode(car.CarHeader{Roots: []cid.Cid{root}, Version: 1})
+	require.NoError(t, err)
+	require.NoError(t, carutil.LdWrite(out, header))
+
+	keys, err := bs.AllKeysChan(context.Background())
+	require.NoError(t, err)
+	for c := range keys {
+		blk, err := bs.Get(c)
+		require.NoError(t, err)
+		require.NoError(t, carutil.LdWrite(out, c.Bytes(), blk.RawData()))
+	}
+	require.NoError(t, out.Close())
+	return buf.Bytes()
+}
+
+// roundTrip encodes a vector to JSON and parses it back.
+func roundTrip(t *testing.T, v *TestVector) *TestVector {
+	raw, err := json.Marshal(v)
+	require.NoError(t, err)
+	parsed, err := Parse(bytes.NewReader(raw))
+	require.NoError(t, err)
+	return parsed
+}
+
+func TestRun(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+
+	t.Run("fixture vector passes", func(t *testing.T) {
+		result, err := Run(ctx, roundTrip(t, fixtureVector(t)), consensus.NewDefaultProcessor())
+		require.NoError(t, err)
+		assert.Equal(t, "transfer-between-accounts", result.ID)
+		assert.True(t, result.Passed(), "failures: %v", result.Failures)
+	})
+
+	t.Run("mismatching receipt and post-state fail", func(t *testing.T) {
+		v := fixtureVector(t)
+		v.Postconditions.Receipts[0].ExitCode = 1
+		v.Postconditions.StateTree.RootCID = v.Preconditions.StateTree.RootCID
+
+		result, err := Run(ctx, roundTrip(t, v), consensus.NewDefaultProcessor())
+		require.NoError(t, err)
+		assert.False(t, result.Passed())
+		assert.Len(t, result.Failures, 2)
+	})
+
+	t.Run("missing receipts cannot be run", func(t *testing.T) {
+		v := fixtureVector(t)
+		v.Postconditions.Receipts = nil
+
+		_, err := Run(ctx, v, consensus.NewDefaultProcessor())
+		assert.Error(t, err)
+	})
+}
+
+func TestParseRejectsUnsupportedClass(t *testing.T) {
+	tf.UnitTest(t)
+
+	_, err := Parse(bytes.NewReader([]byte(`{"class": "tipset"}`)))
+	assert.Error(t, err)
+}
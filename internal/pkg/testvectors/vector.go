@@ -0,0 +1,91 @@
+// Package testvectors runs Filecoin conformance test vectors through the consensus processor.
+//
+// Vectors follow the shared JSON format: a gzipped CAR file holding the pre-state, the
+// messages to apply to it, and the receipts and state root expected after applying them.
+//
+// Only vectors of the message class are supported: their messages are applied one by one with
+// ApplyMessage, outside of any block. Tipset vectors, which apply blocks of messages through
+// ProcessTipSet and pay block rewards, are rejected.
+package testvectors
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/ipfs/go-cid"
+	"github.com/pkg/errors"
+)
+
+// ClassMessage is the class of vectors that apply a sequence of messages to a state.
+const ClassMessage = "message"
+
+// TestVector is a single conformance test.
+type TestVector struct {
+	Class string    `json:"class"`
+	Meta  *Metadata `json:"_meta,omitempty"`
+
+	// CAR is a gzipped CAR file holding the blocks of the pre-state.
+	CAR []byte `json:"car"`
+
+	Preconditions  Preconditions  `json:"preconditions"`
+	ApplyMessages  []Message      `json:"apply_messages"`
+	Postconditions Postconditions `json:"postconditions"`
+}
+
+// Metadata identifies a vector.
+type Metadata struct {
+	ID      string `json:"id"`
+	Version string `json:"version,omitempty"`
+	Comment string `json:"comment,omitempty"`
+}
+
+// StateTree references the root of a state tree.
+type StateTree struct {
+	RootCID cid.Cid `json:"root_cid"`
+}
+
+// Preconditions describe the state messages are applied to.
+type Preconditions struct {
+	Epoch     uint64    `json:"epoch"`
+	StateTree StateTree `json:"state_tree"`
+}
+
+// Message is a CBOR encoded unsigned message to apply.
+type Message struct {
+	Bytes []byte `json:"bytes"`
+	// Epoch is the height to apply the message at, if it differs from the precondition epoch.
+	Epoch *uint64 `json:"epoch,omitempty"`
+}
+
+// Receipt is the receipt a message is expected to produce.
+type Receipt struct {
+	ExitCode    uint8  `json:"exit_code"`
+	ReturnValue []byte `json:"return"`
+	GasUsed     uint64 `json:"gas_used"`
+}
+
+// Postconditions describe the expected results of applying the messages.
+type Postconditions struct {
+	StateTree StateTree `json:"state_tree"`
+	Receipts  []Receipt `json:"receipts"`
+}
+
+// Parse reads a vector from its JSON representation.
+func Parse(r io.Reader) (*TestVector, error) {
+	var v TestVector
+	if err := json.NewDecoder(r).Decode(&v); err != nil {
+		return nil, errors.Wrap(err, "could not decode test vector")
+	}
+	if v.Class != ClassMessage {
+		return nil, errors.Errorf("unsupported test vector class %q", v.Class)
+	}
+	return &v, nil
+}
+
+// ID returns the identifier of the vector, if it has one.
+func (v *TestVector) ID() string {
+	if v.Meta == nil {
+		return ""
+	}
+	return v.Meta.ID
+}
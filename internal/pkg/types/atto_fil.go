@@ -23,6 +23,10 @@ var tenToTheEighteen = big.NewInt(10).Exp(big.NewInt(10), big.NewInt(18), nil)
 // ZeroAttoFIL is the zero value for an AttoFIL, exported for consistency in construction of AttoFILs
 var ZeroAttoFIL AttoFIL
 
+// MaxAttoFIL is the largest amount an actor balance may hold, 2^256 - 1 AttoFIL. Amounts are
+// arbitrary precision, so exceeding it must be checked for explicitly.
+var MaxAttoFIL = NewAttoFIL(new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 256), big.NewInt(1)))
+
 var attoFILAtlasEntry = atlas.BuildEntry(AttoFIL{}).Transform().
 	TransformMarshal(atlas.MakeMarshalTransformFunc(
 		func(a AttoFIL) ([]byte, error) {
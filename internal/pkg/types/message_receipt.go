@@ -3,6 +3,8 @@ package types
 import (
 	"encoding/json"
 	"fmt"
+
+	"github.com/filecoin-project/go-filecoin/internal/pkg/encoding"
 )
 
 // MessageReceipt represents the result of sending a message.
@@ -28,3 +30,18 @@ func (mr *MessageReceipt) String() string {
 	}
 	return fmt.Sprintf("MessageReceipt: %s", string(js))
 }
+
+// EncodeReceipt returns the canonical CBOR encoding of a receipt. This is the encoding of
+// receipts stored in the receipt collections referenced by blocks.
+func EncodeReceipt(r *MessageReceipt) ([]byte, error) {
+	return encoding.Encode(*r)
+}
+
+// DecodeReceipt decodes a receipt encoded by EncodeReceipt.
+func DecodeReceipt(raw []byte) (*MessageReceipt, error) {
+	var r MessageReceipt
+	if err := encoding.Decode(raw, &r); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
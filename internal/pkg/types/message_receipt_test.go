@@ -1,11 +1,13 @@
 package types
 
 import (
+	"math/big"
 	"testing"
 
 	"github.com/filecoin-project/go-filecoin/internal/pkg/encoding"
 	tf "github.com/filecoin-project/go-filecoin/internal/pkg/testhelpers/testflags"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestMessageReceiptMarshal(t *testing.T) {
@@ -35,3 +37,38 @@ func TestMessageReceiptMarshal(t *testing.T) {
 		assert.True(t, expected.GasAttoFIL.Equal(actual.GasAttoFIL))
 	}
 }
+
+func TestEncodeReceiptRoundTrip(t *testing.T) {
+	tf.UnitTest(t)
+
+	expected := &MessageReceipt{ExitCode: 3, Return: [][]byte{{1}, {2, 3}}, GasAttoFIL: NewAttoFILFromFIL(2)}
+	raw, err := EncodeReceipt(expected)
+	require.NoError(t, err)
+
+	actual, err := DecodeReceipt(raw)
+	require.NoError(t, err)
+	assert.Equal(t, expected.ExitCode, actual.ExitCode)
+	assert.Equal(t, expected.Return, actual.Return)
+	assert.True(t, expected.GasAttoFIL.Equal(actual.GasAttoFIL))
+
+	again, err := EncodeReceipt(actual)
+	require.NoError(t, err)
+	assert.Equal(t, raw, again)
+}
+
+func TestEncodeReceiptFixture(t *testing.T) {
+	tf.UnitTest(t)
+
+	receipt := &MessageReceipt{ExitCode: 1, Return: [][]byte{{1, 2, 3}}, GasAttoFIL: NewAttoFIL(big.NewInt(5))}
+	raw, err := EncodeReceipt(receipt)
+	require.NoError(t, err)
+
+	// A map with keys in canonical (length-first) order: Return, ExitCode, GasAttoFIL.
+	expected := []byte{
+		0xa3,
+		0x66, 'R', 'e', 't', 'u', 'r', 'n', 0x81, 0x43, 0x01, 0x02, 0x03,
+		0x68, 'E', 'x', 'i', 't', 'C', 'o', 'd', 'e', 0x01,
+		0x6a, 'G', 'a', 's', 'A', 't', 't', 'o', 'F', 'I', 'L', 0x41, 0x05,
+	}
+	assert.Equal(t, expected, raw)
+}
@@ -40,6 +40,14 @@ func (pvt *ProtocolVersionTable) VersionAt(height *types.BlockHeight) (uint64, e
 	return pvt.versions[idx-1].Version, nil
 }
 
+// EachVersion calls fn with each version in the table and the height it goes into effect at,
+// in the order they go into effect.
+func (pvt *ProtocolVersionTable) EachVersion(fn func(version uint64, effectiveAt *types.BlockHeight)) {
+	for _, v := range pvt.versions {
+		fn(v.Version, v.EffectiveAt)
+	}
+}
+
 // ProtocolVersionTableBuilder constructs a protocol version table
 type ProtocolVersionTableBuilder struct {
 	network  string
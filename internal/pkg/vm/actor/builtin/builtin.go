@@ -5,6 +5,7 @@ import (
 	"fmt"
 
 	"github.com/filecoin-project/go-filecoin/internal/pkg/types"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/abi"
 	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/actor/builtin/account"
 	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/actor/builtin/initactor"
 	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/actor/builtin/miner"
@@ -23,6 +24,10 @@ type codeVersion struct {
 
 type Actors struct {
 	actors map[codeVersion]dispatch.ExecutableActor
+	// read-only flag for the methods of each actor code that have metadata
+	readOnly map[cid.Cid]map[types.MethodID]bool
+	// exit codes each actor code defines for its failures
+	exitCodes map[cid.Cid]map[uint8]bool
 }
 
 // GetActorCode returns executable code for an actor by code cid at a specific protocol version
@@ -37,29 +42,126 @@ func (ba Actors) GetActorCode(code cid.Cid, version uint64) (dispatch.Executable
 	return actor, nil
 }
 
+// IsReadOnlyMethod reports whether the given method of an actor code leaves state unchanged.
+// The second return value is false if there is no metadata for the method.
+func (ba Actors) IsReadOnlyMethod(code cid.Cid, method types.MethodID) (readOnly bool, known bool) {
+	readOnly, known = ba.readOnly[code][method]
+	return
+}
+
+// ReturnTypes returns the types of the values returned by the given method of an actor code,
+// as declared by its signature, so that clients can decode them without a compiled schema.
+// The second return value is false if the code or method is unknown.
+func (ba Actors) ReturnTypes(code cid.Cid, method types.MethodID) ([]abi.Type, bool) {
+	actor, err := ba.GetActorCode(code, 0)
+	if err != nil {
+		return nil, false
+	}
+	_, signature, ok := actor.Method(method)
+	if !ok {
+		return nil, false
+	}
+	return signature.Return, true
+}
+
+// IsActorExitCode reports whether exitCode is one of the exit codes an actor code defines for
+// its failures. Exit codes the VM itself produces are not included.
+func (ba Actors) IsActorExitCode(code cid.Cid, exitCode uint8) bool {
+	return ba.exitCodes[code][exitCode]
+}
+
+// AncestorsRequired returns the number of ancestor tipsets the given method of an actor code
+// requires to be applied, as declared by its signature, so that callers can fetch exactly those.
+// The second return value is false if the code or method is unknown.
+func (ba Actors) AncestorsRequired(code cid.Cid, method types.MethodID) (int, bool) {
+	actor, err := ba.GetActorCode(code, 0)
+	if err != nil {
+		return 0, false
+	}
+	_, signature, ok := actor.Method(method)
+	if !ok {
+		return 0, false
+	}
+	return signature.Ancestors, true
+}
+
 type BuiltinActorsBuilder struct {
-	actors map[codeVersion]dispatch.ExecutableActor
+	actors    map[codeVersion]dispatch.ExecutableActor
+	readOnly  map[cid.Cid]map[types.MethodID]bool
+	exitCodes map[cid.Cid]map[uint8]bool
 }
 
 // NewBuilder creates a builder to generate a builtin.Actor data structure
 func NewBuilder() *BuiltinActorsBuilder {
-	return &BuiltinActorsBuilder{actors: map[codeVersion]dispatch.ExecutableActor{}}
+	return &BuiltinActorsBuilder{
+		actors:    map[codeVersion]dispatch.ExecutableActor{},
+		readOnly:  map[cid.Cid]map[types.MethodID]bool{},
+		exitCodes: map[cid.Cid]map[uint8]bool{},
+	}
 }
 
 func (bab *BuiltinActorsBuilder) AddAll(actors Actors) *BuiltinActorsBuilder {
 	for cv, a := range actors.actors {
 		bab.Add(cv.code, cv.protocolVersion, a)
 	}
+	for c, methods := range actors.readOnly {
+		for m, ro := range methods {
+			bab.setReadOnly(c, m, ro)
+		}
+	}
+	for c, codes := range actors.exitCodes {
+		for exitCode := range codes {
+			bab.addExitCode(c, exitCode)
+		}
+	}
+	return bab
+}
+
+// ReadOnlyMethods records that the given methods of the actor code do not modify state.
+func (bab *BuiltinActorsBuilder) ReadOnlyMethods(c cid.Cid, methods ...types.MethodID) *BuiltinActorsBuilder {
+	for _, m := range methods {
+		bab.setReadOnly(c, m, true)
+	}
+	return bab
+}
+
+// MutatingMethods records that the given methods of the actor code may modify state.
+func (bab *BuiltinActorsBuilder) MutatingMethods(c cid.Cid, methods ...types.MethodID) *BuiltinActorsBuilder {
+	for _, m := range methods {
+		bab.setReadOnly(c, m, false)
+	}
+	return bab
+}
+
+func (bab *BuiltinActorsBuilder) setReadOnly(c cid.Cid, method types.MethodID, readOnly bool) {
+	if _, ok := bab.readOnly[c]; !ok {
+		bab.readOnly[c] = map[types.MethodID]bool{}
+	}
+	bab.readOnly[c][method] = readOnly
+}
+
+// ExitCodes records the exit codes the actor code defines for its failures, as keys of errs.
+func (bab *BuiltinActorsBuilder) ExitCodes(c cid.Cid, errs map[uint8]error) *BuiltinActorsBuilder {
+	for exitCode := range errs {
+		bab.addExitCode(c, exitCode)
+	}
 	return bab
 }
 
+func (bab *BuiltinActorsBuilder) addExitCode(c cid.Cid, exitCode uint8) {
+	if _, ok := bab.exitCodes[c]; !ok {
+		bab.exitCodes[c] = map[uint8]bool{}
+	}
+	bab.exitCodes[c][exitCode] = true
+}
+
 func (bab *BuiltinActorsBuilder) Add(c cid.Cid, version uint64, actor dispatch.ExecutableActor) *BuiltinActorsBuilder {
 	bab.actors[codeVersion{code: c, protocolVersion: version}] = actor
 	return bab
 }
 
 func (bab *BuiltinActorsBuilder) Build() Actors {
-	return Actors{actors: bab.actors}
+	return Actors{actors: bab.actors, readOnly: bab.readOnly, exitCodes: bab.exitCodes}
 }
 
 // DefaultActors is list of all actors that ship with Filecoin.
@@ -72,4 +174,40 @@ var DefaultActors = NewBuilder().
 	Add(types.MinerActorCodeCid, 0, &miner.Actor{}).
 	Add(types.BootstrapMinerActorCodeCid, 0, &miner.Actor{Bootstrap: true}).
 	Add(types.InitActorCodeCid, 0, &initactor.Actor{}).
+	MutatingMethods(types.AccountActorCodeCid, account.Constructor).
+	ReadOnlyMethods(types.StorageMarketActorCodeCid,
+		storagemarket.GetTotalStorage, storagemarket.GetProofsMode, storagemarket.GetLateMiners).
+	MutatingMethods(types.StorageMarketActorCodeCid,
+		storagemarket.CreateStorageMiner, storagemarket.UpdateStorage, storagemarket.PublishStorageDeals,
+		storagemarket.PreCommitSector, storagemarket.CommitSector).
+	ReadOnlyMethods(types.PowerActorCodeCid, power.GetTotalPower, power.GetPowerReport, power.GetSectorSize).
+	MutatingMethods(types.PowerActorCodeCid,
+		power.CreateStorageMiner, power.RemoveStorageMiner, power.ProcessPowerReport, power.ProcessFaultReport).
+	ReadOnlyMethods(types.PaymentBrokerActorCodeCid, paymentbroker.Ls, paymentbroker.Voucher).
+	MutatingMethods(types.PaymentBrokerActorCodeCid,
+		paymentbroker.Cancel, paymentbroker.Close, paymentbroker.CreateChannel,
+		paymentbroker.Extend, paymentbroker.Reclaim, paymentbroker.Redeem).
+	ReadOnlyMethods(types.MinerActorCodeCid, minerReadOnlyMethods...).
+	MutatingMethods(types.MinerActorCodeCid, minerMutatingMethods...).
+	ReadOnlyMethods(types.BootstrapMinerActorCodeCid, minerReadOnlyMethods...).
+	MutatingMethods(types.BootstrapMinerActorCodeCid, minerMutatingMethods...).
+	ReadOnlyMethods(types.InitActorCodeCid,
+		initactor.GetActorIDForAddressMethodID, initactor.GetAddressForActorIDMethodID, initactor.GetNetworkMethodID).
+	MutatingMethods(types.InitActorCodeCid, initactor.ExecMethodID).
+	ExitCodes(types.StorageMarketActorCodeCid, storagemarket.Errors).
+	ExitCodes(types.PowerActorCodeCid, power.Errors).
+	ExitCodes(types.PaymentBrokerActorCodeCid, paymentbroker.Errors).
+	ExitCodes(types.MinerActorCodeCid, miner.Errors).
+	ExitCodes(types.BootstrapMinerActorCodeCid, miner.Errors).
 	Build()
+
+var minerReadOnlyMethods = []types.MethodID{
+	miner.GetOwner, miner.GetWorker, miner.GetPeerID, miner.GetPower, miner.VerifyPieceInclusion,
+	miner.GetSectorSize, miner.GetAsks, miner.GetAsk, miner.GetLastUsedSectorID, miner.GetProvingSetCommitments,
+	miner.IsBootstrapMiner, miner.GetPoStState, miner.GetProvingWindow, miner.CalculateLateFee, miner.GetActiveCollateral,
+}
+
+var minerMutatingMethods = []types.MethodID{
+	miner.Constructor, miner.AddAsk, miner.CommitSector, miner.UpdatePeerID, miner.AddFaults,
+	miner.SubmitPoSt, miner.SlashStorageFault, miner.ChangeWorker,
+}
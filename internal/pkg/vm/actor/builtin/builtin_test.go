@@ -0,0 +1,88 @@
+package builtin_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	tf "github.com/filecoin-project/go-filecoin/internal/pkg/testhelpers/testflags"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/types"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/abi"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/actor"
+	. "github.com/filecoin-project/go-filecoin/internal/pkg/vm/actor/builtin"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/actor/builtin/miner"
+)
+
+func TestIsReadOnlyMethod(t *testing.T) {
+	tf.UnitTest(t)
+
+	t.Run("read-only method", func(t *testing.T) {
+		readOnly, known := DefaultActors.IsReadOnlyMethod(types.MinerActorCodeCid, miner.GetOwner)
+		assert.True(t, known)
+		assert.True(t, readOnly)
+	})
+
+	t.Run("mutating method", func(t *testing.T) {
+		readOnly, known := DefaultActors.IsReadOnlyMethod(types.MinerActorCodeCid, miner.CommitSector)
+		assert.True(t, known)
+		assert.False(t, readOnly)
+	})
+
+	t.Run("metadata survives AddAll", func(t *testing.T) {
+		actors := NewBuilder().AddAll(DefaultActors).Build()
+		readOnly, known := actors.IsReadOnlyMethod(types.BootstrapMinerActorCodeCid, miner.GetPower)
+		assert.True(t, known)
+		assert.True(t, readOnly)
+	})
+
+	t.Run("unknown method", func(t *testing.T) {
+		_, known := DefaultActors.IsReadOnlyMethod(types.AccountActorCodeCid, types.MethodID(12345))
+		assert.False(t, known)
+	})
+}
+
+func TestReturnTypes(t *testing.T) {
+	tf.UnitTest(t)
+
+	t.Run("method returning an address", func(t *testing.T) {
+		returnTypes, known := DefaultActors.ReturnTypes(types.MinerActorCodeCid, miner.GetOwner)
+		assert.True(t, known)
+		assert.Equal(t, []abi.Type{abi.Address}, returnTypes)
+	})
+
+	t.Run("unknown method", func(t *testing.T) {
+		_, known := DefaultActors.ReturnTypes(types.AccountActorCodeCid, types.MethodID(12345))
+		assert.False(t, known)
+	})
+}
+
+func TestAncestorsRequired(t *testing.T) {
+	tf.UnitTest(t)
+
+	fakeActorCodeCid := types.NewCidForTestGetter()()
+	actors := NewBuilder().Add(fakeActorCodeCid, 0, &actor.FakeActor{}).Build()
+
+	t.Run("method sampling randomness", func(t *testing.T) {
+		ancestors, known := actors.AncestorsRequired(fakeActorCodeCid, actor.SamplesRandomnessID)
+		assert.True(t, known)
+		assert.Equal(t, actor.SamplesRandomnessAncestors, ancestors)
+	})
+
+	t.Run("method needing no ancestors", func(t *testing.T) {
+		ancestors, known := actors.AncestorsRequired(fakeActorCodeCid, actor.HasReturnValueID)
+		assert.True(t, known)
+		assert.Equal(t, 0, ancestors)
+	})
+
+	t.Run("unknown code", func(t *testing.T) {
+		_, known := actors.AncestorsRequired(types.AccountActorCodeCid, types.SendMethodID)
+		assert.False(t, known)
+	})
+}
+
+func TestIsActorExitCode(t *testing.T) {
+	tf.UnitTest(t)
+
+	assert.True(t, DefaultActors.IsActorExitCode(types.MinerActorCodeCid, miner.ErrAskNotFound))
+	assert.False(t, DefaultActors.IsActorExitCode(types.AccountActorCodeCid, miner.ErrAskNotFound))
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"math/big"
 	"reflect"
+	"sort"
 
 	"github.com/filecoin-project/go-filecoin/internal/pkg/encoding"
 	"github.com/filecoin-project/go-filecoin/internal/pkg/types"
@@ -14,6 +15,7 @@ import (
 	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/internal/dispatch"
 	internal "github.com/filecoin-project/go-filecoin/internal/pkg/vm/internal/errors"
 	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/internal/runtime"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/internal/storage"
 	"github.com/ipfs/go-cid"
 	"github.com/ipfs/go-hamt-ipld"
 )
@@ -37,7 +39,8 @@ type View struct {
 	store runtime.LegacyStorage
 }
 
-// assignNewID returns the nextID and increments the counter
+// assignNewID returns the nextID and increments the counter. IDs are thus assigned in the
+// order actors are created.
 func (s *State) assignNewID() types.Uint64 {
 	id := s.NextID
 	s.NextID++
@@ -184,6 +187,74 @@ func LookupIDAddress(rt runtime.InvocationContext, addr address.Address) (uint64
 	return uint64(id.(types.Uint64)), true, nil
 }
 
+// LookupAddress returns the address the actor with the given ActorID was created for.
+func LookupAddress(rt runtime.InvocationContext, actorID uint64) (address.Address, bool, error) {
+	var state State
+	rt.StateHandle().Readonly(&state)
+
+	key, err := keyForActorID(types.Uint64(actorID))
+	if err != nil {
+		return address.Undef, false, err
+	}
+
+	ctx := context.TODO()
+	var addr address.Address
+	err = actor.WithLookupForReading(ctx, rt.Runtime().LegacyStorage(), state.IDMap, func(lookup storage.Lookup) error {
+		return lookup.Find(ctx, key, &addr)
+	})
+	if err == hamt.ErrNotFound {
+		return address.Undef, false, nil
+	} else if err != nil {
+		return address.Undef, false, errors.FaultErrorWrap(err, "could not lookup actor address")
+	}
+
+	return addr, true, nil
+}
+
+// NextActorID returns the ActorID that will be assigned to the next actor created.
+func NextActorID(rt runtime.InvocationContext) uint64 {
+	var state State
+	rt.StateHandle().Readonly(&state)
+	return uint64(state.NextID)
+}
+
+// AddressMapping is an entry of the init actor's address map.
+type AddressMapping struct {
+	Address address.Address
+	ID      uint64
+}
+
+// AddressMappings returns all the addresses registered with the init actor and the ActorIDs
+// they map to. The hamt is walked in hash order, so entries are sorted by address to give
+// every node the same result.
+func AddressMappings(rt runtime.InvocationContext) ([]AddressMapping, error) {
+	var state State
+	rt.StateHandle().Readonly(&state)
+
+	ctx := context.TODO()
+	var mappings []AddressMapping
+	err := actor.WithLookupForReading(ctx, rt.Runtime().LegacyStorage(), state.AddressMap, func(lookup storage.Lookup) error {
+		return lookup.ForEachValue(ctx, types.Uint64(0), func(k string, value interface{}) error {
+			addr, err := address.NewFromString(k)
+			if err != nil {
+				return err
+			}
+			id, ok := value.(types.Uint64)
+			if !ok {
+				return errors.NewFaultError("expected actor id in address map")
+			}
+			mappings = append(mappings, AddressMapping{Address: addr, ID: uint64(id)})
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, errors.FaultErrorWrap(err, "could not read address map")
+	}
+
+	sort.Slice(mappings, func(i, j int) bool { return mappings[i].Address.String() < mappings[j].Address.String() })
+	return mappings, nil
+}
+
 //
 // vm methods for actor
 //
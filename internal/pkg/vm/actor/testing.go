@@ -1,7 +1,9 @@
 package actor
 
 import (
+	"math/big"
 	"reflect"
+	"time"
 
 	cid "github.com/ipfs/go-cid"
 
@@ -38,8 +40,29 @@ const (
 	AttemptMultiSpend2ID
 	RunsAnotherMessageID
 	BlockLimitTestMethodID
+	SendsRepeatedlyID
+	SleepsID
+	AllocatesID
+	SamplesRandomnessID
+	WalksAncestorsID
+	EmitsEventID
+	CorruptsBalanceID
+	DrawsTestRandID
+	ReturnsBytesID
+	LoopsID
+	RequiresOwnerID
+	SendsToBothID
 )
 
+// SamplesRandomnessAncestors is the number of ancestors SamplesRandomness needs.
+const SamplesRandomnessAncestors = 3
+
+// EmittedEventTopics are the topics of the event EmitsEvent emits.
+var EmittedEventTopics = [][]byte{[]byte("fake"), []byte("emitted")}
+
+// WalksAncestorsGasPerEpoch is the gas WalksAncestors charges for each epoch it samples.
+const WalksAncestorsGasPerEpoch = 10
+
 var signatures = dispatch.Exports{
 	HasReturnValueID: &dispatch.FunctionSignature{
 		Params: nil,
@@ -89,6 +112,55 @@ var signatures = dispatch.Exports{
 		Params: nil,
 		Return: nil,
 	},
+	SendsRepeatedlyID: &dispatch.FunctionSignature{
+		Params: []abi.Type{abi.Address, abi.Integer},
+		Return: nil,
+	},
+	SleepsID: &dispatch.FunctionSignature{
+		Params: []abi.Type{abi.Integer},
+		Return: nil,
+	},
+	AllocatesID: &dispatch.FunctionSignature{
+		Params: []abi.Type{abi.Integer},
+		Return: nil,
+	},
+	SamplesRandomnessID: &dispatch.FunctionSignature{
+		Params:    nil,
+		Return:    nil,
+		Ancestors: SamplesRandomnessAncestors,
+	},
+	WalksAncestorsID: &dispatch.FunctionSignature{
+		Params: nil,
+		Return: nil,
+	},
+	EmitsEventID: &dispatch.FunctionSignature{
+		Params: []abi.Type{abi.Bytes},
+		Return: nil,
+	},
+	CorruptsBalanceID: &dispatch.FunctionSignature{
+		Params: nil,
+		Return: nil,
+	},
+	DrawsTestRandID: &dispatch.FunctionSignature{
+		Params: nil,
+		Return: []abi.Type{abi.Bytes},
+	},
+	ReturnsBytesID: &dispatch.FunctionSignature{
+		Params: []abi.Type{abi.Integer},
+		Return: []abi.Type{abi.Bytes},
+	},
+	LoopsID: &dispatch.FunctionSignature{
+		Params: []abi.Type{abi.Integer},
+		Return: nil,
+	},
+	RequiresOwnerID: &dispatch.FunctionSignature{
+		Params: nil,
+		Return: nil,
+	},
+	SendsToBothID: &dispatch.FunctionSignature{
+		Params: []abi.Type{abi.Address, abi.Address},
+		Return: nil,
+	},
 }
 
 // InitializeState stores this actors
@@ -138,6 +210,30 @@ func (a *FakeActor) Method(id types.MethodID) (dispatch.Method, *dispatch.Functi
 		return reflect.ValueOf((*impl)(a).RunsAnotherMessage), signatures[RunsAnotherMessageID], true
 	case BlockLimitTestMethodID:
 		return reflect.ValueOf((*impl)(a).BlockLimitTestMethod), signatures[BlockLimitTestMethodID], true
+	case SendsRepeatedlyID:
+		return reflect.ValueOf((*impl)(a).SendsRepeatedly), signatures[SendsRepeatedlyID], true
+	case SleepsID:
+		return reflect.ValueOf((*impl)(a).Sleeps), signatures[SleepsID], true
+	case AllocatesID:
+		return reflect.ValueOf((*impl)(a).Allocates), signatures[AllocatesID], true
+	case SamplesRandomnessID:
+		return reflect.ValueOf((*impl)(a).SamplesRandomness), signatures[SamplesRandomnessID], true
+	case WalksAncestorsID:
+		return reflect.ValueOf((*impl)(a).WalksAncestors), signatures[WalksAncestorsID], true
+	case EmitsEventID:
+		return reflect.ValueOf((*impl)(a).EmitsEvent), signatures[EmitsEventID], true
+	case CorruptsBalanceID:
+		return reflect.ValueOf((*impl)(a).CorruptsBalance), signatures[CorruptsBalanceID], true
+	case DrawsTestRandID:
+		return reflect.ValueOf((*impl)(a).DrawsTestRand), signatures[DrawsTestRandID], true
+	case ReturnsBytesID:
+		return reflect.ValueOf((*impl)(a).ReturnsBytes), signatures[ReturnsBytesID], true
+	case LoopsID:
+		return reflect.ValueOf((*impl)(a).Loops), signatures[LoopsID], true
+	case RequiresOwnerID:
+		return reflect.ValueOf((*impl)(a).RequiresOwner), signatures[RequiresOwnerID], true
+	case SendsToBothID:
+		return reflect.ValueOf((*impl)(a).SendsToBoth), signatures[SendsToBothID], true
 	default:
 		return nil, nil, false
 	}
@@ -261,6 +357,31 @@ func (*impl) BlockLimitTestMethod(ctx runtime.InvocationContext) (uint8, error)
 	return 0, nil
 }
 
+// SendsRepeatedly calls HasReturnValue on the target the given number of times, stopping at the first failure.
+func (*impl) SendsRepeatedly(ctx runtime.InvocationContext, target address.Address, times *big.Int) (uint8, error) {
+	for i := int64(0); i < times.Int64(); i++ {
+		_, code, err := ctx.LegacySend(target, HasReturnValueID, types.ZeroAttoFIL, []interface{}{})
+		if code != 0 || err != nil {
+			return code, err
+		}
+	}
+	return 0, nil
+}
+
+// Sleeps blocks for the given number of milliseconds.
+func (*impl) Sleeps(ctx runtime.InvocationContext, millis *big.Int) (uint8, error) {
+	time.Sleep(time.Duration(millis.Int64()) * time.Millisecond)
+	return 0, nil
+}
+
+// Allocates stores a byte array of the given size.
+func (*impl) Allocates(ctx runtime.InvocationContext, size *big.Int) (uint8, error) {
+	if _, err := ctx.Runtime().LegacyStorage().Put(make([]byte, size.Int64())); err != nil {
+		return 1, errors.RevertErrorWrap(err, "could not store allocation")
+	}
+	return 0, nil
+}
+
 // MustConvertParams encodes the given params and panics if it fails to do so.
 func MustConvertParams(params ...interface{}) []byte {
 	vals, err := abi.ToValues(params)
@@ -274,3 +395,114 @@ func MustConvertParams(params ...interface{}) []byte {
 	}
 	return out
 }
+
+// SamplesRandomness samples the chain randomness at the current epoch.
+func (*impl) SamplesRandomness(ctx runtime.InvocationContext) (uint8, error) {
+	ctx.Runtime().Randomness(ctx.Runtime().CurrentEpoch(), 0)
+	return 0, nil
+}
+
+// WalksAncestors samples the chain randomness at each epoch back from the current one until
+// it reaches an epoch no ancestor covers, charging gas for every epoch sampled. Its gas use
+// therefore scales with the number of ancestors supplied.
+func (*impl) WalksAncestors(ctx runtime.InvocationContext) (uint8, error) {
+	epoch := ctx.Runtime().CurrentEpoch()
+	for canSampleRandomness(ctx.Runtime(), epoch) {
+		if err := ctx.Charge(WalksAncestorsGasPerEpoch); err != nil {
+			return internal.ErrInsufficientGas, errors.RevertErrorWrap(err, "Insufficient gas")
+		}
+		if epoch.IsZero() {
+			break
+		}
+		epoch = *epoch.Sub(types.NewBlockHeight(1))
+	}
+	return 0, nil
+}
+
+// EmitsEvent emits an event with EmittedEventTopics carrying data.
+func (*impl) EmitsEvent(ctx runtime.InvocationContext, data []byte) (uint8, error) {
+	emitter, ok := ctx.Runtime().(runtime.EventEmitter)
+	if !ok {
+		return 1, errors.NewRevertError("runtime does not support events")
+	}
+	emitter.EmitEvent(EmittedEventTopics, data)
+	return 0, nil
+}
+
+// CorruptsBalance makes its own balance negative, bypassing the VM's transfer checks, so tests
+// can produce a state no well-behaved actor could.
+func (*impl) CorruptsBalance(ctx runtime.InvocationContext) (uint8, error) {
+	self, ok := ctx.(interface{ To() *Actor })
+	if !ok {
+		return 1, errors.NewRevertError("context does not expose the executing actor")
+	}
+	self.To().Balance = types.ZeroAttoFIL.Sub(types.NewAttoFILFromFIL(1))
+	return 0, nil
+}
+
+// DrawsTestRand returns 8 bytes drawn from the runtime's seeded pseudo-random number generator.
+func (*impl) DrawsTestRand(ctx runtime.InvocationContext) ([]byte, uint8, error) {
+	source, ok := ctx.Runtime().(runtime.TestRandSource)
+	if !ok || source.TestRand() == nil {
+		return nil, 1, errors.NewRevertError("runtime has no seeded random number generator")
+	}
+	drawn := make([]byte, 8)
+	if _, err := source.TestRand().Read(drawn); err != nil {
+		return nil, 1, errors.FaultErrorWrap(err, "failed to draw random bytes")
+	}
+	return drawn, 0, nil
+}
+
+// ReturnsBytes returns a byte array of the given size.
+func (*impl) ReturnsBytes(ctx runtime.InvocationContext, size *big.Int) ([]byte, uint8, error) {
+	return make([]byte, size.Int64()), 0, nil
+}
+
+// Loops makes the given number of zero-cost gas charges, so it runs for as long as it is told
+// to without running out of gas.
+func (*impl) Loops(ctx runtime.InvocationContext, iterations *big.Int) (uint8, error) {
+	for i := int64(0); i < iterations.Int64(); i++ {
+		if err := ctx.Charge(0); err != nil {
+			return 1, errors.RevertErrorWrap(err, "loop interrupted")
+		}
+	}
+	return 0, nil
+}
+
+// RequiresOwner succeeds only if its caller holds the owner capability over it.
+func (*impl) RequiresOwner(ctx runtime.InvocationContext) (uint8, error) {
+	caps, ok := ctx.Runtime().(runtime.CallerCapabilities)
+	if !ok {
+		return 1, errors.NewRevertError("runtime does not support caller capabilities")
+	}
+	if err := caps.RequireCapability(runtime.CapabilityOwner); err != nil {
+		return internal.ErrMissingCapability, err
+	}
+	return 0, nil
+}
+
+// SendsToBoth sends 100 to first and then to second, creating an actor for each that has none.
+func (*impl) SendsToBoth(ctx runtime.InvocationContext, first, second address.Address) (uint8, error) {
+	for _, target := range []address.Address{first, second} {
+		_, code, err := ctx.LegacySend(target, types.SendMethodID, types.NewAttoFILFromFIL(100), nil)
+		if code != 0 || err != nil {
+			return code, err
+		}
+	}
+	return 0, nil
+}
+
+// canSampleRandomness reports whether rt has the ancestors to sample randomness at epoch,
+// recovering the abort sampling raises otherwise.
+func canSampleRandomness(rt runtime.Runtime, epoch types.BlockHeight) (ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			if _, aborted := r.(runtime.ExecutionPanic); !aborted {
+				panic(r)
+			}
+			ok = false
+		}
+	}()
+	rt.Randomness(epoch, 0)
+	return true
+}
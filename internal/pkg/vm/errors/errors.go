@@ -83,13 +83,18 @@ func ShouldRevert(err error) bool {
 	return ok && re.ShouldRevert()
 }
 
-// CodeError returns the RevertError's error code if it is a revert error, or 1 otherwise.
+type coder interface {
+	Code() uint8
+}
+
+// CodeError returns the error code of the root Cause() of err if it has one, such as a
+// RevertError, or 1 otherwise.
 func CodeError(err error) uint8 {
 	if err == nil {
 		return 0
 	}
-	if ShouldRevert(err) {
-		return err.(*RevertError).Code()
+	if c, ok := errors.Cause(err).(coder); ok {
+		return c.Code()
 	}
 	return 1
 }
@@ -50,6 +50,24 @@ func TestRevertError(t *testing.T) {
 	assert.Equal(t, re, errors.Cause(wrapped2))
 }
 
+type testReverter struct{}
+
+func (testReverter) Error() string      { return "reverter" }
+func (testReverter) ShouldRevert() bool { return true }
+
+func TestCodeError(t *testing.T) {
+	tf.UnitTest(t)
+
+	assert.Equal(t, uint8(0), CodeError(nil))
+	assert.Equal(t, uint8(1), CodeError(errors.New("source")))
+	assert.Equal(t, uint8(7), CodeError(NewCodedRevertError(7, "boom")))
+	assert.Equal(t, uint8(7), CodeError(errors.Wrap(NewCodedRevertError(7, "boom"), "wrapped")))
+
+	// reverters without a code of their own fail with the default code
+	assert.True(t, ShouldRevert(testReverter{}))
+	assert.Equal(t, uint8(1), CodeError(testReverter{}))
+}
+
 func TestApplyErrorPermanent(t *testing.T) {
 	tf.UnitTest(t)
 
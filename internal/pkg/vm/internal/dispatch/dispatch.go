@@ -29,4 +29,6 @@ type FunctionSignature struct {
 	Params []abi.Type
 	// Return is the type of the return value of the function.
 	Return []abi.Type
+	// Ancestors is the number of ancestor tipsets the function needs to be supplied with.
+	Ancestors int
 }
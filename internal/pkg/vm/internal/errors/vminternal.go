@@ -20,11 +20,23 @@ const (
 	// ErrInsufficientGas indicates that an actor did not have sufficient gas to run a message
 	// Dragons: this guy is not following the same pattern, it is missing from the Errors map below
 	ErrInsufficientGas = 36
+	// ErrTooManySends indicates that a message made more nested sends than it is allowed
+	ErrTooManySends = 37
+	// ErrMemoryBudgetExceeded indicates that a message stored more data than its memory budget allows
+	ErrMemoryBudgetExceeded = 38
+	// ErrReadOnlyViolation indicates that a read-only call attempted to change state
+	ErrReadOnlyViolation = 39
+	// ErrMissingCapability indicates that a caller lacks a capability the method requires
+	ErrMissingCapability = 40
 )
 
 // Errors map error codes to revert errors this actor may return
 var Errors = map[uint8]error{
-	ErrDecode:          errors.NewCodedRevertError(ErrDecode, "State could not be decoded"),
-	ErrDanglingPointer: errors.NewCodedRevertError(ErrDanglingPointer, "State contains pointer to non-existent chunk"),
-	ErrStaleHead:       errors.NewCodedRevertError(ErrStaleHead, "Expected head is stale"),
+	ErrDecode:               errors.NewCodedRevertError(ErrDecode, "State could not be decoded"),
+	ErrDanglingPointer:      errors.NewCodedRevertError(ErrDanglingPointer, "State contains pointer to non-existent chunk"),
+	ErrStaleHead:            errors.NewCodedRevertError(ErrStaleHead, "Expected head is stale"),
+	ErrTooManySends:         errors.NewCodedRevertError(ErrTooManySends, "Message exceeded its limit of nested sends"),
+	ErrMemoryBudgetExceeded: errors.NewCodedRevertError(ErrMemoryBudgetExceeded, "Message exceeded its memory budget"),
+	ErrReadOnlyViolation:    errors.NewCodedRevertError(ErrReadOnlyViolation, "Read-only call attempted to change state"),
+	ErrMissingCapability:    errors.NewCodedRevertError(ErrMissingCapability, "Caller lacks a capability the method requires"),
 }
@@ -0,0 +1,33 @@
+package gascost
+
+import (
+	"github.com/filecoin-project/go-filecoin/internal/pkg/types"
+)
+
+// Operation identifies a VM operation actors may be charged for.
+type Operation string
+
+// Operations priced by a Table.
+const (
+	// OnSend is charged each time an actor sends a message to another actor.
+	OnSend Operation = "send"
+	// OnVerifySignature is charged each time an actor verifies a signature.
+	OnVerifySignature Operation = "verify_signature"
+	// OnRandomness is charged each time an actor samples chain randomness.
+	OnRandomness Operation = "randomness"
+	// OnCreateActor is charged each time an actor creates another actor.
+	OnCreateActor Operation = "create_actor"
+	// OnStorageRead is charged each time an actor reads an object from its storage.
+	OnStorageRead Operation = "storage_read"
+	// OnStorageWrite is charged each time an actor stores an object or commits its storage head.
+	OnStorageWrite Operation = "storage_write"
+)
+
+// Table maps operations to the gas charged for them, on top of the gas actors charge
+// themselves. Operations missing from the table cost nothing, as in the legacy VM.
+type Table map[Operation]types.GasUnits
+
+// Cost returns the gas charged for op. A nil table charges nothing.
+func (t Table) Cost(op Operation) types.GasUnits {
+	return t[op]
+}
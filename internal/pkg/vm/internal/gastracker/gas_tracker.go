@@ -5,6 +5,26 @@ import (
 	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/errors"
 )
 
+// GasTracker meters the gas used by messages and by the block that contains them.
+type GasTracker interface {
+	// ResetForNewMessage prepares the tracker for metering the given message.
+	ResetForNewMessage(message *types.UnsignedMessage)
+	// Charge adds cost to the gas used by the current message, failing if this exceeds its limit.
+	Charge(cost types.GasUnits) error
+	// MessageGasLimit returns the gas limit of the current message.
+	MessageGasLimit() types.GasUnits
+	// GasAboveBlockLimit returns true if the current message's limit is above the block gas limit.
+	GasAboveBlockLimit() bool
+	// GasTooHighForCurrentBlock returns true if the current message's limit would not fit in the block.
+	GasTooHighForCurrentBlock() bool
+	// GasConsumedByMessage returns the gas used by the current message so far.
+	GasConsumedByMessage() types.GasUnits
+	// GasConsumedByBlock returns the gas used by the block's messages so far.
+	GasConsumedByBlock() types.GasUnits
+}
+
+var _ GasTracker = (*LegacyGasTracker)(nil)
+
 // LegacyGasTracker maintains the state of gas usage throughout the execution of a block and a message
 type LegacyGasTracker struct {
 	MsgGasLimit          types.GasUnits
@@ -40,6 +60,11 @@ func (gasTracker *LegacyGasTracker) Charge(cost types.GasUnits) error {
 	return nil
 }
 
+// MessageGasLimit returns the gas limit of the current message.
+func (gasTracker *LegacyGasTracker) MessageGasLimit() types.GasUnits {
+	return gasTracker.MsgGasLimit
+}
+
 // GasAboveBlockLimit will return true if the MsgGasLimit of the current message is greater than the block gas limit.
 func (gasTracker *LegacyGasTracker) GasAboveBlockLimit() bool {
 	return gasTracker.MsgGasLimit > types.BlockGasLimit
@@ -55,3 +80,8 @@ func (gasTracker *LegacyGasTracker) GasTooHighForCurrentBlock() bool {
 func (gasTracker *LegacyGasTracker) GasConsumedByMessage() types.GasUnits {
 	return gasTracker.gasConsumedByMessage
 }
+
+// GasConsumedByBlock returns the gas consumed by the block's messages, including the current one.
+func (gasTracker *LegacyGasTracker) GasConsumedByBlock() types.GasUnits {
+	return gasTracker.gasConsumedByBlock
+}
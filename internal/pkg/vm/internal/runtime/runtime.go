@@ -2,6 +2,7 @@ package runtime
 
 import (
 	"fmt"
+	"math/rand"
 
 	"github.com/ipfs/go-cid"
 
@@ -27,6 +28,45 @@ type Runtime interface {
 	LegacyStorage() LegacyStorage
 }
 
+// Event is a structured record emitted by an actor method, for subscribers to index by topic.
+type Event struct {
+	// Emitter is the ID address of the actor that emitted the event.
+	Emitter address.Address
+	Topics  [][]byte
+	Data    []byte
+}
+
+// EventEmitter is implemented by runtimes that let actors emit events.
+type EventEmitter interface {
+	// EmitEvent records an event for the executing message. Events emitted by a call that fails
+	// are discarded with the rest of its effects.
+	EmitEvent(topics [][]byte, data []byte)
+}
+
+// TestRandSource is implemented by runtimes that give actors a seeded pseudo-random number
+// generator, so test scenarios can draw reproducible sequences.
+type TestRandSource interface {
+	// TestRand returns the generator, or nil if the runtime was not given a seed.
+	TestRand() *rand.Rand
+}
+
+// Capability names a right a caller may hold over the actor it calls.
+type Capability string
+
+// CapabilityOwner is held by the owner of the called actor.
+const CapabilityOwner Capability = "owner"
+
+// CallerCapabilities is implemented by runtimes that let actor methods require capabilities of
+// their immediate caller.
+type CallerCapabilities interface {
+	// CallerID is the ID address the immediate caller resolved to.
+	CallerID() address.Address
+	// RequireCapability returns an error unless the immediate caller holds capability over
+	// the executing actor. A message whose execution requires a capability its caller lacks is
+	// rejected.
+	RequireCapability(capability Capability) error
+}
+
 // MessageInfo contains information available to the actor about the executing message.
 type MessageInfo interface {
 	// BlockMiner is the address for the actor who mined the block in which the initial on-chain message appears.
@@ -19,6 +19,12 @@ type ExportContext interface {
 	Params() []byte
 }
 
+// paramsRecorder is implemented by export contexts that record the params methods are
+// dispatched with.
+type paramsRecorder interface {
+	recordParams(params []*abi.Value) error
+}
+
 // makeTypedExport finds the correct method on the given actor and returns it.
 // The returned function is wrapped such that it takes care of serialization and type checks.
 //
@@ -82,6 +88,11 @@ func makeTypedExport(actor dispatch.ExecutableActor, method types.MethodID) (Exp
 		if err != nil {
 			return nil, 1, errors.RevertErrorWrap(err, "invalid params")
 		}
+		if rec, ok := ctx.(paramsRecorder); ok {
+			if err := rec.recordParams(params); err != nil {
+				return nil, 1, errors.FaultErrorWrap(err, "failed to re-encode params")
+			}
+		}
 
 		args := []reflect.Value{
 			reflect.ValueOf(ctx),
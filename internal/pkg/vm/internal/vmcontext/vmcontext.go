@@ -8,6 +8,7 @@ import (
 	"context"
 	"encoding/binary"
 	"math/big"
+	"math/rand"
 
 	"github.com/filecoin-project/go-filecoin/internal/pkg/block"
 	"github.com/filecoin-project/go-filecoin/internal/pkg/encoding"
@@ -20,7 +21,9 @@ import (
 	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/address"
 	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/errors"
 	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/internal/dispatch"
+	internal "github.com/filecoin-project/go-filecoin/internal/pkg/vm/internal/errors"
 	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/internal/exitcode"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/internal/gascost"
 	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/internal/gastracker"
 	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/internal/runtime"
 	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/internal/storagemap"
@@ -28,6 +31,39 @@ import (
 	"github.com/ipfs/go-cid"
 )
 
+// DispatchTracer is called with the code and method of each actor method dispatched.
+type DispatchTracer func(code cid.Cid, method types.MethodID)
+
+// CapabilityCheck reports whether the caller, named by its ID address, holds capability over
+// the actor at target.
+type CapabilityCheck func(target, caller address.Address, capability runtime.Capability) bool
+
+// ActorLoadHook is called with the actor a send is made to, and its ID address, before the
+// send invokes it. It may change the actor in place. An error it returns is a fault.
+type ActorLoadHook func(addr address.Address, act *actor.Actor) error
+
+// AddressResolver resolves an address actors send to to an ID address in the state the message
+// is applied to. It returns false if the address does not resolve.
+type AddressResolver func(ctx context.Context, addr address.Address) (address.Address, bool, error)
+
+// CapabilityDenial records a capability a caller was found to lack.
+type CapabilityDenial struct {
+	Caller     address.Address
+	Target     address.Address
+	Capability runtime.Capability
+}
+
+// SubCall records a send made by an actor while executing a message.
+type SubCall struct {
+	// From is the ID address of the sending actor.
+	From address.Address
+	// To is the ID address of the actor sent to.
+	To     address.Address
+	Method types.MethodID
+	// GasUsed is the gas consumed by the call, including the calls it made in turn.
+	GasUsed types.GasUnits
+}
+
 // ExecutableActorLookup provides a method to get an executable actor by code and protocol version
 type ExecutableActorLookup interface {
 	GetActorCode(code cid.Cid, version uint64) (dispatch.ExecutableActor, error)
@@ -35,6 +71,7 @@ type ExecutableActorLookup interface {
 
 // VMContext is the only thing exposed to an actor while executing.
 // All methods on the VMContext are ABI methods exposed to actors.
+// State kept for the whole message is passed on to the contexts of sends by inheritMessageState.
 type VMContext struct {
 	from              *actor.Actor
 	to                *actor.Actor
@@ -43,7 +80,7 @@ type VMContext struct {
 	originMsg         *types.UnsignedMessage
 	state             *state.CachedTree
 	storageMap        storagemap.StorageMap
-	gasTracker        *gastracker.LegacyGasTracker
+	gasTracker        gastracker.GasTracker
 	blockHeight       *types.BlockHeight
 	ancestors         []block.TipSet
 	actors            ExecutableActorLookup
@@ -51,6 +88,24 @@ type VMContext struct {
 	allowSideEffects  bool
 	stateHandle       actorStateHandle
 	blockMiner        address.Address
+	sends             *sendBudget   // shared by all contexts for the same message
+	memory            *memoryBudget // shared by all contexts for the same message
+	noValueTransfer   bool
+	gasCosts          gascost.Table
+	tracer            DispatchTracer
+	readOnly          *writeGuard      // shared by all contexts for the same message, nil if writes are allowed
+	events            *[]runtime.Event // shared by all contexts for the same message
+	subCalls          *[]SubCall       // shared by all contexts for the same message
+	testRand          *rand.Rand       // shared by all contexts for the same message, nil if not seeded
+	protocolVersion   uint64
+	storageGas        *storageGas // shared by all contexts for the same message
+	reads             readSet     // shared by all contexts for the same message
+	steps             *stepBudget // shared by all contexts for the same message, nil if unlimited
+	fromAddr          address.Address
+	capabilities      *capabilityGuard // shared by all contexts for the same message
+	capturedParams    *[]byte          // nil unless capturing, not shared with sub-calls
+	loadHook          ActorLoadHook    // shared by all contexts for the same message
+	resolver          AddressResolver  // shared by all contexts for the same message
 
 	deps *deps // Inject external dependencies so we can unit test robustly.
 }
@@ -64,11 +119,264 @@ type NewContextParams struct {
 	OriginMsg   *types.UnsignedMessage
 	State       *state.CachedTree
 	StorageMap  storagemap.StorageMap
-	GasTracker  *gastracker.LegacyGasTracker
+	GasTracker  gastracker.GasTracker
 	BlockHeight *types.BlockHeight
 	Ancestors   []block.TipSet
 	Actors      ExecutableActorLookup
 	BlockMiner  address.Address
+	// MaxNestedSends limits the total number of sends made by actors while executing the
+	// message, at any depth. Zero means no limit.
+	MaxNestedSends uint64
+	// MemoryBudget limits the total size in bytes of the objects actors store while executing
+	// the message, at any depth. Zero means no limit.
+	MemoryBudget uint64
+	// DisableValueTransfer makes sends leave balances untouched, at any depth. Methods still
+	// see the value sent to them.
+	DisableValueTransfer bool
+	// GasCosts prices VM operations, at any depth. A nil table charges nothing for them.
+	GasCosts gascost.Table
+	// DispatchTracer, if set, is told of each method dispatched, at any depth.
+	DispatchTracer DispatchTracer
+	// ReadOnly rejects state changes at any depth: storage commits and value transfers fail.
+	ReadOnly bool
+	// TestRandSeed, if set, seeds a pseudo-random number generator actors may draw from, at any
+	// depth. It is for test scenarios and unrelated to chain randomness.
+	TestRandSeed *int64
+	// ProtocolVersion selects the version of the actor code dispatched to, at any depth.
+	ProtocolVersion uint64
+	// MaxSteps limits the total number of VM operations, such as gas charges, storage accesses
+	// and sends, made while executing the message, at any depth. Exceeding it is a fault
+	// whatever gas remains, as a backstop against gas metering bugs. Zero means no limit.
+	MaxSteps uint64
+	// FromAddr is the ID address From resolved to. If undefined, the message's From address is
+	// taken to be it.
+	FromAddr address.Address
+	// CapabilityCheck decides the capabilities callers hold over the actors they call, at any
+	// depth. Without one, callers hold none.
+	CapabilityCheck CapabilityCheck
+	// CaptureParams records the params of the message re-encoded from the values its method
+	// decoded them to. The params of sub-calls are not recorded.
+	CaptureParams bool
+	// ActorLoadHook, if set, is called with the actor of each send actors make, at any depth.
+	// The actors of the message itself are left to the caller.
+	ActorLoadHook ActorLoadHook
+	// AddressResolver, if set, resolves the addresses actors send to, at any depth, in place of
+	// the init actor's address map.
+	AddressResolver AddressResolver
+}
+
+// sendBudget counts the nested sends made while executing a message.
+type sendBudget struct {
+	limit uint64
+	used  uint64
+}
+
+// take uses one send from the budget, returning false if there is none left.
+// A nil budget is unlimited.
+func (b *sendBudget) take() bool {
+	if b == nil {
+		return true
+	}
+	if b.used >= b.limit {
+		return false
+	}
+	b.used++
+	return true
+}
+
+// stepBudget counts the VM operations made while executing a message.
+type stepBudget struct {
+	limit    uint64
+	used     uint64
+	exceeded bool
+}
+
+// step counts one operation, returning a fault once the limit is exceeded. A nil budget is
+// unlimited.
+func (b *stepBudget) step() error {
+	if b == nil {
+		return nil
+	}
+	b.used++
+	if b.used > b.limit {
+		b.exceeded = true
+		return errors.NewFaultErrorf("message exceeded its limit of %d execution steps", b.limit)
+	}
+	return nil
+}
+
+// check returns a fault if the limit has been exceeded, even if actors ignored the fault
+// returned at the time.
+func (b *stepBudget) check() error {
+	if b == nil || !b.exceeded {
+		return nil
+	}
+	return errors.NewFaultErrorf("message exceeded its limit of %d execution steps", b.limit)
+}
+
+// memoryBudget accounts for the bytes stored by actors while executing a message.
+type memoryBudget struct {
+	limit uint64
+	used  uint64
+}
+
+// allocate charges size bytes to the budget, returning false if that exceeds the limit.
+func (b *memoryBudget) allocate(size uint64) bool {
+	if b.used+size > b.limit {
+		return false
+	}
+	b.used += size
+	return true
+}
+
+// budgetedStorage is actor storage that charges the objects it stores to a memory budget.
+type budgetedStorage struct {
+	runtime.LegacyStorage
+	budget *memoryBudget
+}
+
+// Put stores an object if its encoding fits in the remaining budget.
+func (s *budgetedStorage) Put(v interface{}) (cid.Cid, error) {
+	c, err := s.LegacyStorage.Put(v)
+	if err != nil {
+		return cid.Undef, err
+	}
+	raw, err := s.LegacyStorage.Get(c)
+	if err != nil {
+		return cid.Undef, err
+	}
+	if !s.budget.allocate(uint64(len(raw))) {
+		return cid.Undef, internal.Errors[internal.ErrMemoryBudgetExceeded]
+	}
+	return c, nil
+}
+
+// writeGuard rejects the state changes of a read-only call, remembering that one was attempted.
+type writeGuard struct {
+	attempted bool
+}
+
+func (g *writeGuard) reject() error {
+	g.attempted = true
+	return internal.Errors[internal.ErrReadOnlyViolation]
+}
+
+// storageGas accounts for the gas charged for storage operations while executing a message.
+type storageGas struct {
+	read  types.GasUnits
+	write types.GasUnits
+}
+
+// readSet holds the cids of the distinct objects read from actor storage while executing a
+// message.
+type readSet map[cid.Cid]struct{}
+
+// meteredStorage is actor storage that charges the storage operations in a gas cost table.
+type meteredStorage struct {
+	runtime.LegacyStorage
+	ctx *VMContext
+}
+
+// Get charges a storage read and loads the object with the given cid.
+func (s *meteredStorage) Get(c cid.Cid) ([]byte, error) {
+	if err := s.ctx.chargeOperation(gascost.OnStorageRead); err != nil {
+		return nil, err
+	}
+	s.ctx.storageGas.read += s.ctx.gasCosts.Cost(gascost.OnStorageRead)
+	data, err := s.LegacyStorage.Get(c)
+	if err == nil {
+		s.ctx.reads[c] = struct{}{}
+	}
+	return data, err
+}
+
+// Put charges a storage write and stores an object.
+func (s *meteredStorage) Put(v interface{}) (cid.Cid, error) {
+	if err := s.chargeWrite(); err != nil {
+		return cid.Undef, err
+	}
+	return s.LegacyStorage.Put(v)
+}
+
+// LegacyCommit charges a storage write and commits the new storage head.
+func (s *meteredStorage) LegacyCommit(newCid cid.Cid, oldCid cid.Cid) error {
+	if err := s.chargeWrite(); err != nil {
+		return err
+	}
+	return s.LegacyStorage.LegacyCommit(newCid, oldCid)
+}
+
+// chargeWrite charges a storage write, recording it in the message's storage gas.
+func (s *meteredStorage) chargeWrite() error {
+	if err := s.ctx.chargeOperation(gascost.OnStorageWrite); err != nil {
+		return err
+	}
+	s.ctx.storageGas.write += s.ctx.gasCosts.Cost(gascost.OnStorageWrite)
+	return nil
+}
+
+// loggedStorage is actor storage that records the objects read and written in an access log.
+type loggedStorage struct {
+	runtime.LegacyStorage
+	addr address.Address
+	log  *state.AccessLog
+}
+
+// Get loads the object with the given cid, recording the read.
+func (s *loggedStorage) Get(c cid.Cid) ([]byte, error) {
+	raw, err := s.LegacyStorage.Get(c)
+	if err != nil {
+		return nil, err
+	}
+	s.log.Record(state.AccessRead, s.addr, c)
+	return raw, nil
+}
+
+// Put stores an object, recording the write.
+func (s *loggedStorage) Put(v interface{}) (cid.Cid, error) {
+	c, err := s.LegacyStorage.Put(v)
+	if err != nil {
+		return cid.Undef, err
+	}
+	s.log.Record(state.AccessWrite, s.addr, c)
+	return c, nil
+}
+
+// LegacyCommit commits the new storage head, recording the write.
+func (s *loggedStorage) LegacyCommit(newCid cid.Cid, oldCid cid.Cid) error {
+	if err := s.LegacyStorage.LegacyCommit(newCid, oldCid); err != nil {
+		return err
+	}
+	s.log.Record(state.AccessWrite, s.addr, newCid)
+	return nil
+}
+
+// capabilityGuard checks the capabilities methods require of their callers, remembering the
+// first a caller lacked.
+type capabilityGuard struct {
+	check  CapabilityCheck
+	denied *CapabilityDenial
+}
+
+func (g *capabilityGuard) require(target, caller address.Address, capability runtime.Capability) error {
+	if g.check != nil && g.check(target, caller, capability) {
+		return nil
+	}
+	if g.denied == nil {
+		g.denied = &CapabilityDenial{Caller: caller, Target: target, Capability: capability}
+	}
+	return internal.Errors[internal.ErrMissingCapability]
+}
+
+// readOnlyStorage is actor storage that rejects commits.
+type readOnlyStorage struct {
+	runtime.LegacyStorage
+	guard *writeGuard
+}
+
+// LegacyCommit always fails.
+func (s *readOnlyStorage) LegacyCommit(newCid cid.Cid, oldCid cid.Cid) error {
+	return s.guard.reject()
 }
 
 // NewVMContext returns an initialized context.
@@ -77,6 +385,7 @@ func NewVMContext(params NewContextParams) *VMContext {
 		from:              params.From,
 		to:                params.To,
 		toAddr:            params.ToAddr,
+		fromAddr:          params.FromAddr,
 		message:           params.Message,
 		originMsg:         params.OriginMsg,
 		state:             params.State,
@@ -88,8 +397,37 @@ func NewVMContext(params NewContextParams) *VMContext {
 		isCallerValidated: false,
 		allowSideEffects:  true,
 		blockMiner:        params.BlockMiner,
+		noValueTransfer:   params.DisableValueTransfer,
+		gasCosts:          params.GasCosts,
+		tracer:            params.DispatchTracer,
+		protocolVersion:   params.ProtocolVersion,
+		loadHook:          params.ActorLoadHook,
+		resolver:          params.AddressResolver,
 		deps:              makeDeps(params.State),
 	}
+	if params.MaxNestedSends > 0 {
+		ctx.sends = &sendBudget{limit: params.MaxNestedSends}
+	}
+	if params.MemoryBudget > 0 {
+		ctx.memory = &memoryBudget{limit: params.MemoryBudget}
+	}
+	if params.MaxSteps > 0 {
+		ctx.steps = &stepBudget{limit: params.MaxSteps}
+	}
+	if params.ReadOnly {
+		ctx.readOnly = &writeGuard{}
+	}
+	ctx.events = &[]runtime.Event{}
+	ctx.subCalls = &[]SubCall{}
+	ctx.storageGas = &storageGas{}
+	ctx.reads = readSet{}
+	ctx.capabilities = &capabilityGuard{check: params.CapabilityCheck}
+	if params.CaptureParams {
+		ctx.capturedParams = new([]byte)
+	}
+	if params.TestRandSeed != nil {
+		ctx.testRand = rand.New(rand.NewSource(*params.TestRandSeed))
+	}
 	ctx.stateHandle = newActorStateHandle(&ctx, ctx.to.Head)
 	return &ctx
 }
@@ -100,6 +438,8 @@ func (ctx *VMContext) GasUnits() types.GasUnits {
 }
 
 var _ runtime.Runtime = (*VMContext)(nil)
+var _ runtime.EventEmitter = (*VMContext)(nil)
+var _ runtime.CallerCapabilities = (*VMContext)(nil)
 
 // CurrentEpoch is the current chain epoch.
 func (ctx *VMContext) CurrentEpoch() types.BlockHeight {
@@ -108,6 +448,7 @@ func (ctx *VMContext) CurrentEpoch() types.BlockHeight {
 
 // Randomness gives the actors access to sampling peudo-randomess from the chain.
 func (ctx *VMContext) Randomness(epoch types.BlockHeight, offset uint64) runtime.Randomness {
+	ctx.mustChargeOperation(gascost.OnRandomness)
 	rnd, err := sampling.SampleChainRandomness(&epoch, ctx.ancestors)
 	if err != nil {
 		runtime.Abortf(exitcode.MethodAbort, "failed to sample randomness")
@@ -122,6 +463,14 @@ func (ctx *VMContext) LegacySend(to address.Address, method types.MethodID, valu
 		runtime.Abortf(exitcode.MethodAbort, "Calling Send() is not allowed during side-effet lock")
 	}
 
+	if err := ctx.chargeOperation(gascost.OnSend); err != nil {
+		return nil, internal.ErrInsufficientGas, errors.RevertErrorWrap(err, "Insufficient gas")
+	}
+
+	if !ctx.sends.take() {
+		return nil, internal.ErrTooManySends, internal.Errors[internal.ErrTooManySends]
+	}
+
 	deps := ctx.deps
 
 	// the message sender is the `to` actor, so this is what we set as `from` in the new message
@@ -149,6 +498,9 @@ func (ctx *VMContext) LegacySend(to address.Address, method types.MethodID, valu
 	if err != nil {
 		return nil, 1, errors.FaultErrorWrapf(err, "failed to get or create To actor %s", msg.To)
 	}
+	if err := ctx.loadActor(toAddr, toActor); err != nil {
+		return nil, 1, errors.FaultErrorWrapf(err, "failed to load To actor %s", msg.To)
+	}
 	// TODO(fritz) de-dup some of the logic between here and core.Send
 	innerParams := NewContextParams{
 		From:        fromActor,
@@ -162,11 +514,17 @@ func (ctx *VMContext) LegacySend(to address.Address, method types.MethodID, valu
 		BlockHeight: ctx.blockHeight,
 		Ancestors:   ctx.ancestors,
 		Actors:      ctx.actors,
+		FromAddr:    from,
 	}
 	innerCtx := NewVMContext(innerParams)
+	ctx.inheritMessageState(innerCtx)
 
+	emitted := len(*ctx.events)
+	endSubCall := ctx.beginSubCall(toAddr, method)
 	out, ret, err := deps.LegacySend(context.Background(), innerCtx)
+	endSubCall()
 	if err != nil {
+		*ctx.events = (*ctx.events)[:emitted]
 		return nil, ret, err
 	}
 
@@ -183,6 +541,12 @@ func (ctx *VMContext) Send(to address.Address, method types.MethodID, value type
 		runtime.Abortf(exitcode.MethodAbort, "Calling Send() is not allowed during side-effet lock")
 	}
 
+	ctx.mustChargeOperation(gascost.OnSend)
+
+	if !ctx.sends.take() {
+		runtime.Abortf(exitcode.MethodAbort, "message exceeded its limit of %d nested sends", ctx.sends.limit)
+	}
+
 	deps := ctx.deps
 
 	// the message sender is the `to` actor, so this is what we set as `from` in the new message
@@ -210,6 +574,9 @@ func (ctx *VMContext) Send(to address.Address, method types.MethodID, value type
 	if err != nil {
 		runtime.Abortf(exitcode.MethodAbort, "failed to get or create To actor %s", msg.To)
 	}
+	if err := ctx.loadActor(toAddr, toActor); err != nil {
+		panic(faultPanic{err: errors.FaultErrorWrapf(err, "failed to load To actor %s", msg.To)})
+	}
 	// TODO(fritz) de-dup some of the logic between here and core.Send
 	innerParams := NewContextParams{
 		From:        fromActor,
@@ -223,19 +590,58 @@ func (ctx *VMContext) Send(to address.Address, method types.MethodID, value type
 		BlockHeight: ctx.blockHeight,
 		Ancestors:   ctx.ancestors,
 		Actors:      ctx.actors,
+		FromAddr:    from,
 	}
 	innerCtx := NewVMContext(innerParams)
+	ctx.inheritMessageState(innerCtx)
 
+	defer ctx.beginSubCall(toAddr, method)()
 	return deps.Apply(innerCtx)
 }
 
+// inheritMessageState makes inner, the context of a send made by ctx, share the budgets,
+// settings and records kept for the whole message, at any depth.
+func (ctx *VMContext) inheritMessageState(inner *VMContext) {
+	inner.sends = ctx.sends
+	inner.memory = ctx.memory
+	inner.noValueTransfer = ctx.noValueTransfer
+	inner.gasCosts = ctx.gasCosts
+	inner.tracer = ctx.tracer
+	inner.readOnly = ctx.readOnly
+	inner.events = ctx.events
+	inner.subCalls = ctx.subCalls
+	inner.testRand = ctx.testRand
+	inner.protocolVersion = ctx.protocolVersion
+	inner.storageGas = ctx.storageGas
+	inner.reads = ctx.reads
+	inner.capabilities = ctx.capabilities
+	inner.steps = ctx.steps
+	inner.loadHook = ctx.loadHook
+	inner.resolver = ctx.resolver
+}
+
+// loadActor runs the actor load hook, if there is one, on the actor at addr a send is about to
+// invoke.
+func (ctx *VMContext) loadActor(addr address.Address, act *actor.Actor) error {
+	if ctx.loadHook == nil {
+		return nil
+	}
+	return ctx.loadHook(addr, act)
+}
+
 func apply(ctx *VMContext) interface{} {
 	filValue := ctx.message.Value
-	if !filValue.Equal(types.ZeroAttoFIL) {
+	if !ctx.noValueTransfer && !filValue.Equal(types.ZeroAttoFIL) {
 		if filValue.IsNegative() {
 			runtime.Abortf(exitcode.MethodAbort, "Can not transfer negative FIL value")
 		}
+		if ctx.readOnly != nil {
+			runtime.Abortf(exitcode.MethodAbort, "%s", ctx.readOnly.reject())
+		}
 		if err := Transfer(ctx.from, ctx.to, filValue); err != nil {
+			if errors.IsFault(err) {
+				panic(faultPanic{err: err})
+			}
 			runtime.Abort(exitcode.InsufficientFunds)
 		}
 	}
@@ -254,8 +660,7 @@ func apply(ctx *VMContext) interface{} {
 		panic("trying to execute fake method on the actual VM, fix test")
 	}
 
-	// TODO: use chain height based protocol version here (#3360)
-	toExecutable, err := ctx.Actors().GetActorCode(ctx.To().Code, 0)
+	toExecutable, err := ctx.Actors().GetActorCode(ctx.To().Code, ctx.protocolVersion)
 	if err != nil {
 		runtime.Abort(exitcode.ActorCodeNotFound)
 	}
@@ -264,6 +669,7 @@ func apply(ctx *VMContext) interface{} {
 	if !ok {
 		runtime.Abort(exitcode.InvalidMethod)
 	}
+	ctx.traceDispatch()
 
 	vals, code, err := exportedFn(ctx)
 
@@ -348,14 +754,158 @@ func (ctx *VMContext) Storage() runtime.Storage {
 
 // LegacyStorage returns an implementation of the storage module for this context.
 func (ctx *VMContext) LegacyStorage() runtime.LegacyStorage {
-	return ctx.storageMap.NewStorage(ctx.toAddr, ctx.to)
+	var storage runtime.LegacyStorage = ctx.storageMap.NewStorage(ctx.toAddr, ctx.to)
+	if ctx.memory != nil {
+		storage = &budgetedStorage{LegacyStorage: storage, budget: ctx.memory}
+	}
+	if ctx.readOnly != nil {
+		storage = &readOnlyStorage{LegacyStorage: storage, guard: ctx.readOnly}
+	}
+	if log := ctx.state.AccessLog(); log != nil {
+		storage = &loggedStorage{LegacyStorage: storage, addr: ctx.toAddr, log: log}
+	}
+	if ctx.storageGas != nil {
+		// Metered outermost so that reads made by the other wrappers are not charged.
+		storage = &meteredStorage{LegacyStorage: storage, ctx: ctx}
+	}
+	return storage
+}
+
+// EmitEvent records an event emitted by the context's actor.
+func (ctx *VMContext) EmitEvent(topics [][]byte, data []byte) {
+	*ctx.events = append(*ctx.events, runtime.Event{Emitter: ctx.toAddr, Topics: topics, Data: data})
+}
+
+// Events returns the events emitted while executing the message, at any depth, in order.
+func (ctx *VMContext) Events() []runtime.Event {
+	return *ctx.events
+}
+
+// beginSubCall records a send from the context's actor, returning a function to be called
+// when the send returns to record the gas it used.
+func (ctx *VMContext) beginSubCall(to address.Address, method types.MethodID) func() {
+	i := len(*ctx.subCalls)
+	*ctx.subCalls = append(*ctx.subCalls, SubCall{From: ctx.toAddr, To: to, Method: method})
+	start := ctx.GasUnits()
+	return func() {
+		(*ctx.subCalls)[i].GasUsed = ctx.GasUnits() - start
+	}
+}
+
+// SubCalls returns the sends made by actors while executing the message, at any depth, in the
+// order they were made.
+func (ctx *VMContext) SubCalls() []SubCall {
+	return *ctx.subCalls
+}
+
+// TestRand returns the context's seeded pseudo-random number generator, or nil if it has none.
+func (ctx *VMContext) TestRand() *rand.Rand {
+	return ctx.testRand
+}
+
+// StorageGasUsed returns the gas charged for reading and writing actor storage while executing
+// the message, at any depth.
+func (ctx *VMContext) StorageGasUsed() (read, write types.GasUnits) {
+	if ctx.storageGas == nil {
+		return 0, 0
+	}
+	return ctx.storageGas.read, ctx.storageGas.write
+}
+
+// CallerID returns the ID address of the immediate caller.
+func (ctx *VMContext) CallerID() address.Address {
+	if ctx.fromAddr.Empty() {
+		return ctx.message.From
+	}
+	return ctx.fromAddr
+}
+
+// RequireCapability returns an error unless the immediate caller holds capability over the
+// context's actor.
+func (ctx *VMContext) RequireCapability(capability runtime.Capability) error {
+	return ctx.capabilities.require(ctx.toAddr, ctx.CallerID(), capability)
+}
+
+// CapabilityDenied returns the first capability a caller was found to lack while executing
+// the message, at any depth, or nil if there was none.
+func (ctx *VMContext) CapabilityDenied() *CapabilityDenial {
+	return ctx.capabilities.denied
+}
+
+// CanonicalParams returns the params of the message re-encoded from the values its method
+// decoded them to, or nil if they were not captured or the method was never dispatched.
+func (ctx *VMContext) CanonicalParams() []byte {
+	if ctx.capturedParams == nil {
+		return nil
+	}
+	return *ctx.capturedParams
+}
+
+// recordParams captures the canonical encoding of the params the method was dispatched with,
+// if the context captures them.
+func (ctx *VMContext) recordParams(params []*abi.Value) error {
+	if ctx.capturedParams == nil {
+		return nil
+	}
+	canonical, err := abi.EncodeValues(params)
+	if err != nil {
+		return err
+	}
+	*ctx.capturedParams = canonical
+	return nil
+}
+
+// NodesRead returns the number of distinct objects read from actor storage while executing the
+// message, at any depth.
+func (ctx *VMContext) NodesRead() int {
+	return len(ctx.reads)
+}
+
+// WriteAttempted reports whether a read-only call attempted to change state, at any depth.
+func (ctx *VMContext) WriteAttempted() bool {
+	return ctx.readOnly != nil && ctx.readOnly.attempted
 }
 
 // Charge attempts to add the given cost to the accrued gas cost of this transaction
 func (ctx *VMContext) Charge(cost types.GasUnits) error {
+	if err := ctx.steps.step(); err != nil {
+		return err
+	}
 	return ctx.gasTracker.Charge(cost)
 }
 
+// traceDispatch tells the tracer, if any, that the context's message method is being dispatched.
+func (ctx *VMContext) traceDispatch() {
+	if ctx.tracer != nil {
+		ctx.tracer(ctx.to.Code, ctx.message.Method)
+	}
+}
+
+// chargeOperation charges the cost of op in the context's gas cost table.
+func (ctx *VMContext) chargeOperation(op gascost.Operation) error {
+	if err := ctx.steps.step(); err != nil {
+		return err
+	}
+	cost := ctx.gasCosts.Cost(op)
+	if cost == 0 {
+		return nil
+	}
+	return ctx.gasTracker.Charge(cost)
+}
+
+// mustChargeOperation charges the cost of op for the runtime calls that cannot return errors.
+// If the message cannot afford it, the nearest enclosing send fails with an ErrInsufficientGas
+// revert, as a LegacySend that cannot be charged does. If it exceeds the step limit, the send
+// faults.
+func (ctx *VMContext) mustChargeOperation(op gascost.Operation) {
+	if err := ctx.chargeOperation(op); err != nil {
+		if errors.IsFault(err) {
+			panic(faultPanic{err: err})
+		}
+		panic(revertPanic{code: internal.ErrInsufficientGas, err: errors.RevertErrorWrap(err, "Insufficient gas")})
+	}
+}
+
 var _ runtime.ExtendedInvocationContext = (*VMContext)(nil)
 
 func isBuiltinActor(code cid.Cid) bool {
@@ -375,6 +925,8 @@ func isSingletonActor(code cid.Cid) bool {
 
 // CreateActor implements the ExtendedInvocationContext interface.
 func (ctx *VMContext) CreateActor(actorID types.Uint64, code cid.Cid, params []interface{}) address.Address {
+	ctx.mustChargeOperation(gascost.OnCreateActor)
+
 	if !isBuiltinActor(code) {
 		runtime.Abortf(exitcode.MethodAbort, "Can only create built-in actors.")
 	}
@@ -432,7 +984,8 @@ func (ctx *VMContext) CreateActor(actorID types.Uint64, code cid.Cid, params []i
 }
 
 // VerifySignature implemenets the ExtendedInvocationContext interface.
-func (*VMContext) VerifySignature(signer address.Address, signature types.Signature, msg []byte) bool {
+func (ctx *VMContext) VerifySignature(signer address.Address, signature types.Signature, msg []byte) bool {
+	ctx.mustChargeOperation(gascost.OnVerifySignature)
 	return types.IsValidSignature(msg, signer, signature)
 }
 
@@ -553,7 +1106,10 @@ type TransferFn = func(*actor.Actor, *actor.Actor, types.AttoFIL) error
 // send executes a message pass inside the VM. It exists alongside Send so that we can inject its dependencies during test.
 func send(ctx context.Context, transfer TransferFn, vmCtx *VMContext) ([][]byte, uint8, error) {
 	msg := vmCtx.LegacyMessage()
-	if !msg.Value.Equal(types.ZeroAttoFIL) {
+	if !vmCtx.noValueTransfer && !msg.Value.Equal(types.ZeroAttoFIL) {
+		if vmCtx.readOnly != nil {
+			return nil, internal.ErrReadOnlyViolation, vmCtx.readOnly.reject()
+		}
 		if err := transfer(vmCtx.From(), vmCtx.To(), msg.Value); err != nil {
 			if errors.ShouldRevert(err) {
 				return nil, err.(*errors.RevertError).Code(), err
@@ -578,8 +1134,7 @@ func send(ctx context.Context, transfer TransferFn, vmCtx *VMContext) ([][]byte,
 		return nil, 1, errors.NewRevertError("can only construct actor from init actor")
 	}
 
-	// TODO: use chain height based protocol version here (#3360)
-	toExecutable, err := vmCtx.Actors().GetActorCode(vmCtx.To().Code, 0)
+	toExecutable, err := vmCtx.Actors().GetActorCode(vmCtx.To().Code, vmCtx.protocolVersion)
 	if err != nil {
 		return nil, errors.ErrNoActorCode, errors.Errors[errors.ErrNoActorCode]
 	}
@@ -588,8 +1143,12 @@ func send(ctx context.Context, transfer TransferFn, vmCtx *VMContext) ([][]byte,
 	if !ok {
 		return nil, 1, errors.Errors[errors.ErrMissingExport]
 	}
+	vmCtx.traceDispatch()
 
-	vals, code, err := exportedFn(vmCtx)
+	vals, code, err := invokeExport(exportedFn, vmCtx)
+	if stepErr := vmCtx.steps.check(); stepErr != nil {
+		return nil, 1, stepErr
+	}
 	if vals != nil {
 		r, err := abi.ToEncodedValues(vals...)
 		if err != nil {
@@ -608,7 +1167,39 @@ func send(ctx context.Context, transfer TransferFn, vmCtx *VMContext) ([][]byte,
 	return nil, code, err
 }
 
-// Transfer transfers the given value between two actors.
+// faultPanic carries a fault raised within Send or another runtime call that cannot return
+// errors to the nearest enclosing send.
+type faultPanic struct {
+	err error
+}
+
+// revertPanic carries a revert raised within a runtime call that cannot return errors, such as
+// Randomness running out of gas, to the nearest enclosing send.
+type revertPanic struct {
+	code uint8
+	err  error
+}
+
+// invokeExport calls fn, returning a fault or revert raised by a runtime call it made, at any
+// depth, as its error.
+func invokeExport(fn ExportedFunc, vmCtx *VMContext) (vals []interface{}, code uint8, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			switch p := r.(type) {
+			case faultPanic:
+				vals, code, err = nil, 1, p.err
+			case revertPanic:
+				vals, code, err = nil, p.code, p.err
+			default:
+				panic(r)
+			}
+		}
+	}()
+	return fn(vmCtx)
+}
+
+// Transfer transfers the given value between two actors. It faults if the recipient's balance
+// would exceed types.MaxAttoFIL.
 func Transfer(fromActor, toActor *actor.Actor, value types.AttoFIL) error {
 	if value.IsNegative() {
 		return errors.Errors[errors.ErrCannotTransferNegativeValue]
@@ -618,6 +1209,11 @@ func Transfer(fromActor, toActor *actor.Actor, value types.AttoFIL) error {
 		return errors.Errors[errors.ErrInsufficientBalance]
 	}
 
+	// an overflowing balance can only come from a corrupt or adversarial state
+	if toActor.Balance.Add(value).GreaterThan(types.MaxAttoFIL) {
+		return errors.NewFaultErrorf("crediting %s to balance %s exceeds the maximum balance", value, toActor.Balance)
+	}
+
 	fromActor.Balance = fromActor.Balance.Sub(value)
 	toActor.Balance = toActor.Balance.Add(value)
 
@@ -625,6 +1221,8 @@ func Transfer(fromActor, toActor *actor.Actor, value types.AttoFIL) error {
 }
 
 // GetOrCreateActor retrieves an actor by first resolving its address. If that fails it will initialize a new account actor
+// with the next ID the init actor assigns, so the actors a message creates are numbered in the order the sends first
+// referencing them are made.
 func (ctx *VMContext) getOrCreateActor(c context.Context, st *state.CachedTree, addr address.Address) (*actor.Actor, address.Address, error) {
 	// resolve address before lookup
 	idAddr, err := ctx.resolveActorAddress(addr)
@@ -657,6 +1255,14 @@ func (ctx *VMContext) getOrCreateActor(c context.Context, st *state.CachedTree,
 
 // resolveAddress looks up associated id address if actor address. Otherwise it returns the same address.
 func (ctx *VMContext) resolveActorAddress(addr address.Address) (address.Address, error) {
+	if ctx.resolver != nil {
+		idAddr, found, err := ctx.resolver(context.TODO(), addr)
+		if err != nil || !found {
+			return address.Undef, err
+		}
+		return idAddr, nil
+	}
+
 	if addr.Protocol() == address.ID {
 		return addr, nil
 	}
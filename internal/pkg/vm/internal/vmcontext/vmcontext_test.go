@@ -479,6 +479,33 @@ func TestSendErrorHandling(t *testing.T) {
 	})
 }
 
+func TestStepLimitWithinRuntimeCall(t *testing.T) {
+	tf.UnitTest(t)
+
+	msg := types.NewMessageForTestGetter()()
+	vmCtx := NewVMContext(NewContextParams{
+		From:        actor.NewActor(cid.Undef, types.ZeroAttoFIL),
+		To:          actor.NewActor(cid.Undef, types.ZeroAttoFIL),
+		Message:     msg,
+		OriginMsg:   msg,
+		State:       state.NewCachedTree(&state.MockStateTree{NoMocks: true}),
+		GasTracker:  gastracker.NewLegacyGasTracker(),
+		BlockHeight: types.NewBlockHeight(0),
+		MaxSteps:    1,
+	})
+
+	// the charge takes the only step, so Randomness exceeds the limit
+	_, code, err := invokeExport(func(ExportContext) ([]interface{}, uint8, error) {
+		require.NoError(t, vmCtx.Charge(0))
+		vmCtx.Randomness(*types.NewBlockHeight(0), 0)
+		return nil, 0, nil
+	}, vmCtx)
+
+	assert.Equal(t, 1, int(code))
+	assert.True(t, errors.IsFault(err))
+	assert.Contains(t, err.Error(), "execution steps")
+}
+
 func TestTransfer(t *testing.T) {
 	tf.UnitTest(t)
 
@@ -501,6 +528,45 @@ func TestTransfer(t *testing.T) {
 		assert.EqualError(t, Transfer(actor2, actor3, types.NewAttoFILFromFIL(1000)), "not enough balance")
 		assert.EqualError(t, Transfer(actor2, actor3, negval), "cannot transfer negative values")
 	})
+
+	t.Run("balance overflow", func(t *testing.T) {
+		nearMax := actor.NewActor(cid.Undef, types.MaxAttoFIL.Sub(types.NewAttoFILFromFIL(1)))
+		funder := actor.NewActor(cid.Undef, types.NewAttoFILFromFIL(10))
+
+		err := Transfer(funder, nearMax, types.NewAttoFILFromFIL(2))
+		assert.True(t, errors.IsFault(err))
+		assert.Equal(t, types.NewAttoFILFromFIL(10), funder.Balance)
+
+		assert.NoError(t, Transfer(funder, nearMax, types.NewAttoFILFromFIL(1)))
+		assert.True(t, types.MaxAttoFIL.Equal(nearMax.Balance))
+	})
+
+	t.Run("balance overflow in Send is returned as a fault", func(t *testing.T) {
+		nearMax := actor.NewActor(types.CidFromString(t, "somecid"), types.MaxAttoFIL.Sub(types.NewAttoFILFromFIL(1)))
+		funder := actor.NewActor(types.CidFromString(t, "somecid"), types.NewAttoFILFromFIL(10))
+		msg := types.NewMessageForTestGetter()()
+		msg.Value = types.NewAttoFILFromFIL(2)
+		msg.Method = types.SendMethodID
+
+		vmCtx := NewVMContext(NewContextParams{
+			From:        funder,
+			To:          nearMax,
+			Message:     msg,
+			State:       state.NewCachedTree(&state.MockStateTree{NoMocks: true}),
+			StorageMap:  storagemap.NewStorageMap(blockstore.NewBlockstore(datastore.NewMapDatastore())),
+			GasTracker:  gastracker.NewLegacyGasTracker(),
+			BlockHeight: types.NewBlockHeight(0),
+		})
+		// a method whose Send of the message to nearMax overflows its balance
+		sends := func(ExportContext) ([]interface{}, uint8, error) {
+			apply(vmCtx)
+			return nil, 0, nil
+		}
+		_, code, err := invokeExport(sends, vmCtx)
+		assert.True(t, errors.IsFault(err), "unexpected error %v", err)
+		assert.Equal(t, uint8(1), code)
+		assert.Equal(t, types.NewAttoFILFromFIL(10), funder.Balance)
+	})
 }
 
 func requireCreateInitActor(t *testing.T, bs blockstore.Blockstore) *actor.Actor {
@@ -0,0 +1,40 @@
+package state
+
+import (
+	"github.com/ipfs/go-cid"
+
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/address"
+)
+
+// AccessKind distinguishes reads from writes in an access log.
+type AccessKind int
+
+const (
+	// AccessRead is a read of an actor or of an object in its storage.
+	AccessRead AccessKind = iota
+	// AccessWrite is a write of an actor or of an object in its storage.
+	AccessWrite
+)
+
+// Access is a single state read or write.
+type Access struct {
+	Kind AccessKind
+	// Addr is the address of the actor accessed, or whose storage was accessed.
+	Addr address.Address
+	// Cid is the head of the actor accessed, or the cid of the storage object accessed. It is
+	// undefined for a read of an actor that does not exist.
+	Cid cid.Cid
+}
+
+// AccessLog records state accesses in the order they were made.
+type AccessLog struct {
+	Accesses []Access
+}
+
+// Record appends an access to the log. A nil log records nothing.
+func (l *AccessLog) Record(kind AccessKind, addr address.Address, c cid.Cid) {
+	if l == nil {
+		return
+	}
+	l.Accesses = append(l.Accesses, Access{Kind: kind, Addr: addr, Cid: c})
+}
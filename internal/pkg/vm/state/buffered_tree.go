@@ -0,0 +1,157 @@
+package state
+
+import (
+	"context"
+	"sort"
+
+	"github.com/ipfs/go-cid"
+
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/actor"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/address"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/errors"
+)
+
+// BufferedTree is a state tree that holds the actors set or deleted through it in memory, on
+// top of an underlying tree it only reads from until Commit or Flush. Unlike a CachedTree it
+// is itself a Tree, so the cached trees of many messages can be committed to it in turn and
+// the underlying tree is written once for all of them.
+type BufferedTree struct {
+	st Tree
+	// writes maps each address set or deleted since the last commit to its actor, or to nil
+	// if it was deleted.
+	writes map[address.Address]*actor.Actor
+}
+
+var _ Tree = &BufferedTree{}
+
+// NewBufferedTree returns a BufferedTree with no pending writes on top of st.
+func NewBufferedTree(st Tree) *BufferedTree {
+	return &BufferedTree{
+		st:     st,
+		writes: make(map[address.Address]*actor.Actor),
+	}
+}
+
+// GetActor returns a copy of the actor at a, reading the pending writes before the
+// underlying tree. As with the actors a tree returns, callers may modify the copy without
+// changing the buffered actor.
+func (t *BufferedTree) GetActor(ctx context.Context, a address.Address) (*actor.Actor, error) {
+	act, found := t.writes[a]
+	if !found {
+		return t.st.GetActor(ctx, a)
+	}
+	if act == nil {
+		return nil, &actorNotFoundError{}
+	}
+	cp := *act
+	return &cp, nil
+}
+
+// GetOrCreateActor returns the actor at addr, or the actor creator returns if there is none.
+func (t *BufferedTree) GetOrCreateActor(ctx context.Context, addr address.Address, creator func() (*actor.Actor, address.Address, error)) (*actor.Actor, address.Address, error) {
+	act, err := t.GetActor(ctx, addr)
+	if IsActorNotFoundError(err) {
+		return creator()
+	}
+	return act, addr, err
+}
+
+// SetActor buffers a copy of act as the actor at a.
+func (t *BufferedTree) SetActor(ctx context.Context, a address.Address, act *actor.Actor) error {
+	cp := *act
+	t.writes[a] = &cp
+	return nil
+}
+
+// DeleteActor buffers the removal of the actor at a. It returns an error for which
+// IsActorNotFoundError(err) is true if there is no such actor.
+func (t *BufferedTree) DeleteActor(ctx context.Context, a address.Address) error {
+	if _, err := t.GetActor(ctx, a); err != nil {
+		return err
+	}
+	t.writes[a] = nil
+	return nil
+}
+
+// ForEachActor calls walkFn for each actor of the underlying tree that has no pending write,
+// then for each actor set since the last commit, in address order.
+func (t *BufferedTree) ForEachActor(ctx context.Context, walkFn ActorWalkFn) error {
+	err := t.st.ForEachActor(ctx, func(addr address.Address, act *actor.Actor) error {
+		if _, written := t.writes[addr]; written {
+			return nil
+		}
+		return walkFn(addr, act)
+	})
+	if err != nil {
+		return err
+	}
+	for _, addr := range t.writtenAddresses() {
+		if act := t.writes[addr]; act != nil {
+			cp := *act
+			if err := walkFn(addr, &cp); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// GetAllActors returns a channel which provides the actors ForEachActor walks.
+func (t *BufferedTree) GetAllActors(ctx context.Context) <-chan GetAllActorsResult {
+	out := make(chan GetAllActorsResult)
+	go func() {
+		defer close(out)
+		err := t.ForEachActor(ctx, func(addr address.Address, act *actor.Actor) error {
+			select {
+			case out <- GetAllActorsResult{Address: addr.String(), Actor: act}:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+		if err != nil {
+			select {
+			case out <- GetAllActorsResult{Error: err}:
+			case <-ctx.Done():
+			}
+		}
+	}()
+	return out
+}
+
+// Commit writes the pending sets and deletes to the underlying tree, in address order, and
+// clears them.
+func (t *BufferedTree) Commit(ctx context.Context) error {
+	for _, addr := range t.writtenAddresses() {
+		act := t.writes[addr]
+		if act == nil {
+			if err := t.st.DeleteActor(ctx, addr); err != nil && !IsActorNotFoundError(err) {
+				return errors.FaultErrorWrap(err, "could not commit buffered actor deletion to state tree")
+			}
+			continue
+		}
+		if err := t.st.SetActor(ctx, addr, act); err != nil {
+			return errors.FaultErrorWrap(err, "could not commit buffered actors to state tree")
+		}
+	}
+	t.writes = make(map[address.Address]*actor.Actor)
+	return nil
+}
+
+// Flush commits the pending writes and flushes the underlying tree, returning its root.
+func (t *BufferedTree) Flush(ctx context.Context) (cid.Cid, error) {
+	if err := t.Commit(ctx); err != nil {
+		return cid.Undef, err
+	}
+	return t.st.Flush(ctx)
+}
+
+// writtenAddresses returns the addresses with pending writes, sorted.
+func (t *BufferedTree) writtenAddresses() []address.Address {
+	addrs := make([]address.Address, 0, len(t.writes))
+	for addr := range t.writes {
+		addrs = append(addrs, addr)
+	}
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i].String() < addrs[j].String() })
+	return addrs
+}
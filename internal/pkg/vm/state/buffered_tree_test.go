@@ -0,0 +1,77 @@
+package state
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ipfs/go-hamt-ipld"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	tf "github.com/filecoin-project/go-filecoin/internal/pkg/testhelpers/testflags"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/types"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/actor"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/address"
+)
+
+func TestBufferedTreeDefersWrites(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+	underlying := NewTree(hamt.NewCborStore())
+	tree := NewBufferedTree(underlying)
+
+	addrGetter := address.NewForTestGetter()
+	kept, deleted, added := addrGetter(), addrGetter(), addrGetter()
+	require.NoError(t, underlying.SetActor(ctx, kept, actor.NewActor(types.AccountActorCodeCid, types.NewAttoFILFromFIL(1))))
+	require.NoError(t, underlying.SetActor(ctx, deleted, actor.NewActor(types.AccountActorCodeCid, types.NewAttoFILFromFIL(2))))
+	before, err := underlying.Flush(ctx)
+	require.NoError(t, err)
+
+	// a committed cached tree writes to the buffer, not the underlying tree
+	cached := NewCachedTree(tree)
+	act, err := cached.GetActor(ctx, kept)
+	require.NoError(t, err)
+	act.IncrementSeqNum()
+	require.NoError(t, cached.Commit(ctx))
+	require.NoError(t, tree.SetActor(ctx, added, actor.NewActor(types.AccountActorCodeCid, types.NewAttoFILFromFIL(3))))
+	require.NoError(t, tree.DeleteActor(ctx, deleted))
+
+	root, err := underlying.Flush(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, before, root)
+
+	// actors read from the buffer are copies
+	act, err = tree.GetActor(ctx, kept)
+	require.NoError(t, err)
+	assert.Equal(t, types.Uint64(1), act.CallSeqNum)
+	act.IncrementSeqNum()
+	act, err = tree.GetActor(ctx, kept)
+	require.NoError(t, err)
+	assert.Equal(t, types.Uint64(1), act.CallSeqNum)
+
+	_, err = tree.GetActor(ctx, deleted)
+	assert.True(t, IsActorNotFoundError(err))
+	assert.True(t, IsActorNotFoundError(tree.DeleteActor(ctx, deleted)))
+
+	balances := map[address.Address]types.AttoFIL{}
+	require.NoError(t, tree.ForEachActor(ctx, func(addr address.Address, act *actor.Actor) error {
+		balances[addr] = act.Balance
+		return nil
+	}))
+	assert.Equal(t, map[address.Address]types.AttoFIL{
+		kept:  types.NewAttoFILFromFIL(1),
+		added: types.NewAttoFILFromFIL(3),
+	}, balances)
+
+	// flushing writes the buffer to the underlying tree
+	_, err = tree.Flush(ctx)
+	require.NoError(t, err)
+	act, err = underlying.GetActor(ctx, kept)
+	require.NoError(t, err)
+	assert.Equal(t, types.Uint64(1), act.CallSeqNum)
+	_, err = underlying.GetActor(ctx, added)
+	assert.NoError(t, err)
+	_, err = underlying.GetActor(ctx, deleted)
+	assert.True(t, IsActorNotFoundError(err))
+}
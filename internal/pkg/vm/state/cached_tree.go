@@ -2,6 +2,9 @@ package state
 
 import (
 	"context"
+	"sort"
+
+	"github.com/ipfs/go-cid"
 
 	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/actor"
 	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/address"
@@ -12,6 +15,7 @@ import (
 type CachedTree struct {
 	st    Tree
 	cache map[address.Address]*actor.Actor
+	log   *AccessLog
 }
 
 // NewCachedTree returns a `CachedTree` based on an existiing `Tree`.
@@ -24,6 +28,17 @@ func NewCachedTree(st Tree) *CachedTree {
 	}
 }
 
+// SetAccessLog makes the tree record in log each actor read through it and each actor it
+// commits. A nil log stops the recording.
+func (t *CachedTree) SetAccessLog(log *AccessLog) {
+	t.log = log
+}
+
+// AccessLog returns the log the tree records accesses in, or nil if it records none.
+func (t *CachedTree) AccessLog() *AccessLog {
+	return t.log
+}
+
 // GetActor retrieves an actor from the cache. If it's not found it will get it from the
 // underlying tree and then set it in the cache before returning it.
 func (t *CachedTree) GetActor(ctx context.Context, a address.Address) (*actor.Actor, error) {
@@ -32,10 +47,12 @@ func (t *CachedTree) GetActor(ctx context.Context, a address.Address) (*actor.Ac
 	if !found {
 		actor, err = t.st.GetActor(ctx, a)
 		if err != nil {
+			t.log.Record(AccessRead, a, cid.Undef)
 			return nil, err
 		}
 		t.cache[a] = actor
 	}
+	t.log.Record(AccessRead, a, actor.Head)
 	return actor, nil
 }
 
@@ -48,6 +65,7 @@ func (t *CachedTree) GetOrCreateActor(ctx context.Context,
 	var err error
 	actor, found := t.cache[addr]
 	if found {
+		t.log.Record(AccessRead, addr, actor.Head)
 		return actor, addr, nil
 	}
 
@@ -56,11 +74,17 @@ func (t *CachedTree) GetOrCreateActor(ctx context.Context,
 		return nil, address.Undef, err
 	}
 	t.cache[mappedAddr] = actor
+	t.log.Record(AccessRead, mappedAddr, actor.Head)
 	return actor, mappedAddr, nil
 }
 
 // Commit takes all the cached actors and sets them into the underlying cache.
 func (t *CachedTree) Commit(ctx context.Context) error {
+	if t.log != nil {
+		for _, addr := range t.cachedAddresses() {
+			t.log.Record(AccessWrite, addr, t.cache[addr].Head)
+		}
+	}
 	for addr, actor := range t.cache {
 		err := t.st.SetActor(ctx, addr, actor)
 		if err != nil {
@@ -70,3 +94,39 @@ func (t *CachedTree) Commit(ctx context.Context) error {
 	t.cache = make(map[address.Address]*actor.Actor)
 	return nil
 }
+
+// CommitObserved commits the cached actors like Commit, then calls observe with each actor it
+// set, in address order. Nothing is observed if the commit fails.
+func (t *CachedTree) CommitObserved(ctx context.Context, observe func(address.Address, *actor.Actor)) error {
+	actors := t.cache
+	addrs := t.cachedAddresses()
+	if err := t.Commit(ctx); err != nil {
+		return err
+	}
+
+	for _, addr := range addrs {
+		observe(addr, actors[addr])
+	}
+	return nil
+}
+
+// ForEachCachedActor calls walkFn with each actor in the cache, i.e. each actor read or written
+// since the last commit, in address order. It stops at the first error walkFn returns.
+func (t *CachedTree) ForEachCachedActor(walkFn ActorWalkFn) error {
+	for _, addr := range t.cachedAddresses() {
+		if err := walkFn(addr, t.cache[addr]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cachedAddresses returns the addresses of the cached actors, sorted.
+func (t *CachedTree) cachedAddresses() []address.Address {
+	addrs := make([]address.Address, 0, len(t.cache))
+	for addr := range t.cache {
+		addrs = append(addrs, addr)
+	}
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i].String() < addrs[j].String() })
+	return addrs
+}
@@ -94,6 +94,16 @@ func (m *MockStateTree) SetActor(ctx context.Context, address address.Address, a
 	return args.Error(0)
 }
 
+// DeleteActor implements StateTree.DeleteActor.
+func (m *MockStateTree) DeleteActor(ctx context.Context, address address.Address) error {
+	if m.NoMocks {
+		return nil
+	}
+
+	args := m.Called(ctx, address)
+	return args.Error(0)
+}
+
 // GetOrCreateActor implements StateTree.GetOrCreateActor.
 func (m *MockStateTree) GetOrCreateActor(ctx context.Context, addr address.Address, creator func() (*actor.Actor, address.Address, error)) (*actor.Actor, address.Address, error) {
 	return creator()
@@ -21,6 +21,7 @@ type Tree interface {
 	GetActor(ctx context.Context, a address.Address) (*actor.Actor, error)
 	GetOrCreateActor(ctx context.Context, a address.Address, c func() (*actor.Actor, address.Address, error)) (*actor.Actor, address.Address, error)
 	SetActor(ctx context.Context, a address.Address, act *actor.Actor) error
+	DeleteActor(ctx context.Context, a address.Address) error
 
 	ForEachActor(ctx context.Context, walkFn ActorWalkFn) error
 	GetAllActors(ctx context.Context) <-chan GetAllActorsResult
@@ -107,6 +108,18 @@ func (t *tree) SetActor(ctx context.Context, a address.Address, act *actor.Actor
 	return nil
 }
 
+// DeleteActor removes the actor at address 'a'. It returns an error for which
+// IsActorNotFoundError(err) is true if there is no such actor.
+func (t *tree) DeleteActor(ctx context.Context, a address.Address) error {
+	err := t.root.Delete(ctx, a.String())
+	if err == hamt.ErrNotFound {
+		return &actorNotFoundError{}
+	} else if err != nil {
+		return errors.Wrap(err, "deleting actor from state tree failed")
+	}
+	return nil
+}
+
 // ForEachActor calls walkFn for each actor in the state tree
 func (t *tree) ForEachActor(ctx context.Context, walkFn ActorWalkFn) error {
 	return forEachActor(ctx, t.store, t.root, walkFn)
@@ -7,9 +7,13 @@ import (
 
 	"github.com/filecoin-project/go-filecoin/internal/pkg/types"
 	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/actor"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/errors"
 	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/internal/dispatch"
+	internal "github.com/filecoin-project/go-filecoin/internal/pkg/vm/internal/errors"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/internal/gascost"
 	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/internal/gastracker"
 	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/internal/interpreter"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/internal/runtime"
 	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/internal/storage"
 	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/internal/storagemap"
 	"github.com/filecoin-project/go-filecoin/internal/pkg/vm/internal/vmcontext"
@@ -35,6 +39,9 @@ func NewStorageMap(bs blockstore.Blockstore) StorageMap {
 	return storagemap.NewStorageMap(bs)
 }
 
+// GasTracker meters the gas used by messages and by the block that contains them.
+type GasTracker = gastracker.GasTracker
+
 // LegacyGasTracker maintains the state of gas usage throughout the execution of a block and a message
 type LegacyGasTracker = gastracker.LegacyGasTracker
 
@@ -43,6 +50,62 @@ func NewLegacyGasTracker() *gastracker.LegacyGasTracker {
 	return gastracker.NewLegacyGasTracker()
 }
 
+// GasCostTable maps VM operations to the gas charged for them.
+type GasCostTable = gascost.Table
+
+// GasOperation identifies a VM operation actors may be charged for.
+type GasOperation = gascost.Operation
+
+// Operations priced by a GasCostTable.
+const (
+	GasOnSend            = gascost.OnSend
+	GasOnVerifySignature = gascost.OnVerifySignature
+	GasOnRandomness      = gascost.OnRandomness
+	GasOnCreateActor     = gascost.OnCreateActor
+	GasOnStorageRead     = gascost.OnStorageRead
+	GasOnStorageWrite    = gascost.OnStorageWrite
+)
+
+// IsVMExitCode reports whether exitCode is one the VM itself fails messages with, including
+// the generic revert code, rather than one defined by an actor.
+func IsVMExitCode(exitCode uint8) bool {
+	if exitCode == 1 || exitCode == internal.ErrInsufficientGas {
+		return true
+	}
+	if _, ok := errors.Errors[exitCode]; ok {
+		return true
+	}
+	_, ok := internal.Errors[exitCode]
+	return ok
+}
+
+// DispatchTracer is called with the code and method of each actor method dispatched.
+type DispatchTracer = vmcontext.DispatchTracer
+
+// Event is a structured record emitted by an actor method.
+type Event = runtime.Event
+
+// SubCall records a send made by an actor while executing a message.
+type SubCall = vmcontext.SubCall
+
+// Capability names a right a caller may hold over the actor it calls.
+type Capability = runtime.Capability
+
+// CapabilityOwner is held by the owner of the called actor.
+const CapabilityOwner = runtime.CapabilityOwner
+
+// CapabilityCheck reports whether a caller holds a capability over the actor it calls.
+type CapabilityCheck = vmcontext.CapabilityCheck
+
+// CapabilityDenial records a capability a caller was found to lack.
+type CapabilityDenial = vmcontext.CapabilityDenial
+
+// ActorLoadHook is called with the actor a send is made to before the send invokes it.
+type ActorLoadHook = vmcontext.ActorLoadHook
+
+// AddressResolver resolves an address actors send to to an ID address.
+type AddressResolver = vmcontext.AddressResolver
+
 // NewContextParams is passed to NewVMContext to construct a new context.
 type NewContextParams = vmcontext.NewContextParams
 